@@ -12,12 +12,16 @@ func newA11yTreeCmd() *cobra.Command {
   # Print the accessibility tree (interesting nodes only)
 
   vibium a11y-tree --everything
-  # Include all nodes (generic containers, etc.)`,
+  # Include all nodes (generic containers, etc.)
+
+  vibium a11y-tree --outline
+  # Only headings and landmarks`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			everything, _ := cmd.Flags().GetBool("everything")
 
 			toolArgs := map[string]interface{}{}
+			pagingArgs(cmd, toolArgs)
 			if everything {
 				toolArgs["everything"] = true
 			}
@@ -31,5 +35,6 @@ func newA11yTreeCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().Bool("everything", false, "Show all nodes including generic containers")
+	addPagingFlags(cmd, true)
 	return cmd
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	stderrors "errors"
 	"fmt"
 	"net"
 	"os"
@@ -9,12 +10,17 @@ import (
 
 	"github.com/vibium/clicker/internal/daemon"
 	"github.com/vibium/clicker/internal/agent"
+	errs "github.com/vibium/clicker/internal/errors"
 	"github.com/vibium/clicker/internal/paths"
 )
 
 // daemonCall sends a tool call to the daemon, auto-starting if needed.
 // Returns the result or an error.
 func daemonCall(toolName string, args map[string]interface{}) (*agent.ToolsCallResult, error) {
+	if sessionName != "" {
+		args["session"] = sessionName
+	}
+
 	// First attempt
 	result, err := daemon.Call(toolName, args)
 	if err == nil {
@@ -85,6 +91,12 @@ func isConnectionError(err error) bool {
 	if err == nil {
 		return false
 	}
+	// A tool error came from a running daemon, even if its message mentions
+	// a refused connection (e.g. navigating to a dead URL)
+	var toolErr *errs.CodedError
+	if stderrors.As(err, &toolErr) {
+		return false
+	}
 	// Check for common connection-refused patterns
 	if _, ok := err.(*net.OpError); ok {
 		return true
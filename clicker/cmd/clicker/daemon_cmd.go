@@ -12,8 +12,10 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/vibium/clicker/internal/agent"
 	"github.com/vibium/clicker/internal/daemon"
 	"github.com/vibium/clicker/internal/paths"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 func newDaemonCmd() *cobra.Command {
@@ -55,16 +57,50 @@ func newDaemonStartCmd() *cobra.Command {
   # Auto-shutdown after 30 minutes of inactivity
 
   vibium daemon start --connect ws://remote:9515/session
-  # Connect to a remote browser instead of launching a local one`,
+  # Connect to a remote browser instead of launching a local one
+
+  vibium daemon start --allow example.com --block "*.ads.example.com"
+  # Only let pages load example.com URLs, except its ad servers
+
+  vibium daemon start --tools interact
+  # Refuse commands that run JavaScript or read or write files
+
+  vibium daemon start --foreground --confirm all
+  # Ask on this terminal before submitting forms, clicking "Delete", "Pay"
+  # and the like, or going to a new site`,
 		Run: func(cmd *cobra.Command, args []string) {
+			policy, err := policyFromFlags(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			tools, err := toolFilterFromFlags(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			confirm, err := confirmPolicyFromFlags(cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+
+			// A detached daemon runs in its own session, with no terminal
+			// to ask on, so it could only ever decline
+			if confirm != nil && !foreground {
+				fmt.Fprintln(os.Stderr, "Error: --confirm needs --foreground: a background daemon has no terminal to ask for confirmation on")
+				os.Exit(1)
+			}
+
 			if !foreground && !internal {
 				// Daemonize: re-exec as detached child
-				daemonize(idleTimeout, connectFlag, headerFlags)
+				serverFlags := append(append(policyArgs(cmd), toolArgs(cmd)...), confirmArgs(cmd)...)
+				daemonize(idleTimeout, connectFlag, headerFlags, serverFlags)
 				return
 			}
 
 			// Foreground mode (or internal detached child)
-			runDaemonForeground(idleTimeout, connectFlag, headerFlags)
+			runDaemonForeground(idleTimeout, connectFlag, headerFlags, policy, tools, confirm)
 		},
 	}
 
@@ -76,6 +112,9 @@ func newDaemonStartCmd() *cobra.Command {
 	cmd.Flags().MarkHidden("_internal")
 	cmd.Flags().StringVar(&connectFlag, "connect", "", "Connect to a remote BiDi WebSocket URL instead of launching a local browser")
 	cmd.Flags().StringArrayVar(&headerFlags, "connect-header", nil, "HTTP header for WebSocket connect (repeatable, format: \"Key: Value\")")
+	addPolicyFlags(cmd)
+	addToolFlags(cmd)
+	addConfirmFlags(cmd)
 
 	return cmd
 }
@@ -187,7 +226,7 @@ func resolveConnect(connectFlag string, headerFlags []string) (string, http.Head
 }
 
 // runDaemonForeground starts the daemon in the current process.
-func runDaemonForeground(idleTimeout time.Duration, connectFlag string, headerFlags []string) {
+func runDaemonForeground(idleTimeout time.Duration, connectFlag string, headerFlags []string, policy *agent.NavigationPolicy, tools *agent.ToolFilter, confirm *agent.ConfirmPolicy) {
 	// Clean stale files from a previous crash
 	daemon.CleanStale()
 
@@ -204,6 +243,13 @@ func runDaemonForeground(idleTimeout time.Duration, connectFlag string, headerFl
 
 	connectURL, connectHeaders := resolveConnect(connectFlag, headerFlags)
 
+	// Tracing is configured only through the environment, which the detached
+	// child inherits
+	if err := setupTracing("", ""); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: tracing disabled: %v\n", err)
+	}
+	defer tracing.Shutdown()
+
 	d := daemon.New(daemon.Options{
 		Version:        version,
 		ScreenshotDir:  screenshotDir,
@@ -211,6 +257,9 @@ func runDaemonForeground(idleTimeout time.Duration, connectFlag string, headerFl
 		IdleTimeout:    idleTimeout,
 		ConnectURL:     connectURL,
 		ConnectHeaders: connectHeaders,
+		Policy:         policy,
+		Tools:          tools,
+		Confirm:        confirm,
 	})
 
 	// Install signal handler for clean shutdown
@@ -233,7 +282,7 @@ func runDaemonForeground(idleTimeout time.Duration, connectFlag string, headerFl
 }
 
 // daemonize spawns the daemon as a detached background process.
-func daemonize(idleTimeout time.Duration, connectFlag string, headerFlags []string) {
+func daemonize(idleTimeout time.Duration, connectFlag string, headerFlags []string, serverFlags []string) {
 	// Clean stale files first
 	daemon.CleanStale()
 
@@ -261,6 +310,8 @@ func daemonize(idleTimeout time.Duration, connectFlag string, headerFlags []stri
 	for _, h := range headerFlags {
 		args = append(args, fmt.Sprintf("--connect-header=%s", h))
 	}
+	// Forward navigation policy and tool profile flags
+	args = append(args, serverFlags...)
 
 	cmd := exec.Command(exe, args...)
 	cmd.Stdout = nil
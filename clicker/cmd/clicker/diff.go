@@ -17,17 +17,34 @@ func newDiffCmd() *cobra.Command {
 	diffMapCmd := &cobra.Command{
 		Use:   "map",
 		Short: "Compare current page elements vs last map",
+		Long: `Map the page again and show which elements changed since the last map.
+
+Elements are matched by identity, not by ref, so a re-numbered ref or a
+renamed button is reported as what it is. Each line is one element:
+  - removed (with its ref from the last map)
+  + added
+  > moved (its order among the other elements changed)
+  ~ relabelled
+  * state changed (enabled, checked, value, expanded)
+
+With --json the changes are printed as lists: added, removed, moved,
+relabelled and stateChanged.`,
 		Example: `  vibium map           # take initial snapshot
   vibium click @e3     # interact with page
-  vibium diff map      # see what changed`,
+  vibium diff map      # see what changed
+  vibium diff map --json`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			result, err := daemonCall("browser_diff_map", map[string]interface{}{})
+			toolArgs := map[string]interface{}{}
+			if jsonOutput {
+				toolArgs["format"] = "json"
+			}
+			result, err := daemonCall("browser_diff_map", toolArgs)
 			if err != nil {
 				printError(err)
 				return
 			}
-			printResult(result)
+			printJSONResult(result)
 		},
 	}
 
@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vibium/clicker/internal/agent"
+)
 
 // printCheck prints an actionability check result with a checkmark or X.
 func printCheck(name string, passed bool) {
@@ -10,3 +18,139 @@ func printCheck(name string, passed bool) {
 		fmt.Printf("✗ %s: false\n", name)
 	}
 }
+
+// addPagingFlags adds --max-chars and --cursor, and --outline if outline is
+// set, to a command whose tool output is budgeted.
+func addPagingFlags(cmd *cobra.Command, outline bool) {
+	cmd.Flags().Int("max-chars", 0, "Print at most this many characters, ending with the cursor for the next page (default: no limit)")
+	cmd.Flags().String("cursor", "", "Continue from the cursor printed at the end of the previous page")
+	if outline {
+		cmd.Flags().Bool("outline", false, "Print only headings and landmarks")
+	}
+}
+
+// pagingArgs copies the paging flags into toolArgs. Unlike MCP clients, the
+// CLI gets the whole output unless --max-chars is given.
+func pagingArgs(cmd *cobra.Command, toolArgs map[string]interface{}) {
+	maxChars, _ := cmd.Flags().GetInt("max-chars")
+	toolArgs["maxChars"] = maxChars
+	if cursor, _ := cmd.Flags().GetString("cursor"); cursor != "" {
+		toolArgs["cursor"] = cursor
+	}
+	if outline, _ := cmd.Flags().GetBool("outline"); outline {
+		toolArgs["outline"] = true
+	}
+}
+
+// addPolicyFlags adds the navigation policy flags to a command that serves
+// agent sessions.
+func addPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("allow", nil, "Only let pages load URLs on this domain or matching this URL glob (repeatable)")
+	cmd.Flags().StringArray("block", nil, "Never let pages load URLs on this domain or matching this URL glob (repeatable)")
+	cmd.Flags().String("policy", "", "JSON file with {\"allow\": [...], \"block\": [...]} patterns (default $VIBIUM_POLICY)")
+}
+
+// policyFromFlags builds the navigation policy from the policy flags, or
+// returns nil if none are set.
+func policyFromFlags(cmd *cobra.Command) (*agent.NavigationPolicy, error) {
+	allow, _ := cmd.Flags().GetStringArray("allow")
+	block, _ := cmd.Flags().GetStringArray("block")
+	file, _ := cmd.Flags().GetString("policy")
+	if file == "" {
+		file = os.Getenv("VIBIUM_POLICY")
+	}
+	return agent.LoadNavigationPolicy(file, allow, block)
+}
+
+// policyArgs returns the policy flags as set on cmd, for passing on to a
+// child process.
+func policyArgs(cmd *cobra.Command) []string {
+	var args []string
+	for _, name := range []string{"allow", "block"} {
+		values, _ := cmd.Flags().GetStringArray(name)
+		for _, v := range values {
+			args = append(args, fmt.Sprintf("--%s=%s", name, v))
+		}
+	}
+	if file, _ := cmd.Flags().GetString("policy"); file != "" {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		args = append(args, "--policy="+file)
+	}
+	return args
+}
+
+// addToolFlags adds the tool profile flags to a command that serves agent
+// sessions.
+func addToolFlags(cmd *cobra.Command) {
+	cmd.Flags().String("tools", "", "Tool profile: readonly, interact or full (default $VIBIUM_TOOLS, or full)")
+	cmd.Flags().Bool("read-only", false, "Same as --tools readonly")
+	cmd.Flags().StringSlice("include-tool", nil, "Offer this tool too (repeatable or comma-separated)")
+	cmd.Flags().StringSlice("exclude-tool", nil, "Don't offer this tool (repeatable or comma-separated)")
+}
+
+// toolFilterFromFlags builds the tool filter from the tool flags, or
+// returns nil if every tool is offered.
+func toolFilterFromFlags(cmd *cobra.Command) (*agent.ToolFilter, error) {
+	profile, _ := cmd.Flags().GetString("tools")
+	if readOnly, _ := cmd.Flags().GetBool("read-only"); readOnly {
+		if profile != "" && profile != agent.ProfileReadOnly {
+			return nil, fmt.Errorf("--read-only conflicts with --tools %s", profile)
+		}
+		profile = agent.ProfileReadOnly
+	}
+	if profile == "" {
+		profile = os.Getenv("VIBIUM_TOOLS")
+	}
+	include, _ := cmd.Flags().GetStringSlice("include-tool")
+	exclude, _ := cmd.Flags().GetStringSlice("exclude-tool")
+	return agent.NewToolFilter(profile, include, exclude)
+}
+
+// toolArgs returns the tool flags as set on cmd, for passing on to a child
+// process.
+func toolArgs(cmd *cobra.Command) []string {
+	var args []string
+	if profile, _ := cmd.Flags().GetString("tools"); profile != "" {
+		args = append(args, "--tools="+profile)
+	}
+	if readOnly, _ := cmd.Flags().GetBool("read-only"); readOnly {
+		args = append(args, "--read-only")
+	}
+	for _, name := range []string{"include-tool", "exclude-tool"} {
+		if values, _ := cmd.Flags().GetStringSlice(name); len(values) > 0 {
+			args = append(args, fmt.Sprintf("--%s=%s", name, strings.Join(values, ",")))
+		}
+	}
+	return args
+}
+
+// addConfirmFlags adds the confirmation gate flags to a command that serves
+// agent sessions.
+func addConfirmFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("confirm", nil, "Ask a human before these actions: submit, click, origin or all (repeatable or comma-separated)")
+	cmd.Flags().StringArray("confirm-label", nil, "Ask before clicking elements whose label matches this regexp (repeatable; default delete, pay, confirm, ...)")
+}
+
+// confirmPolicyFromFlags builds the confirmation policy from the confirm
+// flags, or returns nil if none are set.
+func confirmPolicyFromFlags(cmd *cobra.Command) (*agent.ConfirmPolicy, error) {
+	rules, _ := cmd.Flags().GetStringSlice("confirm")
+	labels, _ := cmd.Flags().GetStringArray("confirm-label")
+	return agent.NewConfirmPolicy(rules, labels)
+}
+
+// confirmArgs returns the confirm flags as set on cmd, for passing on to a
+// child process.
+func confirmArgs(cmd *cobra.Command) []string {
+	var args []string
+	if rules, _ := cmd.Flags().GetStringSlice("confirm"); len(rules) > 0 {
+		args = append(args, "--confirm="+strings.Join(rules, ","))
+	}
+	labels, _ := cmd.Flags().GetStringArray("confirm-label")
+	for _, label := range labels {
+		args = append(args, "--confirm-label="+label)
+	}
+	return args
+}
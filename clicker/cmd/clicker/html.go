@@ -24,6 +24,7 @@ func newHTMLCmd() *cobra.Command {
 			outer, _ := cmd.Flags().GetBool("outer")
 
 			toolArgs := map[string]interface{}{}
+			pagingArgs(cmd, toolArgs)
 			if outer {
 				toolArgs["outer"] = true
 			}
@@ -58,5 +59,6 @@ func newHTMLCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().Bool("outer", false, "Return outerHTML instead of innerHTML")
+	addPagingFlags(cmd, true)
 	return cmd
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"github.com/vibium/clicker/internal/api"
 )
 
 func newIsCmd() *cobra.Command {
@@ -92,8 +93,9 @@ func newIsCmd() *cobra.Command {
 
 			// Evaluate actionability script
 			script := `(() => {
+				` + api.ShadowDOMJS() + `
 				const selector = ` + fmt.Sprintf("%q", selector) + `;
-				const el = document.querySelector(selector);
+				const el = querySelectorDeep(document, selector);
 				if (!el) return JSON.stringify({ error: 'element not found' });
 
 				const rect = el.getBoundingClientRect();
@@ -102,8 +104,15 @@ func newIsCmd() *cobra.Command {
 					style.visibility !== 'hidden' && style.display !== 'none';
 
 				const cx = rect.x + rect.width/2, cy = rect.y + rect.height/2;
-				const hit = document.elementFromPoint(cx, cy);
-				const receivesEvents = hit && (el === hit || el.contains(hit));
+				let hit = document.elementFromPoint(cx, cy);
+				while (hit && hit.shadowRoot) {
+					const inner = hit.shadowRoot.elementFromPoint(cx, cy);
+					if (!inner || inner === hit) break;
+					hit = inner;
+				}
+				let cur = hit;
+				while (cur && cur !== el) cur = cur.parentNode instanceof ShadowRoot ? cur.parentNode.host : cur.parentNode;
+				const receivesEvents = !!(hit && cur);
 
 				let enabled = true;
 				if (el.disabled === true) enabled = false;
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newLocateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "locate [ref|selector]",
+		Short: "Suggest stable locators for an element",
+		Long: `Suggest locators for an element that a test can keep using, instead of
+the nth-of-type CSS path a ref stands for.
+
+The candidates are the element's test ID, role and accessible name, label,
+placeholder, text and the shortest unique CSS selector, in the locator
+syntax every selector argument takes. Each is checked on the current page;
+unique ones come first. One that matches several elements is narrowed to
+the element's row, list item or form when that makes it unique, e.g.
+  tr:has-text("Invoice 42") >> role=button[name="Delete"]
+
+With --json the candidates are printed as a list of locator, kind, matches
+and unique.`,
+		Example: `  vibium map
+  vibium locate @e3
+  # role=button[name="Log in"]  (role, unique)
+  # text="Log in"               (text, unique)
+  # button.radius               (css, unique)
+
+  vibium locate "#login button" --json`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			toolArgs := map[string]interface{}{"selector": args[0]}
+			if jsonOutput {
+				toolArgs["format"] = "json"
+			}
+			result, err := daemonCall("browser_locate", toolArgs)
+			if err != nil {
+				printError(err)
+				return
+			}
+			printJSONResult(result)
+		},
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/vibium/clicker/internal/log"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 // connectFromEnv reads VIBIUM_CONNECT_URL and VIBIUM_CONNECT_API_KEY from the environment.
@@ -25,13 +26,39 @@ func connectFromEnv() (string, http.Header) {
 	return url, headers
 }
 
+// setupTracing enables span export to an OTLP/HTTP collector at endpoint or to
+// a JSON file. Empty values fall back to VIBIUM_TRACE_ENDPOINT and
+// VIBIUM_TRACE_FILE; with neither set, tracing stays off. The file wins if
+// both are given.
+func setupTracing(endpoint, file string) error {
+	if endpoint == "" {
+		endpoint = os.Getenv("VIBIUM_TRACE_ENDPOINT")
+	}
+	if file == "" {
+		file = os.Getenv("VIBIUM_TRACE_FILE")
+	}
+
+	switch {
+	case file != "":
+		e, err := tracing.NewFileExporter(file)
+		if err != nil {
+			return err
+		}
+		tracing.Setup(e)
+	case endpoint != "":
+		tracing.Setup(tracing.NewOTLPExporter(endpoint))
+	}
+	return nil
+}
+
 var version = "dev"
 
 // Global flags
 var (
-	headless   bool
-	verbose    bool
-	jsonOutput bool
+	headless    bool
+	verbose     bool
+	jsonOutput  bool
+	sessionName string
 )
 
 func main() {
@@ -55,6 +82,7 @@ func main() {
 	rootCmd.PersistentFlags().BoolVar(&headless, "headless", false, "Hide browser window (visible by default)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable debug logging")
 	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Output as JSON")
+	rootCmd.PersistentFlags().StringVar(&sessionName, "session", "", "Named browser session to act in (start it with 'start --session <name>')")
 
 	// Register all commands
 	rootCmd.AddCommand(newVersionCmd())
@@ -88,6 +116,7 @@ func main() {
 	rootCmd.AddCommand(newReloadCmd())
 	rootCmd.AddCommand(newStartCmd())
 	rootCmd.AddCommand(newStopCmd())
+	rootCmd.AddCommand(newSessionsCmd())
 	rootCmd.AddCommand(newFillCmd())
 	rootCmd.AddCommand(newPressCmd())
 	rootCmd.AddCommand(newCheckCmd())
@@ -99,6 +128,7 @@ func main() {
 	rootCmd.AddCommand(newSkillCmd())
 	rootCmd.AddCommand(newMapCmd())
 	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newLocateCmd())
 	rootCmd.AddCommand(newPDFCmd())
 	rootCmd.AddCommand(newHighlightCmd())
 	rootCmd.AddCommand(newDblClickCmd())
@@ -106,6 +136,7 @@ func main() {
 	rootCmd.AddCommand(newCountCmd())
 	rootCmd.AddCommand(newDialogCmd())
 	rootCmd.AddCommand(newCookiesCmd())
+	rootCmd.AddCommand(newRouteCmd())
 	rootCmd.AddCommand(newDragCmd())
 	rootCmd.AddCommand(newViewportCmd())
 	rootCmd.AddCommand(newWindowCmd())
@@ -129,8 +160,10 @@ func main() {
 	rootCmd.Version = version
 	rootCmd.SetVersionTemplate(progName + " v{{.Version}}\n")
 
+	// Commands exit by themselves when they fail, so an error here is
+	// cobra's: a usage error
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
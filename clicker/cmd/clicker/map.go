@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,23 +16,58 @@ func newMapCmd() *cobra.Command {
   # Use refs with other commands: vibium click @e1
 
   vibium map --selector "nav"
-  # Only map elements inside the <nav> element`,
+  # Only map elements inside the <nav> element
+
+  vibium map --forms-only --state --group
+  # Form fields with their values, grouped by form
+
+  vibium map --in-viewport --role button,link
+  # Buttons and links currently on screen
+
+  vibium map --format json
+  # Elements as JSON, with role, group, selector and state`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			toolArgs := map[string]interface{}{}
+			pagingArgs(cmd, toolArgs)
 			if sel, _ := cmd.Flags().GetString("selector"); sel != "" {
 				toolArgs["selector"] = sel
 			}
+			if roles, _ := cmd.Flags().GetStringSlice("role"); len(roles) > 0 {
+				toolArgs["role"] = strings.Join(roles, ",")
+			}
+			for flag, arg := range map[string]string{"state": "state", "group": "group", "in-viewport": "inViewport", "forms-only": "formsOnly"} {
+				if on, _ := cmd.Flags().GetBool(flag); on {
+					toolArgs[arg] = true
+				}
+			}
+			format, _ := cmd.Flags().GetString("format")
+			if format != "" && format != "text" && format != "json" {
+				printError(fmt.Errorf("invalid --format %q (expected text or json)", format))
+				return
+			}
+			toolArgs["format"] = format
 			result, err := daemonCall("browser_map", toolArgs)
 			if err != nil {
 				printError(err)
 				return
 			}
-			printResult(result)
+			if format == "json" {
+				printJSONResult(result)
+			} else {
+				printResult(result)
+			}
 		},
 	}
 
 	cmd.Flags().String("selector", "", "Scope to elements within this CSS selector")
+	cmd.Flags().Bool("state", false, "Show element state: value, checked, disabled, required, expanded, invalid")
+	cmd.Flags().Bool("group", false, "Group elements under their landmark, form or dialog")
+	cmd.Flags().Bool("in-viewport", false, "Only list elements in the viewport")
+	cmd.Flags().StringSlice("role", nil, "Only list elements with this ARIA role (repeatable or comma-separated)")
+	cmd.Flags().Bool("forms-only", false, "Only list form controls")
+	cmd.Flags().String("format", "text", "Output format: text or json")
+	addPagingFlags(cmd, false)
 
 	return cmd
 }
@@ -5,21 +5,25 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vibium/clicker/internal/agent"
 	"github.com/vibium/clicker/internal/paths"
 	"github.com/vibium/clicker/internal/process"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 func newMCPCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "mcp",
-		Short: "Start MCP server (stdio JSON-RPC for LLM agents)",
+		Short: "Start MCP server (stdio or HTTP JSON-RPC for LLM agents)",
 		Long: `Start the Model Context Protocol (MCP) server.
 
 This runs a JSON-RPC 2.0 server over stdin/stdout, designed for integration
-with LLM agents like Claude Code.
+with LLM agents like Claude Code. With --http it serves the MCP Streamable
+HTTP transport instead, so several agents can share one server; each MCP
+session gets its own browser.
 
 The server provides browser automation tools:
   - browser_start: Start a browser session
@@ -56,12 +60,29 @@ The server provides browser automation tools:
   # Disable screenshot file saving (inline only)
   vibium mcp --screenshot-dir ""
 
+  # Serve over HTTP at http://127.0.0.1:9516/mcp
+  vibium mcp --http --headless
+
+  # Listen on all interfaces, require "Authorization: Bearer s3cret"
+  vibium mcp --http --host 0.0.0.0 --token s3cret
+
+  # Keep pages on example.com (and its subdomains), except the admin site
+  vibium mcp --allow example.com --block admin.example.com
+
+  # Only offer tools that read the page, plus clicking
+  vibium mcp --read-only --include-tool browser_click
+
+  # Ask before submitting forms or leaving for another site
+  vibium mcp --confirm submit,origin
+
   # Test with echo
   echo '{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}' | vibium mcp`,
 		Run: func(cmd *cobra.Command, args []string) {
 			process.WithCleanup(func() {
+				httpMode, _ := cmd.Flags().GetBool("http")
+
 				// If running in a terminal, print helpful info to stderr
-				if stat, _ := os.Stdin.Stat(); (stat.Mode() & os.ModeCharDevice) != 0 {
+				if stat, _ := os.Stdin.Stat(); !httpMode && (stat.Mode()&os.ModeCharDevice) != 0 {
 					fmt.Fprintf(os.Stderr, "Vibium MCP server v%s\n", version)
 					fmt.Fprintln(os.Stderr, "This server communicates via JSON-RPC over stdin/stdout.")
 					fmt.Fprintln(os.Stderr, "It's meant to be run by an MCP client (e.g., Claude Desktop).")
@@ -99,13 +120,48 @@ The server provides browser automation tools:
 					}
 				}
 
+				traceEndpoint, _ := cmd.Flags().GetString("trace-endpoint")
+				traceFile, _ := cmd.Flags().GetString("trace-file")
+				if err := setupTracing(traceEndpoint, traceFile); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				defer tracing.Shutdown()
+
 				connectURL, connectHeaders := connectFromEnv()
 
-				server := agent.NewServer(version, agent.ServerOptions{
+				policy, err := policyFromFlags(cmd)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				tools, err := toolFilterFromFlags(cmd)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				confirm, err := confirmPolicyFromFlags(cmd)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+
+				opts := agent.ServerOptions{
 					ScreenshotDir:  screenshotDir,
 					ConnectURL:     connectURL,
 					ConnectHeaders: connectHeaders,
-				})
+					Headless:       headless,
+					Policy:         policy,
+					Tools:          tools,
+					Confirm:        confirm,
+				}
+
+				if httpMode {
+					runMCPHTTP(cmd, opts)
+					return
+				}
+
+				server := agent.NewServer(version, opts)
 				defer server.Close()
 
 				// Handle SIGTERM so Chrome is cleaned up even if stdin isn't closed
@@ -114,6 +170,7 @@ The server provides browser automation tools:
 				go func() {
 					<-sigCh
 					server.Close()
+					tracing.Shutdown()
 					os.Exit(0)
 				}()
 
@@ -124,6 +181,52 @@ The server provides browser automation tools:
 			})
 		},
 	}
+	cmd.Flags().Bool("http", false, "Serve the MCP Streamable HTTP transport instead of stdio")
+	cmd.Flags().IntP("port", "p", agent.DefaultHTTPPort, "Port to listen on (with --http)")
+	cmd.Flags().String("host", "127.0.0.1", "Address to bind to (with --http; 0.0.0.0 = all interfaces)")
+	cmd.Flags().String("token", "", "Require this bearer token from clients (with --http; default $VIBIUM_MCP_TOKEN)")
+	cmd.Flags().StringSlice("allowed-origin", nil, "Allowed browser Origin for requests (with --http; repeatable; default any)")
+	cmd.Flags().Duration("session-idle-timeout", 30*time.Minute, "Close an HTTP session's browser after this long without requests (0 = never)")
 	cmd.Flags().String("screenshot-dir", "", "Directory for saving screenshots (default: ~/Pictures/Vibium, use \"\" to disable)")
+	cmd.Flags().String("trace-endpoint", "", "Export tool call traces to this OTLP/HTTP collector, e.g. "+tracing.DefaultEndpoint+" (default $VIBIUM_TRACE_ENDPOINT)")
+	cmd.Flags().String("trace-file", "", "Append tool call traces to this file as OTLP/JSON (default $VIBIUM_TRACE_FILE)")
+	addPolicyFlags(cmd)
+	addToolFlags(cmd)
+	addConfirmFlags(cmd)
 	return cmd
 }
+
+// runMCPHTTP serves MCP over Streamable HTTP until SIGINT or SIGTERM.
+func runMCPHTTP(cmd *cobra.Command, opts agent.ServerOptions) {
+	port, _ := cmd.Flags().GetInt("port")
+	host, _ := cmd.Flags().GetString("host")
+	token, _ := cmd.Flags().GetString("token")
+	origins, _ := cmd.Flags().GetStringSlice("allowed-origin")
+	idleTimeout, _ := cmd.Flags().GetDuration("session-idle-timeout")
+	if token == "" {
+		token = os.Getenv("VIBIUM_MCP_TOKEN")
+	}
+
+	server := agent.NewHTTPServer(version, opts, agent.HTTPOptions{
+		Host:           host,
+		Port:           port,
+		Token:          token,
+		AllowedOrigins: origins,
+		IdleTimeout:    idleTimeout,
+	})
+	if err := server.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting MCP server: %v\n", err)
+		os.Exit(1)
+	}
+	defer server.Close()
+
+	fmt.Fprintf(os.Stderr, "Vibium MCP server v%s listening on %s\n", version, server.URL())
+	if token != "" {
+		fmt.Fprintln(os.Stderr, "Bearer token authentication enabled")
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+	fmt.Fprintln(os.Stderr, "\nShutting down...")
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/vibium/clicker/internal/agent"
+	errs "github.com/vibium/clicker/internal/errors"
 	"github.com/vibium/clicker/internal/process"
 )
 
@@ -14,6 +15,48 @@ type jsonEnvelope struct {
 	OK     bool        `json:"ok"`
 	Result interface{} `json:"result,omitempty"`
 	Error  string      `json:"error,omitempty"`
+	Code   string      `json:"code,omitempty"` // stable error code, see errors.Code
+}
+
+// Exit codes for failed commands, so scripts can tell failures apart.
+const (
+	exitError           = 1 // unclassified failure
+	exitUsage           = 2 // bad arguments or flags, or an unknown command
+	exitNoSuchElement   = 3
+	exitTimeout         = 4
+	exitNotInteractable = 5
+	exitStrictMode      = 6
+	exitBrowserCrashed  = 7
+	exitConnection      = 8
+	exitProtocol        = 9 // any other BiDi error code, e.g. "no such frame"
+	exitBlocked         = 10
+	exitStaleRef        = 11
+)
+
+// exitCode returns the process exit code for err.
+func exitCode(err error) int {
+	switch code := errs.Code(err); code {
+	case errs.CodeNoSuchElement:
+		return exitNoSuchElement
+	case errs.CodeTimeout:
+		return exitTimeout
+	case errs.CodeNotInteractable:
+		return exitNotInteractable
+	case errs.CodeStrictModeViolation:
+		return exitStrictMode
+	case errs.CodeBrowserCrashed:
+		return exitBrowserCrashed
+	case errs.CodeConnectionFailed:
+		return exitConnection
+	case errs.CodeNavigationBlocked:
+		return exitBlocked
+	case errs.CodeStaleElement:
+		return exitStaleRef
+	case errs.CodeUnknown, errs.CodeCancelled, errs.CodeActionDeclined, "":
+		return exitError
+	default:
+		return exitProtocol
+	}
 }
 
 // printResult prints a tool call result, respecting --json mode.
@@ -39,21 +82,36 @@ func printResult(result *agent.ToolsCallResult) {
 	}
 }
 
+// printJSONResult prints a tool call result whose text is JSON, as
+// printResult does, except that in --json mode the result goes into the
+// envelope as JSON rather than as a string.
+func printJSONResult(result *agent.ToolsCallResult) {
+	if result == nil {
+		return
+	}
+	if text := extractText(result); jsonOutput && json.Valid([]byte(text)) {
+		printJSON(jsonEnvelope{OK: true, Result: json.RawMessage(text)})
+		return
+	}
+	printResult(result)
+}
+
 // printError prints an error, respecting --json mode.
-// In JSON mode: {"ok":false,"error":"..."}
+// In JSON mode: {"ok":false,"error":"...","code":"..."}
 // In normal mode: prints to stderr and exits.
+// The exit code depends on the kind of error (see exitCode).
 func printError(err error) {
 	if jsonOutput {
-		env := jsonEnvelope{OK: false, Error: err.Error()}
+		env := jsonEnvelope{OK: false, Error: err.Error(), Code: errs.Code(err)}
 		printJSON(env)
 		process.KillAll()
-		os.Exit(1)
+		os.Exit(exitCode(err))
 		return
 	}
 
 	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 	process.KillAll()
-	os.Exit(1)
+	os.Exit(exitCode(err))
 }
 
 // printJSON marshals and prints a value as a single JSON line.
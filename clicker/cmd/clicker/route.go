@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newRouteCmd() *cobra.Command {
+	routeCmd := &cobra.Command{
+		Use:   "route [url-glob]",
+		Short: "Stub, block, delay or rewrite network requests",
+		Example: `  vibium route
+  # List active routes
+
+  vibium route "**/api/users" --body '[{"id":1}]' --content-type application/json
+  # Stub an API response
+
+  vibium route "**/api/users" --file users.json
+  # Stub from a file (Content-Type from the extension)
+
+  vibium route "https://*.tracker.com/**" --abort
+  # Block a tracker
+
+  vibium route "**/*" --type image --abort
+  # Block all images
+
+  vibium route "**/api/**" --method POST --delay 2000
+  # Hold POSTs to the API for 2 seconds
+
+  vibium route "**/api/**" --header "Authorization: Bearer xyz"
+  # Add a request header`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				result, err := daemonCall("browser_list_routes", map[string]interface{}{})
+				if err != nil {
+					printError(err)
+					return
+				}
+				printResult(result)
+				return
+			}
+
+			callArgs := map[string]interface{}{"url": args[0]}
+			if method, _ := cmd.Flags().GetString("method"); method != "" {
+				callArgs["method"] = method
+			}
+			if resourceType, _ := cmd.Flags().GetString("type"); resourceType != "" {
+				callArgs["resourceType"] = resourceType
+			}
+			if abort, _ := cmd.Flags().GetBool("abort"); abort {
+				callArgs["action"] = "abort"
+			}
+			if cmd.Flags().Changed("status") {
+				status, _ := cmd.Flags().GetInt("status")
+				callArgs["status"] = status
+			}
+			if cmd.Flags().Changed("body") {
+				body, _ := cmd.Flags().GetString("body")
+				callArgs["body"] = body
+			}
+			if file, _ := cmd.Flags().GetString("file"); file != "" {
+				path, err := filepath.Abs(file)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: invalid path: %v\n", err)
+					os.Exit(1)
+				}
+				callArgs["path"] = path
+			}
+			if contentType, _ := cmd.Flags().GetString("content-type"); contentType != "" {
+				callArgs["contentType"] = contentType
+			}
+			if delay, _ := cmd.Flags().GetInt("delay"); delay > 0 {
+				callArgs["delay"] = delay
+			}
+			headerFlags, _ := cmd.Flags().GetStringArray("header")
+			if len(headerFlags) > 0 {
+				headers := map[string]interface{}{}
+				for _, h := range headerFlags {
+					name, value, ok := strings.Cut(h, ":")
+					if !ok {
+						fmt.Fprintf(os.Stderr, "Error: invalid header %q (expected \"Name: value\")\n", h)
+						os.Exit(1)
+					}
+					headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+				}
+				callArgs["headers"] = headers
+			}
+
+			result, err := daemonCall("browser_route", callArgs)
+			if err != nil {
+				printError(err)
+				return
+			}
+			printResult(result)
+		},
+	}
+	routeCmd.Flags().String("method", "", "Only match this HTTP method")
+	routeCmd.Flags().String("type", "", "Only match this resource type (document, stylesheet, image, media, font, script, fetch, xhr, websocket, other)")
+	routeCmd.Flags().Bool("abort", false, "Fail matching requests")
+	routeCmd.Flags().Int("status", 200, "Response status for a stubbed response")
+	routeCmd.Flags().String("body", "", "Response body for a stubbed response")
+	routeCmd.Flags().String("file", "", "Read the stubbed response body from a file")
+	routeCmd.Flags().String("content-type", "", "Content-Type of the stubbed response")
+	routeCmd.Flags().StringArray("header", nil, "Header as \"Name: value\" (repeatable); response header when stubbing, else added to the request")
+	routeCmd.Flags().Int("delay", 0, "Hold matching requests for this many milliseconds")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [id|url-glob]",
+		Short: "Remove routes (all routes if none given)",
+		Example: `  vibium route remove 2
+  # Remove route 2
+
+  vibium route remove "**/api/users"
+  # Remove the routes added for a glob
+
+  vibium route remove
+  # Remove all routes`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			callArgs := map[string]interface{}{}
+			if len(args) == 1 {
+				if id, err := strconv.Atoi(args[0]); err == nil {
+					callArgs["id"] = id
+				} else {
+					callArgs["url"] = args[0]
+				}
+			}
+			result, err := daemonCall("browser_unroute", callArgs)
+			if err != nil {
+				printError(err)
+				return
+			}
+			printResult(result)
+		},
+	}
+
+	routeCmd.AddCommand(removeCmd)
+	return routeCmd
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vibium/clicker/internal/browser"
 	"github.com/vibium/clicker/internal/api"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 func newServeCmd() *cobra.Command {
@@ -24,28 +29,112 @@ func newServeCmd() *cobra.Command {
   # Starts server on port 8080
 
   vibium serve --headless
-  # Starts server with headless browser`,
+  # Starts server with headless browser
+
+  vibium serve --headless --pool-max 8 --pool-min-idle 2
+  # Keeps 2 browsers pre-launched, at most 8 in total; pool health at /pool
+
+  curl http://localhost:9515/metrics
+  # Prometheus metrics; /healthz checks that every session's browser responds
+
+  vibium serve --host 0.0.0.0 --token s3cret --tls-cert cert.pem --tls-key key.pem
+  # Listens on all interfaces over wss://; clients send "Authorization: Bearer s3cret"
+  # (or ?token=s3cret). The token can also come from VIBIUM_SERVE_TOKEN.
+
+  vibium serve --resume-grace 30s
+  # Keeps a disconnected client's browser for 30s; reconnect with
+  # ws://localhost:9515/?resumeToken=<token from vibium:lifecycle.ready>
+
+  vibium serve --trace-endpoint http://localhost:4318
+  # Exports a span per vibium: command, with its BiDi calls and actionability
+  # retries, to an OTLP/HTTP collector. Send "traceparent" in a command's
+  # params to join an existing trace.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			port, _ := cmd.Flags().GetInt("port")
+			host, _ := cmd.Flags().GetString("host")
+			token, _ := cmd.Flags().GetString("token")
+			tlsCert, _ := cmd.Flags().GetString("tls-cert")
+			tlsKey, _ := cmd.Flags().GetString("tls-key")
+			origins, _ := cmd.Flags().GetStringSlice("allowed-origin")
+			poolMax, _ := cmd.Flags().GetInt("pool-max")
+			poolMinIdle, _ := cmd.Flags().GetInt("pool-min-idle")
+			poolWait, _ := cmd.Flags().GetDuration("pool-wait-timeout")
+			resumeGrace, _ := cmd.Flags().GetDuration("resume-grace")
+			resumeBuffer, _ := cmd.Flags().GetInt("resume-buffer")
+			traceEndpoint, _ := cmd.Flags().GetString("trace-endpoint")
+			traceFile, _ := cmd.Flags().GetString("trace-file")
+
+			if token == "" {
+				token = os.Getenv("VIBIUM_SERVE_TOKEN")
+			}
+			if (tlsCert == "") != (tlsKey == "") {
+				fmt.Fprintln(os.Stderr, "Error: --tls-cert and --tls-key must be used together")
+				os.Exit(1)
+			}
+
+			if err := setupTracing(traceEndpoint, traceFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			defer tracing.Shutdown()
 
 			fmt.Printf("Starting Vibium proxy server on port %d...\n", port)
 
 			// Create router to manage browser sessions
 			router := api.NewRouter(headless, "", nil)
+			if resumeGrace > 0 {
+				router.SetResume(resumeGrace, resumeBuffer)
+				fmt.Printf("Session resume: grace period %s, buffer %d messages\n", resumeGrace, resumeBuffer)
+			}
 
-			server := api.NewServer(
+			opts := []api.ServerOption{
 				api.WithPort(port),
+				api.WithBindAddress(host),
+				api.WithAuthToken(token),
+				api.WithAllowedOrigins(origins),
 				api.WithOnConnect(router.OnClientConnect),
 				api.WithOnMessage(router.OnClientMessage),
 				api.WithOnClose(router.OnClientDisconnect),
-			)
+				api.WithHandler("/metrics", router.MetricsHandler()),
+				api.WithHandler("/healthz", router.HealthHandler()),
+			}
+
+			// Optional browser pool: pre-launch browsers and recycle them between clients
+			if poolMax > 0 {
+				pool := api.NewBrowserPool(api.PoolOptions{
+					Headless:    headless,
+					MinIdle:     poolMinIdle,
+					MaxTotal:    poolMax,
+					WaitTimeout: poolWait,
+				})
+				router.SetPool(pool)
+				pool.Start()
+				opts = append(opts, api.WithHandler("/pool", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(pool.Stats())
+				})))
+				fmt.Printf("Browser pool: min idle %d, max %d\n", poolMinIdle, poolMax)
+			}
+
+			if tlsCert != "" {
+				opts = append(opts, api.WithTLS(tlsCert, tlsKey))
+			}
+
+			server := api.NewServer(opts...)
 
 			if err := server.Start(); err != nil {
 				fmt.Fprintf(os.Stderr, "Error starting server: %v\n", err)
 				os.Exit(1)
 			}
 
-			fmt.Printf("Server listening on ws://localhost:%d\n", server.Port())
+			scheme := "ws"
+			if server.TLS() {
+				scheme = "wss"
+			}
+			fmt.Printf("Server listening on %s://%s\n", scheme, net.JoinHostPort(host, strconv.Itoa(server.Port())))
+			if token != "" {
+				fmt.Println("Bearer token authentication enabled")
+			}
 			fmt.Println("Press Ctrl+C to stop...")
 
 			// Wait for signal
@@ -67,5 +156,17 @@ func newServeCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().IntP("port", "p", 9515, "Port to listen on")
+	cmd.Flags().String("host", api.DefaultBindAddress, "Address to bind to (0.0.0.0 = all interfaces)")
+	cmd.Flags().String("token", "", "Require this bearer token from clients (default $VIBIUM_SERVE_TOKEN)")
+	cmd.Flags().String("tls-cert", "", "TLS certificate file (serve wss://, requires --tls-key)")
+	cmd.Flags().String("tls-key", "", "TLS private key file")
+	cmd.Flags().StringSlice("allowed-origin", nil, "Allowed browser Origin for WebSocket upgrades (repeatable; default any)")
+	cmd.Flags().Int("pool-max", 0, "Maximum number of pooled browsers (0 = launch one browser per client)")
+	cmd.Flags().Int("pool-min-idle", 1, "Number of pre-launched browsers kept idle (with --pool-max)")
+	cmd.Flags().Duration("pool-wait-timeout", 30*time.Second, "How long a client waits for a browser when the pool is exhausted")
+	cmd.Flags().Duration("resume-grace", 0, "Keep a disconnected client's browser session this long for a resume (0 = close immediately)")
+	cmd.Flags().Int("resume-buffer", api.DefaultResumeBuffer, "Maximum browser messages buffered for a detached session")
+	cmd.Flags().String("trace-endpoint", "", "Export command traces to this OTLP/HTTP collector, e.g. "+tracing.DefaultEndpoint+" (default $VIBIUM_TRACE_ENDPOINT)")
+	cmd.Flags().String("trace-file", "", "Append command traces to this file as OTLP/JSON (default $VIBIUM_TRACE_FILE)")
 	return cmd
 }
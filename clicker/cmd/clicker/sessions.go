@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newSessionsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sessions",
+		Short: "List browser sessions",
+		Example: `  vibium sessions
+  # default: running, https://example.com
+  # alice: running, https://chat.example.com
+
+  vibium start --session alice
+  # Start a separate browser named "alice"
+
+  vibium go https://chat.example.com --session alice
+  # Act in it; other commands take --session too`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			result, err := daemonCall("browser_list_sessions", map[string]interface{}{})
+			if err != nil {
+				printError(err)
+				return
+			}
+			printResult(result)
+		},
+	}
+}
@@ -24,12 +24,15 @@ func newSkillCmd() *cobra.Command {
   vibium add-skill --stdout
   # Print skill content to stdout`,
 		Args: cobra.NoArgs,
-		RunE: func(cmd *cobra.Command, args []string) error {
+		Run: func(cmd *cobra.Command, args []string) {
 			if stdout {
 				fmt.Print(skillMD)
-				return nil
+				return
+			}
+			if err := installSkill(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitError)
 			}
-			return installSkill()
 		},
 	}
 	cmd.Flags().BoolVar(&stdout, "stdout", false, "Print skill content to stdout instead of installing")
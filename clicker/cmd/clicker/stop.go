@@ -9,7 +9,10 @@ func newStopCmd() *cobra.Command {
 		Use:   "stop",
 		Short: "Stop the browser session",
 		Example: `  vibium stop
-  # Stop the browser and daemon`,
+  # Stop the browser and daemon
+
+  vibium stop --session alice
+  # Stop only the named session "alice"`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			result, err := daemonCall("browser_stop", map[string]interface{}{})
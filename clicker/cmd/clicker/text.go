@@ -5,7 +5,7 @@ import (
 )
 
 func newTextCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "text [selector]",
 		Short: "Get text content of the page or an element",
 		Example: `  vibium text
@@ -18,10 +18,17 @@ func newTextCmd() *cobra.Command {
   # Navigate then get all page text
 
   vibium text https://example.com "h1"
-  # Navigate then get element text`,
+  # Navigate then get element text
+
+  vibium text --outline
+  # Get only the headings and landmarks
+
+  vibium text --max-chars 5000
+  # Get the first 5000 characters; rerun with --cursor for more`,
 		Args: cobra.MaximumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			toolArgs := map[string]interface{}{}
+			pagingArgs(cmd, toolArgs)
 			if len(args) == 2 {
 				// text <url> <selector> — navigate first
 				_, err := daemonCall("browser_navigate", map[string]interface{}{"url": args[0]})
@@ -52,4 +59,6 @@ func newTextCmd() *cobra.Command {
 			printResult(result)
 		},
 	}
+	addPagingFlags(cmd, true)
+	return cmd
 }
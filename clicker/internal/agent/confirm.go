@@ -0,0 +1,507 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vibium/clicker/internal/api"
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/log"
+)
+
+// Confirmation gate: before a sensitive action runs, a human is asked to
+// approve it — through MCP elicitation when the client supports it (see
+// Server.elicit), otherwise with a prompt on the controlling terminal. A
+// declined action fails with an ActionDeclinedError. Every decision is
+// logged, and noted on the action in the recording when one is running.
+
+// Confirmation rules for NewConfirmPolicy.
+const (
+	ConfirmSubmit = "submit" // clicks and Enter presses that submit a form
+	ConfirmClick  = "click"  // clicks on elements whose label matches the label patterns
+	ConfirmOrigin = "origin" // navigating to an origin not visited or approved before
+	ConfirmAll    = "all"
+)
+
+// defaultConfirmLabels is the label pattern for the click rule when none is
+// given.
+const defaultConfirmLabels = `\b(delete|remove|pay|purchase|buy|order|confirm|transfer|send)\b`
+
+// How long to wait for an answer before declining. A terminal prompt must
+// be answered before the CLI gives up on the daemon (60s).
+const (
+	elicitTimeout = 5 * time.Minute
+	promptTimeout = 45 * time.Second
+)
+
+// ConfirmPolicy says which actions need a human's approval.
+type ConfirmPolicy struct {
+	Submit bool
+	Origin bool
+	Labels *regexp.Regexp // click rule; nil = off
+}
+
+// NewConfirmPolicy builds a policy from rule names (submit, click, origin,
+// all). labels are regexps for the click rule, matched case-insensitively;
+// giving any turns the click rule on. It returns nil if no rule is on.
+func NewConfirmPolicy(rules []string, labels []string) (*ConfirmPolicy, error) {
+	p := &ConfirmPolicy{}
+	click := len(labels) > 0
+	for _, rule := range rules {
+		switch strings.ToLower(strings.TrimSpace(rule)) {
+		case ConfirmSubmit:
+			p.Submit = true
+		case ConfirmClick:
+			click = true
+		case ConfirmOrigin:
+			p.Origin = true
+		case ConfirmAll:
+			p.Submit, p.Origin, click = true, true, true
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown confirmation rule %q (expected %s, %s, %s or %s)", rule, ConfirmSubmit, ConfirmClick, ConfirmOrigin, ConfirmAll)
+		}
+	}
+	if click {
+		pattern := defaultConfirmLabels
+		if len(labels) > 0 {
+			pattern = "(?:" + strings.Join(labels, ")|(?:") + ")"
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label pattern: %w", err)
+		}
+		p.Labels = re
+	}
+	if !p.Submit && !p.Origin && p.Labels == nil {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// SetConfirmPolicy sets the actions that need approval. nil turns the gate
+// off. Named sessions inherit it.
+func (h *Handlers) SetConfirmPolicy(p *ConfirmPolicy) {
+	h.confirm = p
+}
+
+// confirmAction asks for approval if the tool call is a sensitive action,
+// and returns an error unless it was approved.
+func (h *Handlers) confirmAction(name string, args map[string]interface{}) error {
+	if h.confirm == nil {
+		return nil
+	}
+	action, origin, err := h.sensitiveAction(name, args)
+	if err != nil {
+		// Fail closed: an action that couldn't be checked isn't run
+		log.Info("confirmation", "tool", name, "decision", "not checked: "+err.Error())
+		return &errs.ActionDeclinedError{Action: name, Reason: "couldn't check the action: " + err.Error()}
+	}
+	if action == "" {
+		return nil
+	}
+
+	approved, via, err := h.askConfirmation(action)
+	decision := "declined"
+	if approved {
+		decision = "approved"
+	}
+	if err != nil {
+		decision = "not confirmed: " + err.Error()
+	}
+	log.Info("confirmation", "action", action, "decision", decision, "via", via)
+	if h.recorder != nil && h.call != nil && h.call.callId != "" {
+		h.recorder.RecordLog(h.call.callId, fmt.Sprintf("confirmation: %s — %s (%s)", action, decision, via))
+	}
+
+	if err != nil {
+		if _, ok := err.(*errs.CancelledError); ok {
+			return err
+		}
+		return &errs.ActionDeclinedError{Action: action, Reason: err.Error()}
+	}
+	if !approved {
+		return &errs.ActionDeclinedError{Action: action}
+	}
+	if origin != "" {
+		h.approveOrigin(origin)
+	}
+	return nil
+}
+
+// sensitiveAction describes the tool call if the policy needs it approved,
+// or returns "". For navigations it also returns the new origin.
+func (h *Handlers) sensitiveAction(name string, args map[string]interface{}) (string, string, error) {
+	switch name {
+	case "browser_navigate", "browser_new_page":
+		target, _ := args["url"].(string)
+		if !h.confirm.Origin || target == "" {
+			return "", "", nil
+		}
+		origin := urlOrigin(target)
+		if origin == "" || h.knownOrigin(origin) {
+			return "", "", nil
+		}
+		return fmt.Sprintf("Navigate to new origin %s (%s)", origin, target), origin, nil
+
+	case "browser_back", "browser_forward":
+		if !h.confirm.Origin || h.client == nil {
+			return "", "", nil
+		}
+		delta, verb := -1, "Go back"
+		if name == "browser_forward" {
+			delta, verb = 1, "Go forward"
+		}
+		target, err := h.historyTarget(delta)
+		if err != nil || target == "" {
+			return "", "", err
+		}
+		if target != "?" {
+			// A same-origin entry, which the Navigation API lists
+			return "", "", nil
+		}
+		return verb + " to a page that may be on another origin", "", nil
+
+	case "browser_mouse_click":
+		if h.client == nil {
+			return "", "", nil
+		}
+		x, hasX := args["x"].(float64)
+		y, hasY := args["y"].(float64)
+		if !hasX || !hasY {
+			// Nothing to check the element under the mouse by
+			return "Click at the current mouse position", "", nil
+		}
+		info, err := h.targetInfo(targetQuery{Mode: "point", X: x, Y: y})
+		if err != nil || info == nil {
+			return "", "", err
+		}
+		return h.sensitiveTarget(fmt.Sprintf("Click at (%d, %d) on", int(x), int(y)), "", info, true)
+
+	case "browser_select":
+		selector, _ := args["selector"].(string)
+		value, _ := args["value"].(string)
+		if selector == "" || h.client == nil {
+			return "", "", nil
+		}
+		info, err := h.targetInfo(targetQuery{Mode: "select", Selector: h.resolveSelector(selector), Value: value})
+		if err != nil || info == nil {
+			return "", "", err
+		}
+		return h.sensitiveTarget(fmt.Sprintf("Select %q in", value), selector, info, false)
+
+	case "browser_click", "browser_dblclick", "browser_press", "browser_keys", "browser_type":
+		selector, _ := args["selector"].(string)
+		enter := false
+		switch name {
+		case "browser_press":
+			key, _ := args["key"].(string)
+			enter = pressesEnter(key)
+		case "browser_keys":
+			keys, _ := args["keys"].(string)
+			enter = pressesEnter(keys)
+		case "browser_type":
+			text, _ := args["text"].(string)
+			enter = strings.ContainsAny(text, "\r\n")
+		}
+		if (name == "browser_press" || name == "browser_keys" || name == "browser_type") && !enter {
+			return "", "", nil
+		}
+		if (name == "browser_click" || name == "browser_dblclick" || name == "browser_type") && selector == "" {
+			return "", "", nil
+		}
+		if name == "browser_keys" {
+			// Keys go to the focused element
+			selector = ""
+		}
+		if h.client == nil {
+			return "", "", nil
+		}
+		mode := "click"
+		if enter {
+			mode = "enter"
+		}
+		info, err := h.targetInfo(targetQuery{Mode: mode, Selector: h.resolveSelector(selector)})
+		if err != nil || info == nil {
+			return "", "", err
+		}
+		verb := "Click"
+		if enter {
+			verb = "Press Enter in"
+		}
+		return h.sensitiveTarget(verb, selector, info, !enter)
+	}
+	return "", "", nil
+}
+
+// sensitiveTarget describes an action on an element if the policy needs it
+// approved, or returns "". verb is the action ("Click", "Press Enter in");
+// label names the element if it has no label of its own. byLabel says
+// whether the click rule's label patterns apply.
+func (h *Handlers) sensitiveTarget(verb, label string, info *targetInfo, byLabel bool) (string, string, error) {
+	h.approveOrigin(info.Origin)
+	if info.Label != "" {
+		label = info.Label
+	}
+	switch {
+	case h.confirm.Submit && info.Submits:
+		return fmt.Sprintf("%s %q, submitting a form on %s to %s", verb, label, info.Origin, info.Action), "", nil
+	case byLabel && h.confirm.Labels != nil && h.confirm.Labels.MatchString(info.Label):
+		return fmt.Sprintf("%s %q on %s", verb, label, info.Origin), "", nil
+	case h.confirm.Origin && info.Href != "":
+		if origin := urlOrigin(info.Href); origin != "" && !h.knownOrigin(origin) {
+			return fmt.Sprintf("%s %q, going to new origin %s (%s)", verb, label, origin, info.Href), origin, nil
+		}
+	}
+	return "", "", nil
+}
+
+// pressesEnter reports whether a key or key combination (Control+Enter)
+// ends with Enter.
+func pressesEnter(keys string) bool {
+	parts := strings.Split(keys, "+")
+	return strings.EqualFold(strings.TrimSpace(parts[len(parts)-1]), "Enter")
+}
+
+// historyTarget returns the URL of the session history entry delta steps
+// away, if the Navigation API lists it (it lists only the page's own
+// origin); "?" if there may be a cross-origin entry there; or "" if there
+// is none.
+func (h *Handlers) historyTarget(delta int) (string, error) {
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return "", err
+	}
+	result, err := h.client.CallFunction(ctx, `(delta) => {
+		if (window.navigation && navigation.currentEntry) {
+			const entry = navigation.entries()[navigation.currentEntry.index + delta];
+			if (entry) return entry.url;
+		}
+		return history.length > 1 ? '?' : '';
+	}`, []interface{}{delta})
+	if err != nil {
+		return "", err
+	}
+	s, _ := result.(string)
+	return s, nil
+}
+
+// targetInfo is what confirmation needs to know about an action's element.
+type targetInfo struct {
+	Label   string `json:"label"`
+	Submits bool   `json:"submits"`
+	Action  string `json:"action"` // form action URL, when Submits
+	Href    string `json:"href"`   // link target
+	Origin  string `json:"origin"` // origin of the page
+}
+
+// targetQuery says which element targetInfo looks up and for what.
+type targetQuery struct {
+	Mode     string  `json:"mode"`     // click, enter (an Enter press), select, or point (a click at X, Y)
+	Selector string  `json:"selector"` // "" = the focused element
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Value    string  `json:"value"` // option to select
+}
+
+// targetInfo looks up the element an action acts on.
+func (h *Handlers) targetInfo(q targetQuery) (*targetInfo, error) {
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.client.CallFunction(ctx, targetInfoScript(), []interface{}{q.Mode, q.Selector, q.X, q.Y, q.Value})
+	if err != nil {
+		return nil, err
+	}
+	s, _ := result.(string)
+	if s == "" {
+		return nil, nil
+	}
+	var info targetInfo
+	if err := json.Unmarshal([]byte(s), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// targetInfoScript returns the JS function behind targetInfo.
+func targetInfoScript() string {
+	return `(mode, selector, x, y, value) => {
+		` + api.ShadowDOMJS() + `
+		let el;
+		if (mode === 'point') {
+			el = document.elementFromPoint(x, y);
+			// The element may be inside a shadow root
+			while (el && el.shadowRoot) {
+				const inner = el.shadowRoot.elementFromPoint(x, y);
+				if (!inner || inner === el) break;
+				el = inner;
+			}
+		} else {
+			el = selector ? querySelectorDeep(document, selector) : document.activeElement;
+			while (!selector && el && el.shadowRoot && el.shadowRoot.activeElement) el = el.shadowRoot.activeElement;
+		}
+		if (!el) return '';
+		const clean = text => String(text || '').trim().replace(/\s+/g, ' ').slice(0, 100);
+
+		if (mode === 'select') {
+			// A select submits its form if it has a change handler inline, or
+			// if the form has no submit button to do it; a jump menu's option
+			// values are URLs
+			const select = el.closest('select') || el;
+			const form = select.form;
+			const label = clean(select.getAttribute('aria-label') || (select.labels && select.labels[0] && select.labels[0].innerText) || select.name);
+			const submits = select.hasAttribute('onchange') || (!!form && (form.hasAttribute('onchange') ||
+				!form.querySelector('button:not([type=button]):not([type=reset]), input[type=submit], input[type=image]')));
+			const option = Array.from(select.options || []).find(o => o.value === value || clean(o.text) === value);
+			const target = option ? option.value : value;
+			let href = '';
+			if (/^(https?:)?\/\//.test(target) || target.startsWith('/')) {
+				try { href = new URL(target, location.href).href; } catch (e) {}
+			}
+			const action = submits ? (form ? form.action : location.href) : '';
+			return JSON.stringify({ label, submits, action, href, origin: location.origin });
+		}
+
+		const enter = mode === 'enter';
+		const target = el.closest('button, input, a[href], [role=button], [role=link]') || el;
+		const label = clean(target.getAttribute('aria-label') || target.innerText || target.value || target.title || target.getAttribute('alt'));
+		const type = (target.getAttribute('type') || '').toLowerCase();
+		let submits = false;
+		if (target.form) {
+			if (enter) {
+				submits = target.tagName === 'INPUT' && !['button', 'checkbox', 'radio', 'reset', 'file', 'color', 'range'].includes(type);
+			} else {
+				submits = (target.tagName === 'BUTTON' && (type === '' || type === 'submit')) ||
+					(target.tagName === 'INPUT' && (type === 'submit' || type === 'image'));
+			}
+		}
+		const action = submits ? (target.formAction || target.form.action || location.href) : '';
+		const href = !enter && target.tagName === 'A' ? target.href : '';
+		return JSON.stringify({ label, submits, action, href, origin: location.origin });
+	}`
+}
+
+// knownOrigin reports whether origin was approved before or is the current
+// page's.
+func (h *Handlers) knownOrigin(origin string) bool {
+	if origin == "" || origin == "null" {
+		return true
+	}
+	if h.origins[origin] {
+		return true
+	}
+	if h.client != nil {
+		// The page's own origin counts as visited
+		if ctx, err := h.newSession().GetContextID(); err == nil {
+			if current, err := h.client.CallFunction(ctx, "() => location.origin", nil); err == nil {
+				h.approveOrigin(fmt.Sprint(current))
+			}
+		}
+	}
+	return h.origins[origin]
+}
+
+func (h *Handlers) approveOrigin(origin string) {
+	if origin == "" || origin == "null" {
+		return
+	}
+	if h.origins == nil {
+		h.origins = make(map[string]bool)
+	}
+	h.origins[origin] = true
+}
+
+// urlOrigin returns scheme://host[:port] of an http(s) URL, or "".
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// askConfirmation asks whether to go ahead with action, through the call's
+// Confirm hook or else the terminal. via says how it asked.
+func (h *Handlers) askConfirmation(action string) (bool, string, error) {
+	var cancel <-chan struct{}
+	if h.call != nil {
+		cancel = h.call.opts.Cancel
+		if h.call.opts.Confirm != nil {
+			approved, err := h.call.opts.Confirm(action)
+			return approved, "client", err
+		}
+	}
+	approved, err := promptTerminal(action, cancel)
+	return approved, "terminal", err
+}
+
+// ttyMu keeps prompts from several sessions from interleaving.
+var ttyMu sync.Mutex
+
+// promptTerminal asks a yes/no question on the controlling terminal.
+func promptTerminal(question string, cancel <-chan struct{}) (bool, error) {
+	ttyMu.Lock()
+	defer ttyMu.Unlock()
+
+	in, out, err := openTerminal()
+	if err != nil {
+		return false, fmt.Errorf("no MCP elicitation and no terminal to ask on")
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	fmt.Fprintf(out, "\nvibium: %s?\nAllow? [y/N] ", question)
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answer <- line
+	}()
+
+	timer := time.NewTimer(promptTimeout)
+	defer timer.Stop()
+	select {
+	case line := <-answer:
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		}
+		return false, nil
+	case <-cancel:
+		fmt.Fprintln(out, "(cancelled)")
+		return false, &errs.CancelledError{Method: "confirmation"}
+	case <-timer.C:
+		fmt.Fprintln(out, "(timed out)")
+		return false, fmt.Errorf("no answer within %s", promptTimeout)
+	}
+}
+
+// openTerminal opens the controlling terminal for reading and writing.
+func openTerminal() (in, out *os.File, err error) {
+	if runtime.GOOS == "windows" {
+		if in, err = os.Open("CONIN$"); err != nil {
+			return nil, nil, err
+		}
+		if out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0); err != nil {
+			in.Close()
+			return nil, nil, err
+		}
+		return in, out, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
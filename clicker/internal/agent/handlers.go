@@ -8,12 +8,15 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/vibium/clicker/internal/bidi"
 	"github.com/vibium/clicker/internal/browser"
+	errs "github.com/vibium/clicker/internal/errors"
 	"github.com/vibium/clicker/internal/log"
 	"github.com/vibium/clicker/internal/api"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 // Handlers manages browser session state and executes tool calls.
@@ -25,14 +28,56 @@ type Handlers struct {
 	headless       bool
 	connectURL     string      // remote BiDi WebSocket URL (empty = local browser)
 	connectHeaders http.Header // headers for remote WebSocket connection
-	refMap         map[string]string // @e1 -> CSS selector
-	lastMap        string            // last map output (for diff)
+	refMap         map[string]*elementRef // @e1 -> element; see refs.go
+	lastMap        *mapSnapshot      // last map, for diff; see mapdiff.go
 	recorder       *api.Recorder
 	downloadDir    string
 	lastElementBox *api.BoxInfo // stashed by AgentSession.SetLastElementBox via callback
 	activeContext  string         // last page context switched to or created
+	span           *tracing.Span  // span of the tool call in progress; nil when not tracing
+	recordings     []string       // absolute paths of recordings saved this session
+	call           *callState     // tool call in progress; nil between calls
+	eventMu        sync.Mutex     // guards eventRecorder
+	eventRecorder  *api.Recorder  // recorder BiDi events are forwarded to; nil = none
+	routes         routeTable     // browser_route rules
+	policy         *NavigationPolicy // allowed/blocked URLs; nil = any
+	guard          navGuard          // enforces policy on page-started navigations
+	gate           interceptGate     // blocked requests seen while an intercept is added
+	tools          *ToolFilter       // tools offered; nil = all
+	confirm        *ConfirmPolicy    // actions that need approval; nil = none
+	origins        map[string]bool   // origins visited or approved, for confirm
+	sessions       map[string]*Handlers // named sessions from browser_start; see sessions.go
 }
 
+// CallOptions carries per-call settings for CallWithOptions.
+type CallOptions struct {
+	// TraceParent is a W3C traceparent value the call's span joins
+	// ("" starts a new trace).
+	TraceParent string
+	// Cancel aborts the call when closed: waits stop and the pending BiDi
+	// command is abandoned, and the call returns a CancelledError.
+	Cancel <-chan struct{}
+	// Progress, if set, receives messages such as
+	// "waiting for selector, 12s elapsed" while the call is waiting.
+	Progress func(message string)
+	// Confirm, if set, asks the client to approve a sensitive action (see
+	// confirm.go). Without it, the terminal is asked.
+	Confirm func(action string) (bool, error)
+}
+
+// callState tracks the tool call in progress for cancellation and progress.
+type callState struct {
+	name         string
+	callId       string // recording call ID; "" when not recording
+	opts         CallOptions
+	start        time.Time
+	lastProgress time.Time
+	nodes        map[string]string // selectors of the call's refs, to their elements' shared IDs; see resolveRefs
+}
+
+// progressInterval is the minimum time between progress messages.
+const progressInterval = time.Second
+
 // NewHandlers creates a new Handlers instance.
 // screenshotDir specifies where screenshots are saved. If empty, file saving is disabled.
 // headless controls whether the browser is launched in headless mode.
@@ -50,9 +95,14 @@ func NewHandlers(screenshotDir string, headless bool, connectURL string, connect
 func (h *Handlers) newSession() *api.AgentSession {
 	s := api.NewAgentSession(h.client)
 	s.Context = h.activeContext
+	s.Span = h.span
+	s.Wait = h.wait
 	s.OnBoxSet = func(box *api.BoxInfo) {
 		h.lastElementBox = box
 	}
+	if h.call != nil {
+		s.Nodes = h.call.nodes
+	}
 	return s
 }
 
@@ -61,8 +111,38 @@ func (h *Handlers) newSession() *api.AgentSession {
 // to produce before/after events (matching the API path), and captures a
 // screenshot after each non-recording action completes.
 func (h *Handlers) Call(name string, args map[string]interface{}) (*ToolsCallResult, error) {
+	return h.CallWithOptions(name, args, CallOptions{})
+}
+
+// CallWithOptions is like Call, with tracing, cancellation and progress
+// reporting as set in opts. Each BiDi command the tool sends becomes a child
+// span of the call's span.
+func (h *Handlers) CallWithOptions(name string, args map[string]interface{}, opts CallOptions) (*ToolsCallResult, error) {
+	if session, ok := args["session"].(string); ok {
+		args = withoutArg(args, "session")
+		if session != "" && session != DefaultSession {
+			return h.callInSession(session, name, args, opts)
+		}
+	}
+
 	log.Debug("tool call", "name", name, "args", args)
 
+	now := time.Now()
+	h.call = &callState{name: name, opts: opts, start: now, lastProgress: now}
+	h.span = tracing.StartRemote(name, tracing.KindServer, opts.TraceParent)
+	if h.client != nil {
+		h.client.SetSpan(h.span)
+		h.client.SetAbort(opts.Cancel)
+	}
+	defer func() {
+		if h.client != nil {
+			h.client.SetSpan(nil)
+			h.client.SetAbort(nil)
+		}
+		h.span = nil
+		h.call = nil
+	}()
+
 	// Inject a synthetic find trace event before selector-based actions
 	// so CLI recordings match the JS client's find→action pairs.
 	// Skip @e refs — those come from an explicit find the user already ran.
@@ -80,9 +160,16 @@ func (h *Handlers) Call(name string, args map[string]interface{}) (*ToolsCallRes
 		recordArgs := h.resolveRefsInArgs(args)
 		h.recorder.RecordAction(callId, mcpToolToMethod(name), recordArgs, "", pageId)
 		h.lastElementBox = nil
+		h.call.callId = callId
 	}
 
 	result, err := h.dispatch(name, args)
+	// A navigation the page started during the call, or since the last one,
+	// was blocked (this also replaces the error of a browser_navigate that
+	// redirected off-policy)
+	if blocked := h.guard.take(); blocked != nil {
+		result, err = nil, blocked
+	}
 
 	endTime := time.Now()
 
@@ -99,11 +186,44 @@ func (h *Handlers) Call(name string, args map[string]interface{}) (*ToolsCallRes
 		h.recorder.RecordActionEnd(callId, "", endTime, box)
 	}
 
+	h.span.End(err)
 	return result, err
 }
 
+// withoutArg returns a copy of args without key.
+func withoutArg(args map[string]interface{}, key string) map[string]interface{} {
+	out := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
 // dispatch routes a tool call to the appropriate handler method.
 func (h *Handlers) dispatch(name string, args map[string]interface{}) (*ToolsCallResult, error) {
+	if err := h.checkTool(name, args); err != nil {
+		return nil, err
+	}
+	resolved, err := h.resolveRefs(args)
+	if stale, ok := err.(*errs.StaleRefError); ok && name == "browser_wait" && args["state"] == "hidden" && h.refMap[stale.Ref] != nil {
+		// The ref's element is gone, which is as hidden as it gets
+		return &ToolsCallResult{
+			Content: []Content{{
+				Type: "text",
+				Text: fmt.Sprintf("Element %q reached state: hidden (%s)", stale.Ref, stale.Reason),
+			}},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	args = resolved
+	if err := h.confirmAction(name, args); err != nil {
+		return nil, err
+	}
+
 	switch name {
 	case "browser_start":
 		return h.browserLaunch(args)
@@ -121,6 +241,8 @@ func (h *Handlers) dispatch(name string, args map[string]interface{}) (*ToolsCal
 		return h.browserEvaluate(args)
 	case "browser_stop":
 		return h.browserQuit(args)
+	case "browser_list_sessions":
+		return h.browserListSessions(args)
 	case "browser_get_text":
 		return h.browserGetText(args)
 	case "browser_get_url":
@@ -199,6 +321,8 @@ func (h *Handlers) dispatch(name string, args map[string]interface{}) (*ToolsCal
 		return h.browserMap(args)
 	case "browser_diff_map":
 		return h.browserDiffMap(args)
+	case "browser_locate":
+		return h.browserLocate(args)
 	case "browser_pdf":
 		return h.browserPDF(args)
 	case "browser_highlight":
@@ -221,6 +345,12 @@ func (h *Handlers) dispatch(name string, args map[string]interface{}) (*ToolsCal
 		return h.browserDialogAccept(args)
 	case "browser_dialog_dismiss":
 		return h.browserDialogDismiss(args)
+	case "browser_route":
+		return h.browserRoute(args)
+	case "browser_unroute":
+		return h.browserUnroute(args)
+	case "browser_list_routes":
+		return h.browserListRoutes(args)
 	case "browser_get_cookies":
 		return h.browserGetCookies(args)
 	case "browser_set_cookie":
@@ -481,6 +611,12 @@ func mcpToolToMethod(name string) string {
 	case "browser_get_window":
 		return "vibium:page.window"
 
+	// Network
+	case "browser_route":
+		return "vibium:page.route"
+	case "browser_unroute":
+		return "vibium:page.unroute"
+
 	// Cookies/storage
 	case "browser_get_cookies":
 		return "vibium:context.cookies"
@@ -530,6 +666,8 @@ func mcpToolToMethod(name string) string {
 		return "vibium:page.eval"
 	case "browser_diff_map":
 		return "vibium:page.eval"
+	case "browser_locate":
+		return "vibium:page.eval"
 	case "browser_highlight":
 		return "vibium:page.eval"
 
@@ -558,10 +696,21 @@ func mcpToolToMethod(name string) string {
 
 // Close cleans up any active browser sessions.
 func (h *Handlers) Close() {
+	h.closeSessions()
+	h.closeBrowser()
+}
+
+// closeBrowser closes this session's browser.
+func (h *Handlers) closeBrowser() {
 	// Remote mode: end the BiDi session so chromedriver closes Chrome
 	if h.connectURL != "" && h.client != nil {
 		h.client.SendCommand("session.end", map[string]interface{}{})
 	}
+	// Events still arriving must not reach a session being torn down
+	if h.client != nil {
+		h.client.SetEventHandler(nil)
+	}
+	h.setEventRecorder(nil)
 	if h.conn != nil {
 		h.conn.Close()
 		h.conn = nil
@@ -571,6 +720,69 @@ func (h *Handlers) Close() {
 		h.launchResult = nil
 	}
 	h.client = nil
+	h.routes.reset()
+	h.guard.reset()
+}
+
+// listen starts reading the browser's messages in the background, so its
+// events are handled between tool calls too (see bidi.Client.Listen).
+func (h *Handlers) listen() {
+	h.client.SetEventHandler(h.eventHandler(h.client, h.policy))
+	h.client.Listen()
+}
+
+// eventHandler returns the handler for the BiDi events of client. It runs on
+// the client's reader goroutine at any time, during a tool call or between
+// calls, so it keeps to the client and policy it was made with rather than
+// reading h.client, which closeBrowser clears, and reads the recorder under
+// eventMu.
+func (h *Handlers) eventHandler(client *bidi.Client, policy *NavigationPolicy) func(msg string) {
+	return func(msg string) {
+		h.eventMu.Lock()
+		if h.eventRecorder != nil {
+			h.eventRecorder.RecordBidiEvent(msg)
+		}
+		h.eventMu.Unlock()
+		if ev := parseBlockedRequest(msg); ev != nil {
+			h.handleBlocked(client, policy, ev, true)
+		}
+	}
+}
+
+// setEventRecorder starts or (with nil) stops forwarding BiDi events to a
+// recorder. Once it returns, no event is being recorded any more.
+func (h *Handlers) setEventRecorder(recorder *api.Recorder) {
+	h.eventMu.Lock()
+	h.eventRecorder = recorder
+	h.eventMu.Unlock()
+}
+
+// handleBlocked answers a blocked request. One no intercept claims is held
+// while an intercept is being added (if hold), or else let through: it was
+// blocked by an intercept just removed.
+func (h *Handlers) handleBlocked(client *bidi.Client, policy *NavigationPolicy, ev *blockedRequest, hold bool) {
+	if h.guard.handle(client, policy, ev) || h.routes.handle(client, ev) {
+		return
+	}
+	if !h.guard.owns(ev) && hold && h.gate.hold(ev) {
+		return
+	}
+	if err := client.SendCommandNoWait("network.continueRequest", map[string]interface{}{
+		"request": ev.Params.Request.Request,
+	}); err != nil {
+		log.Debug("continueRequest failed", "request", ev.Params.Request.Request, "error", err)
+	}
+}
+
+// addIntercept runs add, which adds a BiDi intercept, holding the requests
+// blocked before it has recorded the intercept's ID until it returns.
+func (h *Handlers) addIntercept(add func(*bidi.Client) error) error {
+	h.gate.begin()
+	err := add(h.client)
+	for _, ev := range h.gate.end() {
+		h.handleBlocked(h.client, h.policy, ev, false)
+	}
+	return err
 }
 
 // browserLaunch launches a new browser session or connects to a remote one.
@@ -593,6 +805,12 @@ func (h *Handlers) browserLaunch(args map[string]interface{}) (*ToolsCallResult,
 		}
 		h.conn = conn
 		h.client = client
+		// Answer blocked requests between tool calls too
+		h.listen()
+		if err := h.startNavGuard(); err != nil {
+			h.closeBrowser()
+			return nil, err
+		}
 
 		return &ToolsCallResult{
 			Content: []Content{{
@@ -629,6 +847,12 @@ func (h *Handlers) browserLaunch(args map[string]interface{}) (*ToolsCallResult,
 	h.launchResult = launchResult
 	h.conn = conn
 	h.client = bidi.NewClient(conn)
+	// Answer blocked requests between tool calls too
+	h.listen()
+	if err := h.startNavGuard(); err != nil {
+		h.closeBrowser()
+		return nil, err
+	}
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -648,6 +872,9 @@ func (h *Handlers) browserNavigate(args map[string]interface{}) (*ToolsCallResul
 	if !ok || url == "" {
 		return nil, fmt.Errorf("url is required")
 	}
+	if err := h.checkNavigation(url); err != nil {
+		return nil, err
+	}
 
 	s := h.newSession()
 	ctx, err := s.GetContextID()
@@ -748,15 +975,16 @@ func (h *Handlers) browserScreenshot(args map[string]interface{}) (*ToolsCallRes
 		selectors := make([]string, 0, len(h.refMap))
 		for i := 1; i <= len(h.refMap); i++ {
 			ref := fmt.Sprintf("@e%d", i)
-			if sel, ok := h.refMap[ref]; ok {
-				selectors = append(selectors, sel)
+			if r, ok := h.refMap[ref]; ok {
+				selectors = append(selectors, r.Selector)
 			}
 		}
 
 		annotateScript := `(selectors) => {
+			` + api.ShadowDOMJS() + `
 			let count = 0;
 			for (let i = 0; i < selectors.length; i++) {
-				const el = document.querySelector(selectors[i]);
+				const el = querySelectorDeep(document, selectors[i]);
 				if (!el) continue;
 				const rect = el.getBoundingClientRect();
 				if (rect.width === 0 || rect.height === 0) continue;
@@ -884,9 +1112,9 @@ func (h *Handlers) browserFind(args map[string]interface{}) (*ToolsCallResult, e
 			return nil, fmt.Errorf("failed to parse find result: %w", err)
 		}
 
-		// Store ref in refMap
-		h.refMap = make(map[string]string)
-		h.refMap["@e1"] = found.Selector
+		if err := h.setRefs([]string{found.Selector}); err != nil {
+			return nil, err
+		}
 
 		return &ToolsCallResult{
 			Content: []Content{{
@@ -905,8 +1133,9 @@ func (h *Handlers) browserFind(args map[string]interface{}) (*ToolsCallResult, e
 
 	// Run getLabel in browser to get consistent label format (with scroll-into-view)
 	labelScript := `(selector) => {
+		` + api.ShadowDOMJS() + `
 		` + GetLabelJS() + `
-		const el = document.querySelector(selector);
+		const el = querySelectorDeep(document, selector);
 		if (!el) return null;
 		if (el.scrollIntoViewIfNeeded) {
 			el.scrollIntoViewIfNeeded(true);
@@ -915,14 +1144,18 @@ func (h *Handlers) browserFind(args map[string]interface{}) (*ToolsCallResult, e
 		}
 		return getLabel(el);
 	}`
-	labelResult, err := h.client.CallFunction("", labelScript, []interface{}{selector})
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return nil, err
+	}
+	labelResult, err := h.client.CallFunction(ctx, labelScript, []interface{}{selector})
 	if err != nil {
 		return nil, err
 	}
 
-	// Store ref in refMap
-	h.refMap = make(map[string]string)
-	h.refMap["@e1"] = selector
+	if err := h.setRefs([]string{selector}); err != nil {
+		return nil, err
+	}
 
 	labelStr := fmt.Sprintf("%v", labelResult)
 	return &ToolsCallResult{
@@ -937,6 +1170,7 @@ func (h *Handlers) browserFind(args map[string]interface{}) (*ToolsCallResult, e
 // Returns JSON: {"selector":"...","label":"...","tag":"...","text":"...","box":{...}}
 func findBySemanticScript() string {
 	return `(role, text, label, placeholder, testid, xpath, alt, title) => {
+		` + api.ShadowDOMJS() + `
 		` + GetSelectorJS() + `
 		` + GetLabelJS() + `
 
@@ -996,13 +1230,13 @@ func findBySemanticScript() string {
 			const labelledBy = el.getAttribute('aria-labelledby');
 			if (labelledBy) {
 				const parts = labelledBy.split(/\s+/).map(id => {
-					const ref = document.getElementById(id);
+					const ref = el.getRootNode().getElementById(id);
 					return ref ? (ref.textContent || '').trim() : '';
 				}).filter(Boolean);
 				if (parts.length) return parts.join(' ');
 			}
 			if (el.id) {
-				const assocLabel = document.querySelector('label[for="' + el.id + '"]');
+				const assocLabel = el.getRootNode().querySelector('label[for="' + el.id + '"]');
 				if (assocLabel) return (assocLabel.textContent || '').trim();
 			}
 			const ph = el.getAttribute('placeholder');
@@ -1019,35 +1253,33 @@ func findBySemanticScript() string {
 		if (role) {
 			// Role-based matching: walk all elements, filter by role + other criteria
 			const roleLower = role.toLowerCase();
-			const walker = document.createTreeWalker(document.body, NodeFilter.SHOW_ELEMENT);
 			const found = [];
-			let node;
-			while (node = walker.nextNode()) {
-				if (getImplicitRole(node) !== roleLower) continue;
+			walkDeep(document.body, (node) => {
+				if (getImplicitRole(node) !== roleLower) return;
 				// Apply additional filters
-				if (text && !(node.textContent || '').trim().includes(text)) continue;
+				if (text && !(node.textContent || '').trim().includes(text)) return;
 				if (label) {
 					const elName = getName(node);
-					if (!elName.includes(label)) continue;
+					if (!elName.includes(label)) return;
 				}
 				if (placeholder) {
 					const ph = node.getAttribute('placeholder');
-					if (!ph || !ph.includes(placeholder)) continue;
+					if (!ph || !ph.includes(placeholder)) return;
 				}
 				if (testid) {
 					const tid = node.getAttribute('data-testid');
-					if (tid !== testid) continue;
+					if (tid !== testid) return;
 				}
 				if (alt) {
 					const a = node.getAttribute('alt');
-					if (!a || !a.includes(alt)) continue;
+					if (!a || !a.includes(alt)) return;
 				}
 				if (title) {
 					const t = node.getAttribute('title');
-					if (!t || !t.includes(title)) continue;
+					if (!t || !t.includes(title)) return;
 				}
 				found.push(node);
-			}
+			});
 			if (found.length === 0) return null;
 			// Pick best: prefer shortest text match if text filter is used
 			el = found[0];
@@ -1062,20 +1294,20 @@ func findBySemanticScript() string {
 			const xresult = document.evaluate(xpath, document, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null);
 			el = xresult.singleNodeValue;
 		} else if (testid) {
-			el = document.querySelector('[data-testid="' + testid.replace(/"/g, '\\"') + '"]');
+			el = querySelectorDeep(document, '[data-testid="' + testid.replace(/"/g, '\\"') + '"]');
 		} else if (placeholder) {
-			el = document.querySelector('[placeholder="' + placeholder.replace(/"/g, '\\"') + '"]');
+			el = querySelectorDeep(document, '[placeholder="' + placeholder.replace(/"/g, '\\"') + '"]');
 		} else if (alt) {
-			el = document.querySelector('[alt="' + alt.replace(/"/g, '\\"') + '"]');
+			el = querySelectorDeep(document, '[alt="' + alt.replace(/"/g, '\\"') + '"]');
 		} else if (title) {
-			el = document.querySelector('[title="' + title.replace(/"/g, '\\"') + '"]');
+			el = querySelectorDeep(document, '[title="' + title.replace(/"/g, '\\"') + '"]');
 		} else if (label) {
 			// Try <label> with for= attribute pointing to an input
-			const labels = document.querySelectorAll('label');
+			const labels = querySelectorAllDeep(document, 'label');
 			for (const lbl of labels) {
 				if (lbl.textContent.trim().includes(label)) {
 					if (lbl.htmlFor) {
-						el = document.getElementById(lbl.htmlFor);
+						el = lbl.getRootNode().getElementById(lbl.htmlFor);
 					} else {
 						el = lbl.querySelector('input, textarea, select');
 					}
@@ -1084,14 +1316,14 @@ func findBySemanticScript() string {
 			}
 			// Fallback: aria-label
 			if (!el) {
-				el = document.querySelector('[aria-label="' + label.replace(/"/g, '\\"') + '"]');
+				el = querySelectorDeep(document, '[aria-label="' + label.replace(/"/g, '\\"') + '"]');
 			}
 			// Fallback: aria-labelledby
 			if (!el) {
-				const all = document.querySelectorAll('[aria-labelledby]');
+				const all = querySelectorAllDeep(document, '[aria-labelledby]');
 				for (const candidate of all) {
 					const labelId = candidate.getAttribute('aria-labelledby');
-					const labelEl = document.getElementById(labelId);
+					const labelEl = candidate.getRootNode().getElementById(labelId);
 					if (labelEl && labelEl.textContent.trim().includes(label)) {
 						el = candidate;
 						break;
@@ -1100,25 +1332,19 @@ func findBySemanticScript() string {
 			}
 		} else if (text) {
 			// Find leaf elements containing the text
-			const walker = document.createTreeWalker(document.body, NodeFilter.SHOW_ELEMENT, {
-				acceptNode: (node) => {
-					if (node.offsetWidth === 0 && node.offsetHeight === 0) return NodeFilter.FILTER_REJECT;
-					const style = window.getComputedStyle(node);
-					if (style.display === 'none' || style.visibility === 'hidden') return NodeFilter.FILTER_REJECT;
-					return NodeFilter.FILTER_ACCEPT;
-				}
-			});
 			let best = null;
 			let bestLen = Infinity;
-			let node;
-			while (node = walker.nextNode()) {
+			walkDeep(document.body, (node) => {
+				if (node.offsetWidth === 0 && node.offsetHeight === 0) return;
+				const style = window.getComputedStyle(node);
+				if (style.display === 'none' || style.visibility === 'hidden') return;
 				const content = node.textContent.trim();
 				if (content.includes(text) && content.length < bestLen) {
 					// Prefer the most specific (smallest text) match
 					best = node;
 					bestLen = content.length;
 				}
-			}
+			});
 			el = best;
 		}
 
@@ -1187,7 +1413,7 @@ func (h *Handlers) browserQuit(args map[string]interface{}) (*ToolsCallResult, e
 		}, nil
 	}
 
-	h.Close()
+	h.closeBrowser()
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -1204,6 +1430,11 @@ func (h *Handlers) browserNewPage(args map[string]interface{}) (*ToolsCallResult
 	}
 
 	url, _ := args["url"].(string)
+	if url != "" {
+		if err := h.checkNavigation(url); err != nil {
+			return nil, err
+		}
+	}
 
 	s := h.newSession()
 	contextID, err := api.NewPage(s, url)
@@ -1375,10 +1606,18 @@ func (h *Handlers) browserA11yTree(args map[string]interface{}) (*ToolsCallResul
 		return nil, err
 	}
 
-	result, err := api.A11yTree(s, ctx, interestingOnly, "")
+	var result string
+	if outline, _ := args["outline"].(bool); outline {
+		result, err = h.outline("")
+	} else {
+		result, err = api.A11yTree(s, ctx, interestingOnly, "")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get accessibility tree: %w", err)
 	}
+	if result, err = paginate(result, args); err != nil {
+		return nil, err
+	}
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -1557,9 +1796,12 @@ func (h *Handlers) browserGetHTML(args map[string]interface{}) (*ToolsCallResult
 	}
 
 	outer, _ := args["outer"].(bool)
+	selector, _ := args["selector"].(string)
 
 	var html string
-	if selector, ok := args["selector"].(string); ok && selector != "" {
+	if outline, _ := args["outline"].(bool); outline {
+		html, err = h.outline(selector)
+	} else if selector != "" {
 		selector = h.resolveSelector(selector)
 		ep := api.ElementParams{Selector: selector}
 		if outer {
@@ -1573,6 +1815,9 @@ func (h *Handlers) browserGetHTML(args map[string]interface{}) (*ToolsCallResult
 	if err != nil {
 		return nil, fmt.Errorf("failed to get HTML: %w", err)
 	}
+	if html, err = paginate(html, args); err != nil {
+		return nil, err
+	}
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -1582,6 +1827,24 @@ func (h *Handlers) browserGetHTML(args map[string]interface{}) (*ToolsCallResult
 	}, nil
 }
 
+// outline returns the headings and landmarks under selector ("" = whole
+// page), for the outline option of the page-reading tools.
+func (h *Handlers) outline(selector string) (string, error) {
+	var scope interface{}
+	if selector != "" {
+		scope = h.resolveSelector(selector)
+	}
+	result, err := h.client.CallFunction("", outlineScript(), []interface{}{scope})
+	if err != nil {
+		return "", err
+	}
+	outline := fmt.Sprintf("%v", result)
+	if outline == "" {
+		outline = "No headings or landmarks found"
+	}
+	return outline, nil
+}
+
 // browserFindAll finds all elements matching a CSS selector.
 func (h *Handlers) browserFindAll(args map[string]interface{}) (*ToolsCallResult, error) {
 	if err := h.ensureBrowser(); err != nil {
@@ -1601,9 +1864,10 @@ func (h *Handlers) browserFindAll(args map[string]interface{}) (*ToolsCallResult
 
 	// Use JS to find elements and generate selectors + labels
 	findAllScript := `(selector, limit) => {
+		` + api.ShadowDOMJS() + `
 		` + GetSelectorJS() + `
 		` + GetLabelJS() + `
-		const els = document.querySelectorAll(selector);
+		const els = querySelectorAllDeep(document, selector);
 		const results = [];
 		const n = Math.min(els.length, limit);
 		for (let i = 0; i < n; i++) {
@@ -1612,7 +1876,11 @@ func (h *Handlers) browserFindAll(args map[string]interface{}) (*ToolsCallResult
 		}
 		return JSON.stringify(results);
 	}`
-	result, err := h.client.CallFunction("", findAllScript, []interface{}{selector, limit})
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.client.CallFunction(ctx, findAllScript, []interface{}{selector, limit})
 	if err != nil {
 		return nil, fmt.Errorf("failed to find elements: %w", err)
 	}
@@ -1626,12 +1894,14 @@ func (h *Handlers) browserFindAll(args map[string]interface{}) (*ToolsCallResult
 	}
 
 	// Build ref map and output
-	h.refMap = make(map[string]string)
+	selectors := make([]string, len(elements))
 	var lines []string
 	for i, el := range elements {
-		ref := fmt.Sprintf("@e%d", i+1)
-		h.refMap[ref] = el.Selector
-		lines = append(lines, fmt.Sprintf("%s %s", ref, el.Label))
+		selectors[i] = el.Selector
+		lines = append(lines, fmt.Sprintf("@e%d %s", i+1, el.Label))
+	}
+	if err := h.setRefs(selectors); err != nil {
+		return nil, err
 	}
 
 	text := strings.Join(lines, "\n")
@@ -1715,8 +1985,12 @@ func (h *Handlers) browserGetText(args map[string]interface{}) (*ToolsCallResult
 		return nil, err
 	}
 
+	selector, _ := args["selector"].(string)
+
 	var text string
-	if selector, ok := args["selector"].(string); ok && selector != "" {
+	if outline, _ := args["outline"].(bool); outline {
+		text, err = h.outline(selector)
+	} else if selector != "" {
 		selector = h.resolveSelector(selector)
 		text, err = api.GetInnerText(s, ctx, api.ElementParams{Selector: selector})
 	} else {
@@ -1725,6 +1999,9 @@ func (h *Handlers) browserGetText(args map[string]interface{}) (*ToolsCallResult
 	if err != nil {
 		return nil, fmt.Errorf("failed to get text: %w", err)
 	}
+	if text, err = paginate(text, args); err != nil {
+		return nil, err
+	}
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -2006,7 +2283,7 @@ func pollCallFunction(h *Handlers, script string, args []interface{}, timeout ti
 	interval := 100 * time.Millisecond
 
 	for {
-		result, err := h.client.CallFunction("", script, args)
+		result, err := h.client.CallFunction(h.activeContext, script, args)
 		if err == nil && result != nil {
 			s := fmt.Sprintf("%v", result)
 			if s != "" && s != "null" && s != "<nil>" {
@@ -2018,7 +2295,9 @@ func pollCallFunction(h *Handlers, script string, args []interface{}, timeout ti
 			return nil, fmt.Errorf("timeout after %s", timeout)
 		}
 
-		time.Sleep(interval)
+		if err := h.wait(interval, "function to return a value"); err != nil {
+			return nil, err
+		}
 	}
 }
 
@@ -2440,7 +2719,9 @@ func (h *Handlers) browserSleep(args map[string]interface{}) (*ToolsCallResult,
 		ms = 30000
 	}
 
-	time.Sleep(time.Duration(ms) * time.Millisecond)
+	if err := h.wait(time.Duration(ms)*time.Millisecond, "sleep"); err != nil {
+		return nil, err
+	}
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -2450,6 +2731,44 @@ func (h *Handlers) browserSleep(args map[string]interface{}) (*ToolsCallResult,
 	}, nil
 }
 
+// wait pauses for d while a tool is waiting for something, returning early
+// with a CancelledError if the call is cancelled. Long waits report progress
+// at most once per progressInterval.
+func (h *Handlers) wait(d time.Duration, waitingFor string) error {
+	c := h.call
+	if c == nil {
+		time.Sleep(d)
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-timer.C:
+			h.reportProgress(now, waitingFor)
+			return nil
+		case <-c.opts.Cancel:
+			return &errs.CancelledError{Method: c.name}
+		case now := <-ticker.C:
+			h.reportProgress(now, waitingFor)
+		}
+	}
+}
+
+// reportProgress sends a progress message for the call in progress if
+// progressInterval has passed since the last one.
+func (h *Handlers) reportProgress(now time.Time, waitingFor string) {
+	c := h.call
+	if c.opts.Progress == nil || now.Sub(c.lastProgress) < progressInterval {
+		return
+	}
+	c.lastProgress = now
+	c.opts.Progress(fmt.Sprintf("waiting for %s, %ds elapsed", waitingFor, int(now.Sub(c.start).Seconds())))
+}
+
 // ensureBrowser checks that a browser session is active.
 // If no browser is running, it auto-launches one (lazy launch).
 func (h *Handlers) ensureBrowser() error {
@@ -2481,20 +2800,27 @@ func (h *Handlers) resolveRefsInArgs(args map[string]interface{}) map[string]int
 	return cp
 }
 
-// resolveSelector resolves @ref selectors to CSS selectors from the refMap.
+// resolveSelector resolves @ref selectors to the CSS selectors the refs
+// were last seen at. Tool calls get their refs checked and resolved by
+// resolveRefs before this.
 func (h *Handlers) resolveSelector(selector string) string {
 	if strings.HasPrefix(selector, "@e") {
-		if resolved, ok := h.refMap[selector]; ok {
-			return resolved
+		if r, ok := h.refMap[selector]; ok {
+			return r.Selector
 		}
 	}
 	return selector
 }
 
 // GetSelectorJS returns the JS getSelector(el) function body that generates unique CSS selectors.
+// Elements inside open shadow roots get the path to their host, then ">>>"
+// and the path within the shadow root (see api.ShadowDOMJS).
 func GetSelectorJS() string {
 	return `function getSelector(el) {
-			if (el.id) return '#' + CSS.escape(el.id);
+			const root = el.getRootNode();
+			const inShadow = root instanceof ShadowRoot;
+			const prefix = inShadow ? getSelector(root.host) + ' >>> ' : '';
+			if (el.id) return prefix + '#' + CSS.escape(el.id);
 			const parts = [];
 			let cur = el;
 			while (cur && cur !== document.body && cur !== document.documentElement) {
@@ -2504,8 +2830,10 @@ func GetSelectorJS() string {
 					break;
 				}
 				const parent = cur.parentElement;
-				if (parent) {
-					const siblings = Array.from(parent.children).filter(c => c.tagName === cur.tagName);
+				// The top elements of a shadow root have the root as parentNode
+				const container = parent || cur.parentNode;
+				if (container) {
+					const siblings = Array.from(container.children).filter(c => c.tagName === cur.tagName);
 					if (siblings.length > 1) {
 						const idx = siblings.indexOf(cur) + 1;
 						seg += ':nth-of-type(' + idx + ')';
@@ -2514,9 +2842,9 @@ func GetSelectorJS() string {
 				parts.unshift(seg);
 				cur = parent;
 			}
-			if (parts.length === 0) return el.tagName.toLowerCase();
-			if (!parts[0].startsWith('#')) parts.unshift('body');
-			return parts.join(' > ');
+			if (parts.length === 0) return prefix + el.tagName.toLowerCase();
+			if (!inShadow && !parts[0].startsWith('#')) parts.unshift('body');
+			return prefix + parts.join(' > ');
 		}`
 }
 
@@ -2552,32 +2880,55 @@ func GetLabelJS() string {
 }
 
 // mapScript returns the JS function that maps interactive elements with refs.
-// When a selector is provided, only elements within the matching subtree are returned.
+// It takes mapOptions as JSON: when a scope is provided, only elements within
+// the matching subtree are returned, and the filters drop the others. It
+// returns [JSON, node, ...], the JSON listing the elements with their
+// fingerprints, followed by the elements themselves.
 func mapScript() string {
-	return `(scopeSelector) => {
+	return `(optionsJSON) => {
+		` + api.ShadowDOMJS() + `
 		` + GetSelectorJS() + `
 		` + GetLabelJS() + `
+		` + fingerprintJS() + `
+		` + elementStateJS() + `
+		` + mapGroupJS() + `
 
+		const opts = JSON.parse(optionsJSON);
 		const interactive = 'a[href], button, input, textarea, select, [role="button"], [role="link"], [role="checkbox"], [role="radio"], [role="tab"], [role="menuitem"], [role="switch"], [onclick], [tabindex]:not([tabindex="-1"]), summary, details';
+		const formControl = 'input, textarea, select, [role="textbox"], [role="searchbox"], [role="checkbox"], [role="radio"], [role="switch"], [role="combobox"], [role="listbox"], [role="slider"], [role="spinbutton"]';
 
-		const root = scopeSelector ? document.querySelector(scopeSelector) : document;
-		if (!root) return JSON.stringify([]);
-		const els = root.querySelectorAll(interactive);
+		const root = opts.scope ? querySelectorDeep(document, opts.scope) : document;
+		if (!root) return [JSON.stringify([])];
+		const els = [];
+		walkDeep(root, (el) => { if (el.matches(interactive)) els.push(el); });
 		const results = [];
+		const nodes = [];
 		const seen = new Set();
 
 		for (const el of els) {
 			const style = window.getComputedStyle(el);
 			if (style.display === 'none' || style.visibility === 'hidden' || el.offsetWidth === 0) continue;
 
+			if (opts.formsOnly && !el.matches(formControl) && !el.form) continue;
+			const role = locRole(el);
+			if (opts.roles && !opts.roles.includes(role)) continue;
+			if (opts.inViewport) {
+				const rect = el.getBoundingClientRect();
+				if (rect.bottom <= 0 || rect.right <= 0 || rect.top >= window.innerHeight || rect.left >= window.innerWidth) continue;
+			}
+
 			const sel = getSelector(el);
 			if (seen.has(sel)) continue;
 			seen.add(sel);
 
-			results.push({ selector: sel, label: getLabel(el) });
+			results.push({
+				selector: sel, label: getLabel(el), tag: el.tagName.toLowerCase(), role,
+				group: groupOf(el), state: elementState(el), print: fingerprint(el),
+			});
+			nodes.push(el);
 		}
 
-		return JSON.stringify(results);
+		return [JSON.stringify(results), ...nodes];
 	}`
 }
 
@@ -2587,39 +2938,20 @@ func (h *Handlers) browserMap(args map[string]interface{}) (*ToolsCallResult, er
 		return nil, err
 	}
 
-	var scopeSelector interface{}
-	if sel, ok := args["selector"].(string); ok && sel != "" {
-		scopeSelector = sel
-	}
-	result, err := h.client.CallFunction("", mapScript(), []interface{}{scopeSelector})
+	opts := mapOptionsFromArgs(args)
+	elements, err := h.mapPage(opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to map elements: %w", err)
-	}
-
-	resultStr := fmt.Sprintf("%v", result)
-
-	var elements []struct {
-		Selector string `json:"selector"`
-		Label    string `json:"label"`
-	}
-	if err := json.Unmarshal([]byte(resultStr), &elements); err != nil {
-		return nil, fmt.Errorf("failed to parse map results: %w", err)
+		return nil, err
 	}
-
-	// Build ref map and output
-	h.refMap = make(map[string]string)
-	var lines []string
-	for i, el := range elements {
-		ref := fmt.Sprintf("@e%d", i+1)
-		h.refMap[ref] = el.Selector
-		lines = append(lines, fmt.Sprintf("%s %s", ref, el.Label))
+	var output string
+	if format, _ := args["format"].(string); format == "json" {
+		output, err = mapJSON(elements, args)
+	} else {
+		output, err = paginate(formatMap(elements, opts), args)
 	}
-
-	output := strings.Join(lines, "\n")
-	if output == "" {
-		output = "No interactive elements found"
+	if err != nil {
+		return nil, err
 	}
-	h.lastMap = output
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -2629,56 +2961,62 @@ func (h *Handlers) browserMap(args map[string]interface{}) (*ToolsCallResult, er
 	}, nil
 }
 
-// browserDiffMap compares current page state vs last map.
-func (h *Handlers) browserDiffMap(args map[string]interface{}) (*ToolsCallResult, error) {
-	if h.lastMap == "" {
-		return nil, fmt.Errorf("no previous map to diff against — run browser_map first")
-	}
-
-	// Get current map
-	prevMap := h.lastMap
-	_, err := h.browserMap(args)
+// mapPage maps the interactive elements opts selects, rebuilding the ref
+// map, and returns them.
+func (h *Handlers) mapPage(opts mapOptions) ([]mapElement, error) {
+	elements, refs, err := h.scanMap(opts)
 	if err != nil {
 		return nil, err
 	}
-	currentMap := h.lastMap
+	h.refMap = refs
 
-	// Simple line-based diff
-	prevLines := strings.Split(prevMap, "\n")
-	currLines := strings.Split(currentMap, "\n")
+	snapshot := &mapSnapshot{Options: opts, Entries: make([]mapEntry, len(elements))}
+	for i, el := range elements {
+		snapshot.Entries[i] = mapEntry{Ref: el.Ref, Label: el.Label, elementRef: refs[el.Ref]}
+	}
+	h.lastMap = snapshot
+	return elements, nil
+}
 
-	prevSet := make(map[string]bool)
-	for _, l := range prevLines {
-		prevSet[l] = true
+// scanMap maps the interactive elements opts selects and returns them with
+// the refs they would get, without touching the ref map. The elements'
+// fingerprints and shared IDs come back in the same call.
+func (h *Handlers) scanMap(opts mapOptions) ([]mapElement, map[string]*elementRef, error) {
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return nil, nil, err
 	}
-	currSet := make(map[string]bool)
-	for _, l := range currLines {
-		currSet[l] = true
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	var diff []string
-	for _, l := range prevLines {
-		if !currSet[l] {
-			diff = append(diff, "- "+l)
-		}
+	result, err := h.client.CallFunctionRemote(ctx, mapScript(), []interface{}{string(optsJSON)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to map elements: %w", err)
 	}
-	for _, l := range currLines {
-		if !prevSet[l] {
-			diff = append(diff, "+ "+l)
-		}
+	resultStr, ids, err := splitRefsResult(result)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	output := strings.Join(diff, "\n")
-	if output == "" {
-		output = "No changes detected"
+	var mapped []struct {
+		mapElement
+		Print fingerprint `json:"print"`
+	}
+	if err := json.Unmarshal([]byte(resultStr), &mapped); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse map results: %w", err)
 	}
 
-	return &ToolsCallResult{
-		Content: []Content{{
-			Type: "text",
-			Text: output,
-		}},
-	}, nil
+	elements := make([]mapElement, len(mapped))
+	selectors := make([]string, len(mapped))
+	infos := make([]*refInfo, len(mapped))
+	for i, m := range mapped {
+		elements[i] = m.mapElement
+		elements[i].Ref = fmt.Sprintf("@e%d", i+1)
+		selectors[i] = m.Selector
+		infos[i] = &refInfo{Print: m.Print, State: m.State}
+	}
+	return elements, newRefMap(ctx, selectors, infos, ids), nil
 }
 
 // browserPDF saves the page as PDF.
@@ -2735,7 +3073,8 @@ func (h *Handlers) browserHighlight(args map[string]interface{}) (*ToolsCallResu
 	selector = h.resolveSelector(selector)
 
 	script := `(selector) => {
-		const el = document.querySelector(selector);
+		` + api.ShadowDOMJS() + `
+		const el = querySelectorDeep(document, selector);
 		if (!el) return 'not_found';
 		const prev = el.style.cssText;
 		el.style.outline = '3px solid red';
@@ -3134,6 +3473,74 @@ func (h *Handlers) browserDeleteCookies(args map[string]interface{}) (*ToolsCall
 	}, nil
 }
 
+// browserRoute adds a network route. Matching requests are fulfilled,
+// aborted, delayed or sent with extra headers; see routes.go.
+func (h *Handlers) browserRoute(args map[string]interface{}) (*ToolsCallResult, error) {
+	if err := h.ensureBrowser(); err != nil {
+		return nil, err
+	}
+
+	r, err := parseRoute(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.addIntercept(func(client *bidi.Client) error { return h.routes.add(client, r) }); err != nil {
+		return nil, fmt.Errorf("failed to add route: %w", err)
+	}
+
+	return &ToolsCallResult{
+		Content: []Content{{
+			Type: "text",
+			Text: "Added route " + r.String(),
+		}},
+	}, nil
+}
+
+// browserUnroute removes routes by ID or URL pattern, or all of them.
+func (h *Handlers) browserUnroute(args map[string]interface{}) (*ToolsCallResult, error) {
+	id := 0
+	if v, ok := args["id"].(float64); ok {
+		id = int(v)
+	}
+	pattern, _ := args["url"].(string)
+
+	removed, err := h.routes.remove(h.client, id, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove route: %w", err)
+	}
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("no matching route")
+	}
+
+	return &ToolsCallResult{
+		Content: []Content{{
+			Type: "text",
+			Text: fmt.Sprintf("Removed %d route(s)", len(removed)),
+		}},
+	}, nil
+}
+
+// browserListRoutes lists the active routes.
+func (h *Handlers) browserListRoutes(args map[string]interface{}) (*ToolsCallResult, error) {
+	routes := h.routes.list()
+	if len(routes) == 0 {
+		return &ToolsCallResult{
+			Content: []Content{{Type: "text", Text: "No active routes"}},
+		}, nil
+	}
+
+	lines := make([]string, len(routes))
+	for i, r := range routes {
+		lines[i] = r.String()
+	}
+	return &ToolsCallResult{
+		Content: []Content{{
+			Type: "text",
+			Text: strings.Join(lines, "\n"),
+		}},
+	}, nil
+}
+
 // browserMouseMove moves the mouse to coordinates.
 func (h *Handlers) browserMouseMove(args map[string]interface{}) (*ToolsCallResult, error) {
 	if err := h.ensureBrowser(); err != nil {
@@ -3710,9 +4117,7 @@ func (h *Handlers) browserRecordStart(args map[string]interface{}) (*ToolsCallRe
 			"browsingContext.fragmentNavigated",
 		},
 	})
-	h.client.SetEventHandler(func(msg string) {
-		h.recorder.RecordBidiEvent(msg)
-	})
+	h.setEventRecorder(h.recorder)
 
 	return &ToolsCallResult{
 		Content: []Content{{
@@ -3729,9 +4134,7 @@ func (h *Handlers) browserRecordStop(args map[string]interface{}) (*ToolsCallRes
 	}
 
 	// Stop forwarding events to the recorder
-	if h.client != nil {
-		h.client.SetEventHandler(nil)
-	}
+	h.setEventRecorder(nil)
 
 	// Stop screenshot goroutine before stopping the recorder
 	h.recorder.StopScreenshots()
@@ -3753,6 +4156,9 @@ func (h *Handlers) browserRecordStop(args map[string]interface{}) (*ToolsCallRes
 	}
 
 	h.recorder = nil
+	if abs, err := filepath.Abs(path); err == nil {
+		h.recordings = append(h.recordings, abs)
+	}
 
 	return &ToolsCallResult{
 		Content: []Content{{
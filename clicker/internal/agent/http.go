@@ -0,0 +1,550 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vibium/clicker/internal/log"
+)
+
+// MCP Streamable HTTP transport (spec revision 2025-03-26 on; initialize
+// negotiates the revision). Clients POST JSON-RPC messages to a single
+// endpoint and get the responses back either as a JSON body or as an SSE
+// stream, one event per response. A GET opens a standalone SSE stream for
+// server-initiated notifications. The initialize response carries an
+// Mcp-Session-Id header; every later request must send it back. Each session
+// has its own Handlers, and so its own browser.
+
+// DefaultHTTPPort is the port `vibium mcp --http` listens on by default.
+const DefaultHTTPPort = 9516
+
+// sessionHeader carries the MCP session ID on requests and responses.
+const sessionHeader = "Mcp-Session-Id"
+
+// maxRequestSize bounds a POSTed JSON-RPC message or batch.
+const maxRequestSize = 10 * 1024 * 1024
+
+// HTTPOptions configures the Streamable HTTP transport.
+type HTTPOptions struct {
+	Host           string        // bind address (default 127.0.0.1)
+	Port           int           // 0 = OS-assigned
+	Path           string        // MCP endpoint (default "/mcp")
+	Token          string        // required bearer token, "" = no auth
+	AllowedOrigins []string      // allowed Origin headers, empty = any
+	IdleTimeout    time.Duration // close sessions unused this long (0 = never)
+}
+
+// HTTPServer serves MCP over Streamable HTTP.
+type HTTPServer struct {
+	version    string
+	opts       ServerOptions
+	http       HTTPOptions
+	httpServer *http.Server
+	stop       chan struct{}
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+// httpSession is one MCP session and the browser behind it.
+type httpSession struct {
+	server   *Server
+	mu       sync.Mutex // serializes requests; handlers are not thread-safe
+	lastUsed time.Time  // guarded by HTTPServer.mu
+	closed   chan struct{}
+
+	// Where notifications go: the GET stream if one is open, else the SSE
+	// response of the POST being handled, else nowhere
+	post  func(data []byte) // guarded by mu
+	getMu sync.Mutex
+	get   chan []byte
+}
+
+func newHTTPSession(version string, opts ServerOptions) *httpSession {
+	sess := &httpSession{
+		server:   newSession(version, opts),
+		lastUsed: time.Now(),
+		closed:   make(chan struct{}),
+	}
+	sess.server.notify = sess.notify
+	return sess
+}
+
+// notify sends a server-initiated message. It's called from handleRequest,
+// so with sess.mu held.
+func (sess *httpSession) notify(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	sess.getMu.Lock()
+	ch := sess.get
+	sess.getMu.Unlock()
+	if ch != nil {
+		select {
+		case ch <- data:
+		default: // the client isn't keeping up; drop it
+		}
+		return
+	}
+	if sess.post != nil {
+		sess.post(data)
+	}
+}
+
+// NewHTTPServer creates an MCP server for the Streamable HTTP transport.
+func NewHTTPServer(version string, opts ServerOptions, httpOpts HTTPOptions) *HTTPServer {
+	if httpOpts.Host == "" {
+		httpOpts.Host = "127.0.0.1"
+	}
+	if httpOpts.Path == "" {
+		httpOpts.Path = "/mcp"
+	}
+	return &HTTPServer{
+		version:  version,
+		opts:     opts,
+		http:     httpOpts,
+		stop:     make(chan struct{}),
+		sessions: make(map[string]*httpSession),
+	}
+}
+
+// Start listens and serves in the background.
+func (s *HTTPServer) Start() error {
+	addr := net.JoinHostPort(s.http.Host, strconv.Itoa(s.http.Port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.http.Port = listener.Addr().(*net.TCPAddr).Port
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.http.Path, s.handleMCP)
+	s.httpServer = &http.Server{Handler: mux}
+	go s.httpServer.Serve(listener)
+
+	if s.http.IdleTimeout > 0 {
+		go s.reapIdle()
+	}
+	return nil
+}
+
+// URL returns the MCP endpoint URL.
+func (s *HTTPServer) URL() string {
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(s.http.Host, strconv.Itoa(s.http.Port)), s.http.Path)
+}
+
+// Close stops the server and closes every session's browser.
+func (s *HTTPServer) Close() {
+	select {
+	case <-s.stop:
+		return
+	default:
+		close(s.stop)
+	}
+
+	if s.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		s.httpServer.Shutdown(ctx)
+		cancel()
+	}
+
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*httpSession)
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.close()
+	}
+}
+
+func (sess *httpSession) close() {
+	close(sess.closed)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.server.Close()
+}
+
+// reapIdle closes sessions that haven't been used for IdleTimeout.
+func (s *HTTPServer) reapIdle() {
+	ticker := time.NewTicker(s.http.IdleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+
+		var idle []*httpSession
+		s.mu.Lock()
+		for id, sess := range s.sessions {
+			if time.Since(sess.lastUsed) > s.http.IdleTimeout {
+				delete(s.sessions, id)
+				idle = append(idle, sess)
+				log.Debug("mcp session expired", "session", id)
+			}
+		}
+		s.mu.Unlock()
+		for _, sess := range idle {
+			sess.close()
+		}
+	}
+}
+
+// authorize checks a request against the token and origin settings. It
+// returns the HTTP status and reason to reject with, or 0 if allowed.
+func (s *HTTPServer) authorize(r *http.Request) (int, string) {
+	if s.http.Token != "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return http.StatusUnauthorized, "missing token"
+		}
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.http.Token)) != 1 {
+			return http.StatusUnauthorized, "invalid token"
+		}
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" && len(s.http.AllowedOrigins) > 0 {
+		for _, o := range s.http.AllowedOrigins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return 0, ""
+			}
+		}
+		return http.StatusForbidden, fmt.Sprintf("origin %s not allowed", origin)
+	}
+
+	return 0, ""
+}
+
+func (s *HTTPServer) handleMCP(w http.ResponseWriter, r *http.Request) {
+	if status, reason := s.authorize(r); status != 0 {
+		fmt.Fprintf(os.Stderr, "[mcp] Rejected request from %s: %s\n", r.RemoteAddr, reason)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		s.handlePost(w, r)
+	case http.MethodGet:
+		s.handleGet(w, r)
+	case http.MethodDelete:
+		s.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePost runs the JSON-RPC message or batch in the request body.
+func (s *HTTPServer) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxRequestSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	messages, batch, err := splitMessages(body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &Response{
+			JSONRPC: "2.0",
+			Error:   &Error{Code: ParseError, Message: "Parse error", Data: err.Error()},
+		})
+		return
+	}
+
+	id, sess, status := s.sessionFor(r, messages)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set(sessionHeader, id)
+
+	// Cancellations and responses to our elicitation requests take effect
+	// at once rather than waiting behind the call they're for
+	pending := messages[:0]
+	for _, msg := range messages {
+		if !sess.server.handleCancel(msg) && !sess.server.handleResponse(msg) {
+			pending = append(pending, msg)
+		}
+	}
+	messages = pending
+	if len(messages) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Only requests get a response; a POST of notifications is just accepted
+	if !containsRequest(messages) {
+		sess.mu.Lock()
+		for _, msg := range messages {
+			sess.server.handleRequest(msg)
+		}
+		sess.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if acceptsSSE(r) {
+		s.streamResponses(w, sess, messages)
+		return
+	}
+
+	sess.mu.Lock()
+	var responses []*Response
+	for _, msg := range messages {
+		if resp := sess.server.handleRequest(msg); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	sess.mu.Unlock()
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted) // every request was cancelled
+	} else if batch {
+		writeJSON(w, http.StatusOK, responses)
+	} else {
+		writeJSON(w, http.StatusOK, responses[0])
+	}
+}
+
+// streamResponses answers over SSE, sending each response as soon as its
+// request has run, then ends the stream.
+func (s *HTTPServer) streamResponses(w http.ResponseWriter, sess *httpSession, messages []json.RawMessage) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	gone := false
+	send := func(data []byte) {
+		if gone {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+			gone = true // client went away
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.post = send
+	defer func() { sess.post = nil }()
+	for _, msg := range messages {
+		resp := sess.server.handleRequest(msg)
+		if resp == nil || gone {
+			continue
+		}
+		if data, err := json.Marshal(resp); err == nil {
+			send(data)
+		}
+	}
+}
+
+// handleGet opens the session's standalone SSE stream for server-initiated
+// notifications. It stays open until the client disconnects or the session
+// ends.
+func (s *HTTPServer) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !acceptsSSE(r) {
+		http.Error(w, "Accept must include text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	id := r.Header.Get(sessionHeader)
+	if id == "" {
+		http.Error(w, "missing "+sessionHeader, http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	ch := make(chan []byte, 64)
+	sess.getMu.Lock()
+	if sess.get != nil {
+		sess.getMu.Unlock()
+		http.Error(w, "stream already open", http.StatusConflict)
+		return
+	}
+	sess.get = ch
+	sess.getMu.Unlock()
+	defer func() {
+		sess.getMu.Lock()
+		sess.get = nil
+		sess.getMu.Unlock()
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(sessionHeader, id)
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case data := <-ch:
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		case <-sess.closed:
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// handleDelete ends a session at the client's request.
+func (s *HTTPServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(sessionHeader)
+	if id == "" {
+		http.Error(w, "missing "+sessionHeader, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	sess.close()
+	log.Debug("mcp session closed", "session", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionFor returns the session a POST belongs to, creating one for an
+// initialize request. It returns an HTTP status to fail with instead: 400 for
+// a missing session ID, 404 for an unknown or expired one.
+func (s *HTTPServer) sessionFor(r *http.Request, messages []json.RawMessage) (string, *httpSession, int) {
+	id := r.Header.Get(sessionHeader)
+
+	if id == "" {
+		if !isInitialize(messages) {
+			return "", nil, http.StatusBadRequest
+		}
+		var b [16]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+		sess := newHTTPSession(s.version, s.opts)
+
+		s.mu.Lock()
+		s.sessions[id] = sess
+		s.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "[mcp] Session %s started from %s\n", id, r.RemoteAddr)
+		return id, sess, 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return "", nil, http.StatusNotFound
+	}
+	sess.lastUsed = time.Now()
+	return id, sess, 0
+}
+
+// splitMessages parses a POST body into its JSON-RPC messages, reporting
+// whether it was a batch.
+func splitMessages(body []byte) ([]json.RawMessage, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var messages []json.RawMessage
+		if err := json.Unmarshal(body, &messages); err != nil {
+			return nil, false, err
+		}
+		if len(messages) == 0 {
+			return nil, false, fmt.Errorf("empty batch")
+		}
+		return messages, true, nil
+	}
+	if !json.Valid(body) {
+		return nil, false, fmt.Errorf("invalid JSON")
+	}
+	return []json.RawMessage{body}, false, nil
+}
+
+// messageHeader holds the fields that tell requests, notifications and
+// responses apart.
+type messageHeader struct {
+	ID     interface{} `json:"id"`
+	Method string      `json:"method"`
+}
+
+func parseHeader(msg json.RawMessage) messageHeader {
+	var h messageHeader
+	json.Unmarshal(msg, &h)
+	return h
+}
+
+// containsRequest reports whether any message expects a response.
+func containsRequest(messages []json.RawMessage) bool {
+	for _, msg := range messages {
+		if h := parseHeader(msg); h.ID != nil && h.Method != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isInitialize reports whether the messages open a session.
+func isInitialize(messages []json.RawMessage) bool {
+	for _, msg := range messages {
+		if parseHeader(msg).Method == "initialize" {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsSSE reports whether the client takes an SSE stream as the response.
+func acceptsSSE(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
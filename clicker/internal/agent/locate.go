@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vibium/clicker/internal/api"
+	errs "github.com/vibium/clicker/internal/errors"
+)
+
+// Locator suggestions: browser_locate turns an element (a ref or a
+// selector) into locators a test can keep using, best first: its test ID,
+// role and accessible name, label, placeholder, text, and the shortest
+// unique CSS selector, rather than the nth-of-type path a ref resolves to.
+// Each candidate is tried on the page as it is now. One that matches
+// several elements is narrowed to the element's row, list item, form, ...
+// (tr:has-text("Invoice 42") >> role=button[name="Delete"]) when that makes
+// it unique; otherwise it is reported with its match count.
+
+// locatorCandidate is one suggested locator.
+type locatorCandidate struct {
+	Locator string `json:"locator"`
+	Kind    string `json:"kind"`    // testid, role, label, placeholder, text, css, or path (the CSS path, a last resort)
+	Matches int    `json:"matches"` // elements it matches on the page
+	Unique  bool   `json:"unique"`  // it matches only this element
+}
+
+// rankCandidates orders candidates for suggesting: the unique ones first,
+// each group in the order locateScript found them (by kind).
+func rankCandidates(candidates []locatorCandidate) []locatorCandidate {
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].Unique && !candidates[b].Unique
+	})
+	return candidates
+}
+
+// formatCandidates formats locator candidates as text, one per line with
+// its kind and whether it is unique.
+func formatCandidates(candidates []locatorCandidate) string {
+	if len(candidates) == 0 {
+		return "No locators found"
+	}
+	width := 0
+	for _, c := range candidates {
+		if len(c.Locator) > width {
+			width = len(c.Locator)
+		}
+	}
+	lines := make([]string, len(candidates))
+	for i, c := range candidates {
+		note := "unique"
+		if !c.Unique {
+			note = fmt.Sprintf("%d matches", c.Matches)
+		}
+		lines[i] = fmt.Sprintf("%-*s  (%s, %s)", width, c.Locator, c.Kind, note)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// browserLocate suggests locators for an element.
+func (h *Handlers) browserLocate(args map[string]interface{}) (*ToolsCallResult, error) {
+	if err := h.ensureBrowser(); err != nil {
+		return nil, err
+	}
+
+	selector, ok := args["selector"].(string)
+	if !ok || selector == "" {
+		return nil, fmt.Errorf("selector is required")
+	}
+	selector = h.resolveSelector(selector)
+
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.client.CallFunction(ctx, locateScript(), []interface{}{selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate: %w", err)
+	}
+	s, _ := result.(string)
+	if s == "" {
+		return nil, &errs.ElementNotFoundError{Selector: selector}
+	}
+	candidates := []locatorCandidate{}
+	if err := json.Unmarshal([]byte(s), &candidates); err != nil {
+		return nil, fmt.Errorf("failed to parse locators: %w", err)
+	}
+	candidates = rankCandidates(candidates)
+
+	output := formatCandidates(candidates)
+	if format, _ := args["format"].(string); format == "json" {
+		data, err := json.MarshalIndent(map[string]interface{}{"candidates": candidates}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		output = string(data)
+	}
+
+	return &ToolsCallResult{
+		Content: []Content{{
+			Type: "text",
+			Text: output,
+		}},
+	}, nil
+}
+
+// locateScript returns the JS function behind browser_locate. It takes the
+// element's selector and returns the candidates as JSON, in kind order, or
+// "" if there is no such element.
+func locateScript() string {
+	return `(selector) => {
+		` + api.ShadowDOMJS() + `
+		` + GetSelectorJS() + `
+		const el = querySelectorDeep(document, selector);
+		if (!el) return '';
+
+		const quote = s => s.includes('"') && !s.includes("'") ? "'" + s + "'" : '"' + s + '"';
+		// Generated IDs and class names (:r1:, ember1234, css-1x2y3z) change
+		// between builds
+		const stable = s => /^[A-Za-z][\w-]*$/.test(s) && !/\d{3,}/.test(s) && !/^(css|sc|jsx|svelte)-/.test(s) && s.length <= 40;
+		const transient = ['active', 'selected', 'focus', 'focused', 'hover', 'open', 'disabled', 'hidden', 'show'];
+		// Up through shadow roots too
+		const up = n => n.parentElement || n.getRootNode().host || null;
+
+		// check tries a locator: it must find el (or, for text=, the
+		// innermost element with the text inside el)
+		const check = (kind, locator) => {
+			let found;
+			try { found = querySelectorAllDeep(document, locator); } catch (e) { return null; }
+			if (!found.some(m => m === el || el.contains(m))) return null;
+			return { locator, kind, matches: found.length, unique: found.length === 1 };
+		};
+
+		// Containers that can narrow a locator matching several elements:
+		// the nearest ones with a test ID or ID, and rows, list items, ...
+		// by a text of theirs outside el
+		const scopes = [];
+		const ownText = container => {
+			const walker = document.createTreeWalker(container, NodeFilter.SHOW_TEXT);
+			for (let node = walker.nextNode(); node; node = walker.nextNode()) {
+				const text = locNorm(node.textContent);
+				if (text && !el.contains(node) && !node.parentElement.closest('script, style')) return text.slice(0, 40);
+			}
+			return '';
+		};
+		for (let cur = up(el); cur && cur !== document.body && scopes.length < 3; cur = up(cur)) {
+			const testid = cur.getAttribute('data-testid');
+			if (testid) {
+				scopes.push('testid=' + quote(testid));
+			} else if (cur.id && stable(cur.id)) {
+				scopes.push('#' + CSS.escape(cur.id));
+			} else if (cur.matches('tr, li, article, section, fieldset, form, dialog, [role=row], [role=listitem], [role=dialog], [role=group]')) {
+				const text = ownText(cur);
+				if (text && !(text.includes('"') && text.includes("'"))) scopes.push(cur.localName + ':has-text(' + quote(text) + ')');
+			}
+		}
+
+		// best returns the first of the locators that is unique, or one
+		// narrowed by a container that is, or else the first that finds el
+		const best = (kind, ...locators) => {
+			const tried = locators.map(l => check(kind, l)).filter(Boolean);
+			const unique = tried.find(c => c.unique);
+			if (unique || tried.length === 0) return unique || null;
+			for (const scope of scopes) {
+				const scoped = check(kind, scope + ' >> ' + tried[0].locator);
+				if (scoped && scoped.unique) return scoped;
+			}
+			return tried[0];
+		};
+
+		const candidates = [];
+		const add = c => { if (c && !candidates.some(d => d.locator === c.locator)) candidates.push(c); };
+		const tag = el.localName;
+		const formControl = ['input', 'textarea', 'select'].includes(tag);
+
+		const testid = el.getAttribute('data-testid');
+		if (testid) add(best('testid', 'testid=' + (/^[\w-]+$/.test(testid) ? testid : quote(testid))));
+
+		const role = locRole(el);
+		const name = locNorm(locName(el));
+		if (role && !['presentation', 'none', 'generic'].includes(role)) {
+			if (name && name.length <= 80) {
+				add(best('role', 'role=' + role + '[name=' + quote(name) + ']', 'role=' + role + '[name=' + quote(name) + 's]'));
+			} else if (!name) {
+				add(best('role', 'role=' + role));
+			}
+		}
+
+		const label = locNorm(locLabel(el));
+		if (label && label.length <= 80) add(best('label', 'label=' + quote(label)));
+
+		const placeholder = locNorm(el.getAttribute('placeholder'));
+		if (placeholder && placeholder.length <= 80) add(best('placeholder', 'placeholder=' + quote(placeholder)));
+
+		const text = formControl ? '' : locNorm(locText(el));
+		if (text && text.length <= 80) add(best('text', 'text=' + quote(text)));
+
+		// The shortest unique CSS selector: el's own ID, attributes or
+		// classes, then the same inside the nearest ancestor with an ID or
+		// test ID
+		const simple = [];
+		if (el.id && stable(el.id)) simple.push('#' + CSS.escape(el.id));
+		const byAttr = attrs => attrs.forEach(attr => {
+			const value = el.getAttribute(attr);
+			if (value && value.length <= 80 && !(attr === 'href' && /^(#|javascript:)?$/.test(value))) {
+				simple.push(tag + '[' + attr + '=' + JSON.stringify(value) + ']');
+			}
+		});
+		byAttr(['data-test', 'data-cy', 'data-qa', 'name', 'aria-label']);
+		const classes = Array.from(el.classList).filter(c => stable(c) && !transient.includes(c) && !c.startsWith('is-'));
+		classes.forEach(c => simple.push(tag + '.' + CSS.escape(c)));
+		for (let i = 0; i < classes.length; i++) {
+			for (let j = i + 1; j < classes.length; j++) simple.push(tag + '.' + CSS.escape(classes[i]) + '.' + CSS.escape(classes[j]));
+		}
+		byAttr(['title', 'alt', 'href', 'type']);
+		simple.push(tag);
+
+		let css = null;
+		for (const s of simple) {
+			const c = check('css', s);
+			if (c && c.unique) { css = c; break; }
+		}
+		for (let cur = up(el); !css && cur && cur !== document.body; cur = up(cur)) {
+			let anchor = '';
+			if (cur.id && stable(cur.id)) anchor = '#' + CSS.escape(cur.id);
+			else if (cur.getAttribute('data-testid')) anchor = '[data-testid=' + JSON.stringify(cur.getAttribute('data-testid')) + ']';
+			if (!anchor) continue;
+			for (const s of simple) {
+				const c = check('css', anchor + ' ' + s);
+				if (c && c.unique) { css = c; break; }
+			}
+			break;
+		}
+		if (css) add(css);
+		if (!candidates.some(c => c.unique)) add(check('path', getSelector(el)));
+
+		return JSON.stringify(candidates);
+	}`
+}
@@ -0,0 +1,341 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Map diffs: browser_diff_map compares the page with the last browser_map
+// by element identity, not by map line. An element is the same one if it
+// has the same BiDi shared ID or, failing that (it was re-rendered), the
+// same fingerprint on the same page. Each element is then reported as
+// added, removed, moved (its order among the other elements changed),
+// relabelled, or with its state changed (enabled, checked, value,
+// expanded).
+
+// elementState is the state of an element that maps show and map diffs
+// track.
+type elementState struct {
+	Enabled  bool    `json:"enabled"`
+	Checked  *bool   `json:"checked,omitempty"`  // nil if not checkable
+	Value    *string `json:"value,omitempty"`    // nil if not a text field or select
+	Expanded *bool   `json:"expanded,omitempty"` // nil if not expandable
+	Required bool    `json:"required,omitempty"`
+	Invalid  bool    `json:"invalid,omitempty"` // failed validation the user has seen, or aria-invalid
+}
+
+// fields returns the state map diffs track as name, value pairs, in report
+// order. A field the element doesn't have is nil.
+func (s elementState) fields() [][2]interface{} {
+	var checked, value, expanded interface{}
+	if s.Checked != nil {
+		checked = *s.Checked
+	}
+	if s.Value != nil {
+		value = *s.Value
+	}
+	if s.Expanded != nil {
+		expanded = *s.Expanded
+	}
+	return [][2]interface{}{{"enabled", s.Enabled}, {"checked", checked}, {"value", value}, {"expanded", expanded}}
+}
+
+// elementStateJS returns the JS elementState(el) function that records an
+// elementState. Password values are masked.
+func elementStateJS() string {
+	return `function elementState(el) {
+			const tag = el.tagName.toLowerCase();
+			const type = (el.getAttribute('type') || '').toLowerCase();
+			const state = {
+				enabled: !(el.disabled === true || el.getAttribute('aria-disabled') === 'true' || !!el.closest('fieldset[disabled]')),
+			};
+			if (tag === 'input' && (type === 'checkbox' || type === 'radio')) state.checked = el.checked;
+			else if (el.hasAttribute('aria-checked')) state.checked = el.getAttribute('aria-checked') === 'true';
+			if (tag === 'select' || tag === 'textarea' ||
+				(tag === 'input' && !['checkbox', 'radio', 'button', 'submit', 'reset', 'image', 'file', 'hidden'].includes(type))) {
+				const value = String(el.value || '');
+				state.value = type === 'password' ? '•'.repeat(value.length) : value.slice(0, 100);
+			}
+			if (el.hasAttribute('aria-expanded')) state.expanded = el.getAttribute('aria-expanded') === 'true';
+			else if (tag === 'details') state.expanded = el.open;
+			else if (tag === 'summary' && el.parentElement && el.parentElement.tagName === 'DETAILS') state.expanded = el.parentElement.open;
+			if (el.required === true || el.getAttribute('aria-required') === 'true') state.required = true;
+			let invalid = el.getAttribute('aria-invalid') === 'true';
+			try { invalid = invalid || el.matches(':user-invalid'); } catch (e) { /* not supported */ }
+			if (invalid) state.invalid = true;
+			return state;
+		}`
+}
+
+// mapSnapshot is a map as browser_diff_map remembers it.
+type mapSnapshot struct {
+	Options mapOptions // what the map listed
+	Entries []mapEntry // in map order
+}
+
+// mapEntry is one element of a map.
+type mapEntry struct {
+	Ref   string
+	Label string
+	*elementRef
+}
+
+// mapDiff is the result of browser_diff_map. Refs are the new map's,
+// except for removed elements, which keep the old map's.
+type mapDiff struct {
+	Added        []mapChange `json:"added"`
+	Removed      []mapChange `json:"removed"`
+	Moved        []mapChange `json:"moved"`
+	Relabelled   []mapChange `json:"relabelled"`
+	StateChanged []mapChange `json:"stateChanged"`
+	Unchanged    int         `json:"unchanged"`
+}
+
+// mapChange is one changed element in a mapDiff.
+type mapChange struct {
+	Ref           string                  `json:"ref"`
+	Label         string                  `json:"label"`
+	PreviousRef   string                  `json:"previousRef,omitempty"`
+	PreviousLabel string                  `json:"previousLabel,omitempty"`
+	Changes       map[string]*stateChange `json:"changes,omitempty"`
+	order         []string                // keys of Changes, in report order
+}
+
+// stateChange is a changed state field. A field the element didn't (or
+// no longer does) have is null.
+type stateChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// empty reports whether nothing changed.
+func (d *mapDiff) empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Moved)+len(d.Relabelled)+len(d.StateChanged) == 0
+}
+
+// diffMaps compares two maps of the same page.
+func diffMaps(prev, curr []mapEntry) *mapDiff {
+	d := &mapDiff{
+		Added:        []mapChange{},
+		Removed:      []mapChange{},
+		Moved:        []mapChange{},
+		Relabelled:   []mapChange{},
+		StateChanged: []mapChange{},
+	}
+
+	match := matchEntries(prev, curr)
+	matched := make([]bool, len(curr))
+	var pairs [][2]int // matched (prev, curr) indexes, in prev order
+	for i, j := range match {
+		if j < 0 {
+			d.Removed = append(d.Removed, mapChange{Ref: prev[i].Ref, Label: prev[i].Label})
+			continue
+		}
+		matched[j] = true
+		pairs = append(pairs, [2]int{i, j})
+	}
+	for j, e := range curr {
+		if !matched[j] {
+			d.Added = append(d.Added, mapChange{Ref: e.Ref, Label: e.Label})
+		}
+	}
+
+	// The elements that keep their order are the longest run of pairs
+	// whose new indexes increase; the others moved
+	order := make([]int, len(pairs))
+	for k, p := range pairs {
+		order[k] = p[1]
+	}
+	kept := longestIncreasing(order)
+
+	// Report in new map order
+	sort.Slice(pairs, func(a, b int) bool { return pairs[a][1] < pairs[b][1] })
+	for _, p := range pairs {
+		was, now := prev[p[0]], curr[p[1]]
+		change := mapChange{Ref: now.Ref, Label: now.Label, PreviousRef: was.Ref}
+		changed := false
+		if !kept[p[1]] {
+			d.Moved = append(d.Moved, change)
+			changed = true
+		}
+		if now.Label != was.Label {
+			relabelled := change
+			relabelled.PreviousLabel = was.Label
+			d.Relabelled = append(d.Relabelled, relabelled)
+			changed = true
+		}
+		if was.SharedID != "" && now.SharedID != "" {
+			before, after := was.State.fields(), now.State.fields()
+			stated := change
+			for k := range after {
+				if before[k][1] != after[k][1] {
+					if stated.Changes == nil {
+						stated.Changes = make(map[string]*stateChange)
+					}
+					name := after[k][0].(string)
+					stated.Changes[name] = &stateChange{From: before[k][1], To: after[k][1]}
+					stated.order = append(stated.order, name)
+				}
+			}
+			if stated.Changes != nil {
+				d.StateChanged = append(d.StateChanged, stated)
+				changed = true
+			}
+		}
+		if !changed {
+			d.Unchanged++
+		}
+	}
+	return d
+}
+
+// matchEntries pairs the elements of two maps: match[i] is the index in
+// curr of prev[i]'s element, or -1 if it is gone.
+func matchEntries(prev, curr []mapEntry) []int {
+	match := make([]int, len(prev))
+	used := make([]bool, len(curr))
+	byID := make(map[string]int, len(curr))
+	for j, e := range curr {
+		if e.SharedID != "" {
+			byID[e.SharedID] = j
+		}
+	}
+	for i, e := range prev {
+		match[i] = -1
+		if j, ok := byID[e.SharedID]; ok && e.SharedID != "" && !used[j] {
+			match[i], used[j] = j, true
+		}
+	}
+
+	// Re-rendered elements get new shared IDs; pair them by fingerprint,
+	// the nearest first
+	for i, e := range prev {
+		if match[i] >= 0 || e.SharedID == "" {
+			continue
+		}
+		best, bestDist := -1, math.Inf(1)
+		for j, c := range curr {
+			if used[j] || c.SharedID == "" || !samePrint(e.Print, c.Print) {
+				continue
+			}
+			dist := math.Hypot(float64(c.Print.X-e.Print.X), float64(c.Print.Y-e.Print.Y))
+			if dist < bestDist {
+				best, bestDist = j, dist
+			}
+		}
+		if best >= 0 {
+			match[i], used[best] = best, true
+		}
+	}
+	return match
+}
+
+// samePrint reports whether two fingerprints are of the same element, as
+// the resolveRefScript check does.
+func samePrint(a, b fingerprint) bool {
+	return a.Tag == b.Tag && a.Role == b.Role && a.Name == b.Name && a.Context == b.Context && a.URL == b.URL
+}
+
+// longestIncreasing returns the values of a longest strictly increasing
+// subsequence of seq.
+func longestIncreasing(seq []int) map[int]bool {
+	tails := []int{} // tails[k]: index in seq ending the best run of length k+1
+	prev := make([]int, len(seq))
+	for i, v := range seq {
+		k := sort.Search(len(tails), func(k int) bool { return seq[tails[k]] >= v })
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	kept := make(map[int]bool, len(tails))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			kept[seq[i]] = true
+		}
+	}
+	return kept
+}
+
+// String formats the diff for browser_diff_map's text output, one element
+// per line: - removed, + added, > moved, ~ relabelled, * state changed.
+func (d *mapDiff) String() string {
+	if d.empty() {
+		return "No changes detected"
+	}
+	var lines []string
+	for _, c := range d.Removed {
+		lines = append(lines, fmt.Sprintf("- %s %s", c.Ref, c.Label))
+	}
+	for _, c := range d.Added {
+		lines = append(lines, fmt.Sprintf("+ %s %s", c.Ref, c.Label))
+	}
+	for _, c := range d.Moved {
+		lines = append(lines, fmt.Sprintf("> %s %s (moved; was %s)", c.Ref, c.Label, c.PreviousRef))
+	}
+	for _, c := range d.Relabelled {
+		lines = append(lines, fmt.Sprintf("~ %s %s (was %s)", c.Ref, c.Label, c.PreviousLabel))
+	}
+	for _, c := range d.StateChanged {
+		var changes []string
+		for _, name := range c.order {
+			s := c.Changes[name]
+			changes = append(changes, fmt.Sprintf("%s %s → %s", name, formatStateValue(s.From), formatStateValue(s.To)))
+		}
+		lines = append(lines, fmt.Sprintf("* %s %s: %s", c.Ref, c.Label, strings.Join(changes, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatStateValue formats a state field value for text output.
+func formatStateValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "(none)"
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// browserDiffMap maps the page again and reports what changed since the
+// last map.
+func (h *Handlers) browserDiffMap(args map[string]interface{}) (*ToolsCallResult, error) {
+	if h.lastMap == nil {
+		return nil, fmt.Errorf("no previous map to diff against — run browser_map first")
+	}
+	if err := h.ensureBrowser(); err != nil {
+		return nil, err
+	}
+
+	prev := h.lastMap
+	if _, err := h.mapPage(prev.Options); err != nil {
+		return nil, err
+	}
+	d := diffMaps(prev.Entries, h.lastMap.Entries)
+
+	output := d.String()
+	if format, _ := args["format"].(string); format == "json" {
+		data, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		output = string(data)
+	}
+
+	return &ToolsCallResult{
+		Content: []Content{{
+			Type: "text",
+			Text: output,
+		}},
+	}, nil
+}
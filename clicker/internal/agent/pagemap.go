@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Map options: browser_map can list only some elements (those in the
+// viewport, with given roles, or form controls), show each element's state
+// after its label, group the elements under the landmark, form or dialog
+// they are in, and return JSON instead of text.
+
+// mapOptions are the browser_map arguments that shape a map. The JSON
+// fields are the ones mapScript filters by.
+type mapOptions struct {
+	Scope      string   `json:"scope,omitempty"` // CSS selector of the subtree to map
+	InViewport bool     `json:"inViewport,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	FormsOnly  bool     `json:"formsOnly,omitempty"`
+	State      bool     `json:"-"` // show state in text output
+	Group      bool     `json:"-"` // group text output under landmarks, forms and dialogs
+}
+
+// mapOptionsFromArgs reads mapOptions from browser_map arguments.
+func mapOptionsFromArgs(args map[string]interface{}) mapOptions {
+	var opts mapOptions
+	opts.Scope, _ = args["selector"].(string)
+	opts.InViewport, _ = args["inViewport"].(bool)
+	opts.FormsOnly, _ = args["formsOnly"].(bool)
+	opts.State, _ = args["state"].(bool)
+	opts.Group, _ = args["group"].(bool)
+	if roles, _ := args["role"].(string); roles != "" {
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.ToLower(strings.TrimSpace(role)); role != "" {
+				opts.Roles = append(opts.Roles, role)
+			}
+		}
+	}
+	return opts
+}
+
+// mapElement is one element of a map.
+type mapElement struct {
+	Ref      string       `json:"ref"`
+	Tag      string       `json:"tag"`
+	Role     string       `json:"role,omitempty"`
+	Label    string       `json:"label"`
+	Group    string       `json:"group,omitempty"` // landmark, form or dialog it is in, e.g. `form "Sign in"`
+	Selector string       `json:"selector"`
+	State    elementState `json:"state"`
+}
+
+// formatMap formats a map as text, one element per line.
+func formatMap(elements []mapElement, opts mapOptions) string {
+	if len(elements) == 0 {
+		return "No interactive elements found"
+	}
+	var lines []string
+	group := ""
+	for _, el := range elements {
+		line := el.Ref + " " + el.Label
+		if opts.State {
+			if state := el.State.summary(); state != "" {
+				line += " (" + state + ")"
+			}
+		}
+		if opts.Group {
+			if el.Group != group {
+				group = el.Group
+				if group != "" {
+					lines = append(lines, group+":")
+				}
+			}
+			if group != "" {
+				line = "  " + line
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mapJSON formats a map as JSON, {"elements": [...]}. Like paginate it keeps
+// to the budget the maxChars and maxTokens arguments set and resumes from
+// cursor, but it pages by whole elements so that every page is valid JSON.
+// A page that isn't the whole map also has the total number of elements,
+// the index of its first one and, unless it is the last, nextCursor.
+func mapJSON(elements []mapElement, args map[string]interface{}) (string, error) {
+	if elements == nil {
+		elements = []mapElement{}
+	}
+	all, err := json.Marshal(elements)
+	if err != nil {
+		return "", err
+	}
+	hash := outputHash(string(all))
+
+	start := 0
+	changed := false
+	cursor, _ := args["cursor"].(string)
+	if cursor != "" {
+		offset, cursorHash, err := parseCursor(cursor)
+		if err != nil {
+			return "", err
+		}
+		if offset > len(elements) {
+			return "", fmt.Errorf("cursor %s is past the end of the map (%d elements) — the page has changed, start again without cursor", cursor, len(elements))
+		}
+		start, changed = offset, cursorHash != hash
+	}
+
+	// As many elements as fit, and at least one
+	end := len(elements)
+	if budget := pageBudget(args); budget > 0 {
+		size := 0
+		for end = start; end < len(elements); end++ {
+			data, err := json.MarshalIndent(elements[end], "    ", "  ")
+			if err != nil {
+				return "", err
+			}
+			size += utf8.RuneCount(data) + 6 // indent, comma, newline
+			if size > budget && end > start {
+				break
+			}
+		}
+	}
+
+	page := map[string]interface{}{"elements": elements[start:end]}
+	if start > 0 || end < len(elements) {
+		page["total"] = len(elements)
+		page["start"] = start
+	}
+	if end < len(elements) {
+		page["nextCursor"] = formatCursor(end, hash)
+	}
+	if changed {
+		page["pageChanged"] = true
+	}
+	data, err := json.MarshalIndent(page, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// summary describes the state for a map line, e.g.
+// `value="", required, invalid`.
+func (s elementState) summary() string {
+	var parts []string
+	if s.Value != nil {
+		parts = append(parts, fmt.Sprintf("value=%q", *s.Value))
+	}
+	if s.Checked != nil {
+		if *s.Checked {
+			parts = append(parts, "checked")
+		} else {
+			parts = append(parts, "unchecked")
+		}
+	}
+	if !s.Enabled {
+		parts = append(parts, "disabled")
+	}
+	if s.Required {
+		parts = append(parts, "required")
+	}
+	if s.Expanded != nil {
+		if *s.Expanded {
+			parts = append(parts, "expanded")
+		} else {
+			parts = append(parts, "collapsed")
+		}
+	}
+	if s.Invalid {
+		parts = append(parts, "invalid")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// mapGroupJS returns the JS groupOf(el) function: the innermost landmark,
+// form or dialog around el, as role and name (`navigation "Main"`), or "".
+func mapGroupJS() string {
+	return `function groupOf(el) {
+			const clean = s => String(s || '').trim().replace(/\s+/g, ' ').slice(0, 60);
+			const roles = ['navigation', 'main', 'banner', 'contentinfo', 'complementary', 'search', 'form', 'dialog', 'alertdialog', 'region'];
+			const tags = { nav: 'navigation', main: 'main', aside: 'complementary', form: 'form', dialog: 'dialog', search: 'search' };
+			// Up through shadow roots too
+			const up = n => n.parentElement || n.getRootNode().host || null;
+
+			for (let cur = up(el); cur && cur !== document.body; cur = up(cur)) {
+				const tag = cur.tagName.toLowerCase();
+				const named = cur.hasAttribute('aria-label') || cur.hasAttribute('aria-labelledby');
+				let role = cur.getAttribute('role');
+				if (!roles.includes(role)) {
+					role = tags[tag] || '';
+					// header and footer are landmarks only at the top level
+					const sectioning = 'article, aside, main, nav, section';
+					if (tag === 'header' && !cur.parentElement?.closest(sectioning)) role = 'banner';
+					if (tag === 'footer' && !cur.parentElement?.closest(sectioning)) role = 'contentinfo';
+					if (tag === 'section' && named) role = 'region';
+				}
+				if (!role) continue;
+
+				let name = cur.getAttribute('aria-label') || '';
+				const labelledBy = cur.getAttribute('aria-labelledby');
+				if (!name && labelledBy) {
+					name = labelledBy.split(/\s+/).map(id => cur.getRootNode().getElementById(id)).filter(Boolean).map(l => l.textContent).join(' ');
+				}
+				if (!name && ['form', 'dialog', 'alertdialog', 'region'].includes(role)) {
+					const heading = cur.querySelector('h1, h2, h3, h4, h5, h6, legend');
+					if (heading) name = heading.textContent;
+				}
+				name = clean(name);
+				return role + (name ? ' "' + name + '"' : '');
+			}
+			return '';
+		}`
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vibium/clicker/internal/api"
+)
+
+// Budgeted output for tools that can return a whole page (browser_get_text,
+// browser_get_html, browser_a11y_tree, browser_map). Results longer than the
+// budget are cut at a line break where possible and end with a marker giving
+// the cursor for the next page. The cursor is the offset to resume from plus
+// a hash of the full output, so a page that changed in between is noticed.
+
+// defaultMaxChars is the output budget when the caller gives none, about
+// 10k tokens.
+const defaultMaxChars = 40000
+
+// charsPerToken converts a maxTokens budget to characters.
+const charsPerToken = 4
+
+// pagedTools take maxChars, maxTokens and cursor; outlineTools also take
+// outline.
+var (
+	pagedTools   = map[string]bool{"browser_get_text": true, "browser_get_html": true, "browser_a11y_tree": true, "browser_map": true}
+	outlineTools = map[string]bool{"browser_get_text": true, "browser_get_html": true, "browser_a11y_tree": true}
+)
+
+// withPagingArgs adds the budget and pagination arguments to the tools that
+// take them.
+func withPagingArgs(tools []Tool) []Tool {
+	for _, tool := range tools {
+		if !pagedTools[tool.Name] {
+			continue
+		}
+		props := tool.InputSchema["properties"].(map[string]interface{})
+		props["maxChars"] = map[string]interface{}{
+			"type":        "number",
+			"description": fmt.Sprintf("Maximum characters to return (default: %d, 0 = no limit). Longer output ends with a marker giving the cursor for the next page.", defaultMaxChars),
+		}
+		props["maxTokens"] = map[string]interface{}{
+			"type":        "number",
+			"description": "Maximum tokens to return, estimated as 4 characters each (overrides maxChars)",
+		}
+		props["cursor"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Cursor from the previous page's truncation marker, to get the next page",
+		}
+		if outlineTools[tool.Name] {
+			props["outline"] = map[string]interface{}{
+				"type":        "boolean",
+				"description": "Return only the page structure, headings and landmarks, instead of the full content (default: false)",
+			}
+		}
+	}
+	return tools
+}
+
+// paginate returns the page of output selected by the maxChars, maxTokens
+// and cursor arguments. Offsets count characters (runes), not bytes.
+func paginate(output string, args map[string]interface{}) (string, error) {
+	budget := pageBudget(args)
+	cursor, _ := args["cursor"].(string)
+	if cursor == "" && (budget <= 0 || utf8.RuneCountInString(output) <= budget) {
+		return output, nil
+	}
+
+	chars := []rune(output)
+	hash := outputHash(output)
+	start := 0
+	var note string
+	if cursor != "" {
+		offset, cursorHash, err := parseCursor(cursor)
+		if err != nil {
+			return "", err
+		}
+		if offset > len(chars) {
+			return "", fmt.Errorf("cursor %s is past the end of the output (%d chars) — the page has changed, start again without cursor", cursor, len(chars))
+		}
+		if cursorHash != hash {
+			note = "[page changed since the previous page was read; continuing at the same offset]\n"
+		}
+		start = offset
+	}
+
+	rest := chars[start:]
+	if budget <= 0 || len(rest) <= budget {
+		return note + string(rest) + marker(rest, fmt.Sprintf("[end of output: chars %d-%d of %d]", start, len(chars), len(chars))), nil
+	}
+
+	end := budget
+	// Prefer to cut after a whole line, unless that loses over half the page
+	for i := end - 1; i >= end/2; i-- {
+		if rest[i] == '\n' {
+			end = i + 1
+			break
+		}
+	}
+
+	next := start + end
+	page := rest[:end]
+	return note + string(page) + marker(page, fmt.Sprintf("[truncated: showing chars %d-%d of %d; next cursor=%s]", start, next, len(chars), formatCursor(next, hash))), nil
+}
+
+// pageBudget returns the output budget in characters the maxChars and
+// maxTokens arguments set; 0 or less means no limit.
+func pageBudget(args map[string]interface{}) int {
+	budget := defaultMaxChars
+	if v, ok := args["maxChars"].(float64); ok {
+		budget = int(v)
+	}
+	if v, ok := args["maxTokens"].(float64); ok && v > 0 {
+		budget = int(v) * charsPerToken
+	}
+	return budget
+}
+
+// marker formats text to follow page on a line of its own, after a blank line.
+func marker(page []rune, text string) string {
+	if len(page) > 0 && page[len(page)-1] == '\n' {
+		return "\n" + text
+	}
+	return "\n\n" + text
+}
+
+func outputHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+func formatCursor(offset int, hash string) string {
+	return strconv.Itoa(offset) + "." + hash
+}
+
+func parseCursor(cursor string) (int, string, error) {
+	offsetStr, hash, _ := strings.Cut(cursor, ".")
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return 0, "", fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return offset, hash, nil
+}
+
+// outlineScript returns the JS function that lists the headings and
+// landmarks under scopeSelector (or the whole page), indented by nesting.
+func outlineScript() string {
+	return `(scopeSelector) => {
+		` + api.ShadowDOMJS() + `
+		const root = scopeSelector ? querySelectorDeep(document, scopeSelector) : document.body;
+		if (!root) return '';
+
+		const landmarkTags = { HEADER: 'banner', NAV: 'navigation', MAIN: 'main', ASIDE: 'complementary', FOOTER: 'contentinfo', FORM: 'form', SECTION: 'region' };
+		const landmarks = new Set(['banner', 'navigation', 'main', 'complementary', 'contentinfo', 'form', 'region', 'search']);
+		const lines = [];
+
+		const walk = (el, depth) => {
+			for (const child of el.children) {
+				const style = getComputedStyle(child);
+				if (style.display === 'none' || style.visibility === 'hidden') continue;
+
+				const role = child.getAttribute('role') || landmarkTags[child.tagName];
+				const name = (child.getAttribute('aria-label') || '').trim();
+				const indent = '  '.repeat(depth);
+
+				if (/^H[1-6]$/.test(child.tagName) || role === 'heading') {
+					const level = Number(child.getAttribute('aria-level') || child.tagName[1]) || 2;
+					const text = (child.innerText || '').trim().replace(/\s+/g, ' ');
+					if (text) lines.push(indent + '#'.repeat(level) + ' ' + text);
+					continue;
+				}
+				// Unnamed sections aren't landmarks
+				if (landmarks.has(role) && !(role === 'region' && !name)) {
+					lines.push(indent + '[' + role + (name ? ' "' + name + '"' : '') + ']');
+					walk(child, depth + 1);
+					continue;
+				}
+				walk(child, depth);
+			}
+		};
+		walk(root, 0);
+		return lines.join('\n');
+	}`
+}
@@ -0,0 +1,263 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/vibium/clicker/internal/bidi"
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/log"
+)
+
+// Navigation policy: the URLs a session may load as a page or frame. The
+// URLs given to browser_navigate and browser_new_page are checked before
+// anything is sent; navigations the page starts itself (links, redirects,
+// scripts, frames, popups) are caught by a BiDi intercept and failed,
+// whether or not a tool call is in flight.
+//
+// A pattern containing "://" is a URL glob, as in browser_route. Any other
+// pattern is a host: "example.com" matches example.com and its subdomains,
+// and "*.example.com" only the subdomains. about: URLs are always allowed.
+
+// NavigationPolicy lists the allowed and blocked URL patterns. A URL is
+// blocked if it matches a Block pattern, or if Allow is non-empty and it
+// matches none of the Allow patterns.
+type NavigationPolicy struct {
+	Allow []string `json:"allow"`
+	Block []string `json:"block"`
+
+	allow, block []urlPattern
+}
+
+// urlPattern is a compiled policy pattern.
+type urlPattern struct {
+	raw  string
+	re   *regexp.Regexp
+	host bool // re matches the host name, not the whole URL
+}
+
+// NewNavigationPolicy compiles a policy from allow and block patterns. It
+// returns nil if both are empty.
+func NewNavigationPolicy(allow, block []string) (*NavigationPolicy, error) {
+	p := &NavigationPolicy{}
+	var err error
+	if p.allow, err = compilePatterns(allow); err != nil {
+		return nil, err
+	}
+	if p.block, err = compilePatterns(block); err != nil {
+		return nil, err
+	}
+	if len(p.allow) == 0 && len(p.block) == 0 {
+		return nil, nil
+	}
+	for _, pat := range p.allow {
+		p.Allow = append(p.Allow, pat.raw)
+	}
+	for _, pat := range p.block {
+		p.Block = append(p.Block, pat.raw)
+	}
+	return p, nil
+}
+
+// LoadNavigationPolicy reads a policy from a JSON file of the form
+// {"allow": [...], "block": [...]}, adding the extra allow and block
+// patterns to the file's. path may be empty.
+func LoadNavigationPolicy(path string, allow, block []string) (*NavigationPolicy, error) {
+	var file NavigationPolicy
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy: %w", err)
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+		}
+	}
+	return NewNavigationPolicy(append(file.Allow, allow...), append(file.Block, block...))
+}
+
+func compilePatterns(patterns []string) ([]urlPattern, error) {
+	var out []urlPattern
+	for _, raw := range patterns {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		pat := urlPattern{raw: raw, host: !strings.Contains(raw, "://")}
+		glob := raw
+		if pat.host {
+			glob = strings.ToLower(raw)
+			if !strings.HasPrefix(glob, "*") {
+				// A bare domain also covers its subdomains
+				glob = "{,*.}" + glob
+			}
+		}
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", raw, err)
+		}
+		pat.re = re
+		out = append(out, pat)
+	}
+	return out, nil
+}
+
+func (pat urlPattern) matches(u *url.URL, rawURL string) bool {
+	if pat.host {
+		return u != nil && u.Host != "" && pat.re.MatchString(strings.ToLower(u.Hostname()))
+	}
+	return pat.re.MatchString(rawURL)
+}
+
+// Check returns why rawURL is blocked, or "" if it is allowed.
+func (p *NavigationPolicy) Check(rawURL string) string {
+	if p == nil {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		u = nil
+	} else if u.Scheme == "about" {
+		return ""
+	}
+	for _, pat := range p.block {
+		if pat.matches(u, rawURL) {
+			return fmt.Sprintf("matches blocked pattern %q", pat.raw)
+		}
+	}
+	if len(p.allow) == 0 {
+		return ""
+	}
+	for _, pat := range p.allow {
+		if pat.matches(u, rawURL) {
+			return ""
+		}
+	}
+	return "not in the allowed domains"
+}
+
+// String describes the policy for logs.
+func (p *NavigationPolicy) String() string {
+	return fmt.Sprintf("allow=%v block=%v", p.Allow, p.Block)
+}
+
+// checkNavigation returns a NavigationBlockedError if the policy blocks
+// rawURL.
+func (h *Handlers) checkNavigation(rawURL string) error {
+	if reason := h.policy.Check(rawURL); reason != "" {
+		log.Warn("navigation blocked", "url", rawURL, "reason", reason)
+		return &errs.NavigationBlockedError{URL: rawURL, Reason: reason}
+	}
+	return nil
+}
+
+// navGuard enforces the policy on navigations the page starts itself.
+type navGuard struct {
+	mu        sync.Mutex
+	intercept string                         // BiDi intercept ID; "" when not installed
+	blocked   []*errs.NavigationBlockedError // blocked since the last take
+}
+
+// install adds the intercept the guard checks navigations with. BiDi
+// intercepts can't be limited to navigations, and urlPatterns can't express
+// the policy's host patterns, so it blocks every request; the event
+// handler, on the client's reader goroutine, lets anything but a navigation
+// continue at once, between tool calls too.
+func (g *navGuard) install(client *bidi.Client) error {
+	if _, err := client.SendCommand("session.subscribe", map[string]interface{}{
+		"events": []string{"network.beforeRequestSent"},
+	}); err != nil {
+		return err
+	}
+	msg, err := client.SendCommand("network.addIntercept", map[string]interface{}{
+		"phases": []string{"beforeRequestSent"},
+	})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Intercept string `json:"intercept"`
+	}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		return fmt.Errorf("failed to parse addIntercept response: %w", err)
+	}
+	g.mu.Lock()
+	g.intercept = result.Intercept
+	g.mu.Unlock()
+	return nil
+}
+
+// owns reports whether the request was blocked by the guard's intercept.
+func (g *navGuard) owns(ev *blockedRequest) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.intercept != "" && ev.hasIntercept(g.intercept)
+}
+
+// handle fails the request if it is a navigation the policy blocks, and
+// reports whether it did.
+func (g *navGuard) handle(client *bidi.Client, policy *NavigationPolicy, ev *blockedRequest) bool {
+	if !g.owns(ev) || !ev.isNavigation() {
+		return false
+	}
+	url := ev.Params.Request.URL
+	reason := policy.Check(url)
+	if reason == "" {
+		return false
+	}
+	log.Warn("navigation blocked", "url", url, "reason", reason, "context", ev.Params.Context)
+	g.mu.Lock()
+	g.blocked = append(g.blocked, &errs.NavigationBlockedError{URL: url, Reason: reason})
+	g.mu.Unlock()
+	if err := client.SendCommandNoWait("network.failRequest", map[string]interface{}{
+		"request": ev.Params.Request.Request,
+	}); err != nil {
+		log.Debug("failRequest failed", "request", ev.Params.Request.Request, "error", err)
+	}
+	return true
+}
+
+// take returns the first navigation blocked since the last call, or nil,
+// and forgets the rest.
+func (g *navGuard) take() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.blocked) == 0 {
+		return nil
+	}
+	first := g.blocked[0]
+	g.blocked = nil
+	return first
+}
+
+// reset forgets the intercept, for when the browser goes away.
+func (g *navGuard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.intercept = ""
+	g.blocked = nil
+}
+
+// SetNavigationPolicy restricts the URLs this session's pages may load. nil
+// removes the restriction. It applies to browsers started afterwards, and to
+// named sessions.
+func (h *Handlers) SetNavigationPolicy(p *NavigationPolicy) {
+	h.policy = p
+}
+
+// startNavGuard installs the navigation guard on a newly started browser.
+func (h *Handlers) startNavGuard() error {
+	if h.policy == nil {
+		return nil
+	}
+	if err := h.addIntercept(h.guard.install); err != nil {
+		return fmt.Errorf("failed to install navigation policy: %w", err)
+	}
+	log.Info("navigation policy active", "policy", h.policy)
+	return nil
+}
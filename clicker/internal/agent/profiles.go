@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tool profiles limit the tools a server offers, both in tools/list and
+// when a tool is called, so an untrusted agent can't run scripts or touch
+// files, and a small agent isn't handed ~90 tool schemas.
+
+// Tool profile names.
+const (
+	ProfileReadOnly = "readonly" // navigate, read the page, take screenshots
+	ProfileInteract = "interact" // readonly plus clicking, typing and the like; no eval, no file access
+	ProfileFull     = "full"     // every tool
+)
+
+// readOnlyTools make up the readonly profile.
+var readOnlyTools = []string{
+	"browser_start", "browser_stop", "browser_list_sessions",
+	"browser_navigate", "browser_back", "browser_forward", "browser_reload",
+	"browser_new_page", "browser_list_pages", "browser_switch_page", "browser_close_page",
+	"browser_frames", "browser_frame",
+	"browser_screenshot", "browser_highlight",
+	"browser_get_text", "browser_get_html", "browser_get_url", "browser_get_title",
+	"browser_get_value", "browser_get_attribute", "browser_a11y_tree",
+	"browser_map", "browser_diff_map", "browser_locate", "browser_find", "browser_find_all", "browser_count",
+	"browser_is_visible", "browser_is_enabled", "browser_is_checked",
+	"browser_get_viewport", "browser_get_window",
+	"browser_scroll", "browser_scroll_into_view",
+	"browser_wait", "browser_wait_for_url", "browser_wait_for_load", "browser_wait_for_text", "browser_sleep",
+}
+
+// interactTools are added to readOnlyTools in the interact profile.
+var interactTools = []string{
+	"browser_click", "browser_dblclick", "browser_hover", "browser_focus",
+	"browser_type", "browser_fill", "browser_press", "browser_keys",
+	"browser_select", "browser_check", "browser_uncheck",
+	"browser_mouse_move", "browser_mouse_down", "browser_mouse_up", "browser_mouse_click", "browser_drag",
+	"browser_dialog_accept", "browser_dialog_dismiss",
+	"browser_set_viewport", "browser_set_window", "browser_emulate_media",
+	"page_clock_install", "page_clock_fast_forward", "page_clock_run_for", "page_clock_pause_at",
+	"page_clock_resume", "page_clock_set_fixed_time", "page_clock_set_system_time", "page_clock_set_timezone",
+}
+
+// ToolFilter is the set of tools a server offers. A nil *ToolFilter offers
+// every tool.
+type ToolFilter struct {
+	Profile string // profile the set started from, for error messages
+	allowed map[string]bool
+}
+
+// NewToolFilter builds the tool set for a profile, with include added and
+// exclude removed. An empty profile means full, unless include is given, in
+// which case only the included tools are offered. It returns nil if the
+// result is every tool.
+func NewToolFilter(profile string, include, exclude []string) (*ToolFilter, error) {
+	known := make(map[string]bool)
+	for _, tool := range GetToolSchemas() {
+		known[tool.Name] = true
+	}
+	for _, name := range append(append([]string(nil), include...), exclude...) {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+	}
+
+	f := &ToolFilter{Profile: strings.ToLower(profile), allowed: make(map[string]bool)}
+	switch f.Profile {
+	case "":
+		if len(include) == 0 {
+			f.Profile = ProfileFull
+		} else {
+			f.Profile = "custom"
+		}
+	case ProfileReadOnly, ProfileInteract, ProfileFull:
+	default:
+		return nil, fmt.Errorf("unknown tool profile %q (expected %s, %s or %s)", profile, ProfileReadOnly, ProfileInteract, ProfileFull)
+	}
+
+	switch f.Profile {
+	case ProfileFull:
+		for name := range known {
+			f.allowed[name] = true
+		}
+	case ProfileInteract:
+		for _, name := range interactTools {
+			f.allowed[name] = true
+		}
+		fallthrough
+	case ProfileReadOnly:
+		for _, name := range readOnlyTools {
+			f.allowed[name] = true
+		}
+	}
+	for _, name := range include {
+		f.allowed[name] = true
+	}
+	for _, name := range exclude {
+		delete(f.allowed, name)
+	}
+
+	if len(f.allowed) == len(known) {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Allows reports whether the tool is offered.
+func (f *ToolFilter) Allows(name string) bool {
+	return f == nil || f.allowed[name]
+}
+
+// Filter returns the tools that are offered.
+func (f *ToolFilter) Filter(tools []Tool) []Tool {
+	if f == nil {
+		return tools
+	}
+	var out []Tool
+	for _, tool := range tools {
+		if f.allowed[tool.Name] {
+			out = append(out, tool)
+		}
+	}
+	return out
+}
+
+// SetToolFilter limits the tools this session offers. nil offers every
+// tool. Named sessions inherit it.
+func (h *Handlers) SetToolFilter(f *ToolFilter) {
+	h.tools = f
+}
+
+// ToolSchemas returns the schemas of the tools this session offers, for
+// tools/list.
+func (h *Handlers) ToolSchemas() []Tool {
+	return h.tools.Filter(GetToolSchemas())
+}
+
+// restrictedSchemes are URL schemes only the full profile may navigate to:
+// file: and view-source: read local files or page source, which a readonly or
+// interact agent isn't meant to see, and javascript: runs script, which they
+// have no eval tool for.
+var restrictedSchemes = []string{"file:", "view-source:", "javascript:"}
+
+// urlControlChars are removed from URLs by browsers before parsing, so
+// "java\tscript:" is still a javascript: URL.
+var urlControlChars = strings.NewReplacer("\t", "", "\n", "", "\r", "")
+
+// checkTool returns an error if the tool isn't offered, or is a navigation
+// to a URL scheme the profile doesn't allow.
+func (h *Handlers) checkTool(name string, args map[string]interface{}) error {
+	if !h.tools.Allows(name) {
+		return fmt.Errorf("tool %s is not available in the %s tool profile", name, h.tools.Profile)
+	}
+	if h.tools == nil || h.tools.Profile == ProfileFull {
+		return nil
+	}
+	if name == "browser_navigate" || name == "browser_new_page" {
+		target, _ := args["url"].(string)
+		target = strings.ToLower(urlControlChars.Replace(strings.TrimSpace(target)))
+		for _, scheme := range restrictedSchemes {
+			if strings.HasPrefix(target, scheme) {
+				return fmt.Errorf("%s URLs are not available in the %s tool profile", strings.TrimSuffix(scheme, ":"), h.tools.Profile)
+			}
+		}
+	}
+	return nil
+}
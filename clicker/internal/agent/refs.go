@@ -0,0 +1,313 @@
+package agent
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"regexp"
+
+	"github.com/vibium/clicker/internal/api"
+	"github.com/vibium/clicker/internal/bidi"
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/log"
+)
+
+// Element refs: browser_map, browser_find and browser_find_all hand out
+// @e1, @e2, ... refs for the elements they report. A ref keeps the
+// element's BiDi shared ID and a fingerprint of it: tag, role, accessible
+// name, the text around it and its rough position. A tool given a ref acts
+// on that same element, passed to its scripts by reference, once its tag,
+// role and name are checked. If the element was replaced (a list
+// re-rendered) it is found again by those, preferring one with the same
+// text around it, then the nearest; if it was removed, or now shows
+// something else, and can't be found again, the call fails with a
+// StaleRefError instead of acting on whatever now sits at the old CSS path.
+
+// elementRef is what a ref stands for.
+type elementRef struct {
+	Selector string // CSS path when the ref was made
+	SharedID string // BiDi shared ID of the element; "" if it wasn't found
+	Context  string // browsing context the ref was made in
+	Print    fingerprint
+	State    elementState // state when the ref was made
+}
+
+// fingerprint is what fingerprintJS records about an element.
+type fingerprint struct {
+	Tag     string `json:"tag"`
+	Role    string `json:"role"`
+	Name    string `json:"name"`
+	Context string `json:"context"` // text around the element
+	X       int    `json:"x"`       // document coordinates
+	Y       int    `json:"y"`
+	URL     string `json:"url"`
+}
+
+// refPattern matches a ref.
+var refPattern = regexp.MustCompile(`^@e\d+$`)
+
+// refArgs are the tool arguments that may hold a ref.
+var refArgs = []string{"selector", "source", "target"}
+
+// refInfo is what the ref scripts record about an element.
+type refInfo struct {
+	Print fingerprint  `json:"print"`
+	State elementState `json:"state"`
+}
+
+// setRefs replaces the refs with @e1, @e2, ... for the elements the
+// selectors match, in the active page.
+func (h *Handlers) setRefs(selectors []string) error {
+	if len(selectors) == 0 {
+		h.refMap = make(map[string]*elementRef)
+		return nil
+	}
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return err
+	}
+	list, err := json.Marshal(selectors)
+	if err != nil {
+		return err
+	}
+	result, err := h.client.CallFunctionRemote(ctx, captureRefsScript(), []interface{}{string(list)})
+	if err != nil {
+		return fmt.Errorf("failed to record refs: %w", err)
+	}
+	infoJSON, ids, err := splitRefsResult(result)
+	if err != nil {
+		return err
+	}
+	var infos []*refInfo
+	if err := json.Unmarshal([]byte(infoJSON), &infos); err != nil {
+		return fmt.Errorf("failed to parse ref fingerprints: %w", err)
+	}
+	h.refMap = newRefMap(ctx, selectors, infos, ids)
+	return nil
+}
+
+// newRefMap builds the refs @e1, @e2, ... for the elements found at
+// selectors in ctx, given what a ref script recorded about them (nil if
+// not found) and their shared IDs.
+func newRefMap(ctx string, selectors []string, infos []*refInfo, ids []string) map[string]*elementRef {
+	refs := make(map[string]*elementRef, len(selectors))
+	for i, selector := range selectors {
+		ref := &elementRef{Selector: selector, Context: ctx}
+		if i < len(ids) && i < len(infos) && ids[i] != "" && infos[i] != nil {
+			ref.SharedID = ids[i]
+			ref.Print = infos[i].Print
+			ref.State = infos[i].State
+		}
+		refs[fmt.Sprintf("@e%d", i+1)] = ref
+	}
+	return refs
+}
+
+// resolveRefs returns args with the refs in refArgs replaced by selectors
+// for their elements, or a StaleRefError if an element is gone. args is
+// copied, not changed. The call's scripts are then passed the elements
+// themselves for those selectors (see api.AgentSession.Nodes), not what the
+// selectors match by then.
+func (h *Handlers) resolveRefs(args map[string]interface{}) (map[string]interface{}, error) {
+	if h.client == nil {
+		return args, nil
+	}
+	out, copied := args, false
+	for _, key := range refArgs {
+		ref, _ := args[key].(string)
+		if !refPattern.MatchString(ref) {
+			continue
+		}
+		selector, err := h.resolveRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		if r := h.refMap[ref]; r.SharedID != "" && h.call != nil {
+			if h.call.nodes == nil {
+				h.call.nodes = make(map[string]string)
+			}
+			h.call.nodes[selector] = r.SharedID
+		}
+		if !copied {
+			out = make(map[string]interface{}, len(args))
+			for k, v := range args {
+				out[k] = v
+			}
+			copied = true
+		}
+		out[key] = selector
+	}
+	return out, nil
+}
+
+// resolveRef finds the element ref stands for and returns a selector for
+// it as the page is now.
+func (h *Handlers) resolveRef(ref string) (string, error) {
+	r, ok := h.refMap[ref]
+	if !ok {
+		return "", &errs.StaleRefError{Ref: ref, Reason: "it isn't one of the refs from the last map, find or find_all"}
+	}
+	if r.SharedID == "" {
+		return r.Selector, nil
+	}
+	ctx, err := h.newSession().GetContextID()
+	if err != nil {
+		return "", err
+	}
+	if ctx != r.Context {
+		return "", &errs.StaleRefError{Ref: ref, Reason: "it was made on another page"}
+	}
+
+	printJSON, err := json.Marshal(r.Print)
+	if err != nil {
+		return "", err
+	}
+	result, err := h.client.CallFunctionRemote(ctx, resolveRefScript(), []interface{}{bidi.SharedReference{SharedID: r.SharedID}, string(printJSON)})
+	var protocol *errs.ProtocolError
+	if err != nil && stderrors.As(err, &protocol) && protocol.Code == "no such node" {
+		// The element (or its document) is gone; look for it by fingerprint
+		result, err = h.client.CallFunctionRemote(ctx, resolveRefScript(), []interface{}{nil, string(printJSON)})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	resJSON, ids, err := splitRefsResult(result)
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Selector string `json:"selector"`
+		Status   string `json:"status"` // same, moved or stale
+		Reason   string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(resJSON), &res); err != nil {
+		return "", fmt.Errorf("failed to parse ref resolution: %w", err)
+	}
+
+	switch res.Status {
+	case "stale":
+		log.Debug("stale ref", "ref", ref, "selector", r.Selector, "reason", res.Reason)
+		return "", &errs.StaleRefError{Ref: ref, Reason: res.Reason}
+	case "moved":
+		log.Debug("ref relocated", "ref", ref, "from", r.Selector, "to", res.Selector)
+		if len(ids) > 0 && ids[0] != "" {
+			r.SharedID = ids[0]
+		}
+	}
+	r.Selector = res.Selector
+	return res.Selector, nil
+}
+
+// splitRefsResult splits the [json, node, node, ...] array the ref scripts
+// return into the JSON string and the nodes' shared IDs ("" for null).
+func splitRefsResult(v *bidi.RemoteValue) (string, []string, error) {
+	items, _ := v.Value.([]interface{})
+	if v.Type != "array" || len(items) == 0 {
+		return "", nil, fmt.Errorf("unexpected ref script result of type %s", v.Type)
+	}
+	first, _ := items[0].(map[string]interface{})
+	text, _ := first["value"].(string)
+	ids := make([]string, len(items)-1)
+	for i, item := range items[1:] {
+		if node, ok := item.(map[string]interface{}); ok {
+			ids[i], _ = node["sharedId"].(string)
+		}
+	}
+	return text, ids, nil
+}
+
+// fingerprintJS returns the JS fingerprint(el) function behind refs. It
+// takes the element's role from locRole, so it needs api.ShadowDOMJS.
+func fingerprintJS() string {
+	return `
+		function fingerprint(el) {
+			const clean = s => String(s || '').trim().replace(/\s+/g, ' ').slice(0, 80);
+			const tag = el.tagName.toLowerCase();
+			const type = (el.getAttribute('type') || '').toLowerCase();
+			const role = locRole(el);
+
+			let name = el.getAttribute('aria-label') || '';
+			const labelledBy = el.getAttribute('aria-labelledby');
+			if (!name && labelledBy) {
+				name = labelledBy.split(/\s+/).map(id => el.getRootNode().getElementById(id)).filter(Boolean).map(l => l.innerText).join(' ');
+			}
+			if (!name && el.labels && el.labels.length) name = el.labels[0].innerText;
+			if (!name) name = el.getAttribute('placeholder') || el.getAttribute('alt') || el.getAttribute('title') || '';
+			if (!name && tag === 'input' && ['button', 'submit', 'reset'].includes(type)) name = el.value;
+			if (!name && !['input', 'textarea', 'select'].includes(tag)) name = el.innerText;
+
+			// The text around the element tells apart look-alikes, e.g. the
+			// Delete buttons of list rows
+			let context = '';
+			const own = clean(el.innerText);
+			let cur = el.parentElement;
+			for (let i = 0; i < 3 && cur && cur !== document.body; i++, cur = cur.parentElement) {
+				const text = clean(cur.innerText);
+				if (text !== own) {
+					context = text;
+					break;
+				}
+			}
+
+			const rect = el.getBoundingClientRect();
+			return {
+				tag, role, name: clean(name), context,
+				x: Math.round(rect.left + window.scrollX), y: Math.round(rect.top + window.scrollY),
+				url: location.href.split('#')[0],
+			};
+		}`
+}
+
+// captureRefsScript returns the JS function behind setRefs. It takes the
+// selectors as a JSON list and returns [JSON, node, ...], the JSON listing
+// each node's fingerprint and state.
+func captureRefsScript() string {
+	return `(selectorsJSON) => {
+		` + api.ShadowDOMJS() + `
+		` + fingerprintJS() + `
+		` + elementStateJS() + `
+		const nodes = JSON.parse(selectorsJSON).map(s => {
+			try { return querySelectorDeep(document, s); } catch (e) { return null; }
+		});
+		return [JSON.stringify(nodes.map(n => n && { print: fingerprint(n), state: elementState(n) })), ...nodes];
+	}`
+}
+
+// resolveRefScript returns the JS function behind resolveRef. It takes the
+// ref's node (null if it is gone) and fingerprint, and returns
+// [result JSON, node].
+func resolveRefScript() string {
+	return `(node, printJSON) => {
+		` + api.ShadowDOMJS() + `
+		` + GetSelectorJS() + `
+		` + fingerprintJS() + `
+		const want = JSON.parse(printJSON);
+		// The element itself is checked by what it is; the text around it
+		// (which changes as rows are added) only ranks look-alikes when it
+		// has to be found again
+		const same = f => f.tag === want.tag && f.role === want.role && f.name === want.name;
+		const found = (el, status) => [JSON.stringify({ selector: getSelector(el), status }), el];
+		const stale = reason => [JSON.stringify({ status: 'stale', reason }), null];
+
+		const live = node && node.isConnected;
+		if (live && same(fingerprint(node))) return found(node, 'same');
+		if (location.href.split('#')[0] !== want.url) return stale('the page has navigated since');
+
+		// Look for the element again, e.g. after a re-render replaced it:
+		// those with the same text around it first, then the nearest
+		const rank = el => {
+			const f = fingerprint(el);
+			return { el, other: f.context === want.context ? 0 : 1, d: Math.hypot(f.x - want.x, f.y - want.y) };
+		};
+		const candidates = [];
+		walkDeep(document, (el) => { if (el.localName === want.tag && same(fingerprint(el))) candidates.push(el); });
+		const matches = candidates
+			.map(rank)
+			.sort((a, b) => a.other - b.other || a.d - b.d);
+		if (matches.length > 1 && matches[0].other === matches[1].other && matches[0].d === matches[1].d) return stale('several elements now match it');
+		if (matches.length > 0) return found(matches[0].el, 'moved');
+		if (!live) return stale('the element was removed');
+		const now = fingerprint(node);
+		return stale('the element changed; it is now ' + (now.role || now.tag) + (now.name ? ' "' + now.name + '"' : ''));
+	}`
+}
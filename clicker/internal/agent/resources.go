@@ -0,0 +1,337 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vibium/clicker/internal/api"
+)
+
+// MCP resources: the current page's text, accessibility tree and element
+// map, screenshots saved in the screenshot directory, and recordings saved
+// by browser_record_stop. Page resources are read live; subscribers get
+// notifications/resources/updated when a tool call leaves the browser on a
+// different page or URL.
+
+// Page resource URIs.
+const (
+	pageTextURI = "vibium://page/text"
+	pageA11yURI = "vibium://page/a11y"
+	pageMapURI  = "vibium://page/map"
+)
+
+var pageResources = []Resource{
+	{URI: pageTextURI, Name: "Page text", Description: "Visible text of the current page", MimeType: "text/plain"},
+	{URI: pageA11yURI, Name: "Accessibility tree", Description: "Accessibility tree of the current page", MimeType: "text/plain"},
+	{URI: pageMapURI, Name: "Element map", Description: "Interactive elements of the current page, by the @ref browser_map or find last gave them or else by CSS selector (reading it doesn't renumber refs)", MimeType: "text/plain"},
+}
+
+type ResourcesCapability struct {
+	Subscribe   bool `json:"subscribe,omitempty"`
+	ListChanged bool `json:"listChanged,omitempty"`
+}
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type ResourcesListResult struct {
+	Resources []Resource `json:"resources"`
+}
+
+type ResourceParams struct {
+	URI string `json:"uri"`
+}
+
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"` // base64
+}
+
+type ResourcesReadResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+// handleResourcesList lists the resources available right now.
+func (s *Server) handleResourcesList() (interface{}, *Error) {
+	return ResourcesListResult{Resources: s.handlers.ListResources()}, nil
+}
+
+// handleResourcesRead reads one resource.
+func (s *Server) handleResourcesRead(params json.RawMessage) (interface{}, *Error) {
+	var p ResourceParams
+	if err := json.Unmarshal(params, &p); err != nil || p.URI == "" {
+		return nil, &Error{Code: InvalidParams, Message: "Invalid params", Data: "uri is required"}
+	}
+
+	contents, err := s.handlers.ReadResource(p.URI)
+	if err == errNoResource {
+		return nil, &Error{Code: ResourceNotFound, Message: "Resource not found", Data: p.URI}
+	}
+	if err != nil {
+		return nil, &Error{Code: InternalError, Message: err.Error(), Data: p.URI}
+	}
+	return ResourcesReadResult{Contents: []ResourceContents{*contents}}, nil
+}
+
+// handleResourcesSubscribe adds or removes a subscription to a resource's
+// updates.
+func (s *Server) handleResourcesSubscribe(params json.RawMessage, subscribe bool) (interface{}, *Error) {
+	var p ResourceParams
+	if err := json.Unmarshal(params, &p); err != nil || p.URI == "" {
+		return nil, &Error{Code: InvalidParams, Message: "Invalid params", Data: "uri is required"}
+	}
+
+	if !subscribe {
+		delete(s.subscriptions, p.URI)
+		return struct{}{}, nil
+	}
+	if !isPageResource(p.URI) {
+		// Files don't change once written; nothing to notify about
+		return nil, &Error{Code: InvalidParams, Message: "Resource does not support subscriptions", Data: p.URI}
+	}
+	if len(s.subscriptions) == 0 {
+		s.lastPage = s.handlers.pageState()
+	}
+	s.subscriptions[p.URI] = true
+	return struct{}{}, nil
+}
+
+// checkPageUpdated notifies subscribers of the page resources if the last
+// tool call navigated or switched pages.
+func (s *Server) checkPageUpdated() {
+	if len(s.subscriptions) == 0 || s.notify == nil {
+		return
+	}
+	page := s.handlers.pageState()
+	if page == s.lastPage {
+		return
+	}
+	s.lastPage = page
+
+	uris := make([]string, 0, len(s.subscriptions))
+	for uri := range s.subscriptions {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		s.notify(&Notification{
+			JSONRPC: "2.0",
+			Method:  "notifications/resources/updated",
+			Params:  ResourceParams{URI: uri},
+		})
+	}
+}
+
+func isPageResource(uri string) bool {
+	for _, r := range pageResources {
+		if r.URI == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// errNoResource is returned by ReadResource for a URI it doesn't serve.
+var errNoResource = fmt.Errorf("resource not found")
+
+// ListResources returns the page resources (while a browser is running),
+// saved screenshots and saved recordings.
+func (h *Handlers) ListResources() []Resource {
+	resources := []Resource{} // never null: the result must carry an array
+	if h.client != nil {
+		resources = append(resources, pageResources...)
+	}
+
+	for _, path := range h.screenshotFiles() {
+		resources = append(resources, Resource{
+			URI:      fileURI(path),
+			Name:     filepath.Base(path),
+			MimeType: imageMimeType(path),
+		})
+	}
+	for _, path := range h.recordings {
+		resources = append(resources, Resource{
+			URI:         fileURI(path),
+			Name:        filepath.Base(path),
+			Description: "Recording (open with the trace viewer)",
+			MimeType:    "application/zip",
+		})
+	}
+	return resources
+}
+
+// ReadResource returns the contents of a resource listed by ListResources.
+func (h *Handlers) ReadResource(uri string) (*ResourceContents, error) {
+	if isPageResource(uri) {
+		if h.client == nil {
+			return nil, fmt.Errorf("no browser session — call browser_start first")
+		}
+		text, err := h.readPage(uri)
+		if err != nil {
+			return nil, err
+		}
+		return &ResourceContents{URI: uri, MimeType: "text/plain", Text: text}, nil
+	}
+
+	path, ok := h.resourceFile(uri)
+	if !ok {
+		return nil, errNoResource
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	mimeType := imageMimeType(path)
+	if mimeType == "" {
+		mimeType = "application/zip"
+	}
+	return &ResourceContents{URI: uri, MimeType: mimeType, Blob: base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// readPage reads one of the page resources.
+func (h *Handlers) readPage(uri string) (string, error) {
+	s := h.newSession()
+	ctx, err := s.GetContextID()
+	if err != nil {
+		return "", err
+	}
+
+	switch uri {
+	case pageTextURI:
+		return api.EvalSimpleScript(s, ctx, "() => document.body.innerText")
+	case pageA11yURI:
+		return api.A11yTree(s, ctx, true, "")
+	default: // pageMapURI
+		return h.resourceMap()
+	}
+}
+
+// resourceMap lists the page's interactive elements like browser_map, but
+// leaves the refs alone: reading a resource mustn't renumber the refs a
+// client is using. An element keeps the ref the last map or find gave it;
+// the others are shown by CSS selector.
+func (h *Handlers) resourceMap() (string, error) {
+	elements, refs, err := h.scanMap(mapOptions{})
+	if err != nil {
+		return "", err
+	}
+	known := make(map[string]string, len(h.refMap)) // shared ID -> ref
+	for ref, r := range h.refMap {
+		if r.SharedID != "" {
+			known[r.SharedID] = ref
+		}
+	}
+	for i, el := range elements {
+		elements[i].Ref = el.Selector
+		if r := refs[el.Ref]; r != nil && r.SharedID != "" {
+			if ref, ok := known[r.SharedID]; ok {
+				elements[i].Ref = ref
+			}
+		}
+	}
+	return formatMap(elements, mapOptions{}), nil
+}
+
+// resourceFile maps a file:// URI back to a screenshot or recording path.
+// Only files the resource list would show are served.
+func (h *Handlers) resourceFile(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	path := filepath.FromSlash(u.Path)
+
+	for _, p := range h.recordings {
+		if p == path {
+			return path, true
+		}
+	}
+	for _, p := range h.screenshotFiles() {
+		if p == path {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// screenshotFiles returns the images in the screenshot directory, newest first.
+func (h *Handlers) screenshotFiles() []string {
+	if h.screenshotDir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(h.screenshotDir)
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	type file struct {
+		path    string
+		modTime int64
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || imageMimeType(e.Name()) == "" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{filepath.Join(dir, e.Name()), info.ModTime().UnixNano()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime > files[j].modTime })
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths
+}
+
+// pageState identifies the page the browser is on, for change detection.
+// Returns "" when no browser is running.
+func (h *Handlers) pageState() string {
+	if h.client == nil {
+		return ""
+	}
+	s := h.newSession()
+	ctx, err := s.GetContextID()
+	if err != nil {
+		return ""
+	}
+	u, err := api.GetURL(s, ctx)
+	if err != nil {
+		return ctx
+	}
+	return ctx + " " + u
+}
+
+func imageMimeType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	}
+	return ""
+}
+
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
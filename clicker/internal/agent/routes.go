@@ -0,0 +1,561 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vibium/clicker/internal/bidi"
+	"github.com/vibium/clicker/internal/log"
+)
+
+// Network routes for browser_route: a single BiDi intercept blocks every
+// request, and routeTable.handle matches it against the routes in Go (URL glob,
+// method, resource type) and fulfills, aborts, delays or rewrites it.
+// Unmatched requests continue untouched.
+//
+// Blocked requests arrive as events on the client's reader goroutine (see
+// bidi.Client.Listen), between tool calls too, so the replies are sent with
+// SendCommandNoWait.
+
+// Route actions.
+const (
+	routeFulfill = "fulfill"
+	routeAbort   = "abort"
+	routeDelay   = "delay"   // continue after Delay
+	routeHeaders = "headers" // continue with Headers added or replaced
+)
+
+// resourceTypes are the values accepted for a route's resource type.
+var resourceTypes = []string{"document", "stylesheet", "image", "media", "font", "script", "fetch", "xhr", "websocket", "other"}
+
+// route is one active browser_route rule.
+type route struct {
+	ID           int
+	Pattern      string // URL glob
+	Method       string // upper case; "" = any
+	ResourceType string // one of resourceTypes; "" = any
+	Action       string
+	Status       int
+	Body         []byte
+	BodyFile     string // source of Body, for listing
+	ContentType  string
+	Headers      map[string]string
+	Delay        time.Duration
+
+	re *regexp.Regexp
+}
+
+// routeTable holds the active routes. It is locked because blocked
+// requests are handled on the client's reader goroutine.
+type routeTable struct {
+	mu        sync.Mutex
+	routes    []*route
+	nextID    int
+	intercept string // BiDi intercept ID; "" when no routes are active
+}
+
+// parseRoute builds a route from browser_route arguments.
+func parseRoute(args map[string]interface{}) (*route, error) {
+	r := &route{Pattern: "**"}
+	if p, ok := args["url"].(string); ok && p != "" {
+		r.Pattern = p
+	}
+	re, err := globToRegexp(r.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url pattern %q: %w", r.Pattern, err)
+	}
+	r.re = re
+
+	if m, ok := args["method"].(string); ok {
+		r.Method = strings.ToUpper(m)
+	}
+	if t, ok := args["resourceType"].(string); ok && t != "" {
+		r.ResourceType = strings.ToLower(t)
+		if !isResourceType(r.ResourceType) {
+			return nil, fmt.Errorf("unknown resourceType %q (expected one of %s)", t, strings.Join(resourceTypes, ", "))
+		}
+	}
+	if ms, ok := args["delay"].(float64); ok && ms > 0 {
+		r.Delay = time.Duration(ms) * time.Millisecond
+	}
+	if headers, ok := args["headers"].(map[string]interface{}); ok {
+		r.Headers = make(map[string]string, len(headers))
+		for name, v := range headers {
+			r.Headers[name] = fmt.Sprint(v)
+		}
+	}
+	if status, ok := args["status"].(float64); ok {
+		r.Status = int(status)
+	}
+	r.ContentType, _ = args["contentType"].(string)
+
+	body, hasBody := args["body"].(string)
+	file, _ := args["path"].(string)
+	if hasBody && file != "" {
+		return nil, fmt.Errorf("give either body or path, not both")
+	}
+	if hasBody {
+		r.Body = []byte(body)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		r.Body = data
+		r.BodyFile = file
+		if r.ContentType == "" {
+			r.ContentType = mime.TypeByExtension(filepath.Ext(file))
+		}
+	}
+
+	r.Action, _ = args["action"].(string)
+	if r.Action == "" {
+		// Infer the action from what was given
+		switch {
+		case hasBody || file != "" || r.Status != 0:
+			r.Action = routeFulfill
+		case len(r.Headers) > 0:
+			r.Action = routeHeaders
+		case r.Delay > 0:
+			r.Action = routeDelay
+		default:
+			return nil, fmt.Errorf("action is required (fulfill, abort, delay or headers)")
+		}
+	}
+	switch r.Action {
+	case routeFulfill:
+		if r.Status == 0 {
+			r.Status = 200
+		}
+	case routeAbort:
+	case routeDelay:
+		if r.Delay <= 0 {
+			return nil, fmt.Errorf("delay is required for action delay")
+		}
+	case routeHeaders:
+		if len(r.Headers) == 0 {
+			return nil, fmt.Errorf("headers is required for action headers")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected fulfill, abort, delay or headers)", r.Action)
+	}
+	return r, nil
+}
+
+// String describes the route for tool output.
+func (r *route) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d: %s", r.ID, r.Pattern)
+	if r.Method != "" {
+		fmt.Fprintf(&b, " method=%s", r.Method)
+	}
+	if r.ResourceType != "" {
+		fmt.Fprintf(&b, " type=%s", r.ResourceType)
+	}
+	switch r.Action {
+	case routeFulfill:
+		fmt.Fprintf(&b, " → fulfill %d", r.Status)
+		if r.BodyFile != "" {
+			fmt.Fprintf(&b, " from %s", r.BodyFile)
+		} else if len(r.Body) > 0 {
+			fmt.Fprintf(&b, " (%d bytes)", len(r.Body))
+		}
+	case routeHeaders:
+		names := make([]string, 0, len(r.Headers))
+		for name := range r.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, " → set headers %s", strings.Join(names, ", "))
+	default:
+		fmt.Fprintf(&b, " → %s", r.Action)
+	}
+	if r.Delay > 0 && r.Action != routeDelay {
+		fmt.Fprintf(&b, " after %v", r.Delay)
+	} else if r.Action == routeDelay {
+		fmt.Fprintf(&b, " %v", r.Delay)
+	}
+	return b.String()
+}
+
+// matches reports whether the route applies to a request.
+func (r *route) matches(url, method, resourceType string) bool {
+	if r.Method != "" && r.Method != method {
+		return false
+	}
+	if r.ResourceType != "" && r.ResourceType != resourceType {
+		return false
+	}
+	return r.re.MatchString(url)
+}
+
+// globToRegexp converts a URL glob to a regexp: ** matches anything, *
+// matches anything but /, ? matches one character, and {a,b} matches
+// either alternative.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	inGroup := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString(".")
+		case c == '{':
+			inGroup = true
+			b.WriteString("(?:")
+		case c == '}' && inGroup:
+			inGroup = false
+			b.WriteString(")")
+		case c == ',' && inGroup:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func isResourceType(t string) bool {
+	for _, rt := range resourceTypes {
+		if rt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// requestResourceType classifies a BiDi request by its initiator type and
+// fetch destination.
+func requestResourceType(initiatorType, destination string) string {
+	switch initiatorType {
+	case "fetch":
+		return "fetch"
+	case "xmlhttprequest":
+		return "xhr"
+	}
+	switch destination {
+	case "document", "iframe", "frame":
+		return "document"
+	case "style":
+		return "stylesheet"
+	case "image":
+		return "image"
+	case "audio", "video", "track":
+		return "media"
+	case "font":
+		return "font"
+	case "script", "worker", "sharedworker", "serviceworker":
+		return "script"
+	case "websocket":
+		return "websocket"
+	}
+	return "other"
+}
+
+// add registers a route, creating the BiDi intercept for the first one.
+func (t *routeTable) add(client *bidi.Client, r *route) error {
+	// Not locked while commands are in flight: blocked requests that
+	// arrive meanwhile are handled by the event handler, which locks it
+	if t.interceptID() == "" {
+		if _, err := client.SendCommand("session.subscribe", map[string]interface{}{
+			"events": []string{"network.beforeRequestSent"},
+		}); err != nil {
+			return err
+		}
+		msg, err := client.SendCommand("network.addIntercept", map[string]interface{}{
+			"phases": []string{"beforeRequestSent"},
+		})
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Intercept string `json:"intercept"`
+		}
+		if err := json.Unmarshal(msg.Result, &result); err != nil {
+			return fmt.Errorf("failed to parse addIntercept response: %w", err)
+		}
+		t.mu.Lock()
+		t.intercept = result.Intercept
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	r.ID = t.nextID
+	t.routes = append(t.routes, r)
+	return nil
+}
+
+// remove drops the routes with the given ID, or URL pattern, or all routes
+// if both are empty, and removes the intercept once none are left. It
+// returns the removed routes.
+func (t *routeTable) remove(client *bidi.Client, id int, pattern string) ([]*route, error) {
+	t.mu.Lock()
+	var removed, kept []*route
+	for _, r := range t.routes {
+		if (id == 0 && pattern == "") || (id != 0 && r.ID == id) || (pattern != "" && r.Pattern == pattern) {
+			removed = append(removed, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	t.routes = kept
+	intercept := ""
+	if len(t.routes) == 0 {
+		intercept, t.intercept = t.intercept, ""
+	}
+	t.mu.Unlock()
+
+	if intercept != "" {
+		if _, err := client.SendCommand("network.removeIntercept", map[string]interface{}{
+			"intercept": intercept,
+		}); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (t *routeTable) interceptID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.intercept
+}
+
+// list returns the active routes in the order they were added.
+func (t *routeTable) list() []*route {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*route(nil), t.routes...)
+}
+
+// reset forgets all routes, for when the browser goes away.
+func (t *routeTable) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = nil
+	t.intercept = ""
+}
+
+// blockedRequest is the part of a network.beforeRequestSent event routing
+// and the navigation guard need.
+type blockedRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		Context    string   `json:"context"`
+		IsBlocked  bool     `json:"isBlocked"`
+		Intercepts []string `json:"intercepts"`
+		Navigation *string  `json:"navigation"`
+		Request    struct {
+			Request       string            `json:"request"`
+			URL           string            `json:"url"`
+			Method        string            `json:"method"`
+			Headers       []json.RawMessage `json:"headers"`
+			Destination   string            `json:"destination"`
+			InitiatorType string            `json:"initiatorType"`
+		} `json:"request"`
+	} `json:"params"`
+}
+
+// parseBlockedRequest returns the request if msg is a
+// network.beforeRequestSent event for a blocked request, or nil.
+func parseBlockedRequest(msg string) *blockedRequest {
+	if !strings.Contains(msg, `"network.beforeRequestSent"`) {
+		return nil
+	}
+	var ev blockedRequest
+	if err := json.Unmarshal([]byte(msg), &ev); err != nil || ev.Method != "network.beforeRequestSent" || !ev.Params.IsBlocked {
+		return nil
+	}
+	return &ev
+}
+
+func (ev *blockedRequest) hasIntercept(id string) bool {
+	for _, i := range ev.Params.Intercepts {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isNavigation reports whether the request loads a page or frame.
+func (ev *blockedRequest) isNavigation() bool {
+	return ev.Params.Navigation != nil || requestResourceType("", ev.Params.Request.Destination) == "document"
+}
+
+// interceptGate holds blocked requests while an intercept is being added.
+// The reader goroutine can see the first requests the new intercept blocks
+// before the tool call that added it has recorded its ID; they are handled
+// once it has.
+type interceptGate struct {
+	mu     sync.Mutex
+	adding int
+	held   []*blockedRequest
+}
+
+// begin marks an intercept as being added.
+func (g *interceptGate) begin() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.adding++
+}
+
+// end marks an intercept as added (or failed), and returns the requests
+// held meanwhile once no other is being added.
+func (g *interceptGate) end() []*blockedRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.adding--
+	if g.adding > 0 {
+		return nil
+	}
+	held := g.held
+	g.held = nil
+	return held
+}
+
+// hold keeps a request no intercept claimed if one is being added, and
+// reports whether it did.
+func (g *interceptGate) hold(ev *blockedRequest) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.adding == 0 {
+		return false
+	}
+	g.held = append(g.held, ev)
+	return true
+}
+
+// handle answers a blocked request from one of our intercepts with the
+// most recently added matching route, or lets it continue. It reports
+// whether the request was ours.
+func (t *routeTable) handle(client *bidi.Client, ev *blockedRequest) bool {
+	t.mu.Lock()
+	ours := t.intercept != "" && ev.hasIntercept(t.intercept)
+	var match *route
+	if ours {
+		req := ev.Params.Request
+		resourceType := requestResourceType(req.InitiatorType, req.Destination)
+		for i := len(t.routes) - 1; i >= 0; i-- {
+			if t.routes[i].matches(req.URL, req.Method, resourceType) {
+				match = t.routes[i]
+				break
+			}
+		}
+	}
+	t.mu.Unlock()
+	if !ours {
+		return false
+	}
+
+	request := ev.Params.Request.Request
+	method, params := "network.continueRequest", map[string]interface{}{"request": request}
+	var delay time.Duration
+	if match != nil {
+		log.Debug("route matched", "route", match.ID, "url", ev.Params.Request.URL)
+		method, params = match.reply(request, ev.Params.Request.Headers)
+		delay = match.Delay
+	}
+
+	send := func() {
+		if err := client.SendCommandNoWait(method, params); err != nil {
+			log.Debug("route reply failed", "request", request, "error", err)
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, send)
+	} else {
+		send()
+	}
+	return true
+}
+
+// reply returns the BiDi command that carries out the route's action for
+// a request with the given headers.
+func (r *route) reply(request string, headers []json.RawMessage) (string, map[string]interface{}) {
+	params := map[string]interface{}{"request": request}
+	switch r.Action {
+	case routeFulfill:
+		params["statusCode"] = r.Status
+		h := map[string]string{}
+		for name, v := range r.Headers {
+			h[name] = v
+		}
+		if r.ContentType != "" {
+			h["Content-Type"] = r.ContentType
+		}
+		if len(h) > 0 {
+			params["headers"] = bidiHeaders(h)
+		}
+		params["body"] = map[string]interface{}{
+			"type":  "base64",
+			"value": base64.StdEncoding.EncodeToString(r.Body),
+		}
+		return "network.provideResponse", params
+	case routeAbort:
+		return "network.failRequest", params
+	case routeHeaders:
+		// continueRequest replaces the whole header list, so carry over the
+		// request's headers that aren't overridden
+		merged := make([]interface{}, 0, len(headers)+len(r.Headers))
+		for _, raw := range headers {
+			var header struct {
+				Name string `json:"name"`
+			}
+			if json.Unmarshal(raw, &header) == nil && !r.overrides(header.Name) {
+				merged = append(merged, raw)
+			}
+		}
+		for _, h := range bidiHeaders(r.Headers) {
+			merged = append(merged, h)
+		}
+		params["headers"] = merged
+	}
+	return "network.continueRequest", params
+}
+
+// overrides reports whether the route sets header name.
+func (r *route) overrides(name string) bool {
+	for h := range r.Headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// bidiHeaders converts {"Name": "Value"} to BiDi header entries.
+func bidiHeaders(headers map[string]string) []map[string]interface{} {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]map[string]interface{}, 0, len(headers))
+	for _, name := range names {
+		out = append(out, map[string]interface{}{
+			"name":  name,
+			"value": map[string]interface{}{"type": "string", "value": headers[name]},
+		})
+	}
+	return out
+}
@@ -2,10 +2,10 @@ package agent
 
 // GetToolSchemas returns the list of available MCP tools with their schemas.
 func GetToolSchemas() []Tool {
-	return []Tool{
+	return withSessionArg(withPagingArgs([]Tool{
 		{
 			Name:        "browser_start",
-			Description: "Start a browser session",
+			Description: "Start a browser session. Pass session to start another, isolated browser under that name, with its own pages, cookies, refs and recording; then pass the same session to other tools to act in it.",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -35,13 +35,13 @@ func GetToolSchemas() []Tool {
 		},
 		{
 			Name:        "browser_click",
-			Description: "Click an element by CSS selector. Waits for element to be visible, stable, and enabled.",
+			Description: "Click an element by CSS selector, @ref or locator. Waits for element to be visible, stable, and enabled.",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
 					"selector": map[string]interface{}{
 						"type":        "string",
-						"description": "CSS selector for the element to click",
+						"description": "CSS selector for the element to click, or a locator chaining steps with >> (e.g. tr:has-text(\"Invoice 42\") >> role=button[name=\"Delete\"])",
 					},
 				},
 				"required":             []string{"selector"},
@@ -93,13 +93,13 @@ func GetToolSchemas() []Tool {
 		},
 		{
 			Name:        "browser_find",
-			Description: "Find an element and return its info (tag, text, bounding box). Use a CSS selector or a semantic locator (role, text, label, placeholder, testid, xpath, alt, title). Combine role with text or other locators to narrow results.",
+			Description: "Find an element and return its info (tag, text, bounding box). Use a CSS selector or a semantic locator (role, text, label, placeholder, testid, xpath, alt, title). Combine role with text or other locators to narrow results. Any selector can also be a chained locator: steps joined by >> that each search inside the previous match, using CSS (with :has-text(), :has(), :visible), role=, text=, testid=, label=, placeholder=, alt=, title=, xpath=, nth= and visible=true.",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
 					"selector": map[string]interface{}{
 						"type":        "string",
-						"description": "CSS selector for the element to find",
+						"description": "CSS selector or locator for the element to find (e.g. \"tr:has-text('Invoice 42') >> text=Delete\")",
 					},
 					"role": map[string]interface{}{
 						"type":        "string",
@@ -154,7 +154,16 @@ func GetToolSchemas() []Tool {
 		},
 		{
 			Name:        "browser_stop",
-			Description: "Stop the browser session",
+			Description: "Stop the browser session, or the named session given by session",
+			InputSchema: map[string]interface{}{
+				"type":                 "object",
+				"properties":           map[string]interface{}{},
+				"additionalProperties": false,
+			},
+		},
+		{
+			Name:        "browser_list_sessions",
+			Description: "List the browser sessions: the default session and any named sessions started with browser_start",
 			InputSchema: map[string]interface{}{
 				"type":                 "object",
 				"properties":           map[string]interface{}{},
@@ -731,7 +740,7 @@ func GetToolSchemas() []Tool {
 		},
 		{
 			Name:        "browser_map",
-			Description: "Map interactive page elements with @refs for targeting. Returns a list of interactive elements (buttons, links, inputs, etc.) each with a short @ref like @e1, @e2. Use these refs as selectors in other commands (click, fill, etc.).",
+			Description: "Map interactive page elements with @refs for targeting. Returns a list of interactive elements (buttons, links, inputs, etc.) each with a short @ref like @e1, @e2. Use these refs as selectors in other commands (click, fill, etc.). Set state to see field values and checked/disabled/required/expanded/invalid without extra calls.",
 			InputSchema: map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -739,16 +748,74 @@ func GetToolSchemas() []Tool {
 						"type":        "string",
 						"description": "CSS selector to scope element discovery to a subtree (e.g. \"nav\", \"#sidebar\")",
 					},
+					"state": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Show each element's state after its label: value, checked, disabled, required, expanded, invalid (default: false)",
+						"default":     false,
+					},
+					"group": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Group elements under the landmark, form or dialog they are in, e.g. form \"Sign in\" (default: false)",
+						"default":     false,
+					},
+					"inViewport": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Only list elements that are at least partly in the viewport (default: false)",
+						"default":     false,
+					},
+					"role": map[string]interface{}{
+						"type":        "string",
+						"description": "Only list elements with these ARIA roles, comma-separated (e.g. \"button,link\")",
+					},
+					"formsOnly": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Only list form controls: fields, checkboxes, selects and the buttons of forms (default: false)",
+						"default":     false,
+					},
+					"format": map[string]interface{}{
+						"type":        "string",
+						"description": "Output format: \"text\" or \"json\" (an elements list with ref, tag, role, label, group, selector and state; paged by whole elements, with nextCursor when cut) (default: \"text\")",
+						"enum":        []string{"text", "json"},
+						"default":     "text",
+					},
 				},
 				"additionalProperties": false,
 			},
 		},
 		{
 			Name:        "browser_diff_map",
-			Description: "Compare current page state vs last map. Shows additions (+) and removals (-) since the last browser_map call.",
+			Description: "Map the page again and report which elements changed since the last browser_map call, matched by element identity rather than by ref: - removed (old ref), + added, > moved, ~ relabelled, * state changed (enabled, checked, value, expanded).",
 			InputSchema: map[string]interface{}{
-				"type":                 "object",
-				"properties":           map[string]interface{}{},
+				"type": "object",
+				"properties": map[string]interface{}{
+					"format": map[string]interface{}{
+						"type":        "string",
+						"description": "Output format: \"text\" (one line per change) or \"json\" (added, removed, moved, relabelled and stateChanged lists) (default: \"text\")",
+						"enum":        []string{"text", "json"},
+						"default":     "text",
+					},
+				},
+				"additionalProperties": false,
+			},
+		},
+		{
+			Name:        "browser_locate",
+			Description: "Suggest stable locators for an element, to use in tests instead of its ref or CSS path. Candidates are its test ID, role and accessible name, label, placeholder, text and the shortest unique CSS selector, each checked on the current page; unique ones come first. A candidate that matches several elements is narrowed to the element's row, list item or form when that makes it unique.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"selector": map[string]interface{}{
+						"type":        "string",
+						"description": "Element ref from browser_map or browser_find (e.g. \"@e3\"), CSS selector or locator",
+					},
+					"format": map[string]interface{}{
+						"type":        "string",
+						"description": "Output format: \"text\" (one locator per line) or \"json\" (candidates with locator, kind, matches and unique) (default: \"text\")",
+						"enum":        []string{"text", "json"},
+						"default":     "text",
+					},
+				},
+				"required":             []string{"selector"},
 				"additionalProperties": false,
 			},
 		},
@@ -919,6 +986,86 @@ func GetToolSchemas() []Tool {
 				"additionalProperties": false,
 			},
 		},
+		{
+			Name:        "browser_route",
+			Description: "Intercept network requests matching a URL glob (** = anything, * = anything but /) and optionally method and resource type. Matching requests are fulfilled with a stub response, aborted, delayed, or sent with extra headers. The most recently added matching route wins; other requests continue normally.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "URL glob, e.g. \"**/api/users*\" or \"https://*.tracker.com/**\" (default: \"**\", every request)",
+					},
+					"method": map[string]interface{}{
+						"type":        "string",
+						"description": "HTTP method to match, e.g. \"POST\" (default: any)",
+					},
+					"resourceType": map[string]interface{}{
+						"type":        "string",
+						"description": "Resource type to match (default: any)",
+						"enum":        resourceTypes,
+					},
+					"action": map[string]interface{}{
+						"type":        "string",
+						"description": "What to do with matching requests. Default: fulfill if status, body or path is given, else headers if headers is given, else delay",
+						"enum":        []string{routeFulfill, routeAbort, routeDelay, routeHeaders},
+					},
+					"status": map[string]interface{}{
+						"type":        "number",
+						"description": "Response status for fulfill (default: 200)",
+					},
+					"body": map[string]interface{}{
+						"type":        "string",
+						"description": "Response body for fulfill",
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "File to read the fulfill response body from (read when the route is added)",
+					},
+					"contentType": map[string]interface{}{
+						"type":        "string",
+						"description": "Content-Type for fulfill (default: from the path's extension)",
+					},
+					"headers": map[string]interface{}{
+						"type":                 "object",
+						"description":          "Response headers for fulfill, or request headers to add or replace for headers",
+						"additionalProperties": map[string]interface{}{"type": "string"},
+					},
+					"delay": map[string]interface{}{
+						"type":        "number",
+						"description": "Milliseconds to hold matching requests before acting on them",
+					},
+				},
+				"additionalProperties": false,
+			},
+		},
+		{
+			Name:        "browser_unroute",
+			Description: "Remove network routes added with browser_route, by id or URL glob. With no arguments, removes all routes.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id": map[string]interface{}{
+						"type":        "number",
+						"description": "Route id, as shown by browser_list_routes",
+					},
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "URL glob the routes were added with",
+					},
+				},
+				"additionalProperties": false,
+			},
+		},
+		{
+			Name:        "browser_list_routes",
+			Description: "List the active network routes",
+			InputSchema: map[string]interface{}{
+				"type":                 "object",
+				"properties":           map[string]interface{}{},
+				"additionalProperties": false,
+			},
+		},
 		{
 			Name:        "browser_get_cookies",
 			Description: "List all cookies for the current page",
@@ -1417,5 +1564,21 @@ func GetToolSchemas() []Tool {
 				"additionalProperties": false,
 			},
 		},
+	}))
+}
+
+// withSessionArg adds the optional session argument, which picks the named
+// browser session a tool runs in, to every tool.
+func withSessionArg(tools []Tool) []Tool {
+	for _, tool := range tools {
+		props, _ := tool.InputSchema["properties"].(map[string]interface{})
+		if props == nil {
+			continue
+		}
+		props["session"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Named browser session to use, as created by browser_start (default: \"default\")",
+		}
 	}
+	return tools
 }
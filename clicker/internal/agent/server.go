@@ -1,5 +1,6 @@
 // Package mcp implements the Model Context Protocol (MCP) server.
-// It provides a JSON-RPC 2.0 interface over stdio for LLM agents.
+// It provides a JSON-RPC 2.0 interface over stdio, or over Streamable HTTP
+// (see http.go), for LLM agents.
 package agent
 
 import (
@@ -9,7 +10,11 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	errs "github.com/vibium/clicker/internal/errors"
 	"github.com/vibium/clicker/internal/log"
 )
 
@@ -29,6 +34,13 @@ type Response struct {
 	Error   *Error      `json:"error,omitempty"`
 }
 
+// JSON-RPC 2.0 notification structure (server → client, no ID)
+type Notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
 // JSON-RPC 2.0 error structure
 type Error struct {
 	Code    int         `json:"code"`
@@ -43,10 +55,39 @@ const (
 	MethodNotFound = -32601
 	InvalidParams  = -32602
 	InternalError  = -32603
+
+	// ToolError is returned by the daemon when a tool call fails; Data is a
+	// ToolErrorData with the stable error code.
+	ToolError = -32000
+
+	// ResourceNotFound is returned by resources/read for an unknown URI.
+	ResourceNotFound = -32002
 )
 
+// ToolErrorData is the Data of a ToolError.
+type ToolErrorData struct {
+	Code string `json:"code"` // see errors.Code
+}
+
 // MCP-specific types
 
+// protocolVersions are the MCP revisions the server speaks, newest first.
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// elicitationVersion is the first revision with elicitation/create.
+const elicitationVersion = "2025-06-18"
+
+// NegotiateProtocolVersion returns the revision to answer initialize with:
+// the one the client asked for if the server speaks it, else the newest.
+func NegotiateProtocolVersion(requested string) string {
+	for _, v := range protocolVersions {
+		if v == requested {
+			return v
+		}
+	}
+	return protocolVersions[0]
+}
+
 type InitializeParams struct {
 	ProtocolVersion string             `json:"protocolVersion"`
 	Capabilities    ClientCapabilities `json:"capabilities"`
@@ -54,8 +95,9 @@ type InitializeParams struct {
 }
 
 type ClientCapabilities struct {
-	Roots    *RootsCapability    `json:"roots,omitempty"`
-	Sampling *SamplingCapability `json:"sampling,omitempty"`
+	Roots       *RootsCapability       `json:"roots,omitempty"`
+	Sampling    *SamplingCapability    `json:"sampling,omitempty"`
+	Elicitation *ElicitationCapability `json:"elicitation,omitempty"`
 }
 
 type RootsCapability struct {
@@ -64,6 +106,8 @@ type RootsCapability struct {
 
 type SamplingCapability struct{}
 
+type ElicitationCapability struct{}
+
 type ClientInfo struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
@@ -76,7 +120,8 @@ type InitializeResult struct {
 }
 
 type ServerCapabilities struct {
-	Tools *ToolsCapability `json:"tools,omitempty"`
+	Tools     *ToolsCapability     `json:"tools,omitempty"`
+	Resources *ResourcesCapability `json:"resources,omitempty"`
 }
 
 type ToolsCapability struct {
@@ -101,11 +146,73 @@ type Tool struct {
 type ToolsCallParams struct {
 	Name      string                 `json:"name"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	Meta      *CallMeta              `json:"_meta,omitempty"`
+}
+
+// CallMeta is client-supplied metadata for a tool call.
+type CallMeta struct {
+	TraceParent   string      `json:"traceparent,omitempty"`   // W3C trace context to join
+	ProgressToken interface{} `json:"progressToken,omitempty"` // set to receive notifications/progress
+}
+
+// CancelledParams are the params of a notifications/cancelled message.
+type CancelledParams struct {
+	RequestID interface{} `json:"requestId"`
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// ElicitParams are the params of an elicitation/create request.
+type ElicitParams struct {
+	Message         string                 `json:"message"`
+	RequestedSchema map[string]interface{} `json:"requestedSchema"`
+}
+
+// ElicitResult is the client's answer to elicitation/create.
+type ElicitResult struct {
+	Action string `json:"action"` // "accept", "decline" or "cancel"
+}
+
+// clientResponse is a client's response to a request the server sent.
+type clientResponse struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// ProgressParams are the params of a notifications/progress message.
+type ProgressParams struct {
+	ProgressToken interface{} `json:"progressToken"`
+	Progress      int         `json:"progress"`
+	Message       string      `json:"message,omitempty"`
+}
+
+// TraceParent returns the call's W3C traceparent, or "".
+func (p *ToolsCallParams) TraceParent() string {
+	if p.Meta == nil {
+		return ""
+	}
+	return p.Meta.TraceParent
 }
 
 type ToolsCallResult struct {
-	Content []Content `json:"content"`
-	IsError bool      `json:"isError,omitempty"`
+	Content []Content   `json:"content"`
+	IsError bool        `json:"isError,omitempty"`
+	Meta    *ResultMeta `json:"_meta,omitempty"`
+}
+
+// ResultMeta is vibium-specific metadata attached to a tool result.
+type ResultMeta struct {
+	ErrorCode string `json:"errorCode,omitempty"` // set on isError results, see errors.Code
+}
+
+// ErrorResult builds the isError result for a failed tool call.
+func ErrorResult(err error) *ToolsCallResult {
+	return &ToolsCallResult{
+		Content: []Content{{Type: "text", Text: err.Error()}},
+		IsError: true,
+		Meta:    &ResultMeta{ErrorCode: errs.Code(err)},
+	}
 }
 
 type Content struct {
@@ -121,32 +228,87 @@ type Server struct {
 	writer   io.Writer
 	handlers *Handlers
 	version  string
+
+	// notify sends a server-initiated message; nil drops it
+	notify func(msg interface{})
+
+	// Requests sent to the client (elicitation), by ID, waiting for a
+	// response. Guarded by pendingMu since responses arrive while a call runs.
+	elicitation bool // the client supports elicitation/create
+	pendingMu   sync.Mutex
+	pending     map[string]chan *clientResponse
+	nextID      int
+
+	// Resource subscriptions (see resources.go)
+	subscriptions map[string]bool // subscribed resource URIs
+	lastPage      string          // page context and URL after the last tool call
+
+	// Tool calls in progress, by request ID, for notifications/cancelled.
+	// Guarded by inflightMu since cancellations arrive while a call runs.
+	inflightMu sync.Mutex
+	inflight   map[string]chan struct{}
 }
 
+// errRequestCancelled marks a request whose response is dropped because the
+// client cancelled it.
+var errRequestCancelled = &Error{Code: InternalError, Message: "Request cancelled"}
+
 // ServerOptions configures the MCP server.
 type ServerOptions struct {
-	ScreenshotDir  string      // Directory for saving screenshots (empty = disabled)
-	ConnectURL     string      // Remote BiDi WebSocket URL (empty = local browser)
-	ConnectHeaders http.Header // Headers for remote WebSocket connection
+	ScreenshotDir  string            // Directory for saving screenshots (empty = disabled)
+	ConnectURL     string            // Remote BiDi WebSocket URL (empty = local browser)
+	ConnectHeaders http.Header       // Headers for remote WebSocket connection
+	Headless       bool              // Launch browsers headless unless browser_start says otherwise
+	Policy         *NavigationPolicy // URLs pages may load (nil = any)
+	Tools          *ToolFilter       // tools offered (nil = all)
+	Confirm        *ConfirmPolicy    // actions that need a human's approval (nil = none)
 }
 
 // NewServer creates a new MCP server.
 func NewServer(version string, opts ServerOptions) *Server {
+	s := newSession(version, opts)
+	s.reader = bufio.NewReader(os.Stdin)
+	s.writer = os.Stdout
+	s.notify = func(msg interface{}) {
+		s.writeMessage(msg)
+	}
+	return s
+}
+
+// newSession creates a server with its own browser session and no stdio,
+// for transports that pass requests to handleRequest themselves.
+func newSession(version string, opts ServerOptions) *Server {
+	handlers := NewHandlers(opts.ScreenshotDir, opts.Headless, opts.ConnectURL, opts.ConnectHeaders)
+	handlers.SetNavigationPolicy(opts.Policy)
+	handlers.SetToolFilter(opts.Tools)
+	handlers.SetConfirmPolicy(opts.Confirm)
 	return &Server{
-		reader:   bufio.NewReader(os.Stdin),
-		writer:   os.Stdout,
-		handlers: NewHandlers(opts.ScreenshotDir, false, opts.ConnectURL, opts.ConnectHeaders),
-		version:  version,
+		handlers:      handlers,
+		version:       version,
+		subscriptions: make(map[string]bool),
+		inflight:      make(map[string]chan struct{}),
+		pending:       make(map[string]chan *clientResponse),
 	}
 }
 
 // Run starts the server loop, reading requests from stdin and writing responses to stdout.
+// Requests run one at a time in order; notifications/cancelled and responses
+// to the server's own requests are handled as soon as they are read, so they
+// reach the tool call in progress.
 func (s *Server) Run() error {
+	lines := make(chan []byte, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(lines)
+	}()
+
 	for {
 		line, err := s.reader.ReadBytes('\n')
 		if err != nil {
+			close(lines)
+			serveErr := <-done
 			if err == io.EOF {
-				return nil // Clean exit
+				return serveErr // Clean exit once queued requests are answered
 			}
 			return fmt.Errorf("read error: %w", err)
 		}
@@ -156,13 +318,126 @@ func (s *Server) Run() error {
 			continue
 		}
 
+		if s.handleCancel(line) || s.handleResponse(line) {
+			continue
+		}
+		select {
+		case lines <- line:
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// serve handles requests from lines in order until lines is closed.
+func (s *Server) serve(lines <-chan []byte) error {
+	for line := range lines {
 		response := s.handleRequest(line)
 		if response != nil {
-			if err := s.writeResponse(response); err != nil {
+			if err := s.writeMessage(response); err != nil {
 				return fmt.Errorf("write error: %w", err)
 			}
 		}
 	}
+	return nil
+}
+
+// handleCancel aborts the tool call named by a notifications/cancelled
+// message and reports whether data was one. Unlike handleRequest it may be
+// called while a request is being handled.
+func (s *Server) handleCancel(data []byte) bool {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil || req.Method != "notifications/cancelled" {
+		return false
+	}
+	s.cancelRequest(req.Params)
+	return true
+}
+
+// cancelRequest aborts the tool call named by notifications/cancelled params.
+func (s *Server) cancelRequest(params json.RawMessage) {
+	var p CancelledParams
+	if err := json.Unmarshal(params, &p); err != nil || p.RequestID == nil {
+		return
+	}
+	log.Debug("mcp request cancelled", "id", p.RequestID, "reason", p.Reason)
+
+	key := fmt.Sprint(p.RequestID)
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+	if cancel, ok := s.inflight[key]; ok {
+		close(cancel)
+		delete(s.inflight, key)
+	}
+}
+
+// handleResponse passes a response to a request the server sent on to the
+// call waiting for it, and reports whether data was one. Like handleCancel
+// it may be called while a request is being handled.
+func (s *Server) handleResponse(data []byte) bool {
+	var resp clientResponse
+	if err := json.Unmarshal(data, &resp); err != nil || resp.Method != "" || resp.ID == nil || (resp.Result == nil && resp.Error == nil) {
+		return false
+	}
+	key := fmt.Sprint(resp.ID)
+	s.pendingMu.Lock()
+	ch, ok := s.pending[key]
+	delete(s.pending, key)
+	s.pendingMu.Unlock()
+	if ok {
+		ch <- &resp
+	} else {
+		log.Debug("mcp response to unknown request", "id", resp.ID)
+	}
+	return true
+}
+
+// elicit asks the user, through the client, to approve action. It gives up
+// when cancel is closed or after elicitTimeout.
+func (s *Server) elicit(action string, cancel <-chan struct{}) (bool, error) {
+	s.pendingMu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("vibium-%d", s.nextID)
+	ch := make(chan *clientResponse, 1)
+	s.pending[id] = ch
+	s.pendingMu.Unlock()
+	defer func() {
+		s.pendingMu.Lock()
+		delete(s.pending, id)
+		s.pendingMu.Unlock()
+	}()
+
+	params, _ := json.Marshal(ElicitParams{
+		Message:         fmt.Sprintf("Allow the agent to %s?", lowerFirst(action)),
+		RequestedSchema: map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	})
+	s.notify(&Request{JSONRPC: "2.0", ID: id, Method: "elicitation/create", Params: params})
+
+	timer := time.NewTimer(elicitTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return false, fmt.Errorf("elicitation failed: %s", resp.Error.Message)
+		}
+		var result ElicitResult
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return false, fmt.Errorf("invalid elicitation result: %w", err)
+		}
+		return result.Action == "accept", nil
+	case <-cancel:
+		return false, &errs.CancelledError{Method: "elicitation/create"}
+	case <-timer.C:
+		return false, fmt.Errorf("no answer within %s", elicitTimeout)
+	}
+}
+
+// lowerFirst lower-cases the first letter of s.
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
 }
 
 // handleRequest parses and routes a JSON-RPC request.
@@ -195,8 +470,9 @@ func (s *Server) handleRequest(data []byte) *Response {
 	// Route to handler
 	result, err := s.route(req)
 
-	// Notifications (no ID) don't get a response (even on error)
-	if req.ID == nil {
+	// Notifications (no ID) don't get a response (even on error), and
+	// neither do cancelled requests
+	if req.ID == nil || err == errRequestCancelled {
 		return nil
 	}
 
@@ -222,13 +498,27 @@ func (s *Server) route(req Request) (interface{}, *Error) {
 	switch req.Method {
 	case "initialize":
 		return s.handleInitialize(req.Params)
+	case "notifications/cancelled":
+		// Normally taken by handleCancel before the request queue
+		s.cancelRequest(req.Params)
+		return nil, nil
 	case "initialized", "notifications/initialized":
 		// Notification, no response needed
 		return nil, nil
+	case "ping":
+		return struct{}{}, nil
 	case "tools/list":
 		return s.handleToolsList()
 	case "tools/call":
-		return s.handleToolsCall(req.Params)
+		return s.handleToolsCall(req.ID, req.Params)
+	case "resources/list":
+		return s.handleResourcesList()
+	case "resources/read":
+		return s.handleResourcesRead(req.Params)
+	case "resources/subscribe":
+		return s.handleResourcesSubscribe(req.Params, true)
+	case "resources/unsubscribe":
+		return s.handleResourcesSubscribe(req.Params, false)
 	default:
 		return nil, &Error{
 			Code:    MethodNotFound,
@@ -250,11 +540,14 @@ func (s *Server) handleInitialize(params json.RawMessage) (interface{}, *Error)
 			}
 		}
 	}
+	version := NegotiateProtocolVersion(p.ProtocolVersion)
+	s.elicitation = p.Capabilities.Elicitation != nil && version >= elicitationVersion
 
 	return InitializeResult{
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: version,
 		Capabilities: ServerCapabilities{
-			Tools: &ToolsCapability{},
+			Tools:     &ToolsCapability{},
+			Resources: &ResourcesCapability{Subscribe: true},
 		},
 		ServerInfo: ServerInfo{
 			Name:    "vibium",
@@ -266,12 +559,14 @@ func (s *Server) handleInitialize(params json.RawMessage) (interface{}, *Error)
 // handleToolsList returns the list of available tools.
 func (s *Server) handleToolsList() (interface{}, *Error) {
 	return ToolsListResult{
-		Tools: GetToolSchemas(),
+		Tools: s.handlers.ToolSchemas(),
 	}, nil
 }
 
-// handleToolsCall executes a tool and returns the result.
-func (s *Server) handleToolsCall(params json.RawMessage) (interface{}, *Error) {
+// handleToolsCall executes a tool and returns the result. The call can be
+// aborted by a notifications/cancelled naming id, and reports progress if
+// the client sent a progress token.
+func (s *Server) handleToolsCall(id interface{}, params json.RawMessage) (interface{}, *Error) {
 	var p ToolsCallParams
 	if err := json.Unmarshal(params, &p); err != nil {
 		return nil, &Error{
@@ -281,20 +576,62 @@ func (s *Server) handleToolsCall(params json.RawMessage) (interface{}, *Error) {
 		}
 	}
 
-	result, err := s.handlers.Call(p.Name, p.Arguments)
+	opts := CallOptions{TraceParent: p.TraceParent()}
+	if id != nil {
+		cancel := make(chan struct{})
+		key := fmt.Sprint(id)
+		s.inflightMu.Lock()
+		s.inflight[key] = cancel
+		s.inflightMu.Unlock()
+		defer func() {
+			s.inflightMu.Lock()
+			delete(s.inflight, key)
+			s.inflightMu.Unlock()
+		}()
+		opts.Cancel = cancel
+	}
+	if s.elicitation && s.notify != nil {
+		opts.Confirm = func(action string) (bool, error) {
+			return s.elicit(action, opts.Cancel)
+		}
+	}
+	if p.Meta != nil && p.Meta.ProgressToken != nil && s.notify != nil {
+		count := 0
+		opts.Progress = func(message string) {
+			count++
+			s.notify(&Notification{
+				JSONRPC: "2.0",
+				Method:  "notifications/progress",
+				Params:  ProgressParams{ProgressToken: p.Meta.ProgressToken, Progress: count, Message: message},
+			})
+		}
+	}
+
+	result, err := s.handlers.CallWithOptions(p.Name, p.Arguments, opts)
+	s.checkPageUpdated()
+	if opts.Cancel != nil && isClosed(opts.Cancel) {
+		return nil, errRequestCancelled
+	}
 	if err != nil {
-		return ToolsCallResult{
-			Content: []Content{{Type: "text", Text: err.Error()}},
-			IsError: true,
-		}, nil
+		return ErrorResult(err), nil
 	}
 
 	return result, nil
 }
 
-// writeResponse writes a JSON-RPC response to stdout.
-func (s *Server) writeResponse(resp *Response) error {
-	data, err := json.Marshal(resp)
+// isClosed reports whether ch has been closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeMessage writes a JSON-RPC response or notification to stdout.
+func (s *Server) writeMessage(msg interface{}) error {
+	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vibium/clicker/internal/api"
+)
+
+// Named browser sessions. Every tool takes an optional session argument;
+// browser_start with a new name starts a separate browser with its own
+// Handlers (ref map, recorder, active page, routes), and browser_stop with
+// that name closes it. The default session is the root Handlers itself.
+
+// DefaultSession is the name of the session tools use when none is given.
+const DefaultSession = "default"
+
+// callInSession runs a tool call in the named session, creating it on
+// browser_start and dropping it on browser_stop.
+func (h *Handlers) callInSession(session, name string, args map[string]interface{}, opts CallOptions) (*ToolsCallResult, error) {
+	if name == "browser_list_sessions" {
+		if err := h.checkTool(name, args); err != nil {
+			return nil, err
+		}
+		return h.browserListSessions(args)
+	}
+
+	s, ok := h.sessions[session]
+	if !ok {
+		switch name {
+		case "browser_start":
+			s = NewHandlers(h.screenshotDir, h.headless, h.connectURL, h.connectHeaders)
+			s.SetNavigationPolicy(h.policy)
+			s.SetToolFilter(h.tools)
+			s.SetConfirmPolicy(h.confirm)
+			if h.sessions == nil {
+				h.sessions = make(map[string]*Handlers)
+			}
+			h.sessions[session] = s
+		case "browser_stop":
+			return &ToolsCallResult{
+				Content: []Content{{
+					Type: "text",
+					Text: fmt.Sprintf("No browser session %q to close", session),
+				}},
+			}, nil
+		default:
+			return nil, fmt.Errorf("unknown session %q (start it with browser_start)", session)
+		}
+	}
+
+	result, err := s.CallWithOptions(name, args, opts)
+	if name == "browser_stop" || (!ok && err != nil) {
+		delete(h.sessions, session)
+	}
+	return result, err
+}
+
+// browserListSessions lists the default session and the named sessions,
+// with each one's current URL.
+func (h *Handlers) browserListSessions(args map[string]interface{}) (*ToolsCallResult, error) {
+	names := make([]string, 0, len(h.sessions))
+	for name := range h.sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{DefaultSession + ": " + h.sessionState()}
+	for _, name := range names {
+		lines = append(lines, name+": "+h.sessions[name].sessionState())
+	}
+	return &ToolsCallResult{
+		Content: []Content{{
+			Type: "text",
+			Text: strings.Join(lines, "\n"),
+		}},
+	}, nil
+}
+
+// sessionState describes whether the session's browser is running, and
+// where.
+func (h *Handlers) sessionState() string {
+	if h.client == nil {
+		return "not started"
+	}
+	s := h.newSession()
+	ctx, err := s.GetContextID()
+	if err != nil {
+		return "running"
+	}
+	url, err := api.GetURL(s, ctx)
+	if err != nil {
+		return "running"
+	}
+	return "running, " + url
+}
+
+// closeSessions closes every named session.
+func (h *Handlers) closeSessions() {
+	for name, s := range h.sessions {
+		s.Close()
+		delete(h.sessions, name)
+	}
+}
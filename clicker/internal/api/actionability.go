@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 // ActionCheck represents a specific actionability check.
@@ -28,7 +31,8 @@ var (
 
 // actionableResult is the JSON structure returned by the combined actionability script.
 type actionableResult struct {
-	Status string  `json:"status"` // "ok", "not_found", "failed"
+	Status string  `json:"status"`          // "ok", "not_found", "failed", "strict"
+	Count  int     `json:"count,omitempty"` // number of matches, for "strict"
 	Check  string  `json:"check,omitempty"`
 	Reason string  `json:"reason,omitempty"`
 	Tag    string  `json:"tag,omitempty"`
@@ -75,20 +79,26 @@ func buildCSSActionableScript(ep ElementParams, checkVisible, checkReceivesEvent
 		{"type": "boolean", "value": checkReceivesEvents},
 		{"type": "boolean", "value": checkEnabled},
 		{"type": "boolean", "value": checkEditable},
+		{"type": "boolean", "value": ep.Strict && !ep.HasIndex},
 	}
 
 	script := `
-		(scope, selector, index, hasIndex, chkVisible, chkEvents, chkEnabled, chkEditable) => {
-			const root = scope ? document.querySelector(scope) : document;
+		(scope, selector, index, hasIndex, chkVisible, chkEvents, chkEnabled, chkEditable, strict) => {
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return JSON.stringify({status:'not_found'});
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return JSON.stringify({status:'not_found'});
+			if (strict) {
+				const count = querySelectorAllDeep(root, selector).length;
+				if (count > 1) return JSON.stringify({status:'strict', count});
+			}
 
 			if (el.scrollIntoViewIfNeeded) {
 				el.scrollIntoViewIfNeeded(true);
@@ -126,11 +136,12 @@ func buildSemanticActionableScript(ep ElementParams, checkVisible, checkReceives
 		{"type": "boolean", "value": checkReceivesEvents},
 		{"type": "boolean", "value": checkEnabled},
 		{"type": "boolean", "value": checkEditable},
+		{"type": "boolean", "value": ep.Strict && !ep.HasIndex},
 	}
 
 	script := `
-		(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex, chkVisible, chkEvents, chkEnabled, chkEditable) => {
-			const root = scope ? document.querySelector(scope) : document;
+		(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex, chkVisible, chkEvents, chkEnabled, chkEditable, strict) => {
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return JSON.stringify({status:'not_found'});
 	` + semanticMatchesHelper() + `
 			const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -141,6 +152,7 @@ func buildSemanticActionableScript(ep ElementParams, checkVisible, checkReceives
 				el = pickBest(found, text);
 			}
 			if (!el) return JSON.stringify({status:'not_found'});
+			if (strict && found.length > 1) return JSON.stringify({status:'strict', count: found.length});
 
 			if (el.scrollIntoViewIfNeeded) {
 				el.scrollIntoViewIfNeeded(true);
@@ -203,8 +215,17 @@ func actionabilityCheckBody() string {
 			}
 			if (chkEvents) {
 				const cx = rect.x + rect.width/2, cy = rect.y + rect.height/2;
-				const hit = document.elementFromPoint(cx, cy);
-				if (!hit || (el !== hit && !el.contains(hit)))
+				// elementFromPoint stops at shadow hosts; look inside them
+				let hit = document.elementFromPoint(cx, cy);
+				while (hit && hit.shadowRoot) {
+					const inner = hit.shadowRoot.elementFromPoint(cx, cy);
+					if (!inner || inner === hit) break;
+					hit = inner;
+				}
+				// Is hit el or inside it, counting shadow roots as inside their host?
+				let cur = hit;
+				while (cur && cur !== el) cur = cur.parentNode instanceof ShadowRoot ? cur.parentNode.host : cur.parentNode;
+				if (!hit || !cur)
 					return JSON.stringify({status:'failed', check:'receivesEvents', reason:'element is obscured'});
 			}
 `
@@ -230,6 +251,22 @@ func callActionableScript(s Session, context, script string, args []map[string]i
 	return &result, nil
 }
 
+// traceActionableScript runs callActionableScript in a span recording the
+// attempt number and the check outcome.
+func traceActionableScript(s Session, context, script string, args []map[string]interface{}, attempt int) (*actionableResult, error) {
+	span := tracing.Start("actionability", tracing.KindInternal, parentSpan(s))
+	span.SetAttr("vibium.actionability.attempt", attempt)
+	result, err := callActionableScript(s, context, script, args)
+	if result != nil {
+		span.SetAttr("vibium.actionability.status", result.Status)
+		if result.Check != "" {
+			span.SetAttr("vibium.actionability.check", result.Check)
+		}
+	}
+	span.End(err)
+	return result, err
+}
+
 // WaitForActionable polls until the element is found and passes all actionability checks,
 // or times out. Returns the element info on success.
 //
@@ -245,20 +282,30 @@ func WaitForActionable(s Session, context string, ep ElementParams, checks []Act
 		}
 	}
 	script, args := buildActionableScript(ep, checksWithoutStable)
+	desc := describeSelector(args)
 
 	deadline := time.Now().Add(ep.Timeout)
 	interval := 100 * time.Millisecond
 	var lastResult *actionableResult
+	attempt := 0
 
 	for {
-		result, err := callActionableScript(s, context, script, args)
+		attempt++
+		result, err := traceActionableScript(s, context, script, args, attempt)
 		if err == nil {
 			lastResult = result
+			if result.Status == "strict" {
+				// Ambiguity won't resolve itself by waiting
+				return nil, &errs.StrictModeViolationError{Selector: desc, Count: result.Count}
+			}
 			if result.Status == "ok" {
 				if needStable {
 					// Check stability: sleep 50ms, re-run, compare bbox
-					time.Sleep(50 * time.Millisecond)
-					result2, err2 := callActionableScript(s, context, script, args)
+					if err := pause(s, 50*time.Millisecond, desc+" to be stable"); err != nil {
+						return nil, err
+					}
+					attempt++
+					result2, err2 := traceActionableScript(s, context, script, args, attempt)
 					if err2 == nil && result2.Status == "ok" {
 						if result.Box == result2.Box {
 							return &ElementInfo{Tag: result2.Tag, Text: result2.Text, Box: result2.Box}, nil
@@ -280,22 +327,28 @@ func WaitForActionable(s Session, context string, ep ElementParams, checks []Act
 		if time.Now().After(deadline) {
 			if lastResult != nil {
 				if lastResult.Status == "not_found" {
-					return nil, fmt.Errorf("timeout after %s: element not found", ep.Timeout)
+					return nil, &errs.ElementNotFoundError{Selector: desc, Context: context, Timeout: ep.Timeout}
 				}
-				return nil, fmt.Errorf("timeout after %s: %s check failed — %s", ep.Timeout, lastResult.Check, lastResult.Reason)
+				return nil, &errs.NotActionableError{Selector: desc, Timeout: ep.Timeout, Check: lastResult.Check, Reason: lastResult.Reason}
 			}
-			return nil, fmt.Errorf("timeout after %s waiting for element", ep.Timeout)
+			return nil, &errs.TimeoutError{Selector: desc, Timeout: ep.Timeout}
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, desc+" to be actionable"); err != nil {
+			return nil, err
+		}
 	}
 }
 
 // resolveWithActionability resolves an element with actionability checks.
-// If Force is set or no checks are needed, falls back to plain ResolveElement.
+// If Force is set or no checks are needed, falls back to plain ResolveElement
+// (unless Strict is set, which needs the match count from the actionability script).
 func resolveWithActionability(s Session, context string, ep ElementParams, checks []ActionCheck) (*ElementInfo, error) {
 	if ep.Force || len(checks) == 0 {
-		return ResolveElement(s, context, ep)
+		if !ep.Strict {
+			return ResolveElement(s, context, ep)
+		}
+		checks = nil
 	}
 	info, err := WaitForActionable(s, context, ep, checks)
 	if err == nil && info != nil {
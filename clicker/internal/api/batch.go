@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	errs "github.com/vibium/clicker/internal/errors"
+)
+
+// batchEntry is one command in a vibium:batch command list.
+type batchEntry struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// batchResult is the outcome of one batch entry, shaped like the response the
+// command would have got on its own, minus the ID.
+type batchResult struct {
+	Type    string      `json:"type"` // "success" or "error"
+	Result  interface{} `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// handleBatch handles vibium:batch — runs a list of queued vibium: commands
+// in order and answers once with all their results. Each command goes
+// through dispatch on its own, so it's recorded, traced and timed like a
+// command sent separately. With stopOnError (the default) the first failure
+// ends the batch; otherwise every command runs.
+//
+// A commandTimeout param bounds the whole batch, and vibium:command.cancel
+// with the batch's ID cancels the running command and skips the rest.
+func (r *Router) handleBatch(session *BrowserSession, cmd bidiCommand) {
+	if cmd.Params == nil {
+		cmd.Params = map[string]interface{}{}
+	}
+	var p struct {
+		Commands    []batchEntry `json:"commands"`
+		StopOnError *bool        `json:"stopOnError"`
+	}
+	raw, _ := json.Marshal(cmd.Params)
+	if err := json.Unmarshal(raw, &p); err != nil {
+		r.sendError(session, cmd.ID, fmt.Errorf("invalid batch: %w", err))
+		return
+	}
+	if len(p.Commands) == 0 {
+		r.sendError(session, cmd.ID, fmt.Errorf("commands is required"))
+		return
+	}
+	stopOnError := p.StopOnError == nil || *p.StopOnError
+
+	rc := session.startCommand(cmd, "")
+	defer session.finishCommand(rc)
+
+	results := make([]batchResult, 0, len(p.Commands))
+	failed := 0
+	for _, entry := range p.Commands {
+		if err := rc.aborted(); err != nil {
+			r.sendError(session, cmd.ID, err)
+			return
+		}
+		resp := r.runBatched(session, rc, entry)
+		results = append(results, resp)
+		if resp.Type == "error" {
+			failed++
+			if stopOnError {
+				break
+			}
+		}
+	}
+
+	r.sendSuccess(session, cmd.ID, map[string]interface{}{
+		"results": results,
+		"failed":  failed,
+	})
+}
+
+// runBatched dispatches one batch entry under a synthetic ID and waits for
+// its response. If the batch is aborted meanwhile, the entry is aborted too.
+func (r *Router) runBatched(session *BrowserSession, batch *runningCommand, entry batchEntry) batchResult {
+	handler := r.queuedHandler(entry.Method)
+	if handler == nil {
+		return batchResult{Type: "error", Error: errs.CodeUnknownCommand, Message: fmt.Sprintf("%s can't be batched", entry.Method)}
+	}
+
+	params := entry.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if _, ok := params["traceparent"]; !ok && batch.span != nil {
+		params["traceparent"] = batch.span.TraceParent()
+	}
+
+	session.batchedMu.Lock()
+	session.nextBatchID--
+	id := session.nextBatchID
+	ch := make(chan bidiResponse, 1)
+	session.batched[id] = ch
+	session.batchedMu.Unlock()
+
+	r.dispatch(session, bidiCommand{ID: id, Method: entry.Method, Params: params}, handler)
+
+	var resp bidiResponse
+	select {
+	case resp = <-ch:
+	case <-batch.done:
+		session.commandsMu.Lock()
+		rc := session.commands[id]
+		session.commandsMu.Unlock()
+		if rc != nil {
+			rc.abort(batch.err)
+		}
+		resp = <-ch
+	}
+	return batchResult{Type: resp.Type, Result: resp.Result, Error: resp.Error, Message: resp.Message}
+}
+
+// deliverBatched hands resp to the vibium:batch waiting for it. It reports
+// false if resp answers a command the client sent directly.
+func (s *BrowserSession) deliverBatched(resp bidiResponse) bool {
+	if resp.ID >= 0 {
+		return false
+	}
+	s.batchedMu.Lock()
+	ch, ok := s.batched[resp.ID]
+	delete(s.batched, resp.ID)
+	s.batchedMu.Unlock()
+	if ok {
+		ch <- resp
+	}
+	return ok
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/tracing"
+)
+
+// runningCommand tracks a dispatched vibium: command so it can be cancelled
+// by vibium:command.cancel or aborted when its deadline passes.
+type runningCommand struct {
+	id     int
+	method string
+	done   chan struct{} // closed when the command is aborted
+	once   sync.Once
+	err    error // why it was aborted; read only after done is closed
+	timer  *time.Timer
+	lane   string        // browsing context the command runs on; "" = session-wide
+	span   *tracing.Span // nil when tracing is off
+
+	// Recording state set while the handler runs; guarded by commandsMu
+	box               *BoxInfo // last resolved element box
+	handlerScreenshot bool     // handler already captured the filmstrip screenshot
+}
+
+// abort stops the command with err. Only the first call has any effect.
+func (c *runningCommand) abort(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// aborted returns the command's abort error, or nil if it's still live.
+func (c *runningCommand) aborted() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// startCommand registers a dispatched command on lane. A "commandTimeout"
+// param (ms) bounds the whole command, including element waits and internal
+// BiDi calls. A "traceparent" param makes the command's span join the
+// client's trace.
+func (s *BrowserSession) startCommand(cmd bidiCommand, lane string) *runningCommand {
+	traceparent, _ := cmd.Params["traceparent"].(string)
+	delete(cmd.Params, "traceparent") // keep it out of recordings
+	rc := &runningCommand{
+		id:     cmd.ID,
+		method: cmd.Method,
+		done:   make(chan struct{}),
+		lane:   lane,
+		span:   tracing.StartRemote(cmd.Method, tracing.KindServer, traceparent),
+	}
+	rc.span.SetAttr("vibium.command.id", cmd.ID)
+	if lane != "" {
+		rc.span.SetAttr("vibium.context", lane)
+	}
+	if ms, ok := cmd.Params["commandTimeout"].(float64); ok && ms > 0 {
+		budget := time.Duration(ms) * time.Millisecond
+		rc.timer = time.AfterFunc(budget, func() {
+			rc.abort(&errs.TimeoutError{Selector: cmd.Method, Timeout: budget})
+		})
+	}
+
+	s.commandsMu.Lock()
+	s.commands[cmd.ID] = rc
+	s.commandsMu.Unlock()
+	return rc
+}
+
+// finishCommand unregisters a command once its handler has returned.
+func (s *BrowserSession) finishCommand(rc *runningCommand) {
+	if rc.timer != nil {
+		rc.timer.Stop()
+	}
+	rc.span.End(rc.aborted())
+	s.commandsMu.Lock()
+	if s.commands[rc.id] == rc {
+		delete(s.commands, rc.id)
+	}
+	if s.active[rc.lane] == rc {
+		delete(s.active, rc.lane)
+	}
+	s.commandsMu.Unlock()
+}
+
+// setActive marks rc as the command whose handler is now running on its lane.
+func (s *BrowserSession) setActive(rc *runningCommand) {
+	s.commandsMu.Lock()
+	s.active[rc.lane] = rc
+	s.commandsMu.Unlock()
+}
+
+// clearActive marks rc's handler as returned.
+func (s *BrowserSession) clearActive(rc *runningCommand) {
+	s.commandsMu.Lock()
+	if s.active[rc.lane] == rc {
+		delete(s.active, rc.lane)
+	}
+	s.commandsMu.Unlock()
+}
+
+// commandFor returns the command whose handler is running against the given
+// browsing context, or nil. A context that matches no lane (e.g. "" from a
+// helper that doesn't know its context) resolves only when a single command
+// is running, since it can't be told apart otherwise.
+func (s *BrowserSession) commandFor(context string) *runningCommand {
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+	return s.commandForLocked(context)
+}
+
+func (s *BrowserSession) commandForLocked(context string) *runningCommand {
+	if rc, ok := s.active[context]; ok {
+		return rc
+	}
+	if len(s.active) == 1 {
+		for _, rc := range s.active {
+			return rc
+		}
+	}
+	return nil
+}
+
+// setElementBox stores the bounding box of the element the command running on
+// context resolved, so its recording event can highlight it.
+func (s *BrowserSession) setElementBox(context string, box *BoxInfo) {
+	s.commandsMu.Lock()
+	if rc := s.commandForLocked(context); rc != nil {
+		rc.box = box
+	}
+	s.commandsMu.Unlock()
+}
+
+// markHandlerScreenshot records that the command running on context already
+// captured its filmstrip screenshot, so dispatch doesn't take another.
+func (s *BrowserSession) markHandlerScreenshot(context string) {
+	s.commandsMu.Lock()
+	if rc := s.commandForLocked(context); rc != nil {
+		rc.handlerScreenshot = true
+	}
+	s.commandsMu.Unlock()
+}
+
+// recordingState returns and clears the recording state the handler left on rc.
+func (s *BrowserSession) recordingState(rc *runningCommand) (*BoxInfo, bool) {
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+	box, captured := rc.box, rc.handlerScreenshot
+	rc.box, rc.handlerScreenshot = nil, false
+	return box, captured
+}
+
+// sessionWideCommand reports whether a command affects the whole browser
+// session rather than one page, and so must not overlap any other command.
+func sessionWideCommand(method string) bool {
+	return strings.HasPrefix(method, "vibium:session.") ||
+		strings.HasPrefix(method, "vibium:browser.") ||
+		strings.HasPrefix(method, "vibium:context.") ||
+		strings.HasPrefix(method, "vibium:clock.")
+}
+
+// laneFor picks the lane a command runs on: its "context" param, or else the
+// page the session is currently on. The latter is written back into the
+// params so the handler keeps targeting that page even if another lane
+// switches pages meanwhile. Session-wide commands, and commands sent before
+// any page is known, use the "" lane and run exclusively.
+func (s *BrowserSession) laneFor(cmd bidiCommand) string {
+	if sessionWideCommand(cmd.Method) {
+		return ""
+	}
+	if ctx, ok := cmd.Params["context"].(string); ok && ctx != "" {
+		return ctx
+	}
+	s.mu.Lock()
+	ctx := s.lastContext
+	s.mu.Unlock()
+	if ctx != "" {
+		cmd.Params["context"] = ctx
+	}
+	return ctx
+}
+
+// laneQueue holds one lane's commands waiting to run, in arrival order.
+type laneQueue struct {
+	jobs    []func()
+	running bool
+}
+
+// enqueue runs job after every job queued before it on the same lane. Jobs on
+// different lanes run concurrently.
+func (s *BrowserSession) enqueue(lane string, job func()) {
+	s.lanesMu.Lock()
+	q := s.lanes[lane]
+	if q == nil {
+		q = &laneQueue{}
+		s.lanes[lane] = q
+	}
+	q.jobs = append(q.jobs, job)
+	if q.running {
+		s.lanesMu.Unlock()
+		return
+	}
+	q.running = true
+	s.lanesMu.Unlock()
+
+	go s.runLane(lane, q)
+}
+
+// runLane drains a lane's queue, then removes the lane.
+func (s *BrowserSession) runLane(lane string, q *laneQueue) {
+	for {
+		s.lanesMu.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			delete(s.lanes, lane)
+			s.lanesMu.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		s.lanesMu.Unlock()
+
+		job()
+	}
+}
+
+// sleep pauses a polling loop for d. It returns early with the command's
+// error if the command running on context is cancelled or out of time.
+func (s *BrowserSession) sleep(context string, d time.Duration) error {
+	rc := s.commandFor(context)
+	if rc == nil {
+		time.Sleep(d)
+		return nil
+	}
+	select {
+	case <-rc.done:
+		return rc.err
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// commandSpan returns the trace span of the command running on context, or nil.
+func (s *BrowserSession) commandSpan(context string) *tracing.Span {
+	if rc := s.commandFor(context); rc != nil {
+		return rc.span
+	}
+	return nil
+}
+
+// failCommand marks the span of the command with the given client ID as
+// failed, if it's still registered.
+func (s *BrowserSession) failCommand(id int, err error) {
+	s.commandsMu.Lock()
+	rc := s.commands[id]
+	s.commandsMu.Unlock()
+	if rc != nil {
+		rc.span.SetError(err)
+	}
+}
+
+// sleeper is implemented by sessions whose polling loops can be interrupted
+// (APISession, for cancellable proxy commands; AgentSession, for cancellable
+// and progress-reporting MCP tool calls).
+type sleeper interface {
+	sleep(d time.Duration, waitingFor string) error
+}
+
+// pause is the Session-level sleep used by the shared polling helpers.
+// waitingFor describes what the loop is waiting for, e.g. "#submit to be
+// actionable", for progress reports.
+func pause(s Session, d time.Duration, waitingFor string) error {
+	if sl, ok := s.(sleeper); ok {
+		return sl.sleep(d, waitingFor)
+	}
+	time.Sleep(d)
+	return nil
+}
+
+// tracer is implemented by sessions that know the trace span of the command
+// they're running on behalf of.
+type tracer interface {
+	traceSpan() *tracing.Span
+}
+
+// parentSpan returns the span that work done through s should nest under, or
+// nil.
+func parentSpan(s Session) *tracing.Span {
+	if t, ok := s.(tracer); ok {
+		return t.traceSpan()
+	}
+	return nil
+}
+
+// handleCommandCancel handles vibium:command.cancel — aborts the in-flight
+// command with the given id. The aborted command answers with a "cancelled"
+// error; this command reports whether there was anything to cancel.
+func (r *Router) handleCommandCancel(session *BrowserSession, cmd bidiCommand) {
+	id, ok := cmd.Params["id"].(float64)
+	if !ok {
+		r.sendError(session, cmd.ID, fmt.Errorf("id is required"))
+		return
+	}
+
+	session.commandsMu.Lock()
+	rc := session.commands[int(id)]
+	session.commandsMu.Unlock()
+
+	if rc == nil || rc.aborted() != nil {
+		r.sendSuccess(session, cmd.ID, map[string]interface{}{"cancelled": false})
+		return
+	}
+	rc.abort(&errs.CancelledError{Method: rc.method})
+	r.sendSuccess(session, cmd.ID, map[string]interface{}{"cancelled": true})
+}
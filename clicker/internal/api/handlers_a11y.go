@@ -73,13 +73,13 @@ func (r *Router) handleVibiumElLabel(session *BrowserSession, cmd bidiCommand) {
 		const labelledBy = el.getAttribute('aria-labelledby');
 		if (labelledBy) {
 			const parts = labelledBy.split(/\s+/).map(id => {
-				const ref = document.getElementById(id);
+				const ref = el.getRootNode().getElementById(id);
 				return ref ? (ref.textContent || '').trim() : '';
 			}).filter(Boolean);
 			if (parts.length) return parts.join(' ');
 		}
 		if (el.id) {
-			const assocLabel = document.querySelector('label[for="' + el.id + '"]');
+			const assocLabel = el.getRootNode().querySelector('label[for="' + el.id + '"]');
 			if (assocLabel) return (assocLabel.textContent || '').trim();
 		}
 		const parentLabel = el.closest('label');
@@ -165,6 +165,7 @@ func A11yTree(s Session, context string, interestingOnly bool, rootSelector stri
 // A11yTreeScript returns the JS function that builds the accessibility tree.
 func A11yTreeScript() string {
 	return `(interestingOnly, rootSelector) => {
+	` + ShadowDOMJS() + `
 		const IMPLICIT_ROLES = {
 			A: (el) => el.hasAttribute('href') ? 'link' : '',
 			AREA: (el) => el.hasAttribute('href') ? 'link' : '',
@@ -223,13 +224,13 @@ func A11yTreeScript() string {
 			const labelledBy = el.getAttribute('aria-labelledby');
 			if (labelledBy) {
 				const parts = labelledBy.split(/\s+/).map(id => {
-					const ref = document.getElementById(id);
+					const ref = el.getRootNode().getElementById(id);
 					return ref ? (ref.textContent || '').trim() : '';
 				}).filter(Boolean);
 				if (parts.length) return parts.join(' ');
 			}
 			if (el.id) {
-				const assocLabel = document.querySelector('label[for="' + el.id + '"]');
+				const assocLabel = el.getRootNode().querySelector('label[for="' + el.id + '"]');
 				if (assocLabel) return (assocLabel.textContent || '').trim();
 			}
 			const placeholder = el.getAttribute('placeholder');
@@ -333,7 +334,7 @@ func A11yTreeScript() string {
 			const describedBy = el.getAttribute('aria-describedby');
 			if (describedBy) {
 				const parts = describedBy.split(/\s+/).map(id => {
-					const ref = document.getElementById(id);
+					const ref = el.getRootNode().getElementById(id);
 					return ref ? (ref.textContent || '').trim() : '';
 				}).filter(Boolean);
 				if (parts.length) node.description = parts.join(' ');
@@ -344,7 +345,7 @@ func A11yTreeScript() string {
 			return node;
 		}
 
-		const rootEl = rootSelector ? document.querySelector(rootSelector) : document.body;
+		const rootEl = rootSelector ? querySelectorDeep(document, rootSelector) : document.body;
 		if (!rootEl) return JSON.stringify({role: 'WebArea', name: document.title, children: []});
 
 		const children = [];
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	errs "github.com/vibium/clicker/internal/errors"
 )
 
 // ElementInfo holds parsed element information.
@@ -147,9 +149,10 @@ func BuildFindScript(params map[string]interface{}, findAll bool) (string, []map
 func buildCSSFindScript() string {
 	return `
 		(scope, selector) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return null;
-			const el = root.querySelector(selector);
+			const el = querySelectorDeep(root, selector);
 			if (!el) return null;
 			if (el.scrollIntoViewIfNeeded) {
 				el.scrollIntoViewIfNeeded(true);
@@ -169,14 +172,15 @@ func buildCSSFindScript() string {
 func buildCSSFindAllScript() string {
 	return `
 		(scope, selector, hasText, has) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return '[]';
-			let els = Array.from(root.querySelectorAll(selector));
+			let els = Array.from(querySelectorAllDeep(root, selector));
 			if (hasText) {
 				els = els.filter(el => (el.textContent || '').includes(hasText));
 			}
 			if (has) {
-				els = els.filter(el => el.querySelector(has) !== null);
+				els = els.filter(el => querySelectorDeep(el, has) !== null);
 			}
 			return JSON.stringify(els.map((el, i) => {
 				const rect = el.getBoundingClientRect();
@@ -191,8 +195,10 @@ func buildCSSFindAllScript() string {
 	`
 }
 
+// semanticMatchesHelper returns the JS helpers for semantic lookups. It
+// includes ShadowDOMJS, and collectMatches searches open shadow roots too.
 func semanticMatchesHelper() string {
-	return `
+	return ShadowDOMJS() + `
 			const IMPLICIT_ROLES = {
 				A: (el) => el.hasAttribute('href') ? 'link' : '',
 				AREA: (el) => el.hasAttribute('href') ? 'link' : '',
@@ -257,11 +263,11 @@ func semanticMatchesHelper() string {
 					const labelledBy = el.getAttribute('aria-labelledby');
 					let labelText = ariaLabel;
 					if (labelledBy) {
-						const labelEl = document.getElementById(labelledBy);
+						const labelEl = el.getRootNode().getElementById(labelledBy);
 						if (labelEl) labelText = labelText || (labelEl.textContent || '').trim();
 					}
 					if (el.id) {
-						const assocLabel = document.querySelector('label[for="' + el.id + '"]');
+						const assocLabel = el.getRootNode().querySelector('label[for="' + el.id + '"]');
 						if (assocLabel) labelText = labelText || (assocLabel.textContent || '').trim();
 					}
 					if (!labelText.includes(label)) return false;
@@ -283,7 +289,14 @@ func semanticMatchesHelper() string {
 			}
 
 			function collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath) {
-				const found = [];
+				let found = [];
+				// A locator (or an element passed by reference) can't be tested
+				// with matches(); look its elements up
+				let located = null;
+				if (selector && (selector.nodeType === 1 || !locIsPlainCSS(selector))) {
+					located = new Set(querySelectorAllDeep(root, selector));
+					selector = '';
+				}
 				if (xpath) {
 					const xr = document.evaluate(xpath, root, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
 					for (let i = 0; i < xr.snapshotLength; i++) {
@@ -293,14 +306,13 @@ func semanticMatchesHelper() string {
 						}
 					}
 				} else {
-					const walker = document.createTreeWalker(root, NodeFilter.SHOW_ELEMENT);
-					let node;
-					while (node = walker.nextNode()) {
+					walkDeep(root, (node) => {
 						if (matches(node, selector, role, text, label, placeholder, alt, title, testid)) {
 							found.push(node);
 						}
-					}
+					});
 				}
+				if (located) found = found.filter(el => located.has(el));
 				return found;
 			}
 
@@ -324,7 +336,7 @@ func semanticMatchesHelper() string {
 func buildSemanticFindScript() string {
 	return `
 		(scope, selector, role, text, label, placeholder, alt, title, testid, xpath) => {
-			const root = scope ? document.querySelector(scope) : document;
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return null;
 ` + semanticMatchesHelper() + `
 			const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -343,7 +355,7 @@ func buildSemanticFindScript() string {
 func buildSemanticFindAllScript() string {
 	return `
 		(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, hasText, has) => {
-			const root = scope ? document.querySelector(scope) : document;
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return '[]';
 ` + semanticMatchesHelper() + `
 			let found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -351,7 +363,7 @@ func buildSemanticFindAllScript() string {
 				found = found.filter(el => (el.textContent || '').includes(hasText));
 			}
 			if (has) {
-				found = found.filter(el => el.querySelector(has) !== null);
+				found = found.filter(el => querySelectorDeep(el, has) !== null);
 			}
 			return JSON.stringify(found.map((el, i) => {
 				const info = toInfo(el);
@@ -404,10 +416,12 @@ func (r *Router) waitForElements(session *BrowserSession, context, script string
 		}
 
 		if time.Now().After(deadline) {
-			return nil, fmt.Errorf("timeout after %s waiting for '%s': no elements found", timeout, desc)
+			return nil, &errs.ElementNotFoundError{Selector: desc, Context: context, Timeout: timeout}
 		}
 
-		time.Sleep(interval)
+		if err := session.sleep(context, interval); err != nil {
+			return nil, err
+		}
 	}
 }
 
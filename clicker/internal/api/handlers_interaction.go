@@ -1019,14 +1019,15 @@ func buildIsCheckedScript(ep ElementParams) (string, []map[string]interface{}) {
 
 	script := `
 		(scope, selector, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return 'false';
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return 'false';
 			return el.checked ? 'true' : 'false';
@@ -1047,14 +1048,15 @@ func buildSelectOptionScript(ep ElementParams, value string) (string, []map[stri
 
 	script := `
 		(scope, selector, index, hasIndex, value) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return 'element not found';
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return 'element not found';
 			el.value = value;
@@ -1078,14 +1080,15 @@ func buildSetValueScript(ep ElementParams, value string) (string, []map[string]i
 
 	script := `
 		(scope, selector, index, hasIndex, value) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return 'element not found';
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return 'element not found';
 			el.focus();
@@ -1118,14 +1121,15 @@ func buildFocusScript(ep ElementParams) (string, []map[string]interface{}) {
 
 	script := `
 		(scope, selector, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return 'not found';
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return 'not found';
 			el.focus();
@@ -1148,14 +1152,15 @@ func buildDispatchEventScript(ep ElementParams, eventType, initJSON string) (str
 
 	script := `
 		(scope, selector, index, hasIndex, eventType, initJSON) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return 'not found';
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return 'not found';
 			const init = JSON.parse(initJSON);
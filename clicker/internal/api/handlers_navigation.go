@@ -3,7 +3,6 @@ package api
 import (
 	"fmt"
 	"strings"
-	"sync/atomic"
 	"time"
 )
 
@@ -36,7 +35,7 @@ func (r *Router) handlePageNavigate(session *BrowserSession, cmd bidiCommand) {
 	if recorder != nil && recorder.IsRecording() {
 		ps := NewAPISession(r, session, context)
 		CaptureRecordingScreenshot(ps, recorder, time.Now())
-		atomic.StoreInt32(&session.handlerScreenshot, 1)
+		session.markHandlerScreenshot(context)
 	}
 
 	r.sendSuccess(session, cmd.ID, map[string]interface{}{"url": url})
@@ -329,7 +328,9 @@ func WaitForURL(s Session, context, pattern string, timeout time.Duration) (stri
 			return "", fmt.Errorf("timeout after %s waiting for URL matching '%s'", timeout, pattern)
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, "URL matching "+pattern); err != nil {
+			return "", err
+		}
 	}
 }
 
@@ -356,7 +357,9 @@ func WaitForReadyState(s Session, context, targetState string, timeout time.Dura
 			return fmt.Errorf("timeout after %s waiting for readyState '%s'", timeout, targetState)
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, "readyState "+targetState); err != nil {
+			return err
+		}
 	}
 }
 
@@ -40,6 +40,7 @@ func (r *Router) handlePageRoute(session *BrowserSession, cmd bidiCommand) {
 		r.sendError(session, cmd.ID, fmt.Errorf("failed to parse addIntercept response: %w", err))
 		return
 	}
+	r.trackIntercept(session, resp)
 
 	r.sendSuccess(session, cmd.ID, map[string]interface{}{"intercept": result.Result.Intercept})
 }
@@ -66,6 +67,7 @@ func (r *Router) handlePageUnroute(session *BrowserSession, cmd bidiCommand) {
 		r.sendError(session, cmd.ID, bidiErr)
 		return
 	}
+	r.untrackIntercept(session, intercept)
 
 	r.sendSuccess(session, cmd.ID, map[string]interface{}{})
 }
@@ -259,6 +261,7 @@ func (r *Router) handlePageSetHeaders(session *BrowserSession, cmd bidiCommand)
 		r.sendError(session, cmd.ID, fmt.Errorf("failed to parse addIntercept response: %w", err))
 		return
 	}
+	r.trackIntercept(session, resp)
 
 	// Store extra headers on the session so the JS client can use them
 	bidiHeaders := convertHeadersToBidi(headers)
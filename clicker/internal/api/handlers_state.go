@@ -97,7 +97,7 @@ func (r *Router) handleVibiumElAttr(session *BrowserSession, cmd bidiCommand) {
 		args = append(args, map[string]interface{}{"type": "string", "value": name})
 		script = `
 			(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex, name) => {
-				const root = scope ? document.querySelector(scope) : document;
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return JSON.stringify({error: 'root not found'});
 		` + semanticMatchesHelper() + `
 				const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -117,13 +117,14 @@ func (r *Router) handleVibiumElAttr(session *BrowserSession, cmd bidiCommand) {
 		args = append(args, map[string]interface{}{"type": "string", "value": name})
 		script = `
 			(scope, selector, index, hasIndex, name) => {
-				const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return JSON.stringify({error: 'root not found'});
 				let el;
 				if (hasIndex) {
-					el = root.querySelectorAll(selector)[index];
+					el = querySelectorAllDeep(root, selector)[index];
 				} else {
-					el = root.querySelector(selector);
+					el = querySelectorDeep(root, selector);
 				}
 				if (!el) return JSON.stringify({error: 'element not found'});
 				const v = el.getAttribute(name);
@@ -440,7 +441,10 @@ func (r *Router) handleVibiumElWaitFor(session *BrowserSession, cmd bidiCommand)
 			return
 		}
 
-		time.Sleep(interval)
+		if err := session.sleep(context, interval); err != nil {
+			r.sendError(session, cmd.ID, err)
+			return
+		}
 	}
 }
 
@@ -482,7 +486,11 @@ func (r *Router) handlePageWait(session *BrowserSession, cmd bidiCommand) {
 		return
 	}
 
-	time.Sleep(time.Duration(ms) * time.Millisecond)
+	context, _ := cmd.Params["context"].(string)
+	if err := session.sleep(context, time.Duration(ms)*time.Millisecond); err != nil {
+		r.sendError(session, cmd.ID, err)
+		return
+	}
 	r.sendSuccess(session, cmd.ID, map[string]interface{}{"waited": true})
 }
 
@@ -553,7 +561,10 @@ func (r *Router) handlePageWaitForFunction(session *BrowserSession, cmd bidiComm
 			return
 		}
 
-		time.Sleep(interval)
+		if err := session.sleep(context, interval); err != nil {
+			r.sendError(session, cmd.ID, err)
+			return
+		}
 	}
 }
 
@@ -594,7 +605,7 @@ func buildElStateScript(ep ElementParams, expr string) (string, []map[string]int
 		args := buildElSemanticArgs(ep)
 		script := fmt.Sprintf(`
 			(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex) => {
-				const root = scope ? document.querySelector(scope) : document;
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return null;
 		`+semanticMatchesHelper()+`
 				const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -614,13 +625,14 @@ func buildElStateScript(ep ElementParams, expr string) (string, []map[string]int
 	args := buildElBaseArgs(ep)
 	script := fmt.Sprintf(`
 		(scope, selector, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+	`+ShadowDOMJS()+`
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return null;
 			let el;
 			if (hasIndex) {
-				el = root.querySelectorAll(selector)[index];
+				el = querySelectorAllDeep(root, selector)[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return null;
 			return %s;
@@ -636,7 +648,7 @@ func buildElBoolScript(ep ElementParams, body string) (string, []map[string]inte
 		args := buildElSemanticArgs(ep)
 		script := fmt.Sprintf(`
 			(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex) => {
-				const root = scope ? document.querySelector(scope) : document;
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return 'error:root not found';
 		`+semanticMatchesHelper()+`
 				const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -657,13 +669,14 @@ func buildElBoolScript(ep ElementParams, body string) (string, []map[string]inte
 	args := buildElBaseArgs(ep)
 	script := fmt.Sprintf(`
 		(scope, selector, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+	`+ShadowDOMJS()+`
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return 'error:root not found';
 			let el;
 			if (hasIndex) {
-				el = root.querySelectorAll(selector)[index];
+				el = querySelectorAllDeep(root, selector)[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return 'error:element not found';
 			const _check = (el) => { %s };
@@ -680,7 +693,7 @@ func buildElJSONScript(ep ElementParams, body string) (string, []map[string]inte
 		args := buildElSemanticArgs(ep)
 		script := fmt.Sprintf(`
 			(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex) => {
-				const root = scope ? document.querySelector(scope) : document;
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return JSON.stringify({error: 'root not found'});
 		`+semanticMatchesHelper()+`
 				const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -700,13 +713,14 @@ func buildElJSONScript(ep ElementParams, body string) (string, []map[string]inte
 	args := buildElBaseArgs(ep)
 	script := fmt.Sprintf(`
 		(scope, selector, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+	`+ShadowDOMJS()+`
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return JSON.stringify({error: 'root not found'});
 			let el;
 			if (hasIndex) {
-				el = root.querySelectorAll(selector)[index];
+				el = querySelectorAllDeep(root, selector)[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			if (!el) return JSON.stringify({error: 'element not found'});
 			%s
@@ -811,7 +825,7 @@ func GetAttribute(s Session, context string, ep ElementParams, name string) (str
 		args = append(args, map[string]interface{}{"type": "string", "value": name})
 		script = `
 			(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex, name) => {
-				const root = scope ? document.querySelector(scope) : document;
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return null;
 		` + semanticMatchesHelper() + `
 				const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -831,13 +845,14 @@ func GetAttribute(s Session, context string, ep ElementParams, name string) (str
 		args = append(args, map[string]interface{}{"type": "string", "value": name})
 		script = `
 			(scope, selector, index, hasIndex, name) => {
-				const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return null;
 				let el;
 				if (hasIndex) {
-					el = root.querySelectorAll(selector)[index];
+					el = querySelectorAllDeep(root, selector)[index];
 				} else {
-					el = root.querySelector(selector);
+					el = querySelectorDeep(root, selector);
 				}
 				if (!el) return null;
 				const v = el.getAttribute(name);
@@ -870,7 +885,9 @@ func IsEnabled(s Session, context string, ep ElementParams) (bool, error) {
 
 // GetCount counts elements matching a CSS selector.
 func GetCount(s Session, context, selector string) (int, error) {
-	expr := fmt.Sprintf(`() => document.querySelectorAll(%q).length`, selector)
+	expr := fmt.Sprintf(`() => {`+ShadowDOMJS()+`
+		return querySelectorAllDeep(document, %q).length;
+	}`, selector)
 	val, err := EvalSimpleScript(s, context, expr)
 	if err != nil {
 		return 0, err
@@ -897,7 +914,9 @@ func WaitForText(s Session, context, text string, timeout time.Duration) error {
 			return fmt.Errorf("timeout waiting for text %q to appear", text)
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, fmt.Sprintf("text %q", text)); err != nil {
+			return err
+		}
 	}
 }
 
@@ -916,7 +935,9 @@ func WaitForFunction(s Session, context, expression string, timeout time.Duratio
 			return "", fmt.Errorf("timeout waiting for expression to return truthy: %s", expression)
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, "expression to return truthy"); err != nil {
+			return "", err
+		}
 	}
 }
 
@@ -968,7 +989,9 @@ func WaitForVisible(s Session, context string, ep ElementParams) error {
 		if time.Now().After(deadline) {
 			return fmt.Errorf("timeout after %s: element not visible", ep.Timeout)
 		}
-		time.Sleep(interval)
+		if err := pause(s, interval, "element to be visible"); err != nil {
+			return err
+		}
 	}
 }
 
@@ -991,7 +1014,9 @@ func WaitForHidden(s Session, context string, ep ElementParams) error {
 		if time.Now().After(deadline) {
 			return fmt.Errorf("timeout after %s: element still visible", ep.Timeout)
 		}
-		time.Sleep(interval)
+		if err := pause(s, interval, "element to be hidden"); err != nil {
+			return err
+		}
 	}
 }
 
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	errs "github.com/vibium/clicker/internal/errors"
 )
 
 // resolveContext extracts the "context" param or returns the first context from getTree.
@@ -42,7 +44,7 @@ func checkBidiError(resp json.RawMessage) error {
 		return nil // Can't parse, assume not an error
 	}
 	if errResp.Type == "error" {
-		return fmt.Errorf("%s: %s", errResp.Error, errResp.Message)
+		return &errs.ProtocolError{Code: errResp.Error, Message: errResp.Message}
 	}
 	return nil
 }
@@ -81,7 +83,7 @@ func buildRefFindScript(ep ElementParams) (string, []map[string]interface{}) {
 		args := buildElSemanticArgs(ep)
 		script := `
 			(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex) => {
-				const root = scope ? document.querySelector(scope) : document;
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return null;
 		` + semanticMatchesHelper() + `
 				const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -106,14 +108,15 @@ func buildRefFindScript(ep ElementParams) (string, []map[string]interface{}) {
 
 	script := `
 		(scope, selector, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return null;
 			let el;
 			if (hasIndex) {
-				const all = root.querySelectorAll(selector);
+				const all = querySelectorAllDeep(root, selector);
 				el = all[index];
 			} else {
-				el = root.querySelector(selector);
+				el = querySelectorDeep(root, selector);
 			}
 			return el || null;
 		}
@@ -138,6 +141,7 @@ type ElementParams struct {
 	Context     string
 	Timeout     time.Duration
 	Force       bool
+	Strict      bool // fail with StrictModeViolationError if more than one element matches
 }
 
 // ExtractElementParams extracts element parameters from command params.
@@ -171,6 +175,10 @@ func ExtractElementParams(params map[string]interface{}) ElementParams {
 		ep.Force = force
 	}
 
+	if strict, ok := params["strict"].(bool); ok {
+		ep.Strict = strict
+	}
+
 	return ep
 }
 
@@ -193,14 +201,15 @@ func buildActionFindScript(ep ElementParams) (string, []map[string]interface{})
 		}
 		script := `
 			(scope, selector, index, hasIndex) => {
-				const root = scope ? document.querySelector(scope) : document;
+	` + ShadowDOMJS() + `
+				const root = scope ? querySelectorDeep(document, scope) : document;
 				if (!root) return null;
 				let el;
 				if (hasIndex) {
-					const all = root.querySelectorAll(selector);
+					const all = querySelectorAllDeep(root, selector);
 					el = all[index];
 				} else {
-					el = root.querySelector(selector);
+					el = querySelectorDeep(root, selector);
 				}
 				if (!el) return null;
 				if (el.scrollIntoViewIfNeeded) {
@@ -237,7 +246,7 @@ func buildActionFindScript(ep ElementParams) (string, []map[string]interface{})
 
 	script := `
 		(scope, selector, role, text, label, placeholder, alt, title, testid, xpath, index, hasIndex) => {
-			const root = scope ? document.querySelector(scope) : document;
+			const root = scope ? querySelectorDeep(document, scope) : document;
 			if (!root) return null;
 	` + semanticMatchesHelper() + `
 			const found = collectMatches(root, selector, role, text, label, placeholder, alt, title, testid, xpath);
@@ -339,10 +348,12 @@ func ResolveElementRef(s Session, context string, ep ElementParams) (string, err
 		}
 
 		if time.Now().After(deadline) {
-			return "", fmt.Errorf("timeout waiting for element: not found")
+			return "", &errs.ElementNotFoundError{Selector: describeSelector(args), Context: context, Timeout: ep.Timeout}
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, describeSelector(args)); err != nil {
+			return "", err
+		}
 	}
 }
 
@@ -375,9 +386,11 @@ func WaitForElementWithScript(s Session, context, script string, args []map[stri
 		}
 
 		if time.Now().After(deadline) {
-			return nil, fmt.Errorf("timeout after %s waiting for '%s': element not found", timeout, desc)
+			return nil, &errs.ElementNotFoundError{Selector: desc, Context: context, Timeout: timeout}
 		}
 
-		time.Sleep(interval)
+		if err := pause(s, interval, desc); err != nil {
+			return nil, err
+		}
 	}
 }
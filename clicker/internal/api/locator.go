@@ -0,0 +1,351 @@
+package api
+
+// Locators: wherever a CSS selector is taken, a locator can be given
+// instead, so "the Delete button in the row for Invoice 42" needs no custom
+// JS:
+//
+//	tr:has-text("Invoice 42") >> role=button[name="Delete"]
+//
+// A locator is a chain of steps joined by ">>"; each step searches inside
+// the elements the previous one matched. (">>>" is not a chain: it steps
+// into a shadow root, see shadow.go.) A step is one of:
+//
+//	css=<selector> or a plain CSS selector, which may also use
+//	    :has-text("text")   the element's text contains text (any case)
+//	    :has(<locator>)     the element contains a match of the locator
+//	    :visible            the element is visible
+//	role=<role>[name="name"]  ARIA role, explicit or implicit; the
+//	    accessible name contains name (any case), or with s after the
+//	    quotes ([name="Save"s]) equals it
+//	text=<text>         the innermost elements whose text contains text
+//	    (any case); text="Save" matches the whole text exactly
+//	testid=<id>         data-testid
+//	label=, placeholder=, alt=, title=  like text=, on the label, the
+//	    placeholder, alt or title attribute
+//	xpath=<expression>
+//	nth=<n>             only the nth match so far (0-based; -1 is the last)
+//	visible=true|false  only the (in)visible matches so far
+//
+// Quotes keep ">>" inside a value from splitting the chain:
+// text=">> next".
+
+// locatorJS returns the JS behind locators: querySelectorAllDeep(root,
+// locator), and the helpers it uses, all named loc*. It is part of
+// ShadowDOMJS, whose cssQueryAllDeep handles the CSS steps.
+func locatorJS() string {
+	return `
+			function locNorm(s) {
+				return String(s || '').replace(/\s+/g, ' ').trim();
+			}
+
+			// locUnquote reads a step value: "quoted" means exact.
+			function locUnquote(value) {
+				const m = /^(["'])([\s\S]*)\1$/.exec(value.trim());
+				return m ? { text: m[2], exact: true } : { text: value.trim(), exact: false };
+			}
+
+			function locTextMatches(actual, want) {
+				if (want.exact) return locNorm(actual) === locNorm(want.text);
+				return locNorm(actual).toLowerCase().includes(locNorm(want.text).toLowerCase());
+			}
+
+			function locText(el) {
+				return ['SCRIPT', 'STYLE', 'NOSCRIPT', 'TEMPLATE'].includes(el.tagName) ? '' : el.textContent;
+			}
+
+			// locSplit splits a locator into its ">>" steps, leaving ">>>",
+			// and ">>" inside quotes, brackets or parentheses, alone.
+			function locSplit(locator) {
+				const s = String(locator);
+				const steps = [];
+				let depth = 0, quote = '', start = 0;
+				for (let i = 0; i < s.length; i++) {
+					const c = s[i];
+					if (quote) {
+						if (c === '\\') i++;
+						else if (c === quote) quote = '';
+						continue;
+					}
+					// An apostrophe inside a word (text=Don't) doesn't quote
+					if ((c === '"' || c === "'") && (i === 0 || /[=(\[,\s]/.test(s[i - 1]))) quote = c;
+					else if (c === '(' || c === '[') depth++;
+					else if (c === ')' || c === ']') depth--;
+					else if (c === '>' && depth === 0) {
+						let n = 1;
+						while (s[i + n] === '>') n++;
+						if (n === 2) {
+							steps.push(s.slice(start, i).trim());
+							start = i + 2;
+						}
+						i += n - 1;
+					}
+				}
+				steps.push(s.slice(start).trim());
+				return steps;
+			}
+
+			function locEngine(step) {
+				const m = /^(css|role|text|testid|label|placeholder|alt|title|xpath|nth|visible)\s*=\s*([\s\S]*)$/.exec(step);
+				return m ? { engine: m[1], value: m[2] } : { engine: 'css', value: step };
+			}
+
+			// locReadParens returns the text inside the parentheses opening
+			// at s[open], and the index after the closing one.
+			function locReadParens(s, open) {
+				let depth = 0, quote = '';
+				for (let i = open; i < s.length; i++) {
+					const c = s[i];
+					if (quote) {
+						if (c === '\\') i++;
+						else if (c === quote) quote = '';
+					} else if (c === '"' || c === "'") quote = c;
+					else if (c === '(') depth++;
+					else if (c === ')' && --depth === 0) return [s.slice(open + 1, i), i + 1];
+				}
+				throw new Error('unclosed parenthesis in selector: ' + s);
+			}
+
+			function locIsPlainCSS(s) {
+				const parts = locCSSParts(s);
+				return locSplit(s).length === 1 && locEngine(s.trim()).engine === 'css' &&
+					parts.length === 1 && parts[0].filters.length === 0;
+			}
+
+			// locCSSParts splits a CSS step at the custom pseudo-classes
+			// (:has-text, :visible, and :has with a locator inside) into
+			// [{css, filters, combinator}], combinator joining a part to the
+			// one before: ' ', '>' or '>>>'.
+			function locCSSParts(s) {
+				const parts = [];
+				let part = { css: '', filters: [], combinator: ' ' };
+				let quote = '', depth = 0;
+				for (let i = 0; i < s.length; ) {
+					const c = s[i];
+					if (quote) {
+						if (c === '\\') { part.css += s.slice(i, i + 2); i += 2; continue; }
+						if (c === quote) quote = '';
+						part.css += c;
+						i++;
+						continue;
+					}
+					if (depth === 0 && s.startsWith(':has-text(', i)) {
+						const [arg, end] = locReadParens(s, i + 9);
+						part.filters.push({ type: 'text', want: { text: locUnquote(arg).text, exact: false } });
+						i = end;
+						continue;
+					}
+					if (depth === 0 && s.startsWith(':has(', i)) {
+						const [arg, end] = locReadParens(s, i + 4);
+						if (locIsPlainCSS(arg)) part.css += s.slice(i, end);
+						else part.filters.push({ type: 'has', locator: arg });
+						i = end;
+						continue;
+					}
+					if (depth === 0 && s.startsWith(':visible', i) && !/[\w-]/.test(s[i + 8] || '')) {
+						part.filters.push({ type: 'visible', want: true });
+						i += 8;
+						continue;
+					}
+					// After a custom pseudo-class, a combinator starts a new part
+					if (depth === 0 && part.filters.length > 0 && (c === ' ' || c === '>' || c === '+' || c === '~')) {
+						let j = i;
+						while (s[j] === ' ') j++;
+						let combinator = ' ';
+						if (s.startsWith('>>>', j)) { combinator = '>>>'; j += 3; }
+						else if (s[j] === '>') { combinator = '>'; j++; }
+						else if (s[j] === '+' || s[j] === '~') throw new Error('sibling combinators after :has-text, :has or :visible are not supported: ' + s);
+						while (s[j] === ' ') j++;
+						if (j < s.length) {
+							parts.push(part);
+							part = { css: '', filters: [], combinator };
+						}
+						i = j;
+						continue;
+					}
+					if (c === '"' || c === "'") quote = c;
+					else if (c === '(' || c === '[') depth++;
+					else if (c === ')' || c === ']') depth--;
+					part.css += c;
+					i++;
+				}
+				parts.push(part);
+				return parts;
+			}
+
+			function locVisible(el) {
+				const style = window.getComputedStyle(el);
+				if (style.display === 'none' || style.visibility === 'hidden') return false;
+				const rect = el.getBoundingClientRect();
+				return rect.width > 0 && rect.height > 0;
+			}
+
+			function locRole(el) {
+				const explicit = el.getAttribute('role');
+				if (explicit) return explicit.trim().split(/\s+/)[0].toLowerCase();
+				const tag = el.tagName.toLowerCase();
+				const type = (el.getAttribute('type') || 'text').toLowerCase();
+				if (tag === 'input') {
+					return { button: 'button', checkbox: 'checkbox', image: 'button', number: 'spinbutton', radio: 'radio',
+						range: 'slider', reset: 'button', search: 'searchbox', submit: 'button', hidden: '' }[type] ?? 'textbox';
+				}
+				if (tag === 'a' || tag === 'area') return el.hasAttribute('href') ? 'link' : '';
+				if (tag === 'img') return el.getAttribute('alt') === '' ? 'presentation' : 'img';
+				if (tag === 'select') return el.hasAttribute('multiple') || el.size > 1 ? 'listbox' : 'combobox';
+				if (/^h[1-6]$/.test(tag)) return 'heading';
+				return { article: 'article', aside: 'complementary', button: 'button', details: 'group', dialog: 'dialog',
+					footer: 'contentinfo', form: 'form', header: 'banner', hr: 'separator', li: 'listitem', main: 'main',
+					menu: 'list', nav: 'navigation', ol: 'list', option: 'option', output: 'status', progress: 'progressbar',
+					section: 'region', summary: 'button', table: 'table', tbody: 'rowgroup', thead: 'rowgroup', tfoot: 'rowgroup',
+					td: 'cell', textarea: 'textbox', th: 'columnheader', tr: 'row', ul: 'list' }[tag] || '';
+			}
+
+			function locLabel(el) {
+				let text = el.getAttribute('aria-label') || '';
+				const labelledBy = el.getAttribute('aria-labelledby');
+				if (!text && labelledBy) {
+					text = labelledBy.split(/\s+/).map(id => el.getRootNode().getElementById(id)).filter(Boolean).map(l => l.textContent).join(' ');
+				}
+				if (!text && el.labels && el.labels.length) text = Array.from(el.labels).map(l => l.textContent).join(' ');
+				return text;
+			}
+
+			function locName(el) {
+				const label = locLabel(el);
+				if (locNorm(label)) return label;
+				const tag = el.tagName;
+				const type = (el.getAttribute('type') || '').toLowerCase();
+				if (tag === 'INPUT' && ['button', 'submit', 'reset'].includes(type)) return el.value;
+				if (['INPUT', 'TEXTAREA', 'SELECT'].includes(tag)) return el.getAttribute('placeholder') || el.getAttribute('title') || '';
+				if (tag === 'IMG') return el.getAttribute('alt') || el.getAttribute('title') || '';
+				return locText(el) || el.getAttribute('title') || '';
+			}
+
+			// locMatcher returns a test for the elements a role=, text=, ...
+			// step matches.
+			function locMatcher(engine, value) {
+				switch (engine) {
+				case 'role': {
+					const m = /^([\w-]+)\s*(?:\[\s*name\s*=\s*(["'])([\s\S]*)\2\s*([is]?)\s*\])?$/.exec(value.trim());
+					if (!m) throw new Error('invalid role locator: role=' + value);
+					const role = m[1].toLowerCase();
+					const name = m[2] ? { text: m[3], exact: m[4] === 's' } : null;
+					return el => locRole(el) === role && (!name || locTextMatches(locName(el), name));
+				}
+				case 'text': {
+					const want = locUnquote(value);
+					// Only the innermost elements with the text, not their ancestors
+					return el => locTextMatches(locText(el), want) &&
+						!Array.from(el.children).some(child => locTextMatches(locText(child), want));
+				}
+				case 'testid': {
+					const want = locUnquote(value).text;
+					return el => el.getAttribute('data-testid') === want;
+				}
+				case 'label': {
+					const want = locUnquote(value);
+					return el => locTextMatches(locLabel(el), want);
+				}
+				default: { // placeholder, alt, title
+					const want = locUnquote(value);
+					return el => el.hasAttribute(engine) && locTextMatches(el.getAttribute(engine), want);
+				}
+				}
+			}
+
+			// locDocumentOrder sorts elements in tree order, shadow roots
+			// included.
+			function locDocumentOrder(els) {
+				const order = new Map();
+				let i = 0;
+				walkDeep(document, (el) => { order.set(el, i++); });
+				return els.sort((a, b) => (order.get(a) ?? i) - (order.get(b) ?? i));
+			}
+
+			// locSearch returns the elements under base that a search step
+			// matches.
+			function locSearch(base, engine, value) {
+				if (engine === 'css') {
+					let found = [base];
+					locCSSParts(value).forEach((part, k) => {
+						const css = part.css.trim() || '*';
+						const next = new Set();
+						for (const b of found) {
+							let matched;
+							if (k > 0 && part.combinator === '>>>') {
+								matched = b.shadowRoot ? cssQueryAllDeep(b.shadowRoot, css) : [];
+							} else if (k > 0 && part.combinator === '>') {
+								matched = Array.from(b.querySelectorAll(':scope > ' + css));
+							} else {
+								matched = cssQueryAllDeep(b, css);
+							}
+							for (const el of matched) {
+								if (part.filters.every(f => locFilter(el, f))) next.add(el);
+							}
+						}
+						found = Array.from(next);
+					});
+					return found;
+				}
+				if (engine === 'xpath') {
+					const found = [];
+					const doc = base.ownerDocument || document;
+					const result = doc.evaluate(value, base, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+					for (let i = 0; i < result.snapshotLength; i++) {
+						const node = result.snapshotItem(i);
+						if (node && node.nodeType === 1) found.push(node);
+					}
+					return found;
+				}
+				const test = locMatcher(engine, value);
+				const found = [];
+				walkDeep(base, (el) => { if (test(el)) found.push(el); });
+				return found;
+			}
+
+			function locFilter(el, filter) {
+				switch (filter.type) {
+				case 'text': return locTextMatches(locText(el), filter.want);
+				case 'has': return querySelectorAllDeep(el, filter.locator).length > 0;
+				case 'visible': return locVisible(el) === filter.want;
+				}
+				return true;
+			}
+
+			function querySelectorAllDeep(root, locator) {
+				// An element passed by reference (AgentSession.Nodes) stands
+				// for itself, if it is under root
+				if (locator && locator.nodeType === 1) {
+					for (let n = locator; n; n = n.parentNode || n.host) {
+						if (n === root) return [locator];
+					}
+					return [];
+				}
+				const steps = locSplit(locator);
+				if (steps.length === 1 && locIsPlainCSS(steps[0])) return cssQueryAllDeep(root, steps[0]);
+
+				let found = [root];
+				steps.forEach((step) => {
+					if (!step) throw new Error('empty step in locator: ' + locator);
+					const { engine, value } = locEngine(step);
+					if (engine === 'nth') {
+						const n = parseInt(value, 10);
+						if (isNaN(n)) throw new Error('invalid nth in locator: ' + step);
+						const el = found[n < 0 ? found.length + n : n];
+						found = el ? [el] : [];
+						return;
+					}
+					if (engine === 'visible') {
+						const want = value.trim() !== 'false';
+						found = found.filter(el => el !== root && locVisible(el) === want);
+						return;
+					}
+					const next = new Set();
+					for (const base of found) {
+						for (const el of locSearch(base, engine, value)) next.add(el);
+					}
+					found = found.length > 1 ? locDocumentOrder(Array.from(next)) : Array.from(next);
+				});
+				return found.filter(el => el !== root);
+			}
+	`
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the histogram bucket upper bounds, in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// healthCheckTimeout bounds the per-session liveness probe of /healthz.
+const healthCheckTimeout = 5 * time.Second
+
+// histogram is a cumulative Prometheus-style histogram over latencyBuckets.
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Metrics collects the counters served on the proxy's /metrics endpoint.
+// All methods are safe to call on a nil *Metrics.
+type Metrics struct {
+	mu             sync.Mutex
+	launches       uint64
+	launchFailures uint64
+	commands       map[string]*histogram // vibium: method -> handler latency
+	commandErrors  map[string]uint64     // error code -> count
+	bidi           map[string]*histogram // BiDi method -> internal round-trip latency
+	bidiTimeouts   uint64
+}
+
+// NewMetrics creates an empty metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		commands:      make(map[string]*histogram),
+		commandErrors: make(map[string]uint64),
+		bidi:          make(map[string]*histogram),
+	}
+}
+
+// browserLaunched counts a browser launch attempt.
+func (m *Metrics) browserLaunched(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.launchFailures++
+		return
+	}
+	m.launches++
+}
+
+// observeCommand records a vibium: command's handler latency.
+func (m *Metrics) observeCommand(method string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.commands[method]
+	if h == nil {
+		h = &histogram{}
+		m.commands[method] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// commandFailed counts an error response by error code.
+func (m *Metrics) commandFailed(code string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.commandErrors[code]++
+	m.mu.Unlock()
+}
+
+// observeBidi records an internal BiDi round-trip.
+func (m *Metrics) observeBidi(method string, d time.Duration, timedOut bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if timedOut {
+		m.bidiTimeouts++
+		return
+	}
+	h := m.bidi[method]
+	if h == nil {
+		h = &histogram{}
+		m.bidi[method] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// gauges are point-in-time values sampled from the router at scrape time.
+type gauges struct {
+	activeSessions   int
+	detachedSessions int
+	recordingBytes   int
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) writeTo(w io.Writer, g gauges) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP vibium_sessions_active Browser sessions attached to a client.")
+	fmt.Fprintln(w, "# TYPE vibium_sessions_active gauge")
+	fmt.Fprintf(w, "vibium_sessions_active %d\n", g.activeSessions)
+	fmt.Fprintln(w, "# HELP vibium_sessions_detached Browser sessions waiting for their client to resume.")
+	fmt.Fprintln(w, "# TYPE vibium_sessions_detached gauge")
+	fmt.Fprintf(w, "vibium_sessions_detached %d\n", g.detachedSessions)
+
+	fmt.Fprintln(w, "# HELP vibium_browser_launches_total Browsers launched successfully.")
+	fmt.Fprintln(w, "# TYPE vibium_browser_launches_total counter")
+	fmt.Fprintf(w, "vibium_browser_launches_total %d\n", m.launches)
+	fmt.Fprintln(w, "# HELP vibium_browser_launch_failures_total Browser launches that failed.")
+	fmt.Fprintln(w, "# TYPE vibium_browser_launch_failures_total counter")
+	fmt.Fprintf(w, "vibium_browser_launch_failures_total %d\n", m.launchFailures)
+
+	fmt.Fprintln(w, "# HELP vibium_command_duration_seconds Latency of vibium: extension commands.")
+	fmt.Fprintln(w, "# TYPE vibium_command_duration_seconds histogram")
+	writeHistograms(w, "vibium_command_duration_seconds", m.commands)
+
+	fmt.Fprintln(w, "# HELP vibium_command_errors_total Error responses sent to clients, by error code.")
+	fmt.Fprintln(w, "# TYPE vibium_command_errors_total counter")
+	for _, code := range sortedKeys(m.commandErrors) {
+		fmt.Fprintf(w, "vibium_command_errors_total{code=%q} %d\n", code, m.commandErrors[code])
+	}
+
+	fmt.Fprintln(w, "# HELP vibium_bidi_roundtrip_seconds Latency of internal BiDi commands sent by the proxy.")
+	fmt.Fprintln(w, "# TYPE vibium_bidi_roundtrip_seconds histogram")
+	writeHistograms(w, "vibium_bidi_roundtrip_seconds", m.bidi)
+
+	fmt.Fprintln(w, "# HELP vibium_bidi_timeouts_total Internal BiDi commands that got no response in time.")
+	fmt.Fprintln(w, "# TYPE vibium_bidi_timeouts_total counter")
+	fmt.Fprintf(w, "vibium_bidi_timeouts_total %d\n", m.bidiTimeouts)
+
+	fmt.Fprintln(w, "# HELP vibium_recording_bytes Screenshot and snapshot bytes held in memory by active recordings.")
+	fmt.Fprintln(w, "# TYPE vibium_recording_bytes gauge")
+	fmt.Fprintf(w, "vibium_recording_bytes %d\n", g.recordingBytes)
+}
+
+// writeHistograms writes one labelled histogram series per method.
+func writeHistograms(w io.Writer, name string, hs map[string]*histogram) {
+	for _, method := range sortedKeys(hs) {
+		h := hs[method]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{method=%q,le=\"%g\"} %d\n", name, method, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{method=%q,le=\"+Inf\"} %d\n", name, method, h.count)
+		fmt.Fprintf(w, "%s_sum{method=%q} %g\n", name, method, h.sum)
+		fmt.Fprintf(w, "%s_count{method=%q} %d\n", name, method, h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MetricsHandler serves the router's metrics in Prometheus text format.
+func (r *Router) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var g gauges
+		r.forEachSession(func(session *BrowserSession) {
+			session.mu.Lock()
+			if session.detached {
+				g.detachedSessions++
+			} else {
+				g.activeSessions++
+			}
+			recorder := session.recorder
+			session.mu.Unlock()
+			if recorder != nil {
+				g.recordingBytes += recorder.ResourceBytes()
+			}
+		})
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.metrics.writeTo(w, g)
+	})
+}
+
+// sessionHealth is one session's entry in the /healthz response.
+type sessionHealth struct {
+	Client  uint64 `json:"client"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthHandler serves /healthz: it probes every session's browser with a
+// cheap BiDi command and answers 503 if any of them doesn't respond.
+func (r *Router) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var sessions []*BrowserSession
+		r.forEachSession(func(session *BrowserSession) {
+			sessions = append(sessions, session)
+		})
+
+		// Probe in parallel so one hung browser doesn't multiply the latency
+		results := make([]sessionHealth, len(sessions))
+		var wg sync.WaitGroup
+		for i, session := range sessions {
+			wg.Add(1)
+			go func(i int, session *BrowserSession) {
+				defer wg.Done()
+				results[i] = sessionHealth{Client: session.currentClient().ID(), Healthy: true}
+				// roundTrip, not sendInternalCommand: probes must not show up in recordings
+				_, err := r.roundTrip(session, "browsingContext.getTree", map[string]interface{}{"maxDepth": 0}, healthCheckTimeout, nil)
+				if err != nil {
+					results[i].Healthy = false
+					results[i].Error = err.Error()
+				}
+			}(i, session)
+		}
+		wg.Wait()
+
+		status := "ok"
+		for _, res := range results {
+			if !res.Healthy {
+				status = "unhealthy"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   status,
+			"sessions": results,
+		})
+	})
+}
+
+// forEachSession calls fn for every open session, attached or detached.
+func (r *Router) forEachSession(fn func(*BrowserSession)) {
+	seen := make(map[*BrowserSession]bool)
+	visit := func(_, value interface{}) bool {
+		session := value.(*BrowserSession)
+		session.mu.Lock()
+		closed := session.closed
+		session.mu.Unlock()
+		if !closed && !seen[session] {
+			seen[session] = true
+			fn(session)
+		}
+		return true
+	}
+	r.sessions.Range(visit)
+	r.resumable.Range(visit)
+}
@@ -0,0 +1,513 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vibium/clicker/internal/bidi"
+	"github.com/vibium/clicker/internal/browser"
+	errs "github.com/vibium/clicker/internal/errors"
+)
+
+// pooledMsgQueueSize is the buffer size for messages read from a pooled browser.
+// The reader goroutine blocks when the queue is full, which is fine: the
+// attached session drains it continuously, and idle browsers receive almost
+// no traffic once their subscriptions have been removed.
+const pooledMsgQueueSize = 1024
+
+// poolResetTimeout bounds each BiDi command used to reset a browser between clients.
+const poolResetTimeout = 10 * time.Second
+
+// PoolOptions configures a BrowserPool.
+type PoolOptions struct {
+	Headless    bool
+	MinIdle     int           // browsers kept launched and waiting for a client
+	MaxTotal    int           // upper bound on idle + busy + launching browsers
+	WaitTimeout time.Duration // how long Acquire queues when the pool is exhausted
+}
+
+// PoolStats is a point-in-time snapshot of pool health.
+type PoolStats struct {
+	Idle      int `json:"idle"`
+	Busy      int `json:"busy"`
+	Launching int `json:"launching"`
+	Queued    int `json:"queued"`
+	MinIdle   int `json:"minIdle"`
+	MaxTotal  int `json:"maxTotal"`
+}
+
+// PooledBrowser is a launched browser owned by a BrowserPool. A single reader
+// goroutine owns the BiDi connection for the browser's whole lifetime so that
+// sessions can attach and detach without racing on Receive.
+type PooledBrowser struct {
+	LaunchResult *browser.LaunchResult
+	BidiConn     *bidi.Connection
+
+	msgs      chan string
+	dead      chan struct{} // closed when the BiDi connection fails
+	err       error         // set before dead is closed
+	stop      chan struct{} // closed by close() to release a blocked reader
+	closeOnce sync.Once
+	nextID    int // next internal command ID; carried across sessions
+
+	// Per-client state that must be undone before the browser is reused.
+	subscriptions  []string
+	preloadScripts []string
+	intercepts     []string
+	origins        []string // http(s) origins whose storage the client may have written
+}
+
+// readLoop forwards every message from the browser to b.msgs until the
+// connection fails.
+func (b *PooledBrowser) readLoop() {
+	for {
+		msg, err := b.BidiConn.Receive()
+		if err != nil {
+			b.err = err
+			close(b.dead)
+			return
+		}
+		select {
+		case b.msgs <- msg:
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// isDead reports whether the browser connection has failed.
+func (b *PooledBrowser) isDead() bool {
+	select {
+	case <-b.dead:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain discards any queued messages left over from a previous client.
+func (b *PooledBrowser) drain() {
+	for {
+		select {
+		case <-b.msgs:
+		default:
+			return
+		}
+	}
+}
+
+// command sends a BiDi command while no session is attached and waits for
+// its response. Unrelated messages (events, late responses) are discarded.
+func (b *PooledBrowser) command(method string, params map[string]interface{}) (json.RawMessage, error) {
+	id := b.nextID
+	b.nextID++
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": params,
+	})
+	if err := b.BidiConn.Send(string(data)); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(poolResetTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-b.msgs:
+			var resp struct {
+				ID int `json:"id"`
+			}
+			if json.Unmarshal([]byte(msg), &resp) == nil && resp.ID == id {
+				raw := json.RawMessage(msg)
+				if err := checkBidiError(raw); err != nil {
+					return nil, err
+				}
+				return raw, nil
+			}
+		case <-b.dead:
+			return nil, fmt.Errorf("browser connection closed: %v", b.err)
+		case <-timer.C:
+			return nil, fmt.Errorf("timeout waiting for response to %s", method)
+		}
+	}
+}
+
+// reset returns the browser to a clean state: removes the previous client's
+// subscriptions, preload scripts and network intercepts, closes all user
+// contexts it created, replaces every tab with a single fresh about:blank
+// tab, and clears the default user context's cookies and the storage of
+// every origin the client loaded. Any failure leaves the browser unfit for
+// reuse.
+func (b *PooledBrowser) reset() error {
+	b.drain()
+
+	if len(b.subscriptions) > 0 {
+		if _, err := b.command("session.unsubscribe", map[string]interface{}{
+			"subscriptions": b.subscriptions,
+		}); err != nil {
+			return fmt.Errorf("unsubscribe: %w", err)
+		}
+	}
+	b.subscriptions = nil
+
+	for _, script := range b.preloadScripts {
+		// The script may already be gone (e.g. removed by the client); ignore errors.
+		b.command("script.removePreloadScript", map[string]interface{}{"script": script})
+	}
+	b.preloadScripts = nil
+
+	// A leftover intercept would block the next client's requests
+	for _, intercept := range b.intercepts {
+		if _, err := b.command("network.removeIntercept", map[string]interface{}{
+			"intercept": intercept,
+		}); err != nil && !isProtocolError(err, "no such intercept") {
+			return fmt.Errorf("remove intercept: %w", err)
+		}
+	}
+	b.intercepts = nil
+
+	resp, err := b.command("browser.getUserContexts", map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("get user contexts: %w", err)
+	}
+	var ucResult struct {
+		Result struct {
+			UserContexts []struct {
+				UserContext string `json:"userContext"`
+			} `json:"userContexts"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &ucResult); err != nil {
+		return fmt.Errorf("failed to parse getUserContexts response: %w", err)
+	}
+	for _, uc := range ucResult.Result.UserContexts {
+		if uc.UserContext == "default" {
+			continue
+		}
+		if _, err := b.command("browser.removeUserContext", map[string]interface{}{
+			"userContext": uc.UserContext,
+		}); err != nil {
+			return fmt.Errorf("remove user context: %w", err)
+		}
+	}
+
+	resp, err = b.command("browsingContext.getTree", map[string]interface{}{"maxDepth": 0})
+	if err != nil {
+		return fmt.Errorf("get tree: %w", err)
+	}
+	var tree struct {
+		Result struct {
+			Contexts []struct {
+				Context string `json:"context"`
+			} `json:"contexts"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &tree); err != nil {
+		return fmt.Errorf("failed to parse getTree response: %w", err)
+	}
+
+	// Open the fresh tab first — closing the last tab would end the browser.
+	if _, err := b.command("browsingContext.create", map[string]interface{}{"type": "tab"}); err != nil {
+		return fmt.Errorf("create tab: %w", err)
+	}
+	for _, ctx := range tree.Result.Contexts {
+		if _, err := b.command("browsingContext.close", map[string]interface{}{"context": ctx.Context}); err != nil {
+			return fmt.Errorf("close tab: %w", err)
+		}
+	}
+
+	// Clear what the client left in the default user context, now that none
+	// of its pages is open to write it again
+	if _, err := b.command("storage.deleteCookies", map[string]interface{}{
+		"partition": map[string]interface{}{
+			"type":        "storageKey",
+			"userContext": "default",
+		},
+	}); err != nil {
+		return fmt.Errorf("delete cookies: %w", err)
+	}
+	for _, origin := range b.origins {
+		// BiDi has no command for localStorage, IndexedDB and the like;
+		// chromedriver passes this one through to CDP
+		if _, err := b.command("goog:cdp.sendCommand", map[string]interface{}{
+			"method": "Storage.clearDataForOrigin",
+			"params": map[string]interface{}{
+				"origin":       origin,
+				"storageTypes": "all",
+			},
+		}); err != nil {
+			return fmt.Errorf("clear storage of %s: %w", origin, err)
+		}
+	}
+	b.origins = nil
+
+	b.drain()
+	return nil
+}
+
+// isProtocolError reports whether err is a BiDi error with the given code.
+func isProtocolError(err error, code string) bool {
+	var protocol *errs.ProtocolError
+	return errors.As(err, &protocol) && protocol.Code == code
+}
+
+// close terminates the browser.
+func (b *PooledBrowser) close() {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		b.BidiConn.Close()
+		if b.LaunchResult != nil {
+			b.LaunchResult.Close()
+		}
+	})
+}
+
+// BrowserPool keeps pre-launched browsers ready for incoming clients and
+// recycles them when clients disconnect.
+type BrowserPool struct {
+	opts PoolOptions
+
+	mu        sync.Mutex
+	idle      []*PooledBrowser
+	busy      int
+	launching int
+	waiters   []chan *PooledBrowser
+	closed    bool
+	metrics   *Metrics // set by Router.SetPool
+}
+
+// NewBrowserPool creates a pool. Call Start to pre-launch MinIdle browsers.
+func NewBrowserPool(opts PoolOptions) *BrowserPool {
+	if opts.MaxTotal < 1 {
+		opts.MaxTotal = 1
+	}
+	if opts.MinIdle > opts.MaxTotal {
+		opts.MinIdle = opts.MaxTotal
+	}
+	if opts.WaitTimeout <= 0 {
+		opts.WaitTimeout = DefaultTimeout
+	}
+	return &BrowserPool{opts: opts}
+}
+
+// Start begins warming the pool up to MinIdle browsers in the background.
+func (p *BrowserPool) Start() {
+	go p.fill()
+}
+
+// Stats returns the current pool counters.
+func (p *BrowserPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Idle:      len(p.idle),
+		Busy:      p.busy,
+		Launching: p.launching,
+		Queued:    len(p.waiters),
+		MinIdle:   p.opts.MinIdle,
+		MaxTotal:  p.opts.MaxTotal,
+	}
+}
+
+// totalLocked returns the number of browsers the pool currently accounts for.
+func (p *BrowserPool) totalLocked() int {
+	return len(p.idle) + p.busy + p.launching
+}
+
+// Acquire returns an idle browser, launches a new one if the pool has room,
+// or queues until one is released. It fails after WaitTimeout.
+func (p *BrowserPool) Acquire() (*PooledBrowser, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("browser pool is closed")
+	}
+
+	for len(p.idle) > 0 {
+		b := p.idle[0]
+		p.idle = p.idle[1:]
+		if b.isDead() {
+			go b.close()
+			continue
+		}
+		p.busy++
+		p.mu.Unlock()
+		go p.fill() // top the idle set back up
+		b.drain()
+		return b, nil
+	}
+
+	if p.totalLocked() < p.opts.MaxTotal {
+		p.launching++
+		p.mu.Unlock()
+
+		b, err := launchPooledBrowser(p.opts.Headless)
+		p.metrics.browserLaunched(err)
+
+		p.mu.Lock()
+		p.launching--
+		if err != nil {
+			p.mu.Unlock()
+			go p.fill() // a queued waiter may be able to use the freed slot
+			return nil, err
+		}
+		p.busy++
+		p.mu.Unlock()
+		return b, nil
+	}
+
+	// Exhausted: queue until a browser is handed to us or we time out.
+	ch := make(chan *PooledBrowser, 1)
+	p.waiters = append(p.waiters, ch)
+	p.mu.Unlock()
+
+	timer := time.NewTimer(p.opts.WaitTimeout)
+	defer timer.Stop()
+
+	select {
+	case b := <-ch:
+		if b == nil {
+			return nil, fmt.Errorf("browser pool is closed")
+		}
+		b.drain()
+		return b, nil
+	case <-timer.C:
+		p.mu.Lock()
+		for i, w := range p.waiters {
+			if w == ch {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				p.mu.Unlock()
+				return nil, fmt.Errorf("timeout after %s waiting for a pooled browser (max %d)", p.opts.WaitTimeout, p.opts.MaxTotal)
+			}
+		}
+		p.mu.Unlock()
+		// A browser was handed over just as we timed out — take it.
+		b := <-ch
+		if b == nil {
+			return nil, fmt.Errorf("browser pool is closed")
+		}
+		b.drain()
+		return b, nil
+	}
+}
+
+// Release returns a browser to the pool. The browser is reset first; if the
+// reset fails or the browser died, it is closed and the slot is freed.
+func (p *BrowserPool) Release(b *PooledBrowser) {
+	healthy := !b.isDead()
+	if healthy {
+		if err := b.reset(); err != nil {
+			fmt.Fprintf(os.Stderr, "[pool] Failed to reset browser, discarding: %v\n", err)
+			healthy = false
+		}
+	}
+
+	p.mu.Lock()
+	p.busy--
+	if !healthy || p.closed {
+		p.mu.Unlock()
+		b.close()
+		go p.fill()
+		return
+	}
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.busy++
+		p.mu.Unlock()
+		w <- b
+		return
+	}
+	p.idle = append(p.idle, b)
+	p.mu.Unlock()
+}
+
+// fill launches browsers until MinIdle are idle and every waiter is served,
+// without exceeding MaxTotal. Safe to call concurrently.
+func (p *BrowserPool) fill() {
+	for {
+		p.mu.Lock()
+		needed := len(p.waiters) > 0 || len(p.idle)+p.launching < p.opts.MinIdle
+		if p.closed || !needed || p.totalLocked() >= p.opts.MaxTotal {
+			p.mu.Unlock()
+			return
+		}
+		p.launching++
+		p.mu.Unlock()
+
+		b, err := launchPooledBrowser(p.opts.Headless)
+		p.metrics.browserLaunched(err)
+
+		p.mu.Lock()
+		p.launching--
+		if err != nil {
+			p.mu.Unlock()
+			// Don't retry in a tight loop; the next Acquire/Release will try again.
+			fmt.Fprintf(os.Stderr, "[pool] Failed to launch browser: %v\n", err)
+			return
+		}
+		if p.closed {
+			p.mu.Unlock()
+			b.close()
+			return
+		}
+		if len(p.waiters) > 0 {
+			w := p.waiters[0]
+			p.waiters = p.waiters[1:]
+			p.busy++
+			p.mu.Unlock()
+			w <- b
+			continue
+		}
+		p.idle = append(p.idle, b)
+		p.mu.Unlock()
+	}
+}
+
+// Close shuts down all idle browsers and fails queued waiters. Busy browsers
+// are closed as they are released.
+func (p *BrowserPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	waiters := p.waiters
+	p.waiters = nil
+	p.mu.Unlock()
+
+	for _, w := range waiters {
+		close(w)
+	}
+	for _, b := range idle {
+		b.close()
+	}
+}
+
+// launchPooledBrowser launches a local browser and starts its reader goroutine.
+func launchPooledBrowser(headless bool) (*PooledBrowser, error) {
+	launchResult, bidiConn, err := launchLocalBrowser(headless)
+	if err != nil {
+		return nil, err
+	}
+	b := &PooledBrowser{
+		LaunchResult: launchResult,
+		BidiConn:     bidiConn,
+		msgs:         make(chan string, pooledMsgQueueSize),
+		dead:         make(chan struct{}),
+		stop:         make(chan struct{}),
+		nextID:       1000000, // same range as Router internal commands
+	}
+	go b.readLoop()
+	fmt.Fprintf(os.Stderr, "[pool] Browser launched\n")
+	return b, nil
+}
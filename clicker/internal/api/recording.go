@@ -284,6 +284,18 @@ func (t *Recorder) StoreResource(sha1 string, data []byte) {
 	t.resources[sha1] = data
 }
 
+// ResourceBytes returns the size of the screenshots and snapshots held in
+// memory for the current recording.
+func (t *Recorder) ResourceBytes() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, data := range t.resources {
+		n += len(data)
+	}
+	return n
+}
+
 // apiNameFromMethod maps a vibium: method to (class, title) for recording display.
 func apiNameFromMethod(method string) (string, string) {
 	// Strip the "vibium:" prefix
@@ -452,6 +464,23 @@ func (t *Recorder) RecordActionEnd(callId, afterSnapshot string, endTime time.Ti
 	t.events = append(t.events, ev)
 }
 
+// RecordLog attaches a log line to an action in the recording; the trace
+// viewer shows it in the action's Log tab.
+func (t *Recorder) RecordLog(callId, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.recording || callId == "" {
+		return
+	}
+	t.events = append(t.events, recordEvent{
+		"type":    "log",
+		"callId":  callId,
+		"time":    float64(time.Now().UnixMilli()),
+		"message": message,
+	})
+}
+
 // RecordBidiCommand records a raw BiDi command sent to the browser in the recording (opt-in via bidi: true).
 // Returns the callId so the caller can pass it to RecordBidiCommandEnd.
 func (t *Recorder) RecordBidiCommand(method string, params map[string]interface{}) string {
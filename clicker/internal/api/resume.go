@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultResumeBuffer is the default number of browser messages kept for a
+// detached session while it waits for its client to reconnect.
+const DefaultResumeBuffer = 1000
+
+// resumableClient is implemented by transports that can carry a resume token
+// from a reconnecting client (see ClientConn.ResumeToken).
+type resumableClient interface {
+	ResumeToken() string
+}
+
+// SetResume enables session resume. When a client disconnects, its browser
+// session is kept alive for grace so a client reconnecting with the session's
+// resume token is reattached to the same browser. Up to bufferSize messages
+// arriving while detached are buffered and replayed on reattach; older ones
+// are dropped. A zero grace disables resume.
+func (r *Router) SetResume(grace time.Duration, bufferSize int) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultResumeBuffer
+	}
+	r.resumeGrace = grace
+	r.resumeBuffer = bufferSize
+}
+
+// newResumeToken returns a random, unguessable session resume token.
+func newResumeToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand doesn't fail on supported platforms
+		panic(fmt.Sprintf("failed to generate resume token: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// currentClient returns the client the session is attached to. The client
+// changes when a detached session is resumed.
+func (s *BrowserSession) currentClient() ClientTransport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Client
+}
+
+// send delivers a message to the session's client. For resumable sessions,
+// messages that can't be delivered because the client is gone are buffered
+// until the client reconnects or the grace period expires.
+func (s *BrowserSession) send(msg string) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	client := s.Client
+	detached := s.detached
+	resumable := s.resumeToken != ""
+	s.mu.Unlock()
+
+	if !detached {
+		err := client.Send(msg)
+		if err == nil || !resumable {
+			return err
+		}
+		// The client went away but OnClientDisconnect hasn't run yet —
+		// keep the message for a reconnect.
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, msg)
+	if len(s.pending) > s.pendingLimit {
+		s.pendingDropped += len(s.pending) - s.pendingLimit
+		s.pending = s.pending[len(s.pending)-s.pendingLimit:]
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// sendReady sends vibium:lifecycle.ready with the session's resume token.
+// The caller must hold session.sendMu.
+func (r *Router) sendReady(session *BrowserSession, client ClientTransport, resumed bool, dropped int) {
+	params := map[string]interface{}{
+		"resumeToken": session.resumeToken,
+		"resumed":     resumed,
+	}
+	if dropped > 0 {
+		params["droppedEvents"] = dropped
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"method": "vibium:lifecycle.ready",
+		"params": params,
+	})
+	client.Send(string(data))
+}
+
+// enableResume gives a new session a resume token, registers it for
+// reattachment and tells the client its token.
+func (r *Router) enableResume(session *BrowserSession) {
+	session.mu.Lock()
+	session.resumeToken = newResumeToken()
+	session.pendingLimit = r.resumeBuffer
+	client := session.Client
+	session.mu.Unlock()
+
+	r.resumable.Store(session.resumeToken, session)
+
+	session.sendMu.Lock()
+	r.sendReady(session, client, false, 0)
+	session.sendMu.Unlock()
+}
+
+// detach keeps a session alive after its client disconnected, closing it if
+// nobody resumes it within the grace period. Returns false if the session
+// can't be resumed and should be closed now.
+func (r *Router) detach(session *BrowserSession, client ClientTransport) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.closed || session.resumeToken == "" || session.Client != client {
+		return false
+	}
+
+	session.detached = true
+	session.detachTimer = time.AfterFunc(r.resumeGrace, func() {
+		r.expireDetached(session)
+	})
+
+	fmt.Fprintf(os.Stderr, "[router] Client %d detached, keeping browser session for %s\n", client.ID(), r.resumeGrace)
+	return true
+}
+
+// expireDetached closes a detached session whose grace period ran out.
+func (r *Router) expireDetached(session *BrowserSession) {
+	session.mu.Lock()
+	if !session.detached {
+		// Resumed in the meantime
+		session.mu.Unlock()
+		return
+	}
+	session.detached = false
+	client := session.Client
+	session.mu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "[router] Resume grace period expired for client %d\n", client.ID())
+	r.closeSession(session)
+}
+
+// reattach attaches a reconnecting client to the detached session identified
+// by token and replays the messages buffered while it was away. Returns false
+// if the token is unknown, expired, or still in use by a connected client.
+func (r *Router) reattach(client ClientTransport, token string) bool {
+	sessionVal, ok := r.resumable.Load(token)
+	if !ok {
+		return false
+	}
+	session := sessionVal.(*BrowserSession)
+
+	// Hold sendMu so no new message reaches the client ahead of the replay.
+	session.sendMu.Lock()
+	defer session.sendMu.Unlock()
+
+	session.mu.Lock()
+	if session.closed || !session.detached {
+		session.mu.Unlock()
+		return false
+	}
+	session.detached = false
+	session.detachTimer.Stop()
+	session.detachTimer = nil
+	oldID := session.Client.ID()
+	session.Client = client
+	pending := session.pending
+	dropped := session.pendingDropped
+	session.pending = nil
+	session.pendingDropped = 0
+	session.mu.Unlock()
+
+	r.sessions.Store(client.ID(), session)
+	fmt.Fprintf(os.Stderr, "[router] Client %d resumed session of client %d (%d buffered, %d dropped)\n",
+		client.ID(), oldID, len(pending), dropped)
+
+	r.sendReady(session, client, true, dropped)
+	for _, msg := range pending {
+		if err := client.Send(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "[router] Failed to replay to client %d: %v\n", client.ID(), err)
+			break
+		}
+	}
+	return true
+}
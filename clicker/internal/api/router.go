@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/vibium/clicker/internal/bidi"
 	"github.com/vibium/clicker/internal/browser"
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 // DefaultTimeout is the default timeout for element resolution and actionability checks.
@@ -24,7 +26,46 @@ type BrowserSession struct {
 	Client       ClientTransport
 	mu           sync.Mutex
 	closed       bool
+	crashErr     error // set when the browser connection dropped unexpectedly
 	stopChan     chan struct{}
+	pooled       *PooledBrowser // non-nil when the browser is borrowed from a BrowserPool
+	routeDone    chan struct{}  // closed when routeBrowserToClient returns
+
+	// Event subscription IDs, removed before a pooled browser is reused
+	subscriptionIDs []string
+
+	// Network intercepts and the origins the pages loaded, undone before a
+	// pooled browser is reused (see pool.go)
+	interceptIDs      []string
+	pendingIntercepts map[int]bool // IDs of client network.addIntercept commands awaiting a response
+	origins           map[string]bool
+
+	// Client event filters from vibium:session.subscribe (see subscriptions.go)
+	eventSubs   []*eventSubscription
+	eventFilter bool // true while the client has chosen its events
+
+	// Resume support (see resume.go)
+	resumeToken    string      // "" if resume is disabled
+	detached       bool        // client gone, waiting for a resume
+	detachTimer    *time.Timer // closes the session when the grace period ends
+	pending        []string    // messages buffered while detached
+	pendingLimit   int
+	pendingDropped int
+	sendMu         sync.Mutex // orders sends to the client with the replay on reattach
+
+	// Dispatched vibium: commands, for cancellation and deadlines (see commands.go)
+	commands   map[int]*runningCommand    // client command ID -> command
+	active     map[string]*runningCommand // lane -> command whose handler is running
+	commandsMu sync.Mutex
+
+	// Per-browsing-context command queues (see commands.go)
+	lanes   map[string]*laneQueue
+	lanesMu sync.Mutex
+
+	// Responses of commands run by vibium:batch (see batch.go)
+	batched     map[int]chan bidiResponse // synthetic command ID -> response channel
+	nextBatchID int                       // counts down from -1 so it can't clash with client IDs
+	batchedMu   sync.Mutex
 
 	// Internal command tracking for vibium: extension commands
 	internalCmds   map[int]chan json.RawMessage // id -> response channel
@@ -42,20 +83,10 @@ type BrowserSession struct {
 	clockPreloadScriptID string // "" if not installed
 
 	// Recording support
-	recorder           *Recorder
-	lastContext        string   // last browsing context resolved by a command
-	lastURL            string   // last known page URL, updated from load/navigation events
-	lastElementBox     *BoxInfo // last resolved element box, for recording
-	screenshotInFlight int32    // atomic; 1 = screenshot capture in progress
-	handlerScreenshot  int32    // atomic; 1 = handler already captured filmstrip screenshot
-	dispatchMu         sync.Mutex // serializes dispatch goroutines so screenshots capture correct page state
-}
-
-// SetLastElementBox stores the bounding box of the last resolved element for recording.
-func (s *BrowserSession) SetLastElementBox(box *BoxInfo) {
-	s.mu.Lock()
-	s.lastElementBox = box
-	s.mu.Unlock()
+	recorder    *Recorder
+	lastContext string       // last browsing context resolved by a command
+	lastURL     string       // last known page URL, updated from load/navigation events
+	dispatchMu  sync.RWMutex // per-page commands hold it shared; session-wide commands and recording control exclusively
 }
 
 // BiDi command structure for parsing incoming messages
@@ -80,6 +111,12 @@ type Router struct {
 	headless       bool
 	connectURL     string
 	connectHeaders http.Header
+	pool           *BrowserPool // optional; local mode only
+	metrics        *Metrics
+
+	resumeGrace  time.Duration // 0 = close sessions as soon as the client disconnects
+	resumeBuffer int
+	resumable    sync.Map // map[string]*BrowserSession (resume token -> session)
 }
 
 // NewRouter creates a new router.
@@ -88,18 +125,76 @@ func NewRouter(headless bool, connectURL string, connectHeaders http.Header) *Ro
 		headless:       headless,
 		connectURL:     connectURL,
 		connectHeaders: connectHeaders,
+		metrics:        NewMetrics(),
+	}
+}
+
+// SetPool makes the router borrow browsers from pool instead of launching one
+// per client. Ignored in remote (connectURL) mode.
+func (r *Router) SetPool(pool *BrowserPool) {
+	r.pool = pool
+	pool.metrics = r.metrics
+}
+
+// launchLocalBrowser launches a browser and returns its BiDi connection.
+func launchLocalBrowser(headless bool) (*browser.LaunchResult, *bidi.Connection, error) {
+	launchResult, err := browser.Launch(browser.LaunchOptions{
+		Headless: headless,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Use BiDi connection from launch if available, otherwise connect via WebSocket URL
+	if launchResult.BidiConn != nil {
+		return launchResult, launchResult.BidiConn, nil
+	}
+
+	bidiConn, err := bidi.Connect(launchResult.WebSocketURL)
+	if err != nil {
+		launchResult.Close()
+		return nil, nil, fmt.Errorf("failed to connect to browser: %w", err)
 	}
+	return launchResult, bidiConn, nil
 }
 
 // OnClientConnect is called when a new client connects.
-// It launches a browser (or connects to a remote one) and establishes a BiDi connection.
+// It launches a browser (or connects to a remote one, or borrows one from the
+// pool) and establishes a BiDi connection.
 func (r *Router) OnClientConnect(client ClientTransport) {
 	var launchResult *browser.LaunchResult
 	var bidiConn *bidi.Connection
 	var bidiClient *bidi.Client
+	var pooled *PooledBrowser
 	var err error
 
-	if r.connectURL != "" {
+	if r.resumeGrace > 0 {
+		if rc, ok := client.(resumableClient); ok && rc.ResumeToken() != "" {
+			if r.reattach(client, rc.ResumeToken()) {
+				return
+			}
+			// Unknown or expired token: fall through to a fresh session
+			fmt.Fprintf(os.Stderr, "[router] Client %d sent an invalid resume token, starting a new session\n", client.ID())
+		}
+	}
+
+	if r.connectURL == "" && r.pool != nil {
+		// Pool mode: borrow a pre-launched browser (may queue until one is free)
+		fmt.Fprintf(os.Stderr, "[router] Acquiring pooled browser for client %d...\n", client.ID())
+
+		pooled, err = r.pool.Acquire()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[router] Failed to acquire browser for client %d: %v\n", client.ID(), err)
+			client.Send(fmt.Sprintf(`{"error":{"code":-32000,"message":"Failed to acquire browser: %s"}}`, err.Error()))
+			client.Close()
+			return
+		}
+
+		launchResult = pooled.LaunchResult
+		bidiConn = pooled.BidiConn
+		bidiClient = bidi.NewClient(bidiConn)
+		fmt.Fprintf(os.Stderr, "[router] Pooled browser attached to client %d\n", client.ID())
+	} else if r.connectURL != "" {
 		// Remote mode: connect to an existing BiDi endpoint and create a session
 		fmt.Fprintf(os.Stderr, "[router] Connecting to remote browser for client %d: %s\n", client.ID(), r.connectURL)
 
@@ -116,9 +211,8 @@ func (r *Router) OnClientConnect(client ClientTransport) {
 		// Local mode: launch a browser
 		fmt.Fprintf(os.Stderr, "[router] Launching browser for client %d...\n", client.ID())
 
-		launchResult, err = browser.Launch(browser.LaunchOptions{
-			Headless: r.headless,
-		})
+		launchResult, bidiConn, err = launchLocalBrowser(r.headless)
+		r.metrics.browserLaunched(err)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "[router] Failed to launch browser for client %d: %v\n", client.ID(), err)
 			client.Send(fmt.Sprintf(`{"error":{"code":-32000,"message":"Failed to launch browser: %s"}}`, err.Error()))
@@ -126,24 +220,7 @@ func (r *Router) OnClientConnect(client ClientTransport) {
 			return
 		}
 
-		// Use BiDi connection from launch if available, otherwise connect via WebSocket URL
-		if launchResult.BidiConn != nil {
-			bidiConn = launchResult.BidiConn
-			fmt.Fprintf(os.Stderr, "[router] Browser launched for client %d (BiDi session)\n", client.ID())
-		} else {
-			fmt.Fprintf(os.Stderr, "[router] Browser launched for client %d, WebSocket: %s\n", client.ID(), launchResult.WebSocketURL)
-
-			bidiConn, err = bidi.Connect(launchResult.WebSocketURL)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "[router] Failed to connect to browser BiDi for client %d: %v\n", client.ID(), err)
-				launchResult.Close()
-				client.Send(fmt.Sprintf(`{"error":{"code":-32000,"message":"Failed to connect to browser: %s"}}`, err.Error()))
-				client.Close()
-				return
-			}
-
-			fmt.Fprintf(os.Stderr, "[router] BiDi connection established for client %d\n", client.ID())
-		}
+		fmt.Fprintf(os.Stderr, "[router] Browser launched for client %d\n", client.ID())
 
 		// Local mode: browser.Launch() already called SessionNew, just wrap the connection
 		bidiClient = bidi.NewClient(bidiConn)
@@ -155,9 +232,20 @@ func (r *Router) OnClientConnect(client ClientTransport) {
 		BidiClient:     bidiClient,
 		Client:         client,
 		stopChan:       make(chan struct{}),
+		pooled:         pooled,
+		routeDone:      make(chan struct{}),
+		commands:       make(map[int]*runningCommand),
+		active:         make(map[string]*runningCommand),
+		lanes:          make(map[string]*laneQueue),
+		batched:        make(map[int]chan bidiResponse),
 		internalCmds:   make(map[int]chan json.RawMessage),
 		nextInternalID: 1000000, // Start at high number to avoid collision with client IDs
 	}
+	if pooled != nil {
+		// Continue the browser's ID sequence so late responses addressed to the
+		// previous client can't be mistaken for ours.
+		session.nextInternalID = pooled.nextID
+	}
 
 	r.sessions.Store(client.ID(), session)
 
@@ -168,7 +256,7 @@ func (r *Router) OnClientConnect(client ClientTransport) {
 	// so Chrome delivers events (contextCreated, beforeRequestSent, etc.) from
 	// the very first navigation. Without this, a fast client could send commands
 	// before Chrome knows to forward events, causing missed events or hangs.
-	_, err = r.sendInternalCommand(session, "session.subscribe", map[string]interface{}{
+	resp, err := r.sendInternalCommand(session, "session.subscribe", map[string]interface{}{
 		"events": []string{
 			"browsingContext.contextCreated",
 			"network.beforeRequestSent",
@@ -183,6 +271,12 @@ func (r *Router) OnClientConnect(client ClientTransport) {
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[router] Failed to subscribe to events for client %d: %v\n", client.ID(), err)
+	} else {
+		r.trackSubscription(session, resp)
+	}
+
+	if r.resumeGrace > 0 {
+		r.enableResume(session)
 	}
 
 	// Download setup is non-critical — run in background so it doesn't
@@ -210,11 +304,33 @@ func handlerCapturesBefore(method string) bool {
 	return false
 }
 
-// dispatch wraps a vibium handler with automatic action recording.
+// dispatch wraps a vibium handler with automatic action recording,
+// cancellation and the optional per-command deadline. Commands run in order
+// per browsing context; commands on different contexts run concurrently.
 func (r *Router) dispatch(session *BrowserSession, cmd bidiCommand, handler vibiumHandler) {
-	go func() {
-		session.dispatchMu.Lock()
-		defer session.dispatchMu.Unlock()
+	if cmd.Params == nil {
+		cmd.Params = map[string]interface{}{}
+	}
+	lane := session.laneFor(cmd)
+
+	// Register before queueing so vibium:command.cancel can find it right away
+	rc := session.startCommand(cmd, lane)
+
+	session.enqueue(lane, func() {
+		defer session.finishCommand(rc)
+		if lane == "" {
+			session.dispatchMu.Lock()
+			defer session.dispatchMu.Unlock()
+		} else {
+			session.dispatchMu.RLock()
+			defer session.dispatchMu.RUnlock()
+		}
+
+		// Cancelled or out of time while queued behind another command
+		if err := rc.aborted(); err != nil {
+			r.sendError(session, cmd.ID, err)
+			return
+		}
 
 		session.mu.Lock()
 		recorder := session.recorder
@@ -236,14 +352,21 @@ func (r *Router) dispatch(session *BrowserSession, cmd bidiCommand, handler vibi
 				cmd.Params["_recordCallId"] = callId
 			}
 
-			session.mu.Lock()
-			pageId := session.lastContext
-			session.mu.Unlock()
+			pageId := lane
+			if pageId == "" {
+				session.mu.Lock()
+				pageId = session.lastContext
+				session.mu.Unlock()
+			}
 
 			recorder.RecordAction(callId, cmd.Method, cmd.Params, beforeSnapshot, pageId)
 		}
 
+		start := time.Now()
+		session.setActive(rc)
 		handler(session, cmd)
+		session.clearActive(rc) // recording captures below must not be cancelled
+		r.metrics.observeCommand(cmd.Method, time.Since(start))
 
 		// Clean up internal recording field so it doesn't appear in recording output
 		delete(cmd.Params, "_recordCallId")
@@ -251,11 +374,8 @@ func (r *Router) dispatch(session *BrowserSession, cmd bidiCommand, handler vibi
 		// Capture endTime immediately after handler returns, before screenshot captures
 		endTime := time.Now()
 
-		// Read and clear the element box stashed by resolveWithActionability/ResolveElement
-		session.mu.Lock()
-		box := session.lastElementBox
-		session.lastElementBox = nil
-		session.mu.Unlock()
+		// Read the element box stashed by resolveWithActionability/ResolveElement
+		box, handlerCapturedSS := session.recordingState(rc)
 
 		if recorder != nil && recorder.IsRecording() {
 			opts := recorder.Options()
@@ -267,17 +387,16 @@ func (r *Router) dispatch(session *BrowserSession, cmd bidiCommand, handler vibi
 			}
 
 			// Skip if handler already captured a screenshot (e.g. navigate).
-			handlerCapturedSS := atomic.CompareAndSwapInt32(&session.handlerScreenshot, 1, 0)
-			if opts.Screenshots && !handlerCapturedSS && atomic.CompareAndSwapInt32(&session.screenshotInFlight, 0, 1) {
+			// The lane is still held, so the page is in the state the action left.
+			if opts.Screenshots && !handlerCapturedSS {
 				context, _ := cmd.Params["context"].(string)
 				ps := NewAPISession(r, session, context)
 				CaptureRecordingScreenshot(ps, recorder, endTime)
-				atomic.StoreInt32(&session.screenshotInFlight, 0)
 			}
 
 			recorder.RecordActionEnd(callId, afterSnapshot, endTime, box)
 		}
-	}()
+	})
 }
 
 // OnClientMessage is called when a message is received from a client.
@@ -310,386 +429,326 @@ func (r *Router) OnClientMessage(client ClientTransport, msg string) {
 
 	// Handle vibium: extension commands (per WebDriver BiDi spec for extensions)
 	switch cmd.Method {
+	// Command control — runs immediately, never queued behind the command it cancels
+	case "vibium:command.cancel":
+		go r.handleCommandCancel(session, cmd)
+		return
+
+	// Batches queue each of their commands in turn
+	case "vibium:batch":
+		go r.handleBatch(session, cmd)
+		return
+
+	// Network interception replies — answer paused requests immediately
+	case "vibium:network.continue":
+		go r.handleNetworkContinue(session, cmd)
+		return
+	case "vibium:network.fulfill":
+		go r.handleNetworkFulfill(session, cmd)
+		return
+	case "vibium:network.abort":
+		go r.handleNetworkAbort(session, cmd)
+		return
+
+	// Recording commands (not recorded — they control recording itself)
+	case "vibium:recording.start":
+		go r.handleRecordingStart(session, cmd)
+		return
+	case "vibium:recording.stop":
+		go r.handleRecordingStop(session, cmd)
+		return
+	case "vibium:recording.startChunk":
+		go r.handleRecordingStartChunk(session, cmd)
+		return
+	case "vibium:recording.stopChunk":
+		go r.handleRecordingStopChunk(session, cmd)
+		return
+	case "vibium:recording.startGroup":
+		go r.handleRecordingStartGroup(session, cmd)
+		return
+	case "vibium:recording.stopGroup":
+		go r.handleRecordingStopGroup(session, cmd)
+		return
+	}
+	if handler := r.queuedHandler(cmd.Method); handler != nil {
+		r.dispatch(session, cmd, handler)
+		return
+	}
+
+	// Note the client's own intercepts, which outlive it in a pooled browser
+	switch cmd.Method {
+	case "network.addIntercept":
+		session.mu.Lock()
+		if session.pendingIntercepts == nil {
+			session.pendingIntercepts = make(map[int]bool)
+		}
+		session.pendingIntercepts[cmd.ID] = true
+		session.mu.Unlock()
+	case "network.removeIntercept":
+		intercept, _ := cmd.Params["intercept"].(string)
+		r.untrackIntercept(session, intercept)
+	}
+
+	// Forward standard BiDi commands to browser
+	if err := session.BidiConn.Send(msg); err != nil {
+		fmt.Fprintf(os.Stderr, "[router] Failed to send to browser for client %d: %v\n", client.ID(), err)
+	}
+}
+
+// queuedHandler returns the handler for a vibium: command that runs through
+// dispatch, or nil if method isn't one.
+func (r *Router) queuedHandler(method string) vibiumHandler {
+	switch method {
+	// Event subscriptions
+	case "vibium:session.subscribe":
+		return r.handleSessionSubscribe
+	case "vibium:session.unsubscribe":
+		return r.handleSessionUnsubscribe
+
 	// Element interaction commands
 	case "vibium:element.click":
-		r.dispatch(session, cmd, r.handleVibiumClick)
-		return
+		return r.handleVibiumClick
 	case "vibium:element.dblclick":
-		r.dispatch(session, cmd, r.handleVibiumDblclick)
-		return
+		return r.handleVibiumDblclick
 	case "vibium:element.fill":
-		r.dispatch(session, cmd, r.handleVibiumFill)
-		return
+		return r.handleVibiumFill
 	case "vibium:element.type":
-		r.dispatch(session, cmd, r.handleVibiumType)
-		return
+		return r.handleVibiumType
 	case "vibium:element.press":
-		r.dispatch(session, cmd, r.handleVibiumPress)
-		return
+		return r.handleVibiumPress
 	case "vibium:element.clear":
-		r.dispatch(session, cmd, r.handleVibiumClear)
-		return
+		return r.handleVibiumClear
 	case "vibium:element.check":
-		r.dispatch(session, cmd, r.handleVibiumCheck)
-		return
+		return r.handleVibiumCheck
 	case "vibium:element.uncheck":
-		r.dispatch(session, cmd, r.handleVibiumUncheck)
-		return
+		return r.handleVibiumUncheck
 	case "vibium:element.selectOption":
-		r.dispatch(session, cmd, r.handleVibiumSelectOption)
-		return
+		return r.handleVibiumSelectOption
 	case "vibium:element.hover":
-		r.dispatch(session, cmd, r.handleVibiumHover)
-		return
+		return r.handleVibiumHover
 	case "vibium:element.focus":
-		r.dispatch(session, cmd, r.handleVibiumFocus)
-		return
+		return r.handleVibiumFocus
 	case "vibium:element.dragTo":
-		r.dispatch(session, cmd, r.handleVibiumDragTo)
-		return
+		return r.handleVibiumDragTo
 	case "vibium:element.tap":
-		r.dispatch(session, cmd, r.handleVibiumTap)
-		return
+		return r.handleVibiumTap
 	case "vibium:element.scrollIntoView":
-		r.dispatch(session, cmd, r.handleVibiumScrollIntoView)
-		return
+		return r.handleVibiumScrollIntoView
 	case "vibium:element.dispatchEvent":
-		r.dispatch(session, cmd, r.handleVibiumDispatchEvent)
-		return
+		return r.handleVibiumDispatchEvent
 
 	// Element finding commands (element-scoped and page-level)
 	case "vibium:element.find", "vibium:page.find":
-		r.dispatch(session, cmd, r.handleVibiumFind)
-		return
+		return r.handleVibiumFind
 	case "vibium:element.findAll", "vibium:page.findAll":
-		r.dispatch(session, cmd, r.handleVibiumFindAll)
-		return
+		return r.handleVibiumFindAll
 
 	// Element state commands
 	case "vibium:element.text":
-		r.dispatch(session, cmd, r.handleVibiumElText)
-		return
+		return r.handleVibiumElText
 	case "vibium:element.innerText":
-		r.dispatch(session, cmd, r.handleVibiumElInnerText)
-		return
+		return r.handleVibiumElInnerText
 	case "vibium:element.html":
-		r.dispatch(session, cmd, r.handleVibiumElHTML)
-		return
+		return r.handleVibiumElHTML
 	case "vibium:element.value":
-		r.dispatch(session, cmd, r.handleVibiumElValue)
-		return
+		return r.handleVibiumElValue
 	case "vibium:element.attr":
-		r.dispatch(session, cmd, r.handleVibiumElAttr)
-		return
+		return r.handleVibiumElAttr
 	case "vibium:element.bounds":
-		r.dispatch(session, cmd, r.handleVibiumElBounds)
-		return
+		return r.handleVibiumElBounds
 	case "vibium:element.isVisible":
-		r.dispatch(session, cmd, r.handleVibiumElIsVisible)
-		return
+		return r.handleVibiumElIsVisible
 	case "vibium:element.isHidden":
-		r.dispatch(session, cmd, r.handleVibiumElIsHidden)
-		return
+		return r.handleVibiumElIsHidden
 	case "vibium:element.isEnabled":
-		r.dispatch(session, cmd, r.handleVibiumElIsEnabled)
-		return
+		return r.handleVibiumElIsEnabled
 	case "vibium:element.isChecked":
-		r.dispatch(session, cmd, r.handleVibiumElIsChecked)
-		return
+		return r.handleVibiumElIsChecked
 	case "vibium:element.isEditable":
-		r.dispatch(session, cmd, r.handleVibiumElIsEditable)
-		return
+		return r.handleVibiumElIsEditable
 	case "vibium:element.screenshot":
-		r.dispatch(session, cmd, r.handleVibiumElScreenshot)
-		return
+		return r.handleVibiumElScreenshot
 	case "vibium:element.waitFor":
-		r.dispatch(session, cmd, r.handleVibiumElWaitFor)
-		return
+		return r.handleVibiumElWaitFor
 
 	// Page-level input commands
 	case "vibium:keyboard.press":
-		r.dispatch(session, cmd, r.handleKeyboardPress)
-		return
+		return r.handleKeyboardPress
 	case "vibium:keyboard.down":
-		r.dispatch(session, cmd, r.handleKeyboardDown)
-		return
+		return r.handleKeyboardDown
 	case "vibium:keyboard.up":
-		r.dispatch(session, cmd, r.handleKeyboardUp)
-		return
+		return r.handleKeyboardUp
 	case "vibium:keyboard.type":
-		r.dispatch(session, cmd, r.handleKeyboardType)
-		return
+		return r.handleKeyboardType
 	case "vibium:mouse.click":
-		r.dispatch(session, cmd, r.handleMouseClick)
-		return
+		return r.handleMouseClick
 	case "vibium:mouse.move":
-		r.dispatch(session, cmd, r.handleMouseMove)
-		return
+		return r.handleMouseMove
 	case "vibium:mouse.down":
-		r.dispatch(session, cmd, r.handleMouseDown)
-		return
+		return r.handleMouseDown
 	case "vibium:mouse.up":
-		r.dispatch(session, cmd, r.handleMouseUp)
-		return
+		return r.handleMouseUp
 	case "vibium:mouse.wheel":
-		r.dispatch(session, cmd, r.handleMouseWheel)
-		return
+		return r.handleMouseWheel
 	case "vibium:page.scroll":
-		r.dispatch(session, cmd, r.handlePageScroll)
-		return
+		return r.handlePageScroll
 	case "vibium:touch.tap":
-		r.dispatch(session, cmd, r.handleTouchTap)
-		return
+		return r.handleTouchTap
 
 	// Page-level capture commands
 	case "vibium:page.screenshot":
-		r.dispatch(session, cmd, r.handlePageScreenshot)
-		return
+		return r.handlePageScreenshot
 	case "vibium:page.pdf":
-		r.dispatch(session, cmd, r.handlePagePDF)
-		return
+		return r.handlePagePDF
 
 	// Page-level evaluation commands
 	case "vibium:page.eval":
-		r.dispatch(session, cmd, r.handlePageEval)
-		return
+		return r.handlePageEval
 	case "vibium:page.addScript":
-		r.dispatch(session, cmd, r.handlePageAddScript)
-		return
+		return r.handlePageAddScript
 	case "vibium:page.addStyle":
-		r.dispatch(session, cmd, r.handlePageAddStyle)
-		return
+		return r.handlePageAddStyle
 	case "vibium:page.expose":
-		r.dispatch(session, cmd, r.handlePageExpose)
-		return
+		return r.handlePageExpose
 
 	// Page-level waiting commands
 	case "vibium:page.waitFor":
-		r.dispatch(session, cmd, r.handlePageWaitFor)
-		return
+		return r.handlePageWaitFor
 	case "vibium:page.wait":
-		r.dispatch(session, cmd, r.handlePageWait)
-		return
+		return r.handlePageWait
 	case "vibium:page.waitForFunction":
-		r.dispatch(session, cmd, r.handlePageWaitForFunction)
-		return
+		return r.handlePageWaitForFunction
 
 	// Navigation commands
 	case "vibium:page.navigate":
-		r.dispatch(session, cmd, r.handlePageNavigate)
-		return
+		return r.handlePageNavigate
 	case "vibium:page.back":
-		r.dispatch(session, cmd, r.handlePageBack)
-		return
+		return r.handlePageBack
 	case "vibium:page.forward":
-		r.dispatch(session, cmd, r.handlePageForward)
-		return
+		return r.handlePageForward
 	case "vibium:page.reload":
-		r.dispatch(session, cmd, r.handlePageReload)
-		return
+		return r.handlePageReload
 	case "vibium:page.url":
-		r.dispatch(session, cmd, r.handlePageURL)
-		return
+		return r.handlePageURL
 	case "vibium:page.title":
-		r.dispatch(session, cmd, r.handlePageTitle)
-		return
+		return r.handlePageTitle
 	case "vibium:page.content":
-		r.dispatch(session, cmd, r.handlePageContent)
-		return
+		return r.handlePageContent
 	case "vibium:page.waitForURL":
-		r.dispatch(session, cmd, r.handlePageWaitForURL)
-		return
+		return r.handlePageWaitForURL
 	case "vibium:page.waitForLoad":
-		r.dispatch(session, cmd, r.handlePageWaitForLoad)
-		return
+		return r.handlePageWaitForLoad
 
 	// Page & context lifecycle commands
 	case "vibium:browser.page":
-		r.dispatch(session, cmd, r.handleBrowserPage)
-		return
+		return r.handleBrowserPage
 	case "vibium:browser.newPage":
-		r.dispatch(session, cmd, r.handleBrowserNewPage)
-		return
+		return r.handleBrowserNewPage
 	case "vibium:browser.newContext":
-		r.dispatch(session, cmd, r.handleBrowserNewContext)
-		return
+		return r.handleBrowserNewContext
 	case "vibium:context.newPage":
-		r.dispatch(session, cmd, r.handleContextNewPage)
-		return
+		return r.handleContextNewPage
 	case "vibium:browser.pages":
-		r.dispatch(session, cmd, r.handleBrowserPages)
-		return
+		return r.handleBrowserPages
 	case "vibium:context.close":
-		r.dispatch(session, cmd, r.handleContextClose)
-		return
+		return r.handleContextClose
 
 	// Cookie & storage commands
 	case "vibium:context.cookies":
-		r.dispatch(session, cmd, r.handleContextCookies)
-		return
+		return r.handleContextCookies
 	case "vibium:context.setCookies":
-		r.dispatch(session, cmd, r.handleContextSetCookies)
-		return
+		return r.handleContextSetCookies
 	case "vibium:context.clearCookies":
-		r.dispatch(session, cmd, r.handleContextClearCookies)
-		return
+		return r.handleContextClearCookies
 	case "vibium:context.storage":
-		r.dispatch(session, cmd, r.handleContextStorage)
-		return
+		return r.handleContextStorage
 	case "vibium:context.setStorage":
-		r.dispatch(session, cmd, r.handleContextSetStorage)
-		return
+		return r.handleContextSetStorage
 	case "vibium:context.clearStorage":
-		r.dispatch(session, cmd, r.handleContextClearStorage)
-		return
+		return r.handleContextClearStorage
 	case "vibium:context.addInitScript":
-		r.dispatch(session, cmd, r.handleContextAddInitScript)
-		return
+		return r.handleContextAddInitScript
 
 	// Frame commands
 	case "vibium:page.frames":
-		r.dispatch(session, cmd, r.handlePageFrames)
-		return
+		return r.handlePageFrames
 	case "vibium:page.frame":
-		r.dispatch(session, cmd, r.handlePageFrame)
-		return
+		return r.handlePageFrame
 
 	// Emulation commands
 	case "vibium:page.setViewport":
-		r.dispatch(session, cmd, r.handlePageSetViewport)
-		return
+		return r.handlePageSetViewport
 	case "vibium:page.viewport":
-		r.dispatch(session, cmd, r.handlePageViewport)
-		return
+		return r.handlePageViewport
 	case "vibium:page.emulateMedia":
-		r.dispatch(session, cmd, r.handlePageEmulateMedia)
-		return
+		return r.handlePageEmulateMedia
 	case "vibium:page.setContent":
-		r.dispatch(session, cmd, r.handlePageSetContent)
-		return
+		return r.handlePageSetContent
 	case "vibium:page.setGeolocation":
-		r.dispatch(session, cmd, r.handlePageSetGeolocation)
-		return
+		return r.handlePageSetGeolocation
 	case "vibium:page.setWindow":
-		r.dispatch(session, cmd, r.handlePageSetWindow)
-		return
+		return r.handlePageSetWindow
 	case "vibium:page.window":
-		r.dispatch(session, cmd, r.handlePageWindow)
-		return
+		return r.handlePageWindow
 
 	// Accessibility commands
 	case "vibium:page.a11yTree":
-		r.dispatch(session, cmd, r.handleVibiumPageA11yTree)
-		return
+		return r.handleVibiumPageA11yTree
 	case "vibium:element.role":
-		r.dispatch(session, cmd, r.handleVibiumElRole)
-		return
+		return r.handleVibiumElRole
 	case "vibium:element.label":
-		r.dispatch(session, cmd, r.handleVibiumElLabel)
-		return
+		return r.handleVibiumElLabel
 
 	case "vibium:browser.stop":
-		r.dispatch(session, cmd, r.handleBrowserStop)
-		return
+		return r.handleBrowserStop
 	case "vibium:page.activate":
-		r.dispatch(session, cmd, r.handlePageActivate)
-		return
+		return r.handlePageActivate
 	case "vibium:page.close":
-		r.dispatch(session, cmd, r.handlePageClose)
-		return
+		return r.handlePageClose
 
 	// Network interception commands
 	case "vibium:page.route":
-		r.dispatch(session, cmd, r.handlePageRoute)
-		return
+		return r.handlePageRoute
 	case "vibium:page.unroute":
-		r.dispatch(session, cmd, r.handlePageUnroute)
-		return
-	case "vibium:network.continue":
-		go r.handleNetworkContinue(session, cmd)
-		return
-	case "vibium:network.fulfill":
-		go r.handleNetworkFulfill(session, cmd)
-		return
-	case "vibium:network.abort":
-		go r.handleNetworkAbort(session, cmd)
-		return
+		return r.handlePageUnroute
 	case "vibium:page.setHeaders":
-		r.dispatch(session, cmd, r.handlePageSetHeaders)
-		return
+		return r.handlePageSetHeaders
 
 	// Dialog commands
 	case "vibium:dialog.accept":
-		r.dispatch(session, cmd, r.handleDialogAccept)
-		return
+		return r.handleDialogAccept
 	case "vibium:dialog.dismiss":
-		r.dispatch(session, cmd, r.handleDialogDismiss)
-		return
+		return r.handleDialogDismiss
 
 	// WebSocket monitoring
 	case "vibium:page.onWebSocket":
-		r.dispatch(session, cmd, r.handlePageOnWebSocket)
-		return
+		return r.handlePageOnWebSocket
 
 	// Download & file commands
 	case "vibium:download.saveAs":
-		r.dispatch(session, cmd, r.handleDownloadSaveAs)
-		return
+		return r.handleDownloadSaveAs
 	case "vibium:element.setFiles":
-		r.dispatch(session, cmd, r.handleVibiumElSetFiles)
-		return
-
-	// Recording commands (not recorded — they control recording itself)
-	case "vibium:recording.start":
-		go r.handleRecordingStart(session, cmd)
-		return
-	case "vibium:recording.stop":
-		go r.handleRecordingStop(session, cmd)
-		return
-	case "vibium:recording.startChunk":
-		go r.handleRecordingStartChunk(session, cmd)
-		return
-	case "vibium:recording.stopChunk":
-		go r.handleRecordingStopChunk(session, cmd)
-		return
-	case "vibium:recording.startGroup":
-		go r.handleRecordingStartGroup(session, cmd)
-		return
-	case "vibium:recording.stopGroup":
-		go r.handleRecordingStopGroup(session, cmd)
-		return
+		return r.handleVibiumElSetFiles
 
 	// Clock commands
 	case "vibium:clock.install":
-		r.dispatch(session, cmd, r.handleClockInstall)
-		return
+		return r.handleClockInstall
 	case "vibium:clock.fastForward":
-		r.dispatch(session, cmd, r.handleClockFastForward)
-		return
+		return r.handleClockFastForward
 	case "vibium:clock.runFor":
-		r.dispatch(session, cmd, r.handleClockRunFor)
-		return
+		return r.handleClockRunFor
 	case "vibium:clock.pauseAt":
-		r.dispatch(session, cmd, r.handleClockPauseAt)
-		return
+		return r.handleClockPauseAt
 	case "vibium:clock.resume":
-		r.dispatch(session, cmd, r.handleClockResume)
-		return
+		return r.handleClockResume
 	case "vibium:clock.setFixedTime":
-		r.dispatch(session, cmd, r.handleClockSetFixedTime)
-		return
+		return r.handleClockSetFixedTime
 	case "vibium:clock.setSystemTime":
-		r.dispatch(session, cmd, r.handleClockSetSystemTime)
-		return
+		return r.handleClockSetSystemTime
 	case "vibium:clock.setTimezone":
-		r.dispatch(session, cmd, r.handleClockSetTimezone)
-		return
-	}
-
-	// Forward standard BiDi commands to browser
-	if err := session.BidiConn.Send(msg); err != nil {
-		fmt.Fprintf(os.Stderr, "[router] Failed to send to browser for client %d: %v\n", client.ID(), err)
+		return r.handleClockSetTimezone
 	}
+	return nil
 }
 
 // getContext retrieves the active browsing context. It checks lastContext first
@@ -726,24 +785,33 @@ func (r *Router) getContext(session *BrowserSession) (string, error) {
 // sendSuccess sends a successful response to the client.
 func (r *Router) sendSuccess(session *BrowserSession, id int, result interface{}) {
 	resp := bidiResponse{ID: id, Type: "success", Result: result}
+	if session.deliverBatched(resp) {
+		return
+	}
 	data, _ := json.Marshal(resp)
-	session.Client.Send(string(data))
+	session.send(string(data))
 }
 
 // sendError sends an error response to the client (follows WebDriver BiDi spec).
+// The error field carries the stable code for err's type (see errors.Code).
 func (r *Router) sendError(session *BrowserSession, id int, err error) {
 	resp := bidiResponse{
 		ID:      id,
 		Type:    "error",
-		Error:   "timeout",
+		Error:   errs.Code(err),
 		Message: err.Error(),
 	}
+	r.metrics.commandFailed(resp.Error)
+	session.failCommand(id, err)
+	if session.deliverBatched(resp) {
+		return
+	}
 	data, _ := json.Marshal(resp)
-	session.Client.Send(string(data))
+	session.send(string(data))
 }
 
 // OnClientDisconnect is called when a client disconnects.
-// It closes the browser session.
+// It closes the browser session, or detaches it for a later resume.
 func (r *Router) OnClientDisconnect(client ClientTransport) {
 	sessionVal, ok := r.sessions.LoadAndDelete(client.ID())
 	if !ok {
@@ -751,11 +819,98 @@ func (r *Router) OnClientDisconnect(client ClientTransport) {
 	}
 
 	session := sessionVal.(*BrowserSession)
+	if r.resumeGrace > 0 && r.detach(session, client) {
+		return
+	}
 	r.closeSession(session)
 }
 
+// trackSubscription records the subscription ID from a session.subscribe
+// response so it can be removed before a pooled browser is reused.
+func (r *Router) trackSubscription(session *BrowserSession, resp json.RawMessage) {
+	var result struct {
+		Result struct {
+			Subscription string `json:"subscription"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(resp, &result) == nil && result.Result.Subscription != "" {
+		session.mu.Lock()
+		session.subscriptionIDs = append(session.subscriptionIDs, result.Result.Subscription)
+		session.mu.Unlock()
+	}
+}
+
+// trackIntercept records the intercept ID from a network.addIntercept
+// response so it can be removed before a pooled browser is reused.
+func (r *Router) trackIntercept(session *BrowserSession, resp json.RawMessage) {
+	var result struct {
+		Result struct {
+			Intercept string `json:"intercept"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(resp, &result) == nil && result.Result.Intercept != "" {
+		session.mu.Lock()
+		session.interceptIDs = append(session.interceptIDs, result.Result.Intercept)
+		session.mu.Unlock()
+	}
+}
+
+// untrackIntercept forgets an intercept the client removed.
+func (r *Router) untrackIntercept(session *BrowserSession, intercept string) {
+	if intercept == "" {
+		return
+	}
+	session.mu.Lock()
+	session.interceptIDs = removeString(session.interceptIDs, intercept)
+	session.mu.Unlock()
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0:0]
+	for _, item := range list {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// trackOrigin records the origin of a page the session loaded, whose
+// storage is wiped before a pooled browser is reused.
+func (session *BrowserSession) trackOrigin(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return
+	}
+	session.mu.Lock()
+	if session.origins == nil {
+		session.origins = make(map[string]bool)
+	}
+	session.origins[u.Scheme+"://"+u.Host] = true
+	session.mu.Unlock()
+}
+
+// receiveFromBrowser returns the next message from the browser. Pooled
+// browsers are read by the pool's reader goroutine, so we take from its queue
+// and stop as soon as the session is closed.
+func (r *Router) receiveFromBrowser(session *BrowserSession) (string, error) {
+	if session.pooled == nil {
+		return session.BidiConn.Receive()
+	}
+	select {
+	case msg := <-session.pooled.msgs:
+		return msg, nil
+	case <-session.pooled.dead:
+		return "", session.pooled.err
+	case <-session.stopChan:
+		return "", fmt.Errorf("session closed")
+	}
+}
+
 // routeBrowserToClient reads messages from the browser and forwards them to the client.
 func (r *Router) routeBrowserToClient(session *BrowserSession) {
+	defer close(session.routeDone)
+
 	for {
 		select {
 		case <-session.stopChan:
@@ -763,22 +918,26 @@ func (r *Router) routeBrowserToClient(session *BrowserSession) {
 		default:
 		}
 
-		msg, err := session.BidiConn.Receive()
+		msg, err := r.receiveFromBrowser(session)
 		if err != nil {
 			session.mu.Lock()
 			closed := session.closed
 			session.mu.Unlock()
 
 			if !closed {
-				fmt.Fprintf(os.Stderr, "[router] Browser connection closed for client %d: %v\n", session.Client.ID(), err)
+				client := session.currentClient()
+				fmt.Fprintf(os.Stderr, "[router] Browser connection closed for client %d: %v\n", client.ID(), err)
+				session.mu.Lock()
+				session.crashErr = err
+				session.mu.Unlock()
 				// Browser died — close the full session so any pending
 				// sendInternalCommand calls fail immediately with "session closed"
 				// instead of waiting for the 60-second timeout.
-				r.sessions.Delete(session.Client.ID())
+				r.sessions.Delete(client.ID())
 				r.closeSession(session)
 				// Close the client WebSocket so JS/Python clients see the
 				// disconnect and can reject their pending commands.
-				session.Client.Close()
+				client.Close()
 			}
 			return
 		}
@@ -803,6 +962,14 @@ func (r *Router) routeBrowserToClient(session *BrowserSession) {
 			if resp.ID >= 1000000 {
 				continue
 			}
+
+			session.mu.Lock()
+			addedIntercept := session.pendingIntercepts[resp.ID]
+			delete(session.pendingIntercepts, resp.ID)
+			session.mu.Unlock()
+			if addedIntercept {
+				r.trackIntercept(session, json.RawMessage(msg))
+			}
 		}
 
 		// Track page URL from load/navigation events (zero extra BiDi round-trips)
@@ -817,6 +984,7 @@ func (r *Router) routeBrowserToClient(session *BrowserSession) {
 				session.mu.Lock()
 				session.lastURL = bidiEvent.Params.URL
 				session.mu.Unlock()
+				session.trackOrigin(bidiEvent.Params.URL)
 			}
 		}
 
@@ -833,9 +1001,14 @@ func (r *Router) routeBrowserToClient(session *BrowserSession) {
 			continue
 		}
 
+		// Drop events the client hasn't subscribed to
+		if !session.wantsEvent(msg) {
+			continue
+		}
+
 		// Forward message to client
-		if err := session.Client.Send(msg); err != nil {
-			fmt.Fprintf(os.Stderr, "[router] Failed to send to client %d: %v\n", session.Client.ID(), err)
+		if err := session.send(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "[router] Failed to send to client %d: %v\n", session.currentClient().ID(), err)
 			return
 		}
 	}
@@ -857,6 +1030,49 @@ func (r *Router) sendInternalCommandWithTimeout(session *BrowserSession, method
 		defer recorder.RecordBidiCommandEnd(callId)
 	}
 
+	// Fail fast when the vibium: command this call belongs to is cancelled
+	var abort <-chan struct{}
+	var parent *tracing.Span
+	rc := session.commandFor(paramsContext(params))
+	if rc != nil {
+		abort = rc.done
+		parent = rc.span
+	}
+
+	span := tracing.Start(method, tracing.KindClient, parent)
+	span.SetAttr("rpc.system", "webdriver-bidi")
+	resp, err := r.roundTrip(session, method, params, timeout, abort)
+	if err == errAborted {
+		err = rc.err
+	}
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	span.End(checkBidiError(resp))
+	return resp, nil
+}
+
+// errAborted is returned by roundTrip when its abort channel closes.
+var errAborted = fmt.Errorf("aborted")
+
+// paramsContext returns the browsing context a BiDi command targets, or "".
+func paramsContext(params map[string]interface{}) string {
+	if ctx, ok := params["context"].(string); ok {
+		return ctx
+	}
+	if target, ok := params["target"].(map[string]interface{}); ok {
+		ctx, _ := target["context"].(string)
+		return ctx
+	}
+	return ""
+}
+
+// roundTrip sends a BiDi command on the session's connection and waits for
+// its response, without recording it. A nil abort channel never fires.
+func (r *Router) roundTrip(session *BrowserSession, method string, params map[string]interface{}, timeout time.Duration, abort <-chan struct{}) (json.RawMessage, error) {
+	start := time.Now()
+
 	session.internalCmdsMu.Lock()
 	id := session.nextInternalID
 	session.nextInternalID++
@@ -884,10 +1100,20 @@ func (r *Router) sendInternalCommandWithTimeout(session *BrowserSession, method
 	// Wait for response (with timeout)
 	select {
 	case resp := <-ch:
+		r.metrics.observeBidi(method, time.Since(start), false)
 		return resp, nil
 	case <-time.After(timeout):
-		return nil, fmt.Errorf("timeout waiting for response to %s", method)
+		r.metrics.observeBidi(method, time.Since(start), true)
+		return nil, &errs.TimeoutError{Selector: method, Timeout: timeout}
+	case <-abort:
+		return nil, errAborted
 	case <-session.stopChan:
+		session.mu.Lock()
+		crashErr := session.crashErr
+		session.mu.Unlock()
+		if crashErr != nil {
+			return nil, &errs.BrowserCrashedError{Output: crashErr.Error()}
+		}
 		return nil, fmt.Errorf("session closed")
 	}
 }
@@ -900,9 +1126,18 @@ func (r *Router) closeSession(session *BrowserSession) {
 		return
 	}
 	session.closed = true
+	session.detached = false
+	if session.detachTimer != nil {
+		session.detachTimer.Stop()
+	}
+	client := session.Client
 	session.mu.Unlock()
 
-	fmt.Fprintf(os.Stderr, "[router] Closing browser session for client %d\n", session.Client.ID())
+	if session.resumeToken != "" {
+		r.resumable.Delete(session.resumeToken)
+	}
+
+	fmt.Fprintf(os.Stderr, "[router] Closing browser session for client %d\n", client.ID())
 
 	// Signal the routing goroutine to stop
 	close(session.stopChan)
@@ -912,6 +1147,13 @@ func (r *Router) closeSession(session *BrowserSession) {
 		session.recorder.StopScreenshots()
 	}
 
+	// Pool mode: hand the browser back instead of killing it
+	if session.pooled != nil {
+		r.releasePooled(session)
+		fmt.Fprintf(os.Stderr, "[router] Browser session released to pool for client %d\n", client.ID())
+		return
+	}
+
 	// Remote mode: end the BiDi session so chromedriver closes Chrome
 	if r.connectURL != "" && session.BidiClient != nil {
 		session.BidiClient.SendCommand("session.end", map[string]interface{}{})
@@ -932,15 +1174,60 @@ func (r *Router) closeSession(session *BrowserSession) {
 		session.LaunchResult.Close()
 	}
 
-	fmt.Fprintf(os.Stderr, "[router] Browser session closed for client %d\n", session.Client.ID())
+	fmt.Fprintf(os.Stderr, "[router] Browser session closed for client %d\n", client.ID())
+}
+
+// releasePooled resets a pooled browser and returns it to the pool.
+func (r *Router) releasePooled(session *BrowserSession) {
+	b := session.pooled
+
+	// Wait for the routing goroutine to stop reading b.msgs so the reset's
+	// responses aren't consumed by it. A dead browser is discarded without a
+	// reset, and may be released from the routing goroutine itself.
+	if !b.isDead() {
+		<-session.routeDone
+	}
+
+	session.mu.Lock()
+	b.subscriptions = append(b.subscriptions, session.subscriptionIDs...)
+	b.intercepts = append(b.intercepts, session.interceptIDs...)
+	for origin := range session.origins {
+		b.origins = append(b.origins, origin)
+	}
+	for _, id := range []string{session.wsPreloadScriptID, session.clockPreloadScriptID} {
+		if id != "" {
+			b.preloadScripts = append(b.preloadScripts, id)
+		}
+	}
+	downloadDir := session.downloadDir
+	session.mu.Unlock()
+
+	session.internalCmdsMu.Lock()
+	b.nextID = session.nextInternalID
+	session.internalCmdsMu.Unlock()
+
+	if downloadDir != "" {
+		os.RemoveAll(downloadDir)
+	}
+
+	r.pool.Release(b)
 }
 
 // CloseAll closes all browser sessions.
 func (r *Router) CloseAll() {
+	if r.pool != nil {
+		// Close idle browsers first; busy ones are closed as they are released.
+		r.pool.Close()
+	}
 	r.sessions.Range(func(key, value interface{}) bool {
 		session := value.(*BrowserSession)
 		r.closeSession(session)
 		r.sessions.Delete(key)
 		return true
 	})
+	// Detached sessions waiting for a resume aren't in r.sessions
+	r.resumable.Range(func(key, value interface{}) bool {
+		r.closeSession(value.(*BrowserSession))
+		return true
+	})
 }
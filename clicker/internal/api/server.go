@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,8 +32,13 @@ type ClientTransport interface {
 	Close() error
 }
 
+// DefaultBindAddress is the interface the server listens on unless
+// WithBindAddress says otherwise. Loopback keeps other hosts out by default.
+const DefaultBindAddress = "127.0.0.1"
+
 // Server is a WebSocket server that accepts client connections.
 type Server struct {
+	host       string
 	port       int
 	httpServer *http.Server
 	upgrader   websocket.Upgrader
@@ -38,6 +47,13 @@ type Server struct {
 	onConnect  func(ClientTransport)
 	onMessage  func(ClientTransport, string)
 	onClose    func(ClientTransport)
+	handlers   map[string]http.Handler // extra HTTP endpoints (e.g. /pool)
+
+	// Access control (all optional)
+	authToken      string // required bearer token, "" = no auth
+	tlsCertFile    string // serve wss:// when both cert and key are set
+	tlsKeyFile     string
+	allowedOrigins []string // allowed Origin headers, empty = any
 }
 
 // ClientConn represents a connected WebSocket client.
@@ -47,6 +63,8 @@ type ClientConn struct {
 	mu     sync.Mutex
 	closed bool
 	server *Server
+
+	resumeToken string // token from the ?resumeToken= query param, if any
 }
 
 // ID returns the client connection ID.
@@ -54,6 +72,12 @@ func (c *ClientConn) ID() uint64 {
 	return c.id
 }
 
+// ResumeToken returns the resume token the client presented when connecting,
+// or "" if it asked for a fresh session.
+func (c *ClientConn) ResumeToken() string {
+	return c.resumeToken
+}
+
 // ServerOption configures a Server.
 type ServerOption func(*Server)
 
@@ -64,6 +88,40 @@ func WithPort(port int) ServerOption {
 	}
 }
 
+// WithBindAddress sets the interface to listen on, e.g. "0.0.0.0" for all.
+func WithBindAddress(host string) ServerOption {
+	return func(s *Server) {
+		s.host = host
+	}
+}
+
+// WithAuthToken requires every request to carry the token, either as an
+// "Authorization: Bearer <token>" header or a ?token= query parameter (for
+// browser clients, which can't set WebSocket headers).
+func WithAuthToken(token string) ServerOption {
+	return func(s *Server) {
+		s.authToken = token
+	}
+}
+
+// WithTLS serves over TLS (wss:// and https://) using the given certificate
+// and key files.
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(s *Server) {
+		s.tlsCertFile = certFile
+		s.tlsKeyFile = keyFile
+	}
+}
+
+// WithAllowedOrigins restricts WebSocket upgrades from browsers to the given
+// origins (e.g. "https://app.example.com"). "*" allows any origin. Requests
+// without an Origin header (non-browser clients) are not affected.
+func WithAllowedOrigins(origins []string) ServerOption {
+	return func(s *Server) {
+		s.allowedOrigins = origins
+	}
+}
+
 // WithOnConnect sets a callback for when a client connects.
 func WithOnConnect(fn func(ClientTransport)) ServerOption {
 	return func(s *Server) {
@@ -85,15 +143,27 @@ func WithOnClose(fn func(ClientTransport)) ServerOption {
 	}
 }
 
+// WithHandler registers an extra HTTP endpoint served alongside the WebSocket
+// endpoint. Requests to any other path are treated as WebSocket upgrades.
+func WithHandler(pattern string, h http.Handler) ServerOption {
+	return func(s *Server) {
+		if s.handlers == nil {
+			s.handlers = make(map[string]http.Handler)
+		}
+		s.handlers[pattern] = h
+	}
+}
+
 // NewServer creates a new WebSocket server.
 func NewServer(opts ...ServerOption) *Server {
 	s := &Server{
+		host: DefaultBindAddress,
 		port: 9515, // default port
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  maxMessageSize,
 			WriteBufferSize: maxMessageSize,
 			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins
+				return true // Checked in authorize, so rejections can be logged
 			},
 		},
 	}
@@ -110,22 +180,47 @@ func (s *Server) Port() int {
 	return s.port
 }
 
+// TLS reports whether the server serves over TLS.
+func (s *Server) TLS() bool {
+	return s.tlsCertFile != "" && s.tlsKeyFile != ""
+}
+
 // Start starts the WebSocket server.
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleWebSocket)
+	for pattern, h := range s.handlers {
+		mux.Handle(pattern, s.protect(h))
+	}
 
-	addr := fmt.Sprintf(":%d", s.port)
+	// Load the certificate up front so a bad cert fails Start, not the first handshake
+	var tlsConfig *tls.Config
+	if s.TLS() {
+		cert, err := tls.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
 
 	// Bind to the port (port 0 = OS-assigned random port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("failed to listen on port %d: %w", s.port, err)
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
 	// Store actual port (important when port=0 for OS-assigned)
 	s.port = listener.Addr().(*net.TCPAddr).Port
 
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
 	s.httpServer = &http.Server{
 		Handler: mux,
 	}
@@ -153,7 +248,61 @@ func (s *Server) Stop(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
 }
 
+// authorize checks a request against the token and origin settings. It
+// returns the HTTP status and reason to reject with, or 0 if allowed.
+func (s *Server) authorize(r *http.Request) (int, string) {
+	if s.authToken != "" {
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if token == "" {
+			return http.StatusUnauthorized, "missing token"
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.authToken)) != 1 {
+			return http.StatusUnauthorized, "invalid token"
+		}
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" && len(s.allowedOrigins) > 0 {
+		allowed := false
+		for _, o := range s.allowedOrigins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return http.StatusForbidden, fmt.Sprintf("origin %s not allowed", origin)
+		}
+	}
+
+	return 0, ""
+}
+
+// reject logs a refused request and answers it with status.
+func (s *Server) reject(w http.ResponseWriter, r *http.Request, status int, reason string) {
+	fmt.Fprintf(os.Stderr, "[proxy] Rejected connection from %s to %s: %s\n", r.RemoteAddr, r.URL.Path, reason)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// protect wraps an extra HTTP endpoint with the server's access checks.
+func (s *Server) protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status, reason := s.authorize(r); status != 0 {
+			s.reject(w, r, status, reason)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if status, reason := s.authorize(r); status != 0 {
+		s.reject(w, r, status, reason)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "WebSocket upgrade error: %v\n", err)
@@ -164,9 +313,10 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn.SetReadLimit(maxMessageSize)
 
 	client := &ClientConn{
-		id:     s.nextID.Add(1),
-		conn:   conn,
-		server: s,
+		id:          s.nextID.Add(1),
+		conn:        conn,
+		server:      s,
+		resumeToken: r.URL.Query().Get("resumeToken"),
 	}
 
 	s.clients.Store(client.id, client)
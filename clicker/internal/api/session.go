@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/vibium/clicker/internal/bidi"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 // Session abstracts BiDi communication so that both the proxy (WebSocket router)
@@ -64,7 +65,17 @@ func (p *APISession) GetContextID() (string, error) {
 }
 
 func (p *APISession) SetLastElementBox(box *BoxInfo) {
-	p.Session.SetLastElementBox(box)
+	p.Session.setElementBox(p.Context, box)
+}
+
+// sleep lets polling helpers stop waiting when the command is cancelled.
+func (p *APISession) sleep(d time.Duration, waitingFor string) error {
+	return p.Session.sleep(p.Context, d)
+}
+
+// traceSpan nests actionability retries under the command's span.
+func (p *APISession) traceSpan() *tracing.Span {
+	return p.Session.commandSpan(p.Context)
 }
 
 // ---------------------------------------------------------------------------
@@ -79,6 +90,18 @@ type AgentSession struct {
 	Client   *bidi.Client
 	Context  string              // optional explicit context override (active tab)
 	OnBoxSet func(box *BoxInfo)  // optional callback when element box is set
+	Span     *tracing.Span       // optional span of the tool call being run
+
+	// Wait, if set, replaces the sleep in polling loops so the tool call can
+	// be cancelled and report progress. waitingFor describes what the loop
+	// waits for.
+	Wait func(d time.Duration, waitingFor string) error
+
+	// Nodes maps selectors that stand for a known element to its BiDi
+	// shared ID. A script argument equal to one is sent as a reference to
+	// the element itself, so the script acts on that element rather than on
+	// whatever now matches the selector (see querySelectorDeep).
+	Nodes map[string]string
 }
 
 // NewAgentSession creates an AgentSession.
@@ -87,7 +110,7 @@ func NewAgentSession(client *bidi.Client) *AgentSession {
 }
 
 func (m *AgentSession) SendBidiCommand(method string, params map[string]interface{}) (json.RawMessage, error) {
-	msg, err := m.Client.SendCommand(method, params)
+	msg, err := m.Client.SendCommand(method, m.withNodes(method, params))
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +124,7 @@ func (m *AgentSession) SendBidiCommand(method string, params map[string]interfac
 }
 
 func (m *AgentSession) SendBidiCommandWithTimeout(method string, params map[string]interface{}, timeout time.Duration) (json.RawMessage, error) {
-	msg, err := m.Client.SendCommandWithTimeout(method, params, timeout)
+	msg, err := m.Client.SendCommandWithTimeout(method, m.withNodes(method, params), timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +135,65 @@ func (m *AgentSession) SendBidiCommandWithTimeout(method string, params map[stri
 	return wrapped, nil
 }
 
+// withNodes returns the params of a script.callFunction with the string
+// arguments found in Nodes replaced by shared references. params is copied,
+// not changed.
+func (m *AgentSession) withNodes(method string, params map[string]interface{}) map[string]interface{} {
+	if method != "script.callFunction" || len(m.Nodes) == 0 {
+		return params
+	}
+	var args []map[string]interface{}
+	switch v := params["arguments"].(type) {
+	case []map[string]interface{}:
+		args = v
+	case []interface{}:
+		for _, arg := range v {
+			if a, ok := arg.(map[string]interface{}); ok {
+				args = append(args, a)
+			} else {
+				return params
+			}
+		}
+	default:
+		return params
+	}
+
+	replaced := make([]map[string]interface{}, len(args))
+	changed := false
+	for i, arg := range args {
+		replaced[i] = arg
+		if arg["type"] != "string" {
+			continue
+		}
+		value, _ := arg["value"].(string)
+		if id, ok := m.Nodes[value]; ok {
+			replaced[i] = map[string]interface{}{"sharedId": id}
+			changed = true
+		}
+	}
+	if !changed {
+		return params
+	}
+	out := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+	out["arguments"] = replaced
+	return out
+}
+
+func (m *AgentSession) sleep(d time.Duration, waitingFor string) error {
+	if m.Wait != nil {
+		return m.Wait(d, waitingFor)
+	}
+	time.Sleep(d)
+	return nil
+}
+
+func (m *AgentSession) traceSpan() *tracing.Span {
+	return m.Span
+}
+
 func (m *AgentSession) SetLastElementBox(box *BoxInfo) {
 	if m.OnBoxSet != nil {
 		m.OnBoxSet(box)
@@ -0,0 +1,78 @@
+package api
+
+// Shadow DOM: element lookups reach into open shadow roots, so the
+// buttons of web-component apps (Lit, Shoelace, LWC) can be found and
+// acted on.
+//
+// A plain CSS selector is looked up in the light DOM first and, if nothing
+// matches there, in every open shadow root (the selector is matched within
+// each root; combinators don't cross into it). ">>>" steps into the shadow
+// root of the element matched so far, at any depth below it:
+// "sl-dialog >>> button.close". GetSelectorJS in the agent package writes
+// paths in the same form. Closed shadow roots can't be reached. Selectors
+// may also be locators, see locator.go.
+
+// ShadowDOMJS returns the JS helpers scripts use instead of querySelector
+// and querySelectorAll: querySelectorDeep(root, selector),
+// querySelectorAllDeep(root, selector), which also take locators, and
+// walkDeep(root, fn), which calls fn for every element under root, inside
+// open shadow roots too, in tree order. Where a selector is expected, the
+// element itself may be passed instead.
+func ShadowDOMJS() string {
+	return `
+			function walkDeep(root, fn) {
+				const visit = (node) => {
+					if (node.shadowRoot) visit(node.shadowRoot);
+					for (const child of node.children) {
+						fn(child);
+						visit(child);
+					}
+				};
+				visit(root);
+			}
+
+			function openShadowRoots(root) {
+				const roots = [];
+				if (root.shadowRoot) roots.push(root.shadowRoot);
+				walkDeep(root, (el) => { if (el.shadowRoot) roots.push(el.shadowRoot); });
+				return roots;
+			}
+
+			// The matches of one ">>>"-free selector: the light DOM's or, if
+			// there are none, those in open shadow roots.
+			function queryAllPiercing(root, selector) {
+				const found = Array.from(root.querySelectorAll(selector));
+				if (found.length > 0) return found;
+				for (const shadow of openShadowRoots(root)) {
+					for (const el of shadow.querySelectorAll(selector)) found.push(el);
+				}
+				return found;
+			}
+
+			// cssQueryAllDeep is querySelectorAllDeep for plain CSS.
+			function cssQueryAllDeep(root, selector) {
+				const parts = String(selector).split('>>>').map(s => s.trim());
+				let found = queryAllPiercing(root, parts[0]);
+				for (const part of parts.slice(1)) {
+					const next = [];
+					for (const host of found) {
+						if (!host.shadowRoot) continue;
+						for (const el of queryAllPiercing(host.shadowRoot, part)) {
+							if (!next.includes(el)) next.push(el);
+						}
+					}
+					found = next;
+				}
+				return found;
+			}
+
+			function querySelectorDeep(root, selector) {
+				if (selector && selector.nodeType === 1) return querySelectorAllDeep(root, selector)[0] || null;
+				if (!String(selector).includes('>>') && locIsPlainCSS(String(selector))) {
+					const el = root.querySelector(selector);
+					if (el) return el;
+				}
+				return querySelectorAllDeep(root, selector)[0] || null;
+			}
+	` + locatorJS()
+}
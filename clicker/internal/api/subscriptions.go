@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// eventSubscription is one vibium:session.subscribe filter. An event is
+// forwarded to the client if any of the session's subscriptions matches it.
+type eventSubscription struct {
+	id       string   // the browser's subscription ID
+	events   []string // event names ("network.responseCompleted") or modules ("network")
+	contexts []string // browsing contexts; empty = all
+	urls     []string // URL patterns (see matchesPattern); empty = all
+}
+
+// matches reports whether the subscription selects the given event.
+func (s *eventSubscription) matches(ev *browserEvent) bool {
+	if !s.matchesName(ev.Method) {
+		return false
+	}
+	if len(s.contexts) > 0 && !containsString(s.contexts, ev.context()) {
+		return false
+	}
+	// Events that carry no URL (console logs, dialogs) pass a URL filter
+	if url := ev.url(); len(s.urls) > 0 && url != "" {
+		for _, pattern := range s.urls {
+			if matchesPattern(url, pattern) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (s *eventSubscription) matchesName(method string) bool {
+	for _, name := range s.events {
+		if method == name || strings.HasPrefix(method, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// browserEvent holds the fields of a BiDi event the subscription filters look at.
+type browserEvent struct {
+	Method string `json:"method"`
+	Params struct {
+		Context   string `json:"context"`
+		URL       string `json:"url"`
+		IsBlocked bool   `json:"isBlocked"`
+		Source    struct {
+			Context string `json:"context"`
+		} `json:"source"`
+		Request struct {
+			URL string `json:"url"`
+		} `json:"request"`
+	} `json:"params"`
+}
+
+func (ev *browserEvent) context() string {
+	if ev.Params.Context != "" {
+		return ev.Params.Context
+	}
+	return ev.Params.Source.Context
+}
+
+func (ev *browserEvent) url() string {
+	if ev.Params.URL != "" {
+		return ev.Params.URL
+	}
+	return ev.Params.Request.URL
+}
+
+// wantsEvent reports whether a message from the browser should be forwarded
+// to the client. Until the client calls vibium:session.subscribe, and again
+// once it has unsubscribed from all it subscribed to, everything is
+// forwarded. Command responses always are, and so are requests paused by
+// an intercept, since the page hangs until the client answers them.
+func (s *BrowserSession) wantsEvent(msg string) bool {
+	s.mu.Lock()
+	filtered := s.eventFilter
+	subs := s.eventSubs
+	s.mu.Unlock()
+	if !filtered {
+		return true
+	}
+
+	var ev browserEvent
+	if json.Unmarshal([]byte(msg), &ev) != nil || ev.Method == "" {
+		return true
+	}
+	if ev.Params.IsBlocked {
+		return true
+	}
+	for _, sub := range subs {
+		if sub.matches(&ev) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSessionSubscribe handles vibium:session.subscribe — selects which
+// browser events reach the client. Params: events (names or modules, e.g.
+// "log" or "network.responseCompleted"), optional contexts and urls filters.
+// The events are also subscribed in the browser, so modules outside the
+// proxy's default set can be requested too.
+func (r *Router) handleSessionSubscribe(session *BrowserSession, cmd bidiCommand) {
+	events := stringList(cmd.Params["events"])
+	if len(events) == 0 {
+		r.sendError(session, cmd.ID, fmt.Errorf("events is required"))
+		return
+	}
+	contexts := stringList(cmd.Params["contexts"])
+	urls := stringList(cmd.Params["urls"])
+
+	params := map[string]interface{}{"events": events}
+	if len(contexts) > 0 {
+		params["contexts"] = contexts
+	}
+	resp, err := r.sendInternalCommand(session, "session.subscribe", params)
+	if err != nil {
+		r.sendError(session, cmd.ID, err)
+		return
+	}
+	if bidiErr := checkBidiError(resp); bidiErr != nil {
+		r.sendError(session, cmd.ID, bidiErr)
+		return
+	}
+	var result struct {
+		Result struct {
+			Subscription string `json:"subscription"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil || result.Result.Subscription == "" {
+		r.sendError(session, cmd.ID, fmt.Errorf("failed to parse session.subscribe response"))
+		return
+	}
+	r.trackSubscription(session, resp)
+
+	sub := &eventSubscription{
+		id:       result.Result.Subscription,
+		events:   events,
+		contexts: contexts,
+		urls:     urls,
+	}
+	session.mu.Lock()
+	session.eventFilter = true
+	session.eventSubs = append(session.eventSubs, sub)
+	session.mu.Unlock()
+
+	r.sendSuccess(session, cmd.ID, map[string]interface{}{"subscription": sub.id})
+}
+
+// handleSessionUnsubscribe handles vibium:session.unsubscribe — removes a
+// subscription made with vibium:session.subscribe. Events it selected stop
+// reaching the client; the proxy's own subscriptions are unaffected.
+func (r *Router) handleSessionUnsubscribe(session *BrowserSession, cmd bidiCommand) {
+	id, _ := cmd.Params["subscription"].(string)
+	if id == "" {
+		r.sendError(session, cmd.ID, fmt.Errorf("subscription is required"))
+		return
+	}
+
+	session.mu.Lock()
+	found := false
+	for _, sub := range session.eventSubs {
+		if sub.id == id {
+			found = true
+			break
+		}
+	}
+	session.mu.Unlock()
+	if !found {
+		r.sendError(session, cmd.ID, fmt.Errorf("no such subscription: %s", id))
+		return
+	}
+
+	resp, err := r.sendInternalCommand(session, "session.unsubscribe", map[string]interface{}{
+		"subscriptions": []string{id},
+	})
+	if err != nil {
+		r.sendError(session, cmd.ID, err)
+		return
+	}
+	if bidiErr := checkBidiError(resp); bidiErr != nil {
+		r.sendError(session, cmd.ID, bidiErr)
+		return
+	}
+
+	session.mu.Lock()
+	session.eventSubs = removeSubscription(session.eventSubs, id)
+	session.subscriptionIDs = removeString(session.subscriptionIDs, id)
+	// With its last filter gone the client gets everything again
+	session.eventFilter = len(session.eventSubs) > 0
+	session.mu.Unlock()
+
+	r.sendSuccess(session, cmd.ID, map[string]interface{}{})
+}
+
+// stringList converts a JSON array param to a []string, skipping non-strings.
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeSubscription(subs []*eventSubscription, id string) []*eventSubscription {
+	out := subs[:0:0]
+	for _, sub := range subs {
+		if sub.id != id {
+			out = append(out, sub)
+		}
+	}
+	return out
+}
@@ -46,8 +46,15 @@ type EvaluateResult struct {
 
 // RemoteValue represents a value returned from script evaluation.
 type RemoteValue struct {
-	Type  string      `json:"type"`
-	Value interface{} `json:"value,omitempty"`
+	Type     string      `json:"type"`
+	Value    interface{} `json:"value,omitempty"`
+	SharedID string      `json:"sharedId,omitempty"` // set for nodes
+}
+
+// SharedReference is a function argument that refers to a DOM node by its
+// BiDi shared ID.
+type SharedReference struct {
+	SharedID string
 }
 
 // Evaluate evaluates a JavaScript expression and returns the result.
@@ -102,6 +109,16 @@ func (c *Client) Evaluate(context, expression string) (interface{}, error) {
 // CallFunction calls a JavaScript function with arguments.
 // If context is empty, it uses the first available context.
 func (c *Client) CallFunction(context, functionDeclaration string, args []interface{}) (interface{}, error) {
+	remoteValue, err := c.CallFunctionRemote(context, functionDeclaration, args)
+	if err != nil {
+		return nil, err
+	}
+	return remoteValue.Value, nil
+}
+
+// CallFunctionRemote is CallFunction returning the whole remote value, so
+// nodes in the result come with their shared IDs.
+func (c *Client) CallFunctionRemote(context, functionDeclaration string, args []interface{}) (*RemoteValue, error) {
 	// If no context provided, get the first one from the tree
 	if context == "" {
 		tree, err := c.GetTree()
@@ -152,7 +169,7 @@ func (c *Client) CallFunction(context, functionDeclaration string, args []interf
 		return nil, fmt.Errorf("failed to parse remote value: %w", err)
 	}
 
-	return remoteValue.Value, nil
+	return &remoteValue, nil
 }
 
 // serializeValue converts a Go value to a BiDi serialized value.
@@ -166,6 +183,8 @@ func serializeValue(v interface{}) map[string]interface{} {
 		return map[string]interface{}{"type": "number", "value": val}
 	case string:
 		return map[string]interface{}{"type": "string", "value": val}
+	case SharedReference:
+		return map[string]interface{}{"sharedId": val.SharedID}
 	default:
 		// For complex types, try to serialize as string
 		return map[string]interface{}{"type": "string", "value": fmt.Sprintf("%v", val)}
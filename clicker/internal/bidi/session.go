@@ -3,16 +3,41 @@ package bidi
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
+
+	errs "github.com/vibium/clicker/internal/errors"
+	"github.com/vibium/clicker/internal/tracing"
 )
 
 // Client is a BiDi client that wraps a WebSocket connection.
 type Client struct {
-	conn         *Connection
-	verbose      bool
+	conn    *Connection
+	verbose bool
+	span    *tracing.Span   // parent of command spans; nil = none
+	abort   <-chan struct{} // stops waiting for responses when closed; nil = never
+
+	handlerMu    sync.Mutex
 	eventHandler func(msg string) // optional callback for BiDi events
+
+	readMu sync.Mutex         // held while reading from conn
+	stray  map[int64]*Message // responses read while waiting for another command; guarded by readMu
+
+	noWaitMu sync.Mutex
+	noWait   map[int64]bool // IDs of commands sent with SendCommandNoWait
+
+	// Set by Listen: the reader goroutine hands responses to their
+	// waiters instead of commands reading the connection themselves
+	waitMu     sync.Mutex
+	waiters    map[int64]chan *Message
+	listening  bool
+	listenDone chan struct{} // closed when the reader stops
+	listenErr  error         // why it stopped; set before listenDone is closed
 }
 
+// maxStray bounds the responses kept for commands nobody is waiting for yet.
+const maxStray = 64
+
 // NewClient creates a new BiDi client from a WebSocket connection.
 func NewClient(conn *Connection) *Client {
 	return &Client{conn: conn}
@@ -24,9 +49,107 @@ func (c *Client) SetVerbose(verbose bool) {
 }
 
 // SetEventHandler sets a callback for BiDi events received while waiting
-// for command responses. Pass nil to stop forwarding events.
+// for command responses, or at any time once Listen was called. Pass nil to
+// stop forwarding events.
 func (c *Client) SetEventHandler(handler func(msg string)) {
+	c.handlerMu.Lock()
 	c.eventHandler = handler
+	c.handlerMu.Unlock()
+}
+
+// handleEvent passes an event to the event handler, if any.
+func (c *Client) handleEvent(msg string) {
+	c.handlerMu.Lock()
+	handler := c.eventHandler
+	c.handlerMu.Unlock()
+	if handler != nil {
+		handler(msg)
+	}
+}
+
+// Listen starts a goroutine that reads every message from the connection
+// from now on, so events reach the event handler between commands too, not
+// only while a command waits for its response. Without it, a request
+// blocked by an intercept while no command is in flight waits for the next
+// command. The handler runs on that goroutine, so it must not wait for a
+// command's response (SendCommandNoWait is fine). Nothing else may read the
+// connection once Listen was called.
+func (c *Client) Listen() {
+	c.waitMu.Lock()
+	defer c.waitMu.Unlock()
+	if c.listening {
+		return
+	}
+	c.listening = true
+	c.waiters = make(map[int64]chan *Message)
+	c.listenDone = make(chan struct{})
+	go c.readLoop()
+}
+
+// readLoop reads messages until the connection fails, handing responses to
+// their commands and events to the event handler.
+func (c *Client) readLoop() {
+	// Any command reading the connection itself has finished, or is given
+	// its response below
+	c.readMu.Lock()
+	stray := c.stray
+	c.stray = nil
+	c.readMu.Unlock()
+	for id, msg := range stray {
+		c.deliver(id, msg)
+	}
+
+	for {
+		resp, err := c.conn.Receive()
+		if err != nil {
+			c.waitMu.Lock()
+			c.listenErr = err
+			close(c.listenDone)
+			c.waitMu.Unlock()
+			return
+		}
+		if c.verbose {
+			fmt.Printf("       <-- %s\n", resp)
+		}
+		msg, err := UnmarshalMessage([]byte(resp))
+		if err != nil {
+			continue
+		}
+		if msg.IsEvent() {
+			c.handleEvent(resp)
+			continue
+		}
+		if msg.ID != nil && !c.discard(*msg.ID) {
+			c.deliver(*msg.ID, msg)
+		}
+	}
+}
+
+// deliver hands a response to the command waiting for it. A response
+// nobody waits for any more (the command timed out or was cancelled) is
+// dropped.
+func (c *Client) deliver(id int64, msg *Message) {
+	c.waitMu.Lock()
+	ch, ok := c.waiters[id]
+	delete(c.waiters, id)
+	c.waitMu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// SetSpan makes every command sent from now on a child span of parent. Pass
+// nil to stop tracing commands.
+func (c *Client) SetSpan(parent *tracing.Span) {
+	c.span = parent
+}
+
+// SetAbort makes commands stop waiting for their response, with a
+// CancelledError, once abort is closed. The abandoned response is still read
+// in the background so the connection stays in sync; the next command waits
+// for that. Pass nil to wait normally.
+func (c *Client) SetAbort(abort <-chan struct{}) {
+	c.abort = abort
 }
 
 // defaultCommandTimeout is the maximum time to wait for a BiDi command response.
@@ -39,6 +162,48 @@ func (c *Client) SendCommand(method string, params interface{}) (*Message, error
 
 // SendCommandWithTimeout sends a BiDi command and waits for the response with a custom timeout.
 func (c *Client) SendCommandWithTimeout(method string, params interface{}, timeout time.Duration) (*Message, error) {
+	if c.span == nil {
+		return c.sendCommand(method, params, timeout)
+	}
+	span := tracing.Start(method, tracing.KindClient, c.span)
+	span.SetAttr("rpc.system", "webdriver-bidi")
+	msg, err := c.sendCommand(method, params, timeout)
+	span.End(err)
+	return msg, err
+}
+
+// SendCommandNoWait sends a BiDi command without waiting for its response,
+// which is discarded when it arrives. Event handlers use it to answer events
+// such as blocked requests, since they run while another command is waiting.
+func (c *Client) SendCommandNoWait(method string, params interface{}) error {
+	cmd := NewCommand(method, params)
+
+	data, err := cmd.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal command: %w", err)
+	}
+
+	if c.verbose {
+		fmt.Printf("       --> %s\n", string(data))
+	}
+
+	c.noWaitMu.Lock()
+	if c.noWait == nil {
+		c.noWait = make(map[int64]bool)
+	}
+	c.noWait[cmd.ID] = true
+	c.noWaitMu.Unlock()
+
+	if err := c.conn.Send(string(data)); err != nil {
+		c.noWaitMu.Lock()
+		delete(c.noWait, cmd.ID)
+		c.noWaitMu.Unlock()
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) sendCommand(method string, params interface{}, timeout time.Duration) (*Message, error) {
 	cmd := NewCommand(method, params)
 
 	data, err := cmd.Marshal()
@@ -50,15 +215,99 @@ func (c *Client) SendCommandWithTimeout(method string, params interface{}, timeo
 		fmt.Printf("       --> %s\n", string(data))
 	}
 
+	if c.isListening() {
+		return c.sendListening(cmd.ID, method, string(data), timeout)
+	}
+
 	if err := c.conn.Send(string(data)); err != nil {
 		return nil, fmt.Errorf("failed to send command: %w", err)
 	}
 
+	if c.abort == nil {
+		return c.awaitResponse(cmd.ID, method, timeout)
+	}
+
+	type response struct {
+		msg *Message
+		err error
+	}
+	done := make(chan response, 1)
+	go func() {
+		msg, err := c.awaitResponse(cmd.ID, method, timeout)
+		done <- response{msg, err}
+	}()
+	select {
+	case r := <-done:
+		return r.msg, r.err
+	case <-c.abort:
+		return nil, &errs.CancelledError{Method: method}
+	}
+}
+
+func (c *Client) isListening() bool {
+	c.waitMu.Lock()
+	defer c.waitMu.Unlock()
+	return c.listening
+}
+
+// sendListening sends a command once Listen was called and waits for the
+// reader goroutine to hand over its response.
+func (c *Client) sendListening(id int64, method, data string, timeout time.Duration) (*Message, error) {
+	ch := make(chan *Message, 1)
+	c.waitMu.Lock()
+	select {
+	case <-c.listenDone:
+		err := c.listenErr
+		c.waitMu.Unlock()
+		return nil, fmt.Errorf("failed to receive response: %w", err)
+	default:
+	}
+	c.waiters[id] = ch
+	c.waitMu.Unlock()
+	forget := func() {
+		c.waitMu.Lock()
+		delete(c.waiters, id)
+		c.waitMu.Unlock()
+	}
+
+	if err := c.conn.Send(data); err != nil {
+		forget()
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ch:
+		return commandResult(msg)
+	case <-c.listenDone:
+		forget()
+		return nil, fmt.Errorf("failed to receive response: %w", c.listenErr)
+	case <-timer.C:
+		forget()
+		return nil, &errs.TimeoutError{Selector: method, Timeout: timeout}
+	case <-c.abort:
+		forget()
+		return nil, &errs.CancelledError{Method: method}
+	}
+}
+
+// awaitResponse reads from the connection until the response to command id
+// arrives, forwarding events and keeping other commands' responses for them.
+func (c *Client) awaitResponse(id int64, method string, timeout time.Duration) (*Message, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if msg, ok := c.stray[id]; ok {
+		delete(c.stray, id)
+		return commandResult(msg)
+	}
+
 	// Wait for response with matching ID (with timeout)
 	deadline := time.Now().Add(timeout)
 	for {
 		if time.Now().After(deadline) {
-			return nil, fmt.Errorf("timeout waiting for response to %s after %s", method, timeout)
+			return nil, &errs.TimeoutError{Selector: method, Timeout: timeout}
 		}
 
 		resp, err := c.conn.Receive()
@@ -76,15 +325,8 @@ func (c *Client) SendCommandWithTimeout(method string, params interface{}, timeo
 		}
 
 		// Check if this is the response we're waiting for
-		if msg.ID != nil && *msg.ID == cmd.ID {
-			if msg.IsError() {
-				errData, _ := msg.GetError()
-				if errData != nil {
-					return nil, fmt.Errorf("BiDi error: %s - %s", errData.Error, errData.Message)
-				}
-				return nil, fmt.Errorf("BiDi error: %s", string(msg.Error))
-			}
-			return msg, nil
+		if msg.ID != nil && *msg.ID == id {
+			return commandResult(msg)
 		}
 
 		// If it's an event, forward to handler if set, otherwise skip
@@ -92,12 +334,63 @@ func (c *Client) SendCommandWithTimeout(method string, params interface{}, timeo
 			if c.verbose {
 				fmt.Printf("       (event, skipping)\n")
 			}
-			if c.eventHandler != nil {
-				c.eventHandler(resp)
-			}
+			c.handleEvent(resp)
 			continue
 		}
+
+		if msg.ID != nil && c.discard(*msg.ID) {
+			continue
+		}
+
+		// A response to a command sent while an aborted one was still
+		// being read; keep it for its sender
+		if msg.ID != nil {
+			if c.stray == nil {
+				c.stray = make(map[int64]*Message)
+			}
+			if len(c.stray) >= maxStray {
+				c.dropOldestStray()
+			}
+			c.stray[*msg.ID] = msg
+		}
+	}
+}
+
+// dropOldestStray forgets the kept response of the earliest command (IDs
+// are handed out in order), whose sender has most likely given up on it.
+// The caller holds readMu.
+func (c *Client) dropOldestStray() {
+	oldest := int64(-1)
+	for id := range c.stray {
+		if oldest < 0 || id < oldest {
+			oldest = id
+		}
+	}
+	delete(c.stray, oldest)
+}
+
+// discard reports whether id belongs to a SendCommandNoWait command, and
+// forgets it.
+func (c *Client) discard(id int64) bool {
+	c.noWaitMu.Lock()
+	defer c.noWaitMu.Unlock()
+	if !c.noWait[id] {
+		return false
+	}
+	delete(c.noWait, id)
+	return true
+}
+
+// commandResult turns a BiDi error response into an error.
+func commandResult(msg *Message) (*Message, error) {
+	if msg.IsError() {
+		errData, _ := msg.GetError()
+		if errData != nil {
+			return nil, fmt.Errorf("BiDi error: %w", &errs.ProtocolError{Code: errData.Error, Message: errData.Message})
+		}
+		return nil, fmt.Errorf("BiDi error: %s", string(msg.Error))
 	}
+	return msg, nil
 }
 
 // SessionStatusResult represents the result of session.status command.
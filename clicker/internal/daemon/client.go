@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/vibium/clicker/internal/agent"
+	errs "github.com/vibium/clicker/internal/errors"
 	"github.com/vibium/clicker/internal/paths"
 )
 
@@ -33,6 +34,9 @@ func Call(toolName string, args map[string]interface{}) (*agent.ToolsCallResult,
 	}
 
 	if resp.Error != nil {
+		if resp.Error.Code == agent.ToolError {
+			return nil, toolError(resp.Error)
+		}
 		return nil, fmt.Errorf("daemon error: %s", resp.Error.Message)
 	}
 
@@ -57,6 +61,19 @@ func Call(toolName string, args map[string]interface{}) (*agent.ToolsCallResult,
 	return &result, nil
 }
 
+// toolError rebuilds a failed tool call's error, keeping its error code so
+// callers can still tell failures apart with errors.Code.
+func toolError(e *agent.Error) error {
+	var data agent.ToolErrorData
+	if raw, err := json.Marshal(e.Data); err == nil {
+		json.Unmarshal(raw, &data)
+	}
+	if data.Code == "" {
+		data.Code = errs.CodeUnknown
+	}
+	return &errs.CodedError{Code: data.Code, Message: e.Message}
+}
+
 // Status sends a daemon/status request and returns the result.
 func Status() (*StatusResult, error) {
 	resp, err := sendRequest("daemon/status", nil)
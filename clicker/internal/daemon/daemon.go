@@ -38,12 +38,19 @@ type Options struct {
 	IdleTimeout    time.Duration
 	ConnectURL     string      // Remote BiDi WebSocket URL (empty = local browser)
 	ConnectHeaders http.Header // Headers for remote WebSocket connection
+	Policy         *agent.NavigationPolicy // URLs pages may load (nil = any)
+	Tools          *agent.ToolFilter       // tools offered (nil = all)
+	Confirm        *agent.ConfirmPolicy    // actions that need a human's approval (nil = none)
 }
 
 // New creates a new Daemon instance.
 func New(opts Options) *Daemon {
+	handlers := agent.NewHandlers(opts.ScreenshotDir, opts.Headless, opts.ConnectURL, opts.ConnectHeaders)
+	handlers.SetNavigationPolicy(opts.Policy)
+	handlers.SetToolFilter(opts.Tools)
+	handlers.SetConfirmPolicy(opts.Confirm)
 	return &Daemon{
-		handlers:     agent.NewHandlers(opts.ScreenshotDir, opts.Headless, opts.ConnectURL, opts.ConnectHeaders),
+		handlers:     handlers,
 		version:      opts.Version,
 		idleTimeout:  opts.IdleTimeout,
 		startTime:    time.Now(),
@@ -10,6 +10,7 @@ import (
 
 	"github.com/vibium/clicker/internal/log"
 	"github.com/vibium/clicker/internal/agent"
+	errs "github.com/vibium/clicker/internal/errors"
 )
 
 // StatusResult is returned by daemon/status.
@@ -116,10 +117,10 @@ func (d *Daemon) route(req agent.Request) (interface{}, *agent.Error) {
 		return d.handleToolsCall(req.Params)
 	case "tools/list":
 		return agent.ToolsListResult{
-			Tools: agent.GetToolSchemas(),
+			Tools: d.handlers.ToolSchemas(),
 		}, nil
 	case "initialize":
-		return d.handleInitialize()
+		return d.handleInitialize(req.Params)
 	case "initialized", "notifications/initialized":
 		return nil, nil
 	default:
@@ -143,9 +144,19 @@ func (d *Daemon) handleStatus() (interface{}, *agent.Error) {
 }
 
 // handleInitialize handles the MCP initialize request.
-func (d *Daemon) handleInitialize() (interface{}, *agent.Error) {
+func (d *Daemon) handleInitialize(params json.RawMessage) (interface{}, *agent.Error) {
+	var p agent.InitializeParams
+	if params != nil {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &agent.Error{
+				Code:    agent.InvalidParams,
+				Message: "Invalid params",
+				Data:    err.Error(),
+			}
+		}
+	}
 	return agent.InitializeResult{
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: agent.NegotiateProtocolVersion(p.ProtocolVersion),
 		Capabilities: agent.ServerCapabilities{
 			Tools: &agent.ToolsCapability{},
 		},
@@ -169,14 +180,15 @@ func (d *Daemon) handleToolsCall(params json.RawMessage) (interface{}, *agent.Er
 
 	// Serialize handler access — handlers are not thread-safe
 	d.mu.Lock()
-	result, err := d.handlers.Call(p.Name, p.Arguments)
+	result, err := d.handlers.CallWithOptions(p.Name, p.Arguments, agent.CallOptions{TraceParent: p.TraceParent()})
 	d.mu.Unlock()
 
 	if err != nil {
-		return agent.ToolsCallResult{
-			Content: []agent.Content{{Type: "text", Text: err.Error()}},
-			IsError: true,
-		}, nil
+		return nil, &agent.Error{
+			Code:    agent.ToolError,
+			Message: err.Error(),
+			Data:    agent.ToolErrorData{Code: errs.Code(err)},
+		}
 	}
 
 	return result, nil
@@ -2,10 +2,29 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"time"
 )
 
+// Stable error codes reported to clients in BiDi error responses, MCP tool
+// results and daemon JSON-RPC errors. Codes that exist in WebDriver (BiDi or
+// classic) use the spec's wording; the rest are vibium extensions.
+const (
+	CodeNoSuchElement       = "no such element"
+	CodeTimeout             = "timeout"
+	CodeNotInteractable     = "element not interactable"
+	CodeStrictModeViolation = "strict mode violation"
+	CodeStaleElement        = "stale element reference"
+	CodeBrowserCrashed      = "browser crashed"
+	CodeConnectionFailed    = "connection failed"
+	CodeCancelled           = "cancelled"
+	CodeNavigationBlocked   = "navigation blocked"
+	CodeActionDeclined      = "action declined"
+	CodeUnknownCommand      = "unknown command"
+	CodeUnknown             = "unknown error"
+)
+
 // ConnectionError is returned when a connection to the browser fails.
 type ConnectionError struct {
 	URL   string
@@ -40,10 +59,14 @@ func (e *TimeoutError) Error() string {
 // ElementNotFoundError is returned when a selector matches no elements.
 type ElementNotFoundError struct {
 	Selector string
-	Context  string // browsing context ID
+	Context  string        // browsing context ID
+	Timeout  time.Duration // set when we polled for the element until a deadline
 }
 
 func (e *ElementNotFoundError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("timeout after %s waiting for '%s': element not found", e.Timeout, e.Selector)
+	}
 	if e.Context != "" {
 		return fmt.Sprintf("element not found: %s (context: %s)", e.Selector, e.Context)
 	}
@@ -57,8 +80,155 @@ type BrowserCrashedError struct {
 }
 
 func (e *BrowserCrashedError) Error() string {
-	if e.Output != "" {
+	switch {
+	case e.ExitCode == 0 && e.Output != "":
+		// Exit code unknown (e.g. we only saw the connection drop)
+		return fmt.Sprintf("browser crashed: %s", e.Output)
+	case e.ExitCode == 0:
+		return "browser crashed"
+	case e.Output != "":
 		return fmt.Sprintf("browser crashed with exit code %d: %s", e.ExitCode, e.Output)
 	}
 	return fmt.Sprintf("browser crashed with exit code %d", e.ExitCode)
 }
+
+// NotActionableError is returned when an element was found but kept failing
+// an actionability check (visible, enabled, receives events, ...) until the
+// timeout.
+type NotActionableError struct {
+	Selector string
+	Timeout  time.Duration
+	Check    string // the failing check, e.g. "visible"
+	Reason   string
+}
+
+func (e *NotActionableError) Error() string {
+	return fmt.Sprintf("timeout after %s: %s check failed — %s", e.Timeout, e.Check, e.Reason)
+}
+
+// StrictModeViolationError is returned when a strict locator matches more
+// than one element.
+type StrictModeViolationError struct {
+	Selector string
+	Count    int
+}
+
+func (e *StrictModeViolationError) Error() string {
+	return fmt.Sprintf("strict mode violation: '%s' resolved to %d elements", e.Selector, e.Count)
+}
+
+// CancelledError is returned when a client aborts an in-flight command.
+type CancelledError struct {
+	Method string
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("%s cancelled", e.Method)
+}
+
+// NavigationBlockedError is returned when the navigation policy stops a
+// page from loading a URL.
+type NavigationBlockedError struct {
+	URL    string
+	Reason string // e.g. "not in the allowed domains"
+}
+
+func (e *NavigationBlockedError) Error() string {
+	return fmt.Sprintf("navigation to %s blocked by policy: %s", e.URL, e.Reason)
+}
+
+// ActionDeclinedError is returned when a human declined, or couldn't be
+// asked to approve, a sensitive action.
+type ActionDeclinedError struct {
+	Action string
+	Reason string // why there was no answer; "" if declined
+}
+
+func (e *ActionDeclinedError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("action not confirmed: %s: %s", e.Action, e.Reason)
+	}
+	return fmt.Sprintf("action declined: %s", e.Action)
+}
+
+// StaleRefError is returned when an @ref from browser_map (or find) no
+// longer matches the element it was taken from, and that element can't be
+// found again.
+type StaleRefError struct {
+	Ref    string
+	Reason string // e.g. "the element was removed"
+}
+
+func (e *StaleRefError) Error() string {
+	return fmt.Sprintf("stale ref %s: %s — re-run map to get fresh refs", e.Ref, e.Reason)
+}
+
+// ProtocolError is an error response from the browser's BiDi endpoint.
+// Code is the BiDi error code, e.g. "no such frame" or "invalid argument".
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// CodedError carries an error code across a process boundary (e.g. from the
+// daemon to the CLI), where the original typed error is lost.
+type CodedError struct {
+	Code    string
+	Message string
+}
+
+func (e *CodedError) Error() string {
+	return e.Message
+}
+
+// Code returns the stable error code for err, looking through wrapped errors.
+// Untyped errors map to CodeUnknown.
+func Code(err error) string {
+	var (
+		notFound   *ElementNotFoundError
+		timeout    *TimeoutError
+		notAction  *NotActionableError
+		strict     *StrictModeViolationError
+		stale      *StaleRefError
+		crashed    *BrowserCrashedError
+		cancelled  *CancelledError
+		blocked    *NavigationBlockedError
+		declined   *ActionDeclinedError
+		connection *ConnectionError
+		protocol   *ProtocolError
+		coded      *CodedError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case stderrors.As(err, &coded):
+		return coded.Code
+	case stderrors.As(err, &notFound):
+		return CodeNoSuchElement
+	case stderrors.As(err, &notAction):
+		return CodeNotInteractable
+	case stderrors.As(err, &strict):
+		return CodeStrictModeViolation
+	case stderrors.As(err, &stale):
+		return CodeStaleElement
+	case stderrors.As(err, &timeout):
+		return CodeTimeout
+	case stderrors.As(err, &cancelled):
+		return CodeCancelled
+	case stderrors.As(err, &blocked):
+		return CodeNavigationBlocked
+	case stderrors.As(err, &declined):
+		return CodeActionDeclined
+	case stderrors.As(err, &crashed):
+		return CodeBrowserCrashed
+	case stderrors.As(err, &connection):
+		return CodeConnectionFailed
+	case stderrors.As(err, &protocol) && protocol.Code != "":
+		return protocol.Code
+	}
+	return CodeUnknown
+}
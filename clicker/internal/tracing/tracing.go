@@ -0,0 +1,260 @@
+// Package tracing records OpenTelemetry-compatible spans for vibium commands
+// and exports them as OTLP/JSON, either over OTLP/HTTP or to a file.
+//
+// Tracing is off until Setup is called with an exporter. While it's off, Start
+// returns a nil *Span and every Span method is a no-op, so callers don't need
+// to check.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+const (
+	batchSize     = 256
+	flushInterval = 2 * time.Second
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(spans []*Span) error
+	Close() error
+}
+
+var (
+	mu       sync.Mutex
+	exporter Exporter
+	queue    []*Span
+	stop     chan struct{}
+	stopped  chan struct{}
+	flushes  sync.WaitGroup // flushes enqueue started for full batches
+)
+
+// Setup enables tracing with the given exporter. Spans are batched and
+// exported in the background; call Shutdown to flush them before exiting.
+func Setup(e Exporter) {
+	Shutdown()
+
+	mu.Lock()
+	exporter = e
+	stop = make(chan struct{})
+	stopped = make(chan struct{})
+	go flushLoop(stop, stopped)
+	mu.Unlock()
+}
+
+// Enabled reports whether spans are being recorded.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return exporter != nil
+}
+
+// Shutdown exports any queued spans and disables tracing.
+func Shutdown() {
+	mu.Lock()
+	e := exporter
+	s, done := stop, stopped
+	exporter = nil
+	mu.Unlock()
+	if e == nil {
+		return
+	}
+
+	close(s)
+	<-done
+	flushes.Wait()
+	flush(e)
+	e.Close()
+}
+
+func flushLoop(stop, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mu.Lock()
+			e := exporter
+			mu.Unlock()
+			if e != nil {
+				flush(e)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// flush exports everything queued so far.
+func flush(e Exporter) {
+	mu.Lock()
+	spans := queue
+	queue = nil
+	mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := e.Export(spans); err != nil {
+		fmt.Fprintf(os.Stderr, "[tracing] Failed to export %d spans: %v\n", len(spans), err)
+	}
+}
+
+// enqueue queues a finished span, exporting early when a batch is full.
+func enqueue(s *Span) {
+	mu.Lock()
+	e := exporter
+	if e == nil {
+		mu.Unlock()
+		return
+	}
+	queue = append(queue, s)
+	full := len(queue) >= batchSize
+	if full {
+		// Added under mu while the exporter is set, so Shutdown, which
+		// clears it under mu, waits for this flush before closing it
+		flushes.Add(1)
+	}
+	mu.Unlock()
+	if full {
+		go func() {
+			defer flushes.Done()
+			flush(e)
+		}()
+	}
+}
+
+// Span is a timed operation in a trace.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a root span
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+// Start begins a span. It's a child of parent, or the root of a new trace if
+// parent is nil. Returns nil when tracing is off.
+func Start(name string, kind int, parent *Span) *Span {
+	if !Enabled() {
+		return nil
+	}
+	s := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: make(map[string]interface{}),
+	}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// StartRemote begins a span that joins the caller's trace, given as a W3C
+// traceparent header value ("00-<trace id>-<span id>-<flags>"). An empty or
+// malformed traceparent starts a new trace.
+func StartRemote(name string, kind int, traceparent string) *Span {
+	s := Start(name, kind, nil)
+	if s == nil {
+		return nil
+	}
+	if traceID, parentID, ok := parseTraceParent(traceparent); ok {
+		s.traceID = traceID
+		s.parentID = parentID
+	}
+	return s
+}
+
+// parseTraceParent decodes a W3C traceparent value.
+func parseTraceParent(v string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return traceID, spanID, false
+	}
+	t, err1 := hex.DecodeString(parts[1])
+	p, err2 := hex.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || len(t) != 16 || len(p) != 8 {
+		return traceID, spanID, false
+	}
+	copy(traceID[:], t)
+	copy(spanID[:], p)
+	if traceID == ([16]byte{}) || spanID == ([8]byte{}) {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, true
+}
+
+// TraceParent returns the span's context as a W3C traceparent value, or ""
+// for a nil span.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// SetAttr sets a span attribute. Values may be strings, bools, ints or floats.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed. The first error wins.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+}
+
+// End finishes the span, marking it failed if err is non-nil, and queues it
+// for export. Only the first call has any effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.SetError(err)
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	enqueue(s)
+}
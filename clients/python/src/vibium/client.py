@@ -113,10 +113,12 @@ class BiDiClient:
             if response.get("type") == "error":
                 error_code = response.get("error", "unknown")
                 error_message = response.get("message", "Unknown error")
-                if "element not found" in error_message:
+                if error_code == "no such element" or "element not found" in error_message:
                     raise errors.ElementNotFoundError(error_message)
-                if error_code == "timeout":
+                if error_code in ("timeout", "element not interactable"):
                     raise errors.TimeoutError(error_message)
+                if error_code == "browser crashed":
+                    raise errors.BrowserCrashedError(error_message)
                 raise BiDiError(error_code, error_message)
 
             return response.get("result")
@@ -0,0 +1,826 @@
+/**
+ * CLI Tests: Proxy Server
+ * Tests vibium serve through its WebSocket and HTTP endpoints
+ */
+
+const { test, describe, before, after } = require('node:test');
+const assert = require('node:assert');
+const { spawn, execFileSync } = require('node:child_process');
+const fs = require('node:fs');
+const http = require('node:http');
+const https = require('node:https');
+const net = require('node:net');
+const os = require('node:os');
+const path = require('node:path');
+const WebSocket = require('ws');
+const { VIBIUM } = require('../helpers');
+const { createTestServer } = require('../helpers/test-server');
+
+/**
+ * Sleep helper
+ */
+function sleep(ms) {
+  return new Promise(resolve => setTimeout(resolve, ms));
+}
+
+/**
+ * Poll until the (possibly async) predicate returns true, or timeout.
+ */
+async function waitUntil(fn, description, { timeout = 30000, interval = 250 } = {}) {
+  const deadline = Date.now() + timeout;
+  while (Date.now() < deadline) {
+    if (await fn()) return;
+    await sleep(interval);
+  }
+  throw new Error(`waitUntil timed out after ${timeout}ms: ${description}`);
+}
+
+// Find an available TCP port
+function findAvailablePort() {
+  return new Promise((resolve, reject) => {
+    const server = net.createServer();
+    server.listen(0, '127.0.0.1', () => {
+      const port = server.address().port;
+      server.close(() => resolve(port));
+    });
+    server.on('error', reject);
+  });
+}
+
+/**
+ * Start vibium serve with extra flags and wait until it listens.
+ * stop() sends SIGTERM and waits for the process to exit.
+ */
+async function startServe(args = []) {
+  const port = await findAvailablePort();
+  const proc = spawn(VIBIUM, ['serve', '--headless', '--port', String(port), ...args], {
+    stdio: ['ignore', 'pipe', 'pipe'],
+  });
+
+  let output = '';
+  await new Promise((resolve, reject) => {
+    const timer = setTimeout(() => reject(new Error(`serve did not start:\n${output}`)), 30000);
+    proc.stdout.on('data', (data) => {
+      output += data.toString();
+      if (output.includes('Server listening on')) {
+        clearTimeout(timer);
+        resolve();
+      }
+    });
+    // Drain the router's log so the pipe never fills up
+    proc.stderr.on('data', (data) => { output += data.toString(); });
+    proc.on('close', (code) => {
+      clearTimeout(timer);
+      reject(new Error(`serve exited with code ${code}:\n${output}`));
+    });
+  });
+
+  return {
+    proc,
+    port,
+    url: `ws://127.0.0.1:${port}`,
+    http: `http://127.0.0.1:${port}`,
+    output: () => output,
+    stop() {
+      return new Promise((resolve) => {
+        if (proc.exitCode !== null) return resolve();
+        proc.on('exit', resolve);
+        proc.kill('SIGTERM');
+      });
+    },
+  };
+}
+
+/**
+ * GET a URL, resolving with the status, headers and body.
+ */
+function httpGet(url, options = {}) {
+  const client = url.startsWith('https:') ? https : http;
+  return new Promise((resolve, reject) => {
+    client.get(url, options, (res) => {
+      let body = '';
+      res.on('data', (chunk) => { body += chunk; });
+      res.on('end', () => resolve({ status: res.statusCode, headers: res.headers, body }));
+    }).on('error', reject);
+  });
+}
+
+async function getJSON(url, options) {
+  const res = await httpGet(url, options);
+  return JSON.parse(res.body);
+}
+
+// Command IDs are unique across clients, so a response replayed to a
+// resumed connection can't be mistaken for one of its own.
+let nextId = 1;
+
+/**
+ * WebSocket client for the proxy. call() sends a command and resolves with
+ * its result (or rejects with its error code); every message received is
+ * kept in messages for waitFor().
+ */
+class ProxyClient {
+  static connect(url, options = {}) {
+    return new Promise((resolve, reject) => {
+      const client = new ProxyClient(new WebSocket(url, options));
+      client.ws.once('open', () => resolve(client));
+      client.ws.once('error', reject);
+    });
+  }
+
+  constructor(ws) {
+    this.ws = ws;
+    this.messages = [];
+    this.waiters = [];
+    ws.on('message', (data) => {
+      const msg = JSON.parse(data.toString());
+      this.messages.push(msg);
+      this.waiters = this.waiters.filter(waiter => !waiter(msg));
+    });
+  }
+
+  send(method, params = {}) {
+    const id = nextId++;
+    this.ws.send(JSON.stringify({ id, method, params }));
+    return id;
+  }
+
+  // waitFor resolves with the first message, received or still to come,
+  // that matches predicate.
+  waitFor(predicate, timeout = 30000) {
+    const found = this.messages.find(predicate);
+    if (found) return Promise.resolve(found);
+    return new Promise((resolve, reject) => {
+      const timer = setTimeout(() => reject(new Error(`timed out after ${timeout}ms waiting for a message`)), timeout);
+      this.waiters.push((msg) => {
+        if (!predicate(msg)) return false;
+        clearTimeout(timer);
+        resolve(msg);
+        return true;
+      });
+    });
+  }
+
+  response(id, timeout) {
+    return this.waitFor(msg => msg.id === id, timeout);
+  }
+
+  async call(method, params = {}, timeout) {
+    const resp = await this.response(this.send(method, params), timeout);
+    if (resp.type === 'error') {
+      const err = new Error(resp.message);
+      err.code = resp.error;
+      throw err;
+    }
+    return resp.result;
+  }
+
+  close() {
+    return new Promise((resolve) => {
+      if (this.ws.readyState === WebSocket.CLOSED) return resolve();
+      this.ws.once('close', resolve);
+      this.ws.close();
+    });
+  }
+}
+
+describe('Proxy Server: Browser Pool', { timeout: 180000 }, () => {
+  let serve, server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    serve = await startServe(['--pool-max', '1', '--pool-min-idle', '1']);
+    await waitUntil(async () => (await getJSON(`${serve.http}/pool`)).idle === 1, 'pool warmed up');
+  });
+
+  after(async () => {
+    await serve.stop();
+    server.close();
+  });
+
+  test('a released browser is reset before the next client gets it', async () => {
+    const a = await ProxyClient.connect(serve.url);
+    const { context } = await a.call('vibium:browser.page');
+    await a.call('vibium:page.navigate', { url: `${baseURL}/login`, context });
+    await a.call('vibium:page.eval', {
+      expression: "document.cookie = 'left=over'; localStorage.setItem('left', 'over')",
+      context,
+    });
+    await a.call('vibium:browser.newPage');
+    await a.call('network.addIntercept', { phases: ['beforeRequestSent'] });
+    await a.close();
+
+    await waitUntil(async () => {
+      const stats = await getJSON(`${serve.http}/pool`);
+      return stats.idle === 1 && stats.busy === 0;
+    }, 'browser returned to the pool');
+
+    const b = await ProxyClient.connect(serve.url);
+    try {
+      const tree = await b.call('browsingContext.getTree', {});
+      assert.strictEqual(tree.contexts.length, 1, 'Should have a single tab');
+      assert.strictEqual(tree.contexts[0].url, 'about:blank');
+
+      // A leftover intercept would hold this navigation
+      const ctx = tree.contexts[0].context;
+      await b.call('vibium:page.navigate', { url: `${baseURL}/login`, context: ctx, commandTimeout: 15000 });
+      const { value } = await b.call('vibium:page.eval', {
+        expression: "JSON.stringify([document.cookie, localStorage.getItem('left')])",
+        context: ctx,
+      });
+      assert.deepStrictEqual(JSON.parse(value), ['', null], 'Cookies and storage should be cleared');
+
+      // The pool holds one browser, so b got the one a released
+      const stats = await getJSON(`${serve.http}/pool`);
+      assert.strictEqual(stats.busy, 1);
+      assert.strictEqual(stats.idle, 0);
+      assert.strictEqual(stats.maxTotal, 1);
+    } finally {
+      await b.close();
+    }
+  });
+
+  test('a client queues for a browser while the pool is exhausted', async () => {
+    await waitUntil(async () => (await getJSON(`${serve.http}/pool`)).idle === 1, 'browser idle');
+
+    const a = await ProxyClient.connect(serve.url);
+    await a.call('vibium:browser.page');
+    const b = await ProxyClient.connect(serve.url);
+    try {
+      await waitUntil(async () => (await getJSON(`${serve.http}/pool`)).queued === 1, 'second client queued');
+
+      const pending = b.call('vibium:page.eval', { expression: '1 + 1' });
+      await a.close();
+      const { value } = await pending;
+      assert.strictEqual(value, 2);
+    } finally {
+      await a.close();
+      await b.close();
+    }
+  });
+});
+
+describe('Proxy Server: Session Resume', { timeout: 120000 }, () => {
+  let serve, server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    serve = await startServe(['--resume-grace', '30s']);
+  });
+
+  after(async () => {
+    await serve.stop();
+    server.close();
+  });
+
+  const isReady = msg => msg.method === 'vibium:lifecycle.ready';
+
+  test('a reconnecting client resumes its browser and gets missed responses', async () => {
+    const a = await ProxyClient.connect(serve.url);
+    const ready = await a.waitFor(isReady);
+    assert.ok(ready.params.resumeToken, 'Should send a resume token');
+    assert.strictEqual(ready.params.resumed, false);
+
+    const { context } = await a.call('vibium:browser.page');
+    await a.call('vibium:page.navigate', { url: `${baseURL}/login`, context });
+    await a.call('vibium:page.eval', { expression: 'window.__resumed = 42', context });
+
+    // Disconnect while a command is still running
+    const waitId = a.send('vibium:page.wait', { ms: 1500, context });
+    await a.close();
+
+    const b = await ProxyClient.connect(`${serve.url}/?resumeToken=${ready.params.resumeToken}`);
+    try {
+      const resumed = await b.waitFor(isReady);
+      assert.strictEqual(resumed.params.resumed, true);
+      assert.strictEqual(resumed.params.resumeToken, ready.params.resumeToken);
+
+      const missed = await b.response(waitId);
+      assert.strictEqual(missed.type, 'success');
+      assert.deepStrictEqual(missed.result, { waited: true });
+
+      const { value } = await b.call('vibium:page.eval', { expression: 'window.__resumed', context });
+      assert.strictEqual(value, 42, 'Should be the same page');
+    } finally {
+      await b.close();
+    }
+  });
+
+  test('an unknown resume token starts a new session', async () => {
+    const c = await ProxyClient.connect(`${serve.url}/?resumeToken=0123456789abcdef`);
+    try {
+      const ready = await c.waitFor(isReady);
+      assert.strictEqual(ready.params.resumed, false);
+      assert.notStrictEqual(ready.params.resumeToken, '0123456789abcdef');
+
+      const { value } = await c.call('vibium:page.eval', { expression: 'location.href' });
+      assert.strictEqual(value, 'about:blank');
+    } finally {
+      await c.close();
+    }
+  });
+});
+
+describe('Proxy Server: Error Codes', { timeout: 120000 }, () => {
+  let serve, client;
+
+  before(async () => {
+    serve = await startServe();
+    client = await ProxyClient.connect(serve.url);
+  });
+
+  after(async () => {
+    await client.close();
+    await serve.stop();
+  });
+
+  test('a missing element fails with no such element', async () => {
+    const resp = await client.response(client.send('vibium:element.click', { selector: '#missing', timeout: 500 }));
+    assert.strictEqual(resp.type, 'error');
+    assert.strictEqual(resp.error, 'no such element');
+    assert.ok(resp.message.includes('#missing'), resp.message);
+  });
+
+  test('an unknown command fails with unknown command', async () => {
+    const resp = await client.response(client.send('vibium:no.such.command'));
+    assert.strictEqual(resp.type, 'error');
+    assert.strictEqual(resp.error, 'unknown command');
+  });
+});
+
+describe('Proxy Server: Access Control', { timeout: 120000 }, () => {
+  let serve;
+
+  before(async () => {
+    serve = await startServe(['--token', 's3cret', '--allowed-origin', 'http://allowed.test']);
+  });
+
+  after(async () => {
+    await serve.stop();
+  });
+
+  test('requests without the right token are refused', async () => {
+    assert.strictEqual((await httpGet(`${serve.http}/healthz`)).status, 401);
+    assert.strictEqual((await httpGet(`${serve.http}/healthz?token=wrong`)).status, 401);
+    assert.strictEqual((await httpGet(`${serve.http}/metrics`)).status, 401);
+    await assert.rejects(ProxyClient.connect(serve.url), /401/);
+    await assert.rejects(
+      ProxyClient.connect(serve.url, { headers: { Authorization: 'Bearer wrong' } }),
+      /401/
+    );
+  });
+
+  test('the token is accepted as a bearer header or a query parameter', async () => {
+    const res = await httpGet(`${serve.http}/healthz`, { headers: { Authorization: 'Bearer s3cret' } });
+    assert.strictEqual(res.status, 200);
+    assert.strictEqual((await httpGet(`${serve.http}/healthz?token=s3cret`)).status, 200);
+
+    const client = await ProxyClient.connect(`${serve.url}/?token=s3cret`);
+    try {
+      const { value } = await client.call('vibium:page.eval', { expression: '1 + 1' });
+      assert.strictEqual(value, 2);
+    } finally {
+      await client.close();
+    }
+  });
+
+  test('WebSocket upgrades from other origins are refused', async () => {
+    const headers = { Authorization: 'Bearer s3cret' };
+    await assert.rejects(
+      ProxyClient.connect(serve.url, { headers, origin: 'http://evil.test' }),
+      /403/
+    );
+    const client = await ProxyClient.connect(serve.url, { headers, origin: 'http://allowed.test' });
+    await client.close();
+  });
+
+  test('listens on loopback only by default', () => {
+    assert.match(serve.output(), /Server listening on ws:\/\/127\.0\.0\.1:/);
+  });
+});
+
+describe('Proxy Server: TLS', { timeout: 120000 }, () => {
+  let serve, dir;
+
+  // The certificate is generated with openssl; skip if it isn't installed
+  let haveOpenSSL = true;
+  try {
+    execFileSync('openssl', ['version'], { stdio: 'ignore' });
+  } catch {
+    haveOpenSSL = false;
+  }
+
+  before(async () => {
+    if (!haveOpenSSL) return;
+    dir = fs.mkdtempSync(path.join(os.tmpdir(), 'vibium-tls-'));
+    execFileSync('openssl', [
+      'req', '-x509', '-newkey', 'rsa:2048', '-nodes', '-days', '1', '-subj', '/CN=localhost',
+      '-keyout', path.join(dir, 'key.pem'), '-out', path.join(dir, 'cert.pem'),
+    ], { stdio: 'ignore' });
+    serve = await startServe([
+      '--tls-cert', path.join(dir, 'cert.pem'),
+      '--tls-key', path.join(dir, 'key.pem'),
+    ]);
+  });
+
+  after(async () => {
+    if (serve) await serve.stop();
+    if (dir) fs.rmSync(dir, { recursive: true, force: true });
+  });
+
+  test('serves wss:// and https:// with the given certificate', { skip: !haveOpenSSL && 'openssl not installed' }, async () => {
+    assert.match(serve.output(), /Server listening on wss:\/\//);
+
+    const res = await httpGet(`https://127.0.0.1:${serve.port}/healthz`, { rejectUnauthorized: false });
+    assert.strictEqual(res.status, 200);
+
+    const client = await ProxyClient.connect(`wss://127.0.0.1:${serve.port}`, { rejectUnauthorized: false });
+    try {
+      const { value } = await client.call('vibium:page.eval', { expression: 'location.protocol' });
+      assert.strictEqual(value, 'about:');
+    } finally {
+      await client.close();
+    }
+  });
+
+  test('--tls-cert without --tls-key is an error', async () => {
+    await assert.rejects(startServe(['--tls-cert', 'cert.pem']), /--tls-cert and --tls-key must be used together/);
+  });
+});
+
+describe('Proxy Server: Metrics and Health', { timeout: 120000 }, () => {
+  let serve;
+
+  before(async () => {
+    serve = await startServe();
+  });
+
+  after(async () => {
+    await serve.stop();
+  });
+
+  test('/healthz is ok with no sessions', async () => {
+    const res = await httpGet(`${serve.http}/healthz`);
+    assert.strictEqual(res.status, 200);
+    const health = JSON.parse(res.body);
+    assert.strictEqual(health.status, 'ok');
+    assert.ok(!health.sessions || health.sessions.length === 0);
+  });
+
+  test('/healthz probes each session\'s browser', async () => {
+    const client = await ProxyClient.connect(serve.url);
+    try {
+      await client.call('vibium:browser.page');
+      const res = await httpGet(`${serve.http}/healthz`);
+      assert.strictEqual(res.status, 200);
+      const health = JSON.parse(res.body);
+      assert.strictEqual(health.status, 'ok');
+      assert.strictEqual(health.sessions.length, 1);
+      assert.strictEqual(health.sessions[0].healthy, true);
+    } finally {
+      await client.close();
+    }
+  });
+
+  test('/metrics reports sessions, launches, command latency and errors', async () => {
+    const client = await ProxyClient.connect(serve.url);
+    try {
+      await client.call('vibium:page.eval', { expression: '1 + 1' });
+      await assert.rejects(
+        client.call('vibium:page.wait', { ms: 5000, commandTimeout: 100 }),
+        err => err.code === 'timeout'
+      );
+
+      const res = await httpGet(`${serve.http}/metrics`);
+      assert.strictEqual(res.status, 200);
+      assert.match(res.body, /^vibium_sessions_active 1$/m);
+      assert.match(res.body, /^vibium_browser_launches_total [1-9]\d*$/m);
+      assert.match(res.body, /^vibium_command_duration_seconds_count\{method="vibium:page\.eval"\} [1-9]\d*$/m);
+      assert.match(res.body, /^vibium_command_errors_total\{code="timeout"\} [1-9]\d*$/m);
+    } finally {
+      await client.close();
+    }
+
+    await waitUntil(async () => /^vibium_sessions_active 0$/m.test((await httpGet(`${serve.http}/metrics`)).body),
+      'session closed');
+  });
+});
+
+describe('Proxy Server: Command Deadlines and Cancel', { timeout: 120000 }, () => {
+  let serve, client, context;
+
+  before(async () => {
+    serve = await startServe();
+    client = await ProxyClient.connect(serve.url);
+    ({ context } = await client.call('vibium:browser.page'));
+  });
+
+  after(async () => {
+    await client.close();
+    await serve.stop();
+  });
+
+  test('commandTimeout aborts a command with a timeout error', async () => {
+    const start = Date.now();
+    await assert.rejects(
+      client.call('vibium:page.wait', { ms: 10000, context, commandTimeout: 300 }),
+      err => err.code === 'timeout'
+    );
+    assert.ok(Date.now() - start < 5000, 'Should stop at the deadline, not when the wait ends');
+  });
+
+  test('commandTimeout covers element waits', async () => {
+    await assert.rejects(
+      client.call('vibium:element.click', { selector: '#missing', context, timeout: 30000, commandTimeout: 300 }),
+      err => err.code === 'timeout'
+    );
+  });
+
+  test('vibium:command.cancel aborts a running command', async () => {
+    const waitId = client.send('vibium:page.wait', { ms: 10000, context });
+    await sleep(200);
+    const result = await client.call('vibium:command.cancel', { id: waitId });
+    assert.deepStrictEqual(result, { cancelled: true });
+
+    const resp = await client.response(waitId, 5000);
+    assert.strictEqual(resp.type, 'error');
+    assert.strictEqual(resp.error, 'cancelled');
+  });
+
+  test('vibium:command.cancel aborts a queued command', async () => {
+    const first = client.send('vibium:page.wait', { ms: 1000, context });
+    const second = client.send('vibium:page.wait', { ms: 10000, context });
+    const result = await client.call('vibium:command.cancel', { id: second });
+    assert.deepStrictEqual(result, { cancelled: true });
+
+    assert.strictEqual((await client.response(first, 5000)).type, 'success');
+    const resp = await client.response(second, 5000);
+    assert.strictEqual(resp.error, 'cancelled');
+  });
+
+  test('cancelling an unknown command reports nothing cancelled', async () => {
+    const result = await client.call('vibium:command.cancel', { id: 987654 });
+    assert.deepStrictEqual(result, { cancelled: false });
+  });
+
+  test('the session keeps working after a cancelled command', async () => {
+    const { value } = await client.call('vibium:page.eval', { expression: '1 + 1', context });
+    assert.strictEqual(value, 2);
+  });
+});
+
+describe('Proxy Server: Concurrent Pages', { timeout: 120000 }, () => {
+  let serve, client, pageA, pageB;
+
+  before(async () => {
+    serve = await startServe();
+    client = await ProxyClient.connect(serve.url);
+    ({ context: pageA } = await client.call('vibium:browser.page'));
+    ({ context: pageB } = await client.call('vibium:browser.newPage'));
+  });
+
+  after(async () => {
+    await client.close();
+    await serve.stop();
+  });
+
+  // order returns the IDs in the order their responses arrive
+  async function order(ids) {
+    const arrived = [];
+    await Promise.all(ids.map(id => client.response(id).then(() => arrived.push(id))));
+    return arrived;
+  }
+
+  test('a command on one page doesn\'t wait for a command on another', async () => {
+    const slow = client.send('vibium:page.wait', { ms: 2000, context: pageA });
+    const fast = client.send('vibium:page.eval', { expression: '1 + 1', context: pageB });
+    assert.deepStrictEqual(await order([slow, fast]), [fast, slow]);
+  });
+
+  test('commands on the same page run in order', async () => {
+    const slow = client.send('vibium:page.wait', { ms: 1000, context: pageA });
+    const next = client.send('vibium:page.eval', { expression: '1 + 1', context: pageA });
+    assert.deepStrictEqual(await order([slow, next]), [slow, next]);
+  });
+
+  test('session-wide commands wait for every page\'s commands', async () => {
+    const slow = client.send('vibium:page.wait', { ms: 1000, context: pageA });
+    const pages = client.send('vibium:browser.pages');
+    assert.deepStrictEqual(await order([slow, pages]), [slow, pages]);
+  });
+});
+
+describe('Proxy Server: Event Subscriptions', { timeout: 120000 }, () => {
+  let serve, server, baseURL, client, context;
+  const subscriptions = [];
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    serve = await startServe();
+    client = await ProxyClient.connect(serve.url);
+    ({ context } = await client.call('vibium:browser.page'));
+  });
+
+  after(async () => {
+    await client.close();
+    await serve.stop();
+    server.close();
+  });
+
+  // eventsSince returns the events received after the first `since` messages
+  const eventsSince = since => client.messages.slice(since).filter(msg => msg.method && msg.id === undefined);
+  const isNetwork = msg => msg.method.startsWith('network.');
+
+  test('all events are forwarded before subscribing', async () => {
+    const since = client.messages.length;
+    await client.call('vibium:page.navigate', { url: `${baseURL}/login`, context });
+    assert.ok(eventsSince(since).some(isNetwork), 'Should get network events');
+  });
+
+  test('only subscribed events are forwarded', async () => {
+    const { subscription } = await client.call('vibium:session.subscribe', { events: ['log'] });
+    assert.ok(subscription);
+    subscriptions.push(subscription);
+
+    const since = client.messages.length;
+    await client.call('vibium:page.navigate', { url: `${baseURL}/checkboxes`, context });
+    await client.call('vibium:page.eval', { expression: "console.log('subscribed')", context });
+    const log = await client.waitFor(msg => msg.method === 'log.entryAdded' && msg.params.text === 'subscribed');
+    assert.ok(log);
+    assert.ok(!eventsSince(since).some(isNetwork), 'Should not get network events');
+  });
+
+  test('URL filters select events by URL', async () => {
+    const { subscription } = await client.call('vibium:session.subscribe', {
+      events: ['network.responseCompleted'],
+      urls: ['/inputs'],
+    });
+    subscriptions.push(subscription);
+
+    const since = client.messages.length;
+    await client.call('vibium:page.navigate', { url: `${baseURL}/dropdown`, context });
+    await client.call('vibium:page.navigate', { url: `${baseURL}/inputs`, context });
+    await client.waitFor(msg => msg.method === 'network.responseCompleted' && msg.params.request.url.endsWith('/inputs'));
+    const urls = eventsSince(since).filter(isNetwork).map(msg => msg.params.request.url);
+    assert.ok(urls.every(url => url.includes('/inputs')), `Unexpected events for ${urls.join(', ')}`);
+  });
+
+  test('unsubscribing an unknown subscription is an error', async () => {
+    await assert.rejects(
+      client.call('vibium:session.unsubscribe', { subscription: 'no-such-subscription' }),
+      /no such subscription/
+    );
+  });
+
+  test('all events are forwarded again once every subscription is removed', async () => {
+    for (const subscription of subscriptions) {
+      await client.call('vibium:session.unsubscribe', { subscription });
+    }
+
+    const since = client.messages.length;
+    await client.call('vibium:page.navigate', { url: `${baseURL}/hovers`, context });
+    assert.ok(eventsSince(since).some(isNetwork), 'Should get network events again');
+  });
+});
+
+describe('Proxy Server: Trace Export', { timeout: 120000 }, () => {
+  let serve, collector, client;
+  const exports = [];
+
+  before(async () => {
+    // A stand-in OTLP/HTTP collector that keeps what it's sent
+    collector = http.createServer((req, res) => {
+      let body = '';
+      req.on('data', (chunk) => { body += chunk; });
+      req.on('end', () => {
+        if (req.method === 'POST' && req.url === '/v1/traces') exports.push(JSON.parse(body));
+        res.writeHead(200, { 'Content-Type': 'application/json' });
+        res.end('{}');
+      });
+    });
+    await new Promise(resolve => collector.listen(0, '127.0.0.1', resolve));
+
+    serve = await startServe(['--trace-endpoint', `http://127.0.0.1:${collector.address().port}`]);
+    client = await ProxyClient.connect(serve.url);
+  });
+
+  after(async () => {
+    await client.close();
+    await serve.stop();
+    collector.close();
+  });
+
+  const spans = () => exports.flatMap(req => req.resourceSpans.flatMap(rs => rs.scopeSpans.flatMap(ss => ss.spans)));
+
+  test('commands are exported as spans joining the caller\'s trace', async () => {
+    const traceId = '4bf92f3577b34da6a3ce929d0e0e4736';
+    const parentId = '00f067aa0ba902b7';
+    await client.call('vibium:page.eval', {
+      expression: '1 + 1',
+      traceparent: `00-${traceId}-${parentId}-01`,
+    });
+
+    let command;
+    await waitUntil(() => (command = spans().find(s => s.name === 'vibium:page.eval')), 'span exported');
+    assert.strictEqual(command.traceId, traceId);
+    assert.strictEqual(command.parentSpanId, parentId);
+    assert.strictEqual(command.kind, 2, 'Should be a server span');
+    assert.notStrictEqual(command.status.code, 2);
+
+    const bidi = spans().find(s => s.name === 'script.evaluate' && s.parentSpanId === command.spanId);
+    assert.ok(bidi, 'Should export the command\'s BiDi calls as child spans');
+    assert.strictEqual(bidi.traceId, traceId);
+    assert.strictEqual(bidi.kind, 3, 'Should be a client span');
+
+    const service = exports[0].resourceSpans[0].resource.attributes.find(a => a.key === 'service.name');
+    assert.deepStrictEqual(service.value, { stringValue: 'vibium' });
+  });
+
+  test('failed commands are exported with an error status', async () => {
+    await assert.rejects(client.call('vibium:page.wait', { ms: 5000, commandTimeout: 100 }));
+
+    let failed;
+    await waitUntil(() => (failed = spans().find(s => s.name === 'vibium:page.wait')), 'span exported');
+    assert.strictEqual(failed.status.code, 2);
+  });
+});
+
+describe('Proxy Server: Batches', { timeout: 120000 }, () => {
+  let serve, server, baseURL, client, context;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    serve = await startServe();
+    client = await ProxyClient.connect(serve.url);
+    ({ context } = await client.call('vibium:browser.page'));
+  });
+
+  after(async () => {
+    await client.close();
+    await serve.stop();
+    server.close();
+  });
+
+  test('vibium:batch runs its commands in order and answers once', async () => {
+    const { results, failed } = await client.call('vibium:batch', {
+      commands: [
+        { method: 'vibium:page.navigate', params: { url: `${baseURL}/login`, context } },
+        { method: 'vibium:element.fill', params: { selector: '#username', value: 'tomsmith', context } },
+        { method: 'vibium:page.eval', params: { expression: "document.querySelector('#username').value", context } },
+      ],
+    });
+    assert.strictEqual(failed, 0);
+    assert.strictEqual(results.length, 3);
+    assert.ok(results.every(r => r.type === 'success'));
+    assert.strictEqual(results[2].result.value, 'tomsmith');
+  });
+
+  test('the first failure ends the batch by default', async () => {
+    const { results, failed } = await client.call('vibium:batch', {
+      commands: [
+        { method: 'vibium:page.eval', params: { expression: '1', context } },
+        { method: 'vibium:element.click', params: { selector: '#missing', timeout: 200, context } },
+        { method: 'vibium:page.eval', params: { expression: '3', context } },
+      ],
+    });
+    assert.strictEqual(failed, 1);
+    assert.strictEqual(results.length, 2);
+    assert.strictEqual(results[1].type, 'error');
+    assert.strictEqual(results[1].error, 'no such element');
+  });
+
+  test('stopOnError false runs every command', async () => {
+    const { results, failed } = await client.call('vibium:batch', {
+      stopOnError: false,
+      commands: [
+        { method: 'vibium:element.click', params: { selector: '#missing', timeout: 200, context } },
+        { method: 'vibium:page.eval', params: { expression: '2', context } },
+      ],
+    });
+    assert.strictEqual(failed, 1);
+    assert.deepStrictEqual(results.map(r => r.type), ['error', 'success']);
+    assert.strictEqual(results[1].result.value, 2);
+  });
+
+  test('cancelling a batch stops it and skips the rest', async () => {
+    const batchId = client.send('vibium:batch', {
+      commands: [
+        { method: 'vibium:page.wait', params: { ms: 10000, context } },
+        { method: 'vibium:page.eval', params: { expression: 'window.__batchRan = true', context } },
+      ],
+    });
+    await sleep(200);
+    assert.deepStrictEqual(await client.call('vibium:command.cancel', { id: batchId }), { cancelled: true });
+
+    const resp = await client.response(batchId, 5000);
+    assert.strictEqual(resp.type, 'success');
+    assert.strictEqual(resp.result.results.length, 1);
+    assert.strictEqual(resp.result.results[0].error, 'cancelled');
+    const { value } = await client.call('vibium:page.eval', { expression: 'window.__batchRan === true', context });
+    assert.strictEqual(value, false);
+  });
+
+  test('an empty batch is an error', async () => {
+    await assert.rejects(client.call('vibium:batch', { commands: [] }), /commands is required/);
+  });
+});
@@ -265,3 +265,38 @@ describe('Daemon CLI: quit command', () => {
     assert.ok(result.result.includes('closed'), 'Should confirm browser closed');
   });
 });
+
+describe('Daemon CLI: Error codes', () => {
+  before(() => {
+    stopDaemon();
+    clicker('daemon start --headless');
+    clicker('go https://example.com');
+  });
+
+  after(() => {
+    stopDaemon();
+  });
+
+  // Runs clicker expecting it to fail, returning the exit status and output
+  function clickerFails(args) {
+    try {
+      execSync(`${VIBIUM} ${args}`, { encoding: 'utf-8', timeout: 60000, stdio: 'pipe' });
+    } catch (e) {
+      return { status: e.status, stdout: e.stdout.trim() };
+    }
+    assert.fail(`${args} should have failed`);
+  }
+
+  test('a missing element exits 3 with the no such element code', () => {
+    const { status, stdout } = clickerFails('click "#missing" --timeout 1s --json');
+    assert.strictEqual(status, 3);
+    const result = JSON.parse(stdout);
+    assert.strictEqual(result.ok, false);
+    assert.strictEqual(result.code, 'no such element');
+  });
+
+  test('usage errors exit 2', () => {
+    assert.strictEqual(clickerFails('no-such-command').status, 2);
+    assert.strictEqual(clickerFails('click').status, 2);
+  });
+});
@@ -7,6 +7,7 @@ const { test, describe, before, after } = require('node:test');
 const assert = require('node:assert');
 const { execSync } = require('node:child_process');
 const { VIBIUM } = require('../helpers');
+const { createTestServer } = require('../helpers/test-server');
 
 function clicker(args, opts = {}) {
   const result = execSync(`${VIBIUM} ${args}`, {
@@ -102,3 +103,258 @@ describe('Daemon: Find @refs workflow', () => {
     assert.ok(urlResult.result.includes('example.com'), 'Should still be on example.com');
   });
 });
+
+describe('Daemon: Stable @refs', () => {
+  before(() => {
+    stopDaemon();
+    clicker('daemon start --headless');
+  });
+
+  after(() => {
+    stopDaemon();
+  });
+
+  test('ref still points at its element after the DOM is reordered', () => {
+    clicker('go https://example.com');
+    const mapResult = clickerJSON('map');
+    assert.ok(mapResult.result.includes('@e1'), 'map should have @e1');
+
+    // A new link before the mapped one shifts every nth-of-type path
+    clicker(`eval "document.body.insertBefore(Object.assign(document.createElement('p'), {innerHTML: '<a href=\\"#toast\\">Toast</a>'}), document.body.firstChild)"`);
+
+    clickerJSON('click @e1');
+    clickerJSON('wait load');
+    const urlResult = clickerJSON('url');
+    assert.ok(urlResult.result.includes('iana.org'), 'Should click the mapped link, not the new one');
+  });
+
+  test('ref to a removed element fails as stale', () => {
+    clicker('go https://example.com');
+    clickerJSON('map');
+    clicker(`eval "document.querySelector('a').remove()"`);
+
+    let output;
+    try {
+      clicker('click @e1 --json');
+      assert.fail('click on a removed ref should fail');
+    } catch (e) {
+      assert.strictEqual(e.status, 11, 'Should exit with the stale ref status');
+      output = JSON.parse(e.stdout.trim());
+    }
+    assert.strictEqual(output.ok, false);
+    assert.strictEqual(output.code, 'stale element reference');
+    assert.match(output.error, /stale ref @e1.*re-run map/);
+  });
+});
+
+describe('Daemon: Shadow DOM @refs', () => {
+  let server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    stopDaemon();
+    clicker('daemon start --headless');
+  });
+
+  after(() => {
+    stopDaemon();
+    if (server) server.close();
+  });
+
+  test('map lists elements inside shadow roots and their refs are clickable', () => {
+    clicker(`go ${baseURL}/shadow`);
+    const mapResult = clickerJSON('map');
+    assert.strictEqual(mapResult.ok, true);
+    assert.match(mapResult.result, /\[a\] "Home"/, 'map should list the link in my-app');
+    assert.match(mapResult.result, /\[input\]/, 'map should list the input in settings-panel');
+    const save = mapResult.result.split('\n').find(line => line.includes('"Save"'));
+    assert.ok(save, 'map should list the Save button');
+    const ref = save.split(' ')[0];
+
+    clickerJSON('fill "settings-panel >>> input" "grace@example.com"');
+    clickerJSON(`click ${ref}`);
+    const text = clickerJSON('text "#result"');
+    assert.strictEqual(text.result, 'Saved grace@example.com');
+  });
+});
+
+describe('Daemon: diff map', () => {
+  let server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    stopDaemon();
+    clicker('daemon start --headless');
+  });
+
+  after(() => {
+    stopDaemon();
+    if (server) server.close();
+  });
+
+  test('reports a checked checkbox as a state change, not a new element', () => {
+    clicker(`go ${baseURL}/checkboxes`);
+    clickerJSON('map');
+    clickerJSON('click @e1');
+
+    const diff = clickerJSON('diff map');
+    assert.strictEqual(diff.ok, true);
+    assert.deepStrictEqual(diff.result.added, []);
+    assert.deepStrictEqual(diff.result.removed, []);
+    assert.strictEqual(diff.result.stateChanged.length, 1);
+    assert.strictEqual(diff.result.stateChanged[0].ref, '@e1');
+    assert.deepStrictEqual(diff.result.stateChanged[0].changes.checked, { from: false, to: true });
+  });
+
+  test('reports added and relabelled elements', () => {
+    clicker(`go ${baseURL}/add_remove_elements/`);
+    clickerJSON('map');
+    clickerJSON('click @e1');
+
+    let diff = clicker('diff map');
+    assert.match(diff, /^\+ @e2 \[button\] "Delete"$/m);
+    assert.doesNotMatch(diff, /^- /m, 'Add Element should not be reported as removed');
+
+    clicker(`eval "document.querySelector('button').textContent = 'Add Item'"`);
+    diff = clicker('diff map');
+    assert.match(diff, /^~ @e1 \[button\] "Add Item" \(was \[button\] "Add Element"\)$/m);
+  });
+});
+
+describe('Daemon: map options', () => {
+  let server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    stopDaemon();
+    clicker('daemon start --headless');
+  });
+
+  after(() => {
+    stopDaemon();
+    if (server) server.close();
+  });
+
+  test('map --in-viewport leaves out elements below the fold', () => {
+    clicker(`go ${baseURL}/`);
+    const all = clicker('map');
+    assert.match(all, /"Elemental Selenium"/);
+
+    const visible = clicker('map --in-viewport');
+    assert.match(visible, /"Form Authentication"/);
+    assert.doesNotMatch(visible, /"Elemental Selenium"/, 'the footer link is 2000px down');
+  });
+
+  test('map --forms-only --state --group shows field values under their form', () => {
+    clicker(`go ${baseURL}/login`);
+    clicker('fill "#username" "tom"');
+    clicker('fill "#password" "secret"');
+
+    const output = clicker('map --forms-only --state --group');
+    const lines = output.split('\n');
+    assert.strictEqual(lines[0], 'form:');
+    assert.match(output, /^  @e1 \[input type="text"\] name="username" \(value="tom"\)$/m);
+    assert.doesNotMatch(output, /secret/, 'password values are masked');
+    assert.strictEqual(lines.length, 4, 'username, password and the submit button');
+  });
+
+  test('map --format json lists elements with role, group and state', () => {
+    clicker(`go ${baseURL}/login`);
+    const map = JSON.parse(clicker('map --role button --format json'));
+    assert.strictEqual(map.elements.length, 1);
+    const [button] = map.elements;
+    assert.strictEqual(button.ref, '@e1');
+    assert.strictEqual(button.role, 'button');
+    assert.strictEqual(button.group, 'form');
+    assert.strictEqual(button.state.enabled, true);
+  });
+
+  test('map --format json pages by whole elements', () => {
+    clicker(`go ${baseURL}/login`);
+    const first = JSON.parse(clicker('map --forms-only --format json --max-chars 100'));
+    assert.strictEqual(first.elements.length, 1, 'at least one element, even over the budget');
+    assert.strictEqual(first.total, 3);
+    assert.ok(first.nextCursor, 'Should give the cursor for the next page');
+
+    const rest = JSON.parse(clicker(`map --forms-only --format json --cursor ${first.nextCursor}`));
+    assert.strictEqual(rest.start, 1);
+    assert.strictEqual(rest.elements.length, 2);
+    assert.strictEqual(rest.nextCursor, undefined, 'Should be the last page');
+  });
+});
+
+describe('Daemon: locators', () => {
+  let server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    stopDaemon();
+    clicker('daemon start --headless');
+  });
+
+  after(() => {
+    stopDaemon();
+    if (server) server.close();
+  });
+
+  test('click takes a chained locator', () => {
+    clicker(`go ${baseURL}/invoices`);
+    clickerJSON(`click 'tr:has-text("Invoice 42") >> role=button[name="Delete"]'`);
+    const text = clickerJSON('text "#result"');
+    assert.strictEqual(text.result, 'Delete Invoice 42');
+  });
+
+  test('find returns a ref for a chained locator', () => {
+    clicker(`go ${baseURL}/invoices`);
+    const found = clickerJSON(`find 'tr >> nth=0 >> text=Edit'`);
+    assert.match(found.result, /@e1 \[button\] "Edit"/);
+    clickerJSON('click @e1');
+    assert.strictEqual(clickerJSON('text "#result"').result, 'Edit Invoice 41');
+  });
+});
+
+describe('Daemon: locate', () => {
+  let server, baseURL;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    stopDaemon();
+    clicker('daemon start --headless');
+  });
+
+  after(() => {
+    stopDaemon();
+    if (server) server.close();
+  });
+
+  test('locate suggests unique role, text and CSS locators', () => {
+    clicker(`go ${baseURL}/login`);
+    const { result } = clickerJSON('locate "#login button" --json');
+    const locators = result.candidates.map(c => c.locator);
+    assert.strictEqual(locators[0], 'role=button[name="Login"]');
+    assert.ok(locators.includes('text="Login"'), `Should suggest text, got ${locators}`);
+    assert.ok(locators.includes('button.radius'), `Should suggest CSS, got ${locators}`);
+    assert.ok(result.candidates.every(c => c.unique && c.matches === 1));
+  });
+
+  test('locate takes a ref and narrows look-alikes to their row', () => {
+    clicker(`go ${baseURL}/invoices`);
+    const found = clickerJSON(`find 'tr >> nth=1 >> text=Delete'`);
+    assert.match(found.result, /@e1 \[button\] "Delete"/);
+    const { result } = clickerJSON('locate @e1 --json');
+    const best = result.candidates[0];
+    assert.strictEqual(best.locator, 'tr:has-text("Invoice 42") >> role=button[name="Delete"]');
+    assert.strictEqual(best.unique, true);
+
+    clickerJSON(`click '${best.locator}'`);
+    assert.strictEqual(clickerJSON('text "#result"').result, 'Delete Invoice 42');
+  });
+
+  test('locate prints one locator per line', () => {
+    clicker(`go ${baseURL}/login`);
+    const out = clicker('locate "#username"');
+    assert.match(out, /^role=textbox\[name="Username"\]\s+\(role, unique\)$/m);
+    assert.match(out, /^label="Username"\s+\(label, unique\)$/m);
+    assert.match(out, /^#username\s+\(css, unique\)$/m);
+  });
+});
@@ -130,6 +130,49 @@ const SELECTORS_HTML = `<html><head><title>Selectors</title></head><body>
   <div class="container"><span class="inner-text">Hello from span</span></div>
 </body></html>`;
 
+const SHADOW_HTML = `<html><head><title>Shadow DOM</title></head><body>
+  <h1>Web Components</h1>
+  <my-app></my-app>
+  <div id="result"></div>
+  <script>
+    customElements.define('settings-panel', class extends HTMLElement {
+      constructor() {
+        super();
+        this.attachShadow({ mode: 'open' }).innerHTML =
+          '<label for="email">Email</label><input id="email" name="email" />' +
+          '<button class="save">Save</button>';
+        this.shadowRoot.querySelector('.save').onclick = () => {
+          const value = this.shadowRoot.querySelector('input').value;
+          document.getElementById('result').textContent = 'Saved ' + value;
+        };
+      }
+    });
+    customElements.define('my-app', class extends HTMLElement {
+      constructor() {
+        super();
+        this.attachShadow({ mode: 'open' }).innerHTML = '<nav><a href="#home">Home</a></nav><settings-panel></settings-panel>';
+      }
+    });
+  </script>
+</body></html>`;
+
+const INVOICES_HTML = `<html><head><title>Invoices</title></head><body>
+  <h1>Invoices</h1>
+  <table>
+    <tr><td>Invoice 41</td><td><button class="edit">Edit</button> <button class="delete">Delete</button></td></tr>
+    <tr><td>Invoice 42</td><td><button class="edit">Edit</button> <button class="delete">Delete</button></td></tr>
+    <tr><td>Invoice 43</td><td><button class="edit">Edit</button> <button class="delete" style="display:none">Delete</button></td></tr>
+  </table>
+  <div id="result"></div>
+  <script>
+    document.querySelectorAll('tr').forEach(row => {
+      row.querySelectorAll('button').forEach(btn => {
+        btn.onclick = () => { document.getElementById('result').textContent = btn.textContent + ' ' + row.cells[0].textContent; };
+      });
+    });
+  </script>
+</body></html>`;
+
 const routes = {
   '/': HOME_HTML,
   '/login': LOGIN_HTML,
@@ -141,6 +184,8 @@ const routes = {
   '/dynamic_loading/1': DYNAMIC_LOADING_HTML,
   '/add_remove_elements/': ADD_REMOVE_HTML,
   '/selectors': SELECTORS_HTML,
+  '/shadow': SHADOW_HTML,
+  '/invoices': INVOICES_HTML,
 };
 
 function handleRequest(req, res) {
@@ -0,0 +1,59 @@
+/**
+ * JS Library Tests: Locators
+ * Tests chained (>>) locators, :has-text(), :has(), nth= and visible=
+ */
+
+const { test, describe, before, after } = require('node:test');
+const assert = require('node:assert');
+
+const { browser } = require('../../../clients/javascript/dist');
+const { createTestServer } = require('../../helpers/test-server');
+
+let server, baseURL, bro, vibe;
+
+before(async () => {
+  ({ server, baseURL } = await createTestServer());
+  bro = await browser.start({ headless: true });
+  vibe = await bro.page();
+});
+
+after(async () => {
+  if (bro) await bro.stop();
+  if (server) server.close();
+});
+
+describe('JS Locators', () => {
+  test(':has-text() and >> role= pick the button in the right row', async () => {
+    await vibe.go(baseURL + '/invoices');
+    await vibe.find('tr:has-text("Invoice 42") >> role=button[name="Delete"]').click();
+    const result = await vibe.find('#result');
+    assert.strictEqual(await result.text(), 'Delete Invoice 42');
+  });
+
+  test(':has() takes a locator', async () => {
+    await vibe.go(baseURL + '/invoices');
+    await vibe.find('tr:has(text="Invoice 41") >> text=Edit').click();
+    const result = await vibe.find('#result');
+    assert.strictEqual(await result.text(), 'Edit Invoice 41');
+  });
+
+  test('nth= picks a match, counting from the end when negative', async () => {
+    await vibe.go(baseURL + '/invoices');
+    const last = await vibe.find('tr >> nth=-1 >> td >> nth=0');
+    assert.strictEqual(await last.text(), 'Invoice 43');
+  });
+
+  test('visible=true drops hidden matches', async () => {
+    await vibe.go(baseURL + '/invoices');
+    const all = await vibe.findAll('button.delete');
+    assert.strictEqual(all.length, 3);
+    const visible = await vibe.findAll('button.delete >> visible=true');
+    assert.strictEqual(visible.length, 2);
+  });
+
+  test('plain CSS selectors behave as before', async () => {
+    await vibe.go(baseURL + '/invoices');
+    const edits = await vibe.findAll('tr > td > button.edit');
+    assert.strictEqual(edits.length, 3);
+  });
+});
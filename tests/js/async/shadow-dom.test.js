@@ -0,0 +1,53 @@
+/**
+ * JS Library Tests: Shadow DOM
+ * Tests that finding and actions reach into open shadow roots
+ */
+
+const { test, describe, before, after } = require('node:test');
+const assert = require('node:assert');
+
+const { browser } = require('../../../clients/javascript/dist');
+const { createTestServer } = require('../../helpers/test-server');
+
+let server, baseURL, bro, vibe;
+
+before(async () => {
+  ({ server, baseURL } = await createTestServer());
+  bro = await browser.start({ headless: true });
+  vibe = await bro.page();
+});
+
+after(async () => {
+  if (bro) await bro.stop();
+  if (server) server.close();
+});
+
+describe('JS Shadow DOM', () => {
+  test('plain CSS selector finds an element inside shadow roots', async () => {
+    await vibe.go(baseURL + '/shadow');
+    const save = await vibe.find('button.save', { timeout: 5000 });
+    assert.strictEqual(await save.text(), 'Save');
+  });
+
+  test('>>> selector steps into nested shadow roots', async () => {
+    await vibe.go(baseURL + '/shadow');
+    await vibe.find('my-app >>> settings-panel >>> input[name="email"]').fill('ada@example.com');
+    await vibe.find('my-app >>> settings-panel >>> button.save').click();
+    const result = await vibe.find('#result');
+    assert.strictEqual(await result.text(), 'Saved ada@example.com');
+  });
+
+  test('semantic selectors search shadow roots', async () => {
+    await vibe.go(baseURL + '/shadow');
+    const link = await vibe.find({ role: 'link', text: 'Home' });
+    assert.strictEqual(await link.text(), 'Home');
+    const email = await vibe.find({ label: 'Email' });
+    assert.strictEqual(await email.attr('name'), 'email');
+  });
+
+  test('findAll counts matches inside shadow roots', async () => {
+    await vibe.go(baseURL + '/shadow');
+    const inputs = await vibe.findAll('input');
+    assert.strictEqual(inputs.length, 1);
+  });
+});
@@ -10,12 +10,14 @@ const fs = require('node:fs');
 const path = require('node:path');
 const os = require('node:os');
 const { VIBIUM } = require('../helpers');
+const { createTestServer } = require('../helpers/test-server');
 
 /**
  * Helper to run MCP server and send/receive JSON-RPC messages
  */
 class MCPClient {
-  constructor() {
+  constructor(args = []) {
+    this.args = args;
     this.proc = null;
     this.buffer = '';
     this.responses = [];
@@ -24,7 +26,7 @@ class MCPClient {
 
   start() {
     return new Promise((resolve, reject) => {
-      this.proc = spawn(VIBIUM, ['mcp'], {
+      this.proc = spawn(VIBIUM, ['mcp', ...this.args], {
         stdio: ['pipe', 'pipe', 'pipe'],
       });
 
@@ -133,17 +135,25 @@ describe('MCP Server: Protocol', () => {
 
     assert.strictEqual(response.jsonrpc, '2.0');
     assert.ok(response.result, 'Should have result');
-    assert.strictEqual(response.result.protocolVersion, '2024-11-05');
+    assert.strictEqual(response.result.protocolVersion, '2024-11-05', 'Should echo a supported version');
     assert.strictEqual(response.result.serverInfo.name, 'vibium');
     assert.ok(response.result.capabilities.tools, 'Should have tools capability');
   });
 
-  test('tools/list returns all 85 browser tools', async () => {
+  test('initialize negotiates the protocol version', async () => {
+    const current = await client.call('initialize', { protocolVersion: '2025-06-18', capabilities: {} });
+    assert.strictEqual(current.result.protocolVersion, '2025-06-18', 'Should echo a supported version');
+
+    const unknown = await client.call('initialize', { protocolVersion: '1999-01-01', capabilities: {} });
+    assert.strictEqual(unknown.result.protocolVersion, '2025-06-18', 'Should answer an unknown version with the newest');
+  });
+
+  test('tools/list returns all 90 browser tools', async () => {
     const response = await client.call('tools/list', {});
 
     assert.ok(response.result, 'Should have result');
     assert.ok(response.result.tools, 'Should have tools array');
-    assert.strictEqual(response.result.tools.length, 85, 'Should have 85 tools');
+    assert.strictEqual(response.result.tools.length, 90, 'Should have 90 tools');
 
     const toolNames = response.result.tools.map(t => t.name);
     const expectedTools = [
@@ -153,7 +163,7 @@ describe('MCP Server: Protocol', () => {
       'browser_get_html', 'browser_find_all', 'browser_wait',
       'browser_hover', 'browser_select', 'browser_scroll', 'browser_keys',
       'browser_new_page', 'browser_list_pages', 'browser_switch_page', 'browser_close_page',
-      'browser_a11y_tree',
+      'browser_a11y_tree', 'browser_list_sessions',
       'page_clock_install', 'page_clock_fast_forward', 'page_clock_run_for',
       'page_clock_pause_at', 'page_clock_resume', 'page_clock_set_fixed_time',
       'page_clock_set_system_time', 'page_clock_set_timezone',
@@ -162,11 +172,12 @@ describe('MCP Server: Protocol', () => {
       'browser_get_value', 'browser_get_attribute', 'browser_is_visible',
       'browser_check', 'browser_uncheck', 'browser_scroll_into_view',
       'browser_wait_for_url', 'browser_wait_for_load', 'browser_sleep',
-      'browser_map', 'browser_diff_map', 'browser_pdf', 'browser_highlight',
+      'browser_map', 'browser_diff_map', 'browser_locate', 'browser_pdf', 'browser_highlight',
       'browser_dblclick', 'browser_focus', 'browser_count',
       'browser_is_enabled', 'browser_is_checked',
       'browser_wait_for_text', 'browser_wait_for_fn',
       'browser_dialog_accept', 'browser_dialog_dismiss',
+      'browser_route', 'browser_unroute', 'browser_list_routes',
       'browser_get_cookies', 'browser_set_cookie', 'browser_delete_cookies',
       'browser_mouse_move', 'browser_mouse_down', 'browser_mouse_up', 'browser_mouse_click', 'browser_drag',
       'browser_set_viewport', 'browser_get_viewport',
@@ -689,6 +700,400 @@ function readNetworkEvents(extractedDir) {
   return events;
 }
 
+describe('MCP Server: Budgeted Output', () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient();
+    await client.start();
+    await client.call('initialize', { capabilities: {} });
+    await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: 'https://example.com' },
+    });
+  });
+
+  after(async () => {
+    await client.call('tools/call', { name: 'browser_stop', arguments: {} });
+    client.stop();
+  });
+
+  test('browser_get_html with maxChars truncates and returns a cursor', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_get_html',
+      arguments: { maxChars: 200 },
+    });
+    const text = response.result.content[0].text;
+    const match = text.match(/\[truncated: showing chars 0-(\d+) of (\d+); next cursor=(\S+)\]$/);
+    assert.ok(match, 'Should end with a truncation marker');
+    assert.ok(Number(match[1]) <= 200, 'Should respect the budget');
+
+    const next = await client.call('tools/call', {
+      name: 'browser_get_html',
+      arguments: { maxChars: 100000, cursor: match[3] },
+    });
+    const rest = next.result.content[0].text;
+    assert.ok(rest.includes(`[end of output: chars ${match[1]}-${match[2]} of ${match[2]}]`), 'Should return the rest');
+  });
+
+  test('small results are returned whole', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_get_text',
+      arguments: {},
+    });
+    assert.ok(!response.result.content[0].text.includes('[truncated'), 'Should not be truncated');
+  });
+
+  test('browser_get_text with outline returns headings', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_get_text',
+      arguments: { outline: true },
+    });
+    assert.ok(response.result.content[0].text.includes('# Example Domain'), 'Should list the h1');
+  });
+
+  test('invalid cursor is an error', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_map',
+      arguments: { cursor: 'bogus' },
+    });
+    assert.ok(response.result.isError, 'Should be an error');
+  });
+});
+
+describe('MCP Server: Network Routes', () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient();
+    await client.start();
+    await client.call('initialize', { capabilities: {} });
+    await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: 'https://example.com' },
+    });
+  });
+
+  after(async () => {
+    await client.call('tools/call', { name: 'browser_stop', arguments: {} });
+    client.stop();
+  });
+
+  test('browser_route fulfills matching requests with a stub', async () => {
+    const added = await client.call('tools/call', {
+      name: 'browser_route',
+      arguments: { url: '**/stub.json', body: '{"stubbed":true}', contentType: 'application/json' },
+    });
+    assert.ok(!added.result.isError, 'Should not be an error');
+    assert.ok(added.result.content[0].text.includes('fulfill 200'), 'Should describe the route');
+
+    const response = await client.call('tools/call', {
+      name: 'browser_evaluate',
+      arguments: { expression: "fetch('/stub.json').then(r => r.text())" },
+    });
+    assert.ok(response.result.content[0].text.includes('stubbed'), 'Should return the stub body');
+  });
+
+  test('browser_route aborts matching requests', async () => {
+    await client.call('tools/call', {
+      name: 'browser_route',
+      arguments: { url: '**/blocked', action: 'abort' },
+    });
+
+    const response = await client.call('tools/call', {
+      name: 'browser_evaluate',
+      arguments: { expression: "fetch('/blocked').then(() => 'loaded', () => 'failed')" },
+    });
+    assert.ok(response.result.content[0].text.includes('failed'), 'Request should fail');
+  });
+
+  test('browser_route answers requests made between tool calls', async () => {
+    await client.call('tools/call', {
+      name: 'browser_route',
+      arguments: { url: '**/later.json', body: 'later-stub' },
+    });
+
+    // The fetch starts after browser_evaluate has returned, while no tool
+    // call is in flight
+    await client.call('tools/call', {
+      name: 'browser_evaluate',
+      arguments: { expression: "setTimeout(() => fetch('/later.json').then(r => r.text()).then(t => { window.__later = t; }), 300); 'scheduled'" },
+    });
+    await new Promise(r => setTimeout(r, 1500));
+
+    const response = await client.call('tools/call', {
+      name: 'browser_evaluate',
+      arguments: { expression: "window.__later || 'pending'" },
+    });
+    assert.ok(response.result.content[0].text.includes('later-stub'), 'Should have been answered by the route');
+
+    await client.call('tools/call', {
+      name: 'browser_unroute',
+      arguments: { url: '**/later.json' },
+    });
+  });
+
+  test('browser_list_routes lists active routes', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_list_routes',
+      arguments: {},
+    });
+    const text = response.result.content[0].text;
+    assert.ok(text.includes('**/stub.json'), 'Should list the stub route');
+    assert.ok(text.includes('**/blocked'), 'Should list the abort route');
+  });
+
+  test('browser_unroute removes routes', async () => {
+    const removed = await client.call('tools/call', {
+      name: 'browser_unroute',
+      arguments: {},
+    });
+    assert.ok(removed.result.content[0].text.includes('Removed 2'), 'Should remove both routes');
+
+    const response = await client.call('tools/call', {
+      name: 'browser_list_routes',
+      arguments: {},
+    });
+    assert.ok(response.result.content[0].text.includes('No active routes'));
+  });
+});
+
+describe('MCP Server: Named Sessions', () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient();
+    await client.start();
+    await client.call('initialize', { capabilities: {} });
+  });
+
+  after(async () => {
+    await client.call('tools/call', { name: 'browser_stop', arguments: { session: 'alice' } });
+    await client.call('tools/call', { name: 'browser_stop', arguments: {} });
+    client.stop();
+  });
+
+  test('every tool accepts a session argument', async () => {
+    const response = await client.call('tools/list', {});
+    for (const tool of response.result.tools) {
+      assert.ok(tool.inputSchema.properties.session, `${tool.name} should take session`);
+    }
+  });
+
+  test('tools in an unknown session fail', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_get_url',
+      arguments: { session: 'nobody' },
+    });
+    assert.ok(response.result.isError, 'Should be an error');
+    assert.ok(response.result.content[0].text.includes('unknown session'));
+  });
+
+  test('named sessions are isolated from the default session', async () => {
+    await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: 'https://example.com' },
+    });
+    const started = await client.call('tools/call', {
+      name: 'browser_start',
+      arguments: { session: 'alice' },
+    });
+    assert.ok(!started.result.isError, 'Should start the named session');
+
+    await client.call('tools/call', {
+      name: 'browser_set_cookie',
+      arguments: { session: 'alice', name: 'who', value: 'alice', domain: 'example.com' },
+    });
+    await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { session: 'alice', url: 'https://example.com/?alice' },
+    });
+
+    const defaultCookies = await client.call('tools/call', {
+      name: 'browser_get_cookies',
+      arguments: {},
+    });
+    assert.ok(!defaultCookies.result.content[0].text.includes('alice'), 'Default session should not see alice\'s cookie');
+
+    const list = await client.call('tools/call', {
+      name: 'browser_list_sessions',
+      arguments: {},
+    });
+    const text = list.result.content[0].text;
+    assert.ok(text.includes('default: running, https://example.com/'), 'Should list the default session');
+    assert.ok(text.includes('alice: running, https://example.com/?alice'), 'Should list alice');
+  });
+
+  test('browser_stop with session closes only that session', async () => {
+    await client.call('tools/call', {
+      name: 'browser_stop',
+      arguments: { session: 'alice' },
+    });
+
+    const list = await client.call('tools/call', {
+      name: 'browser_list_sessions',
+      arguments: {},
+    });
+    const text = list.result.content[0].text;
+    assert.ok(!text.includes('alice'), 'alice should be gone');
+    assert.ok(text.includes('default: running'), 'Default session should still run');
+  });
+});
+
+describe('MCP Server: Navigation Policy', () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient(['--allow', 'example.com', '--block', 'forbidden.example.com']);
+    await client.start();
+    await client.call('initialize', { capabilities: {} });
+  });
+
+  after(async () => {
+    await client.call('tools/call', { name: 'browser_stop', arguments: {} });
+    client.stop();
+  });
+
+  test('browser_navigate allows URLs on allowed domains', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: 'https://example.com' },
+    });
+    assert.ok(!response.result.isError, 'Should not be an error');
+  });
+
+  test('browser_navigate rejects URLs outside the allowed domains', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: 'https://www.iana.org/' },
+    });
+    assert.strictEqual(response.result.isError, true, 'Should be an error');
+    assert.ok(response.result.content[0].text.includes('blocked by policy'), 'Should say the policy blocked it');
+    assert.strictEqual(response.result._meta.errorCode, 'navigation blocked');
+  });
+
+  test('browser_new_page rejects blocked URLs', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_new_page',
+      arguments: { url: 'https://forbidden.example.com/' },
+    });
+    assert.strictEqual(response.result.isError, true, 'Should be an error');
+    assert.ok(response.result.content[0].text.includes('blocked pattern'), 'Should name the blocking pattern');
+  });
+
+  test('navigations started by the page are blocked', async () => {
+    // The page navigates after browser_evaluate has returned, while no tool
+    // call is in flight
+    await client.call('tools/call', {
+      name: 'browser_evaluate',
+      arguments: { expression: "setTimeout(() => { location.href = 'https://www.iana.org/'; }, 300); 'scheduled'" },
+    });
+    await new Promise(r => setTimeout(r, 1500));
+
+    const url = await client.call('tools/call', { name: 'browser_get_url', arguments: {} });
+    assert.strictEqual(url.result.isError, true, 'Should report the blocked navigation');
+    assert.ok(url.result.content[0].text.includes('www.iana.org'), 'Should name the blocked URL');
+
+    const again = await client.call('tools/call', { name: 'browser_get_url', arguments: {} });
+    assert.ok(again.result.content[0].text.includes('example.com'), 'Should stay on the allowed page');
+  });
+});
+
+describe('MCP Server: Tool Profiles', () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient(['--read-only', '--include-tool', 'browser_click']);
+    await client.start();
+    await client.call('initialize', { capabilities: {} });
+  });
+
+  after(() => {
+    client.stop();
+  });
+
+  test('tools/list only lists the profile\'s tools', async () => {
+    const response = await client.call('tools/list', {});
+    const names = response.result.tools.map(t => t.name);
+    assert.ok(names.includes('browser_navigate'), 'Should list browser_navigate');
+    assert.ok(names.includes('browser_get_text'), 'Should list browser_get_text');
+    assert.ok(names.includes('browser_click'), 'Should list the included browser_click');
+    assert.ok(!names.includes('browser_evaluate'), 'Should not list browser_evaluate');
+    assert.ok(!names.includes('browser_set_cookie'), 'Should not list browser_set_cookie');
+    assert.ok(!names.includes('browser_upload'), 'Should not list browser_upload');
+  });
+
+  test('calling a tool outside the profile is an error', async () => {
+    const response = await client.call('tools/call', {
+      name: 'browser_evaluate',
+      arguments: { expression: '1 + 1' },
+    });
+    assert.strictEqual(response.result.isError, true, 'Should be an error');
+    assert.ok(response.result.content[0].text.includes('not available in the readonly tool profile'));
+  });
+
+  test('local file and page source URLs are refused outside the full profile', async () => {
+    for (const [name, url] of [['browser_navigate', 'file:///etc/hosts'], ['browser_new_page', 'view-source:https://example.com']]) {
+      const response = await client.call('tools/call', { name, arguments: { url } });
+      assert.strictEqual(response.result.isError, true, `${name} ${url} should be an error`);
+      assert.ok(response.result.content[0].text.includes('not available in the readonly tool profile'));
+    }
+  });
+
+  test('javascript: URLs are refused outside the full profile', async () => {
+    for (const [name, url] of [['browser_navigate', 'javascript:document.title'], ['browser_new_page', ' JavaScript:alert(1)']]) {
+      const response = await client.call('tools/call', { name, arguments: { url } });
+      assert.strictEqual(response.result.isError, true, `${name} ${url} should be an error`);
+      assert.ok(response.result.content[0].text.includes('javascript URLs are not available in the readonly tool profile'));
+    }
+  });
+});
+
+describe('MCP Server: Confirmation Gate', () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient(['--confirm', 'origin']);
+    await client.start();
+    await client.call('initialize', { protocolVersion: '2025-06-18', capabilities: { elicitation: {} } });
+  });
+
+  after(async () => {
+    await client.call('tools/call', { name: 'browser_stop', arguments: {} });
+    client.stop();
+  });
+
+  // Sends a tool call, answers the elicitation request it triggers with
+  // action, and returns the tool call's response.
+  async function callWithAnswer(name, args, action) {
+    const id = client.send('tools/call', { name, arguments: args });
+    const request = await client.receive();
+    assert.strictEqual(request.method, 'elicitation/create', 'Should ask for confirmation');
+    assert.ok(request.params.message.includes('new origin'), 'Should describe the action');
+    client.proc.stdin.write(JSON.stringify({ jsonrpc: '2.0', id: request.id, result: { action } }) + '\n');
+    const response = await client.receive();
+    assert.strictEqual(response.id, id);
+    return response;
+  }
+
+  test('declined navigation to a new origin is an error', async () => {
+    const response = await callWithAnswer('browser_navigate', { url: 'https://example.com' }, 'decline');
+    assert.strictEqual(response.result.isError, true, 'Should be an error');
+    assert.ok(response.result.content[0].text.includes('action declined'));
+  });
+
+  test('approved navigation goes ahead, and the origin is not asked about again', async () => {
+    const response = await callWithAnswer('browser_navigate', { url: 'https://example.com' }, 'accept');
+    assert.ok(!response.result.isError, 'Should not be an error');
+
+    const again = await client.call('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: 'https://example.com/?again' },
+    });
+    assert.ok(!again.result.isError, 'Should navigate without asking');
+  });
+});
+
 describe('MCP Server: Recording', { timeout: 120000 }, () => {
   let client;
   const tmpFiles = [];
@@ -1053,3 +1458,301 @@ describe('MCP Server: Recording', { timeout: 120000 }, () => {
     }
   });
 });
+
+describe('MCP Server: Streamable HTTP', { timeout: 120000 }, () => {
+  let proc, endpoint, sessionId;
+  const auth = { Authorization: 'Bearer s3cret' };
+
+  before(async () => {
+    proc = spawn(VIBIUM, ['mcp', '--http', '--port', '0', '--token', 's3cret'], {
+      stdio: ['ignore', 'pipe', 'pipe'],
+    });
+    let output = '';
+    endpoint = await new Promise((resolve, reject) => {
+      const timer = setTimeout(() => reject(new Error(`MCP server did not start:\n${output}`)), 30000);
+      proc.stderr.on('data', (data) => {
+        output += data.toString();
+        const match = output.match(/listening on (http:\/\/\S+)/);
+        if (match) {
+          clearTimeout(timer);
+          resolve(match[1]);
+        }
+      });
+    });
+  });
+
+  after(() => {
+    proc.kill();
+  });
+
+  // post sends a JSON-RPC message (or batch) and returns the fetch response
+  function post(body, headers = {}) {
+    return fetch(endpoint, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', Accept: 'application/json', ...auth, ...headers },
+      body: JSON.stringify(body),
+    });
+  }
+
+  const session = () => ({ 'Mcp-Session-Id': sessionId });
+
+  test('requests without the token are refused', async () => {
+    const res = await fetch(endpoint, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ jsonrpc: '2.0', id: 1, method: 'ping' }),
+    });
+    assert.strictEqual(res.status, 401);
+  });
+
+  test('requests before initialize are refused', async () => {
+    const res = await post({ jsonrpc: '2.0', id: 1, method: 'tools/list' });
+    assert.strictEqual(res.status, 400);
+  });
+
+  test('initialize starts a session', async () => {
+    const res = await post({
+      jsonrpc: '2.0',
+      id: 1,
+      method: 'initialize',
+      params: { protocolVersion: '2025-03-26', capabilities: {}, clientInfo: { name: 'test', version: '1.0' } },
+    });
+    assert.strictEqual(res.status, 200);
+    sessionId = res.headers.get('mcp-session-id');
+    assert.ok(sessionId, 'Should return a session ID');
+    const body = await res.json();
+    assert.strictEqual(body.id, 1);
+    assert.strictEqual(body.result.protocolVersion, '2025-03-26');
+
+    const initialized = await post({ jsonrpc: '2.0', method: 'notifications/initialized' }, session());
+    assert.strictEqual(initialized.status, 202, 'Notifications are accepted without a body');
+  });
+
+  test('requests in the session get JSON responses', async () => {
+    const res = await post({ jsonrpc: '2.0', id: 2, method: 'tools/list' }, session());
+    assert.strictEqual(res.status, 200);
+    assert.strictEqual(res.headers.get('mcp-session-id'), sessionId);
+    const body = await res.json();
+    assert.strictEqual(body.id, 2);
+    assert.ok(body.result.tools.length > 0);
+  });
+
+  test('a batch gets a batch of responses', async () => {
+    const res = await post([
+      { jsonrpc: '2.0', id: 3, method: 'ping' },
+      { jsonrpc: '2.0', id: 4, method: 'tools/list' },
+    ], session());
+    const body = await res.json();
+    assert.ok(Array.isArray(body), 'Should answer with an array');
+    assert.deepStrictEqual(body.map(r => r.id), [3, 4]);
+  });
+
+  test('clients accepting SSE get the responses as events', async () => {
+    const res = await post({
+      jsonrpc: '2.0',
+      id: 5,
+      method: 'tools/call',
+      params: { name: 'browser_evaluate', arguments: { expression: '1 + 1' } },
+    }, { ...session(), Accept: 'application/json, text/event-stream' });
+    assert.strictEqual(res.status, 200);
+    assert.match(res.headers.get('content-type'), /text\/event-stream/);
+
+    const events = (await res.text()).split('\n\n').filter(Boolean);
+    const data = events.map(e => JSON.parse(e.split('\n').find(l => l.startsWith('data: ')).slice(6)));
+    const response = data.find(m => m.id === 5);
+    assert.ok(response, 'Should stream the response');
+    assert.ok(!response.result.isError, 'Should not be an error');
+    assert.ok(response.result.content[0].text.includes('2'));
+  });
+
+  test('GET without accepting SSE is refused', async () => {
+    const res = await fetch(endpoint, { headers: { ...auth, ...session() } });
+    assert.strictEqual(res.status, 406);
+  });
+
+  test('DELETE ends the session', async () => {
+    const res = await fetch(endpoint, { method: 'DELETE', headers: { ...auth, ...session() } });
+    assert.strictEqual(res.status, 204);
+
+    const ended = await post({ jsonrpc: '2.0', id: 6, method: 'ping' }, session());
+    assert.strictEqual(ended.status, 404, 'The ended session is unknown');
+  });
+});
+
+describe('MCP Server: Resources', { timeout: 120000 }, () => {
+  let client, server, baseURL, screenshotDir;
+
+  before(async () => {
+    ({ server, baseURL } = await createTestServer());
+    screenshotDir = fs.mkdtempSync(path.join(os.tmpdir(), 'vibium-resources-'));
+    client = new MCPClient(['--screenshot-dir', screenshotDir]);
+    await client.start();
+    await client.call('initialize', {
+      protocolVersion: '2025-06-18',
+      capabilities: {},
+      clientInfo: { name: 'test', version: '1.0' },
+    });
+  });
+
+  after(async () => {
+    await client.call('tools/call', { name: 'browser_stop', arguments: {} });
+    client.stop();
+    server.close();
+    fs.rmSync(screenshotDir, { recursive: true, force: true });
+  });
+
+  // Sends a request and collects the notifications that arrive before its response
+  async function callCollecting(method, params) {
+    const id = client.send(method, params);
+    const notifications = [];
+    for (;;) {
+      const msg = await client.receive();
+      if (msg.id === id) return { response: msg, notifications };
+      notifications.push(msg);
+    }
+  }
+
+  test('page resources are listed once a browser is running', async () => {
+    const idle = await client.call('resources/list');
+    assert.ok(!idle.result.resources.some(r => r.uri.startsWith('vibium://')), 'No page resources without a browser');
+
+    await client.call('tools/call', { name: 'browser_navigate', arguments: { url: `${baseURL}/login` } });
+    const uris = (await client.call('resources/list')).result.resources.map(r => r.uri);
+    for (const uri of ['vibium://page/text', 'vibium://page/a11y', 'vibium://page/map']) {
+      assert.ok(uris.includes(uri), `Should list ${uri}`);
+    }
+  });
+
+  test('resources/read returns the page text', async () => {
+    const response = await client.call('resources/read', { uri: 'vibium://page/text' });
+    const [contents] = response.result.contents;
+    assert.strictEqual(contents.uri, 'vibium://page/text');
+    assert.strictEqual(contents.mimeType, 'text/plain');
+    assert.ok(contents.text.includes('Login Page'), 'Should contain the page text');
+  });
+
+  test('reading the map resource keeps the refs browser_map gave', async () => {
+    const map = await client.call('tools/call', { name: 'browser_map', arguments: {} });
+    const refs = map.result.content[0].text.match(/@e\d+/g);
+    assert.ok(refs && refs.length > 0, 'browser_map should assign refs');
+
+    const response = await client.call('resources/read', { uri: 'vibium://page/map' });
+    const text = response.result.contents[0].text;
+    for (const ref of refs) {
+      assert.ok(text.includes(ref), `Should show ${ref}`);
+    }
+
+    // The refs still point at the same elements
+    const hover = await client.call('tools/call', { name: 'browser_hover', arguments: { selector: refs[0] } });
+    assert.ok(!hover.result.isError, hover.result.content[0].text);
+  });
+
+  test('saved screenshots are listed and readable', async () => {
+    await client.call('tools/call', { name: 'browser_screenshot', arguments: { filename: 'login.png' } });
+    const resource = (await client.call('resources/list')).result.resources.find(r => r.name === 'login.png');
+    assert.ok(resource, 'Should list the screenshot');
+    assert.strictEqual(resource.mimeType, 'image/png');
+
+    const response = await client.call('resources/read', { uri: resource.uri });
+    const blob = Buffer.from(response.result.contents[0].blob, 'base64');
+    assert.strictEqual(blob.subarray(1, 4).toString(), 'PNG');
+  });
+
+  test('an unknown resource is an error', async () => {
+    const response = await client.call('resources/read', { uri: 'vibium://page/nothing' });
+    assert.strictEqual(response.error.code, -32002);
+  });
+
+  test('subscribers are told when the page changes', async () => {
+    const sub = await client.call('resources/subscribe', { uri: 'vibium://page/text' });
+    assert.ok(!sub.error);
+
+    const { notifications } = await callCollecting('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: `${baseURL}/checkboxes` },
+    });
+    assert.ok(notifications.some(n =>
+      n.method === 'notifications/resources/updated' && n.params.uri === 'vibium://page/text'
+    ), 'Should notify about the page text');
+
+    await client.call('resources/unsubscribe', { uri: 'vibium://page/text' });
+    const quiet = await callCollecting('tools/call', {
+      name: 'browser_navigate',
+      arguments: { url: `${baseURL}/login` },
+    });
+    assert.ok(!quiet.notifications.some(n => n.method === 'notifications/resources/updated'),
+      'Should not notify after unsubscribing');
+  });
+});
+
+describe('MCP Server: Progress and Cancellation', { timeout: 60000 }, () => {
+  let client;
+
+  before(async () => {
+    client = new MCPClient();
+    await client.start();
+    await client.call('initialize', {
+      protocolVersion: '2025-06-18',
+      capabilities: {},
+      clientInfo: { name: 'test', version: '1.0' },
+    });
+  });
+
+  after(() => {
+    client.stop();
+  });
+
+  // Sends a request and collects the notifications that arrive before its response
+  async function callCollecting(method, params) {
+    const id = client.send(method, params);
+    const notifications = [];
+    for (;;) {
+      const msg = await client.receive();
+      if (msg.id === id) return { response: msg, notifications };
+      notifications.push(msg);
+    }
+  }
+
+  test('long tool calls report progress when given a progress token', async () => {
+    const { response, notifications } = await callCollecting('tools/call', {
+      name: 'browser_sleep',
+      arguments: { ms: 2500 },
+      _meta: { progressToken: 'sleep-1' },
+    });
+    assert.ok(!response.result.isError, 'Should not be an error');
+
+    const progress = notifications.filter(n => n.method === 'notifications/progress');
+    assert.ok(progress.length >= 2, `Should report progress, got ${progress.length}`);
+    for (const [i, n] of progress.entries()) {
+      assert.strictEqual(n.params.progressToken, 'sleep-1');
+      assert.strictEqual(n.params.progress, i + 1, 'Progress should count up');
+      assert.ok(n.params.message.includes('sleep'), n.params.message);
+    }
+  });
+
+  test('no progress is reported without a progress token', async () => {
+    const { notifications } = await callCollecting('tools/call', {
+      name: 'browser_sleep',
+      arguments: { ms: 1500 },
+    });
+    assert.ok(!notifications.some(n => n.method === 'notifications/progress'));
+  });
+
+  test('a cancelled tool call stops and gets no response', async () => {
+    const sleepId = client.send('tools/call', { name: 'browser_sleep', arguments: { ms: 20000 } });
+    await new Promise(resolve => setTimeout(resolve, 300));
+    client.proc.stdin.write(JSON.stringify({
+      jsonrpc: '2.0',
+      method: 'notifications/cancelled',
+      params: { requestId: sleepId, reason: 'test' },
+    }) + '\n');
+
+    // The next request is answered long before the sleep would have ended,
+    // and nothing answers the cancelled one
+    const start = Date.now();
+    const ping = await client.call('ping', {});
+    assert.ok(ping.result, 'Should answer the next request');
+    assert.ok(Date.now() - start < 5000, 'The cancelled call should stop right away');
+    assert.strictEqual(client.responses.length, 0, 'Should not answer the cancelled call');
+  });
+});